@@ -12,9 +12,10 @@ import (
 //玩家移动
 type MoveApi struct {
 	znet.BaseRouter
+	World *core.WorldManager //玩家所在的游戏世界，为空时使用默认的WorldMgrObj
 }
 
-func (*MoveApi) Handle(request ziface.IRequest) {
+func (api *MoveApi) Handle(request ziface.IRequest) {
 	//1. 将客户端传来的proto协议解码
 	msg := &pb.Position{}
 	err := proto.Unmarshal(request.GetData(), msg)
@@ -24,7 +25,7 @@ func (*MoveApi) Handle(request ziface.IRequest) {
 	}
 
 	//2. 得知当前的消息是从哪个玩家传递来的,从连接属性pid中获取
-	pid, err := request.GetConn().GetProperty("pid")
+	pid, err := request.GetConn().GetPropertyInt32("pid")
 	if err != nil {
 		fmt.Println("GetProperty pid error", err)
 		request.GetConn().Stop()
@@ -34,7 +35,7 @@ func (*MoveApi) Handle(request ziface.IRequest) {
 	//fmt.Printf("user pid = %d , move(%f,%f,%f,%f)\n", pid, msg.X, msg.Y, msg.Z, msg.V)
 
 	//3. 根据pid得到player对象
-	player := core.WorldMgrObj.GetPlayerByPid(pid.(int32))
+	player := worldOf(api.World).GetPlayerByPid(pid)
 
 	//4. 让player对象发起移动位置信息广播
 	player.UpdatePos(msg.X, msg.Y, msg.Z, msg.V)
@@ -0,0 +1,11 @@
+package api
+
+import "server/main/mmo_game/core"
+
+//获取路由所在的游戏世界，没有指定时使用默认的WorldMgrObj
+func worldOf(world *core.WorldManager) *core.WorldManager {
+	if world == nil {
+		return core.WorldMgrObj
+	}
+	return world
+}
@@ -12,9 +12,10 @@ import (
 //世界聊天 路由业务
 type WorldChatApi struct {
 	znet.BaseRouter
+	World *core.WorldManager //玩家所在的游戏世界，为空时使用默认的WorldMgrObj
 }
 
-func (*WorldChatApi) Handle(request ziface.IRequest) {
+func (api *WorldChatApi) Handle(request ziface.IRequest) {
 	//1. 将客户端传来的proto协议解码
 	msg := &pb.Talk{}
 	err := proto.Unmarshal(request.GetData(), msg)
@@ -24,14 +25,14 @@ func (*WorldChatApi) Handle(request ziface.IRequest) {
 	}
 
 	//2. 得知当前的消息是从哪个玩家传递来的,从连接属性pid中获取
-	pid, err := request.GetConn().GetProperty("pid")
+	pid, err := request.GetConn().GetPropertyInt32("pid")
 	if err != nil {
 		fmt.Println("GetProperty pid error", err)
 		request.GetConn().Stop()
 		return
 	}
 	//3. 根据pid得到player对象
-	player := core.WorldMgrObj.GetPlayerByPid(pid.(int32))
+	player := worldOf(api.World).GetPlayerByPid(pid)
 
 	//4. 让player对象发起聊天广播请求
 	player.Talk(msg.Content)
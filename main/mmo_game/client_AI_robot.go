@@ -103,7 +103,7 @@ func (this *TcpClient) AIRobotAction() {
 		msg := &pb.Talk{
 			Content: content,
 		}
-		this.SendMsg(2, msg)
+		this.SendMsg(pb.MsgIDTalk, msg)
 	} else {
 		//移动
 		x := this.X
@@ -149,7 +149,7 @@ func (this *TcpClient) AIRobotAction() {
 
 		fmt.Println(fmt.Sprintf("player ID: %d. Walking...", this.Pid))
 		//发送移动MsgID:3的指令
-		this.SendMsg(3, msg)
+		this.SendMsg(pb.MsgIDMove, msg)
 	}
 }
 
@@ -159,7 +159,7 @@ func (this *TcpClient) AIRobotAction() {
 func (this *TcpClient) DoMsg(msg *Message) {
 	//处理消息
 	//fmt.Println(fmt.Sprintf("msg id :%d, data len: %d", msg.MsgId, msg.Len))
-	if msg.MsgId == 1 {
+	if msg.MsgId == pb.MsgIDSyncPid {
 		//服务器回执给客户端 分配ID
 
 		//解析proto
@@ -168,7 +168,7 @@ func (this *TcpClient) DoMsg(msg *Message) {
 
 		//给当前客户端ID进行赋值
 		this.Pid = syncpid.Pid
-	} else if msg.MsgId == 200 {
+	} else if msg.MsgId == pb.MsgIDBroadCast {
 		//服务器回执客户端广播数据
 
 		//解析proto
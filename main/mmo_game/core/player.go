@@ -13,30 +13,29 @@ import (
 //玩家对象
 type Player struct {
 	Pid  int32              //玩家ID
-	Conn ziface.IConn		//当前玩家的连接
+	conn ziface.IConn		//当前玩家的连接，断线重连时被替换，通过GetConn/SetConn读写
+	connLock sync.RWMutex	//保护conn的读写锁
 	X    float32            //平面x坐标
 	Y    float32            //高度
 	Z    float32            //平面y坐标 (注意不是Y)
 	V    float32            //旋转0-360度
+	World *WorldManager     //玩家所在的游戏世界
 }
 
-/*
-	Player ID 生成器
-*/
-var PidGen int32 = 1  //用来生成玩家ID的计数器
-var IdLock sync.Mutex //保护PidGen的互斥机制
-
-//创建一个玩家对象
+//在默认的游戏世界WorldMgrObj中创建一个玩家对象
 func NewPlayer(conn ziface.IConn) *Player {
+	return NewPlayerInWorld(WorldMgrObj, conn)
+}
+
+//在指定的游戏世界中创建一个玩家对象
+func NewPlayerInWorld(world *WorldManager, conn ziface.IConn) *Player {
 	//生成一个PID
-	IdLock.Lock()
-	id := PidGen
-	PidGen++
-	IdLock.Unlock()
+	id := world.genPid()
 
 	p := &Player{
-		Pid:  id,
-		Conn: conn,
+		Pid:   id,
+		conn:  conn,
+		World: world,
 		X:    float32(160 + rand.Intn(50)), //随机在160坐标点 基于X轴偏移若干坐标
 		Y:    0,                            //高度为0
 		Z:    float32(134 + rand.Intn(50)), //随机在134坐标点 基于Y轴偏移若干坐标
@@ -46,6 +45,20 @@ func NewPlayer(conn ziface.IConn) *Player {
 	return p
 }
 
+//获取玩家当前的连接
+func (p *Player) GetConn() ziface.IConn {
+	p.connLock.RLock()
+	defer p.connLock.RUnlock()
+	return p.conn
+}
+
+//断线重连恢复会话时，玩家改用新的连接
+func (p *Player) SetConn(conn ziface.IConn) {
+	p.connLock.Lock()
+	p.conn = conn
+	p.connLock.Unlock()
+}
+
 //告知客户端pid,同步已经生成的玩家ID给客户端
 func (p *Player) SyncPid() {
 	//组建MsgId0 proto数据
@@ -54,7 +67,7 @@ func (p *Player) SyncPid() {
 	}
 
 	//发送数据给客户端
-	p.SendMsg(1, data)
+	p.SendMsg(pb.MsgIDSyncPid, data)
 }
 
 //广播玩家自己的出生地点
@@ -75,18 +88,18 @@ func (p *Player) BroadCastStartPosition() {
 	}
 
 	//发送数据给客户端
-	p.SendMsg(200, msg)
+	p.SendMsg(pb.MsgIDBroadCast, msg)
 }
 
 //给当前玩家周边的(九宫格内)玩家广播自己的位置，让他们显示自己
 func (p *Player) SyncSurrounding() {
 	//1 根据自己的位置，获取周围九宫格内的玩家pid
-	pids := WorldMgrObj.AoiMgr.GetPidsByPos(p.X, p.Z)
+	pids := p.World.AoiMgr.GetPidsByPos(p.X, p.Z)
 	//2 根据pid得到所有玩家对象
 	players := make([]*Player, 0, len(pids))
 	//3 给这些玩家发送MsgID:200消息，让自己出现在对方视野中
 	for _, pid := range pids {
-		players = append(players, WorldMgrObj.GetPlayerByPid(int32(pid)))
+		players = append(players, p.World.GetPlayerByPid(int32(pid)))
 	}
 	//3.1 组建MsgId200 proto数据
 	msg := &pb.BroadCast{
@@ -103,7 +116,7 @@ func (p *Player) SyncSurrounding() {
 	}
 	//3.2 每个玩家分别给对应的客户端发送200消息，显示人物
 	for _, player := range players {
-		player.SendMsg(200, msg)
+		player.SendMsg(pb.MsgIDBroadCast, msg)
 	}
 	//4 让周围九宫格内的玩家出现在自己的视野中
 	//4.1 制作Message SyncPlayers 数据
@@ -127,7 +140,7 @@ func (p *Player) SyncSurrounding() {
 	}
 
 	//4.3 给当前玩家发送需要显示周围的全部玩家数据
-	p.SendMsg(202, SyncPlayersMsg)
+	p.SendMsg(pb.MsgIDSyncPlayers, SyncPlayersMsg)
 }
 
 //广播玩家聊天
@@ -141,12 +154,15 @@ func (p *Player) Talk(content string) {
 		},
 	}
 
-	//2. 得到当前世界所有的在线玩家
-	players := WorldMgrObj.GetAllPlayers()
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		fmt.Println("marshal msg err: ", err)
+		return
+	}
 
-	//3. 向所有的玩家发送MsgId:200消息
-	for _, player := range players {
-		player.SendMsg(200, msg)
+	//2. 发布到世界聊天主题，由订阅的各节点向自己的全部在线玩家发送MsgId:200消息
+	if err := p.World.GetBus().Publish(WorldChatTopic, data); err != nil {
+		fmt.Println("publish world chat err: ", err)
 	}
 }
 
@@ -155,9 +171,9 @@ func (p *Player) UpdatePos(x float32, y float32, z float32, v float32) {
 
 	//触发消失视野和添加视野业务
 	//计算旧格子gid
-	oldGid := WorldMgrObj.AoiMgr.GetGidByPos(p.X, p.Z)
+	oldGid := p.World.AoiMgr.GetGidByPos(p.X, p.Z)
 	//计算新格子gid
-	newGid := WorldMgrObj.AoiMgr.GetGidByPos(x, z)
+	newGid := p.World.AoiMgr.GetGidByPos(x, z)
 
 	//更新玩家的位置信息
 	p.X = x
@@ -168,9 +184,9 @@ func (p *Player) UpdatePos(x float32, y float32, z float32, v float32) {
 	if oldGid != newGid {
 		//触发gird切换
 		//把pid从就的aoi格子中删除
-		WorldMgrObj.AoiMgr.RemovePidFromGrid(int(p.Pid), oldGid)
+		p.World.AoiMgr.RemovePidFromGrid(int(p.Pid), oldGid)
 		//把pid添加到新的aoi格子中去
-		WorldMgrObj.AoiMgr.AddPidToGrid(int(p.Pid), newGid)
+		p.World.AoiMgr.AddPidToGrid(int(p.Pid), newGid)
 
 		_ = p.OnExchangeAoiGrid(oldGid, newGid)
 	}
@@ -193,13 +209,13 @@ func (p *Player) UpdatePos(x float32, y float32, z float32, v float32) {
 	players := p.GetSurroundingPlayers()
 	//向周边的每个玩家发送MsgID:200消息，移动位置更新消息
 	for _, player := range players {
-		player.SendMsg(200, msg)
+		player.SendMsg(pb.MsgIDBroadCast, msg)
 	}
 }
 
 func (p *Player) OnExchangeAoiGrid(oldGid, newGid int) error {
 	//获取就的九宫格成员
-	oldGrids := WorldMgrObj.AoiMgr.GetSurroundGridsByGid(oldGid)
+	oldGrids := p.World.AoiMgr.GetSurroundGridsByGid(oldGid)
 
 	//为旧的九宫格成员建立哈希表,用来快速查找
 	oldGridsMap := make(map[int]bool, len(oldGrids))
@@ -208,7 +224,7 @@ func (p *Player) OnExchangeAoiGrid(oldGid, newGid int) error {
 	}
 
 	//获取新的九宫格成员
-	newGrids := WorldMgrObj.AoiMgr.GetSurroundGridsByGid(newGid)
+	newGrids := p.World.AoiMgr.GetSurroundGridsByGid(newGid)
 	//为新的九宫格成员建立哈希表,用来快速查找
 	newGridsMap := make(map[int]bool, len(newGrids))
 	for _, grid := range newGrids {
@@ -230,16 +246,16 @@ func (p *Player) OnExchangeAoiGrid(oldGid, newGid int) error {
 
 	//获取需要消失的格子中的全部玩家
 	for _, grid := range leavingGrids {
-		players := WorldMgrObj.GetPlayersByGid(grid.GID)
+		players := p.World.GetPlayersByGid(grid.GID)
 		for _, player := range players {
 			//让自己在其他玩家的客户端中消失
-			player.SendMsg(201, offlineMsg)
+			player.SendMsg(pb.MsgIDPlayerOffline, offlineMsg)
 
 			//将其他玩家信息 在自己的客户端中消失
 			anotherOfflineMsg := &pb.SyncPid{
 				Pid: player.Pid,
 			}
-			p.SendMsg(201, anotherOfflineMsg)
+			p.SendMsg(pb.MsgIDPlayerOffline, anotherOfflineMsg)
 			time.Sleep(200 * time.Millisecond)
 		}
 	}
@@ -269,11 +285,11 @@ func (p *Player) OnExchangeAoiGrid(oldGid, newGid int) error {
 
 	//获取需要显示格子的全部玩家
 	for _, grid := range enteringGrids {
-		players := WorldMgrObj.GetPlayersByGid(grid.GID)
+		players := p.World.GetPlayersByGid(grid.GID)
 
 		for _, player := range players {
 			//让自己出现在其他人视野中
-			player.SendMsg(200, onlineMsg)
+			player.SendMsg(pb.MsgIDBroadCast, onlineMsg)
 
 			//让其他人出现在自己的视野中
 			anotherOnlineMsg := &pb.BroadCast{
@@ -290,7 +306,7 @@ func (p *Player) OnExchangeAoiGrid(oldGid, newGid int) error {
 			}
 
 			time.Sleep(200 * time.Millisecond)
-			p.SendMsg(200, anotherOnlineMsg)
+			p.SendMsg(pb.MsgIDBroadCast, anotherOnlineMsg)
 		}
 	}
 
@@ -300,12 +316,12 @@ func (p *Player) OnExchangeAoiGrid(oldGid, newGid int) error {
 //获得当前玩家的AOI周边玩家信息
 func (p *Player) GetSurroundingPlayers() []*Player {
 	//得到当前AOI区域的所有pid
-	pids := WorldMgrObj.AoiMgr.GetPidsByPos(p.X, p.Z)
+	pids := p.World.AoiMgr.GetPidsByPos(p.X, p.Z)
 
 	//将所有pid对应的Player放到Player切片中
 	players := make([]*Player, 0, len(pids))
 	for _, pid := range pids {
-		players = append(players, WorldMgrObj.GetPlayerByPid(int32(pid)))
+		players = append(players, p.World.GetPlayerByPid(int32(pid)))
 	}
 
 	return players
@@ -323,12 +339,12 @@ func (p *Player) LostConnection() {
 
 	//3 向周围玩家发送消息
 	for _, player := range players {
-		player.SendMsg(201, msg)
+		player.SendMsg(pb.MsgIDPlayerOffline, msg)
 	}
 
 	//4 世界管理器将当前玩家从AOI中摘除
-	WorldMgrObj.AoiMgr.RemoveFromGridByPos(int(p.Pid), p.X, p.Z)
-	WorldMgrObj.RemovePlayerByPid(p.Pid)
+	p.World.AoiMgr.RemoveFromGridByPos(int(p.Pid), p.X, p.Z)
+	p.World.RemovePlayerByPid(p.Pid)
 }
 
 /*
@@ -345,13 +361,14 @@ func (p *Player) SendMsg(msgId uint32, data proto.Message) {
 	}
 	//fmt.Printf("after Marshal data = %+v\n", msg)
 
-	if p.Conn == nil {
+	conn := p.GetConn()
+	if conn == nil {
 		fmt.Println("connection in player is nil")
 		return
 	}
 
 	//调用Zinx框架的SendMsg发包
-	if err := p.Conn.SendMsg(msgId, msg); err != nil {
+	if err := conn.SendMsg(msgId, msg); err != nil {
 		fmt.Println("Player SendMsg error !")
 		return
 	}
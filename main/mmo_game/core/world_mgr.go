@@ -1,9 +1,15 @@
 package core
 
 import (
+	"fmt"
+	"server/main/mmo_game/pb"
+	"server/zpubsub"
 	"sync"
 )
 
+//世界聊天在发布订阅总线上的主题
+const WorldChatTopic = "world.chat"
+
 /*
 	当前游戏世界的总管理模块
 */
@@ -11,19 +17,69 @@ type WorldManager struct {
 	AoiMgr  *AOIManager       //当前世界地图的AOI规划管理器
 	Players map[int32]*Player //当前在线的玩家集合
 	pLock   sync.RWMutex      //保护Players的互斥读写机制
+	pidGen  int32             //用来生成玩家ID的计数器
+	idLock  sync.Mutex        //保护pidGen的互斥机制
+	bus     zpubsub.Bus       //世界聊天经过的发布订阅总线
+	busSub  int               //世界聊天在bus上的订阅ID
 }
 
-//提供一个对外的世界管理模块句柄
+//提供一个对外的世界管理模块句柄，作为默认的游戏世界
 var WorldMgrObj *WorldManager
 
-//提供WorldManager 初始化方法
-func init() {
-	WorldMgrObj = &WorldManager{
+//创建一个游戏世界，同一进程中可以同时存在多个互不影响的游戏世界
+func NewWorldManager() *WorldManager {
+	wm := &WorldManager{
 		Players: make(map[int32]*Player),
 		AoiMgr:  NewAOIManager(AOI_MIN_X, AOI_MAX_X, AOI_CNTS_X, AOI_MIN_Y, AOI_MAX_Y, AOI_CNTS_Y),
+		pidGen:  1,
+	}
+	//默认只在进程内广播
+	wm.SetBus(zpubsub.NewLocalBus())
+	return wm
+}
+
+//设置世界聊天经过的发布订阅总线，使用zpubsub.ClusterBus时聊天广播到集群中的全部节点
+func (wm *WorldManager) SetBus(bus zpubsub.Bus) {
+	if wm.bus != nil {
+		wm.bus.Unsubscribe(wm.busSub)
+	}
+	wm.bus = bus
+	wm.busSub = bus.Subscribe(WorldChatTopic, wm.onWorldChat)
+}
+
+//获取世界聊天经过的发布订阅总线
+func (wm *WorldManager) GetBus() zpubsub.Bus {
+	return wm.bus
+}
+
+//收到世界聊天，将已经序列化好的MsgID:200消息发送给本世界的全部在线玩家
+func (wm *WorldManager) onWorldChat(topic string, data []byte) {
+	for _, player := range wm.GetAllPlayers() {
+		conn := player.GetConn()
+		if conn == nil {
+			continue
+		}
+		if err := conn.SendMsg(pb.MsgIDBroadCast, data); err != nil {
+			fmt.Println("world chat send error: ", err)
+		}
 	}
 }
 
+//提供WorldManager 初始化方法
+func init() {
+	WorldMgrObj = NewWorldManager()
+}
+
+//生成一个当前世界中唯一的玩家ID
+func (wm *WorldManager) genPid() int32 {
+	wm.idLock.Lock()
+	defer wm.idLock.Unlock()
+
+	id := wm.pidGen
+	wm.pidGen++
+	return id
+}
+
 //提供添加一个玩家的的功能，将玩家添加进玩家信息表Players
 func (wm *WorldManager) AddPlayer(player *Player) {
 	//将player添加到 世界管理器中
@@ -1,2 +1,3 @@
 #!/bin/bash
-protoc --go_out=. *.proto
+#protoc-gen-zmsg根据@msgid标注生成msgID常量(msg.zmsg.go)和Unity客户端的C#常量(msg.msgid.cs)
+protoc --go_out=. --zmsg_out=csharp:. *.proto
@@ -20,7 +20,9 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
-//同步客户端玩家ID
+// 同步客户端玩家ID
+// @msgid 1 s2c
+// @msgid 201 s2c PlayerOffline 玩家下线或者离开视野，在客户端中消失
 type SyncPid struct {
 	Pid                  int32    `protobuf:"varint,1,opt,name=Pid,proto3" json:"Pid,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -60,7 +62,8 @@ func (m *SyncPid) GetPid() int32 {
 	return 0
 }
 
-//玩家位置
+// 玩家位置
+// @msgid 3 c2s Move
 type Position struct {
 	X                    float32  `protobuf:"fixed32,1,opt,name=X,proto3" json:"X,omitempty"`
 	Y                    float32  `protobuf:"fixed32,2,opt,name=Y,proto3" json:"Y,omitempty"`
@@ -124,7 +127,8 @@ func (m *Position) GetV() float32 {
 	return 0
 }
 
-//玩家广播数据
+// 玩家广播数据
+// @msgid 200 s2c
 type BroadCast struct {
 	Pid int32 `protobuf:"varint,1,opt,name=Pid,proto3" json:"Pid,omitempty"`
 	Tp  int32 `protobuf:"varint,2,opt,name=Tp,proto3" json:"Tp,omitempty"`
@@ -236,7 +240,8 @@ func (*BroadCast) XXX_OneofWrappers() []interface{} {
 	}
 }
 
-//玩家聊天数据
+// 玩家聊天数据
+// @msgid 2 c2s
 type Talk struct {
 	Content              string   `protobuf:"bytes,1,opt,name=Content,proto3" json:"Content,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -276,7 +281,7 @@ func (m *Talk) GetContent() string {
 	return ""
 }
 
-//玩家信息
+// 玩家信息
 type Player struct {
 	Pid                  int32     `protobuf:"varint,1,opt,name=Pid,proto3" json:"Pid,omitempty"`
 	P                    *Position `protobuf:"bytes,2,opt,name=P,proto3" json:"P,omitempty"`
@@ -324,7 +329,8 @@ func (m *Player) GetP() *Position {
 	return nil
 }
 
-//同步玩家显示数据
+// 同步玩家显示数据
+// @msgid 202 s2c
 type SyncPlayers struct {
 	Ps                   []*Player `protobuf:"bytes,1,rep,name=ps,proto3" json:"ps,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
@@ -376,7 +382,7 @@ func init() {
 func init() { proto.RegisterFile("msg.proto", fileDescriptor_c06e4cca6c2cc899) }
 
 var fileDescriptor_c06e4cca6c2cc899 = []byte{
-	// 278 bytes of a gzipped FileDescriptorProto
+	// 274 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x91, 0xbf, 0x4e, 0xc3, 0x30,
 	0x10, 0x87, 0x63, 0xe7, 0x4f, 0xc9, 0xa5, 0x42, 0xc8, 0x93, 0x15, 0x18, 0x22, 0x4f, 0x65, 0xc9,
 	0x50, 0x24, 0x76, 0x52, 0x86, 0x8c, 0x96, 0x89, 0xaa, 0xb6, 0x9b, 0xd3, 0x54, 0x28, 0xa2, 0xc4,
@@ -393,6 +399,6 @@ var fileDescriptor_c06e4cca6c2cc899 = []byte{
 	0xfe, 0xcd, 0x72, 0x46, 0xe9, 0xef, 0x24, 0xf6, 0x0c, 0x09, 0x3f, 0xca, 0xd3, 0x61, 0xbc, 0x62,
 	0x9c, 0x3b, 0x0b, 0x7c, 0x69, 0x21, 0x10, 0x67, 0x8f, 0x90, 0xf9, 0xe7, 0xf4, 0x77, 0x0d, 0xc9,
 	0x01, 0x6b, 0x43, 0x51, 0x11, 0x2e, 0xb2, 0x25, 0xf8, 0xb3, 0xbe, 0x21, 0xb0, 0x36, 0x55, 0xfc,
-	0x8d, 0x31, 0x6f, 0xdb, 0xc4, 0xff, 0xc5, 0xd3, 0x4f, 0x00, 0x00, 0x00, 0xff, 0xff, 0xe6, 0xb4,
-	0xb8, 0x13, 0x98, 0x01, 0x00, 0x00,
+	0x8d, 0x31, 0x6f, 0xdb, 0xc4, 0xff, 0xc5, 0xd3, 0xcf, 0x00, 0xe6, 0xb4, 0xb8, 0x13, 0x98, 0x01,
+	0x00, 0x00,
 }
@@ -2,10 +2,18 @@ package main
 
 import (
 	"fmt"
+	"github.com/golang/protobuf/proto"
+	"os"
+	"server/main/mmo_game/api"
+	"server/main/mmo_game/core"
+	"server/main/mmo_game/pb"
+	"server/utils"
+	"server/zadmin"
+	"server/zcapture"
 	"server/ziface"
 	"server/znet"
-	"server/main/mmo_game/core"
-	"server/main/mmo_game/api"
+	"server/zproto"
+	"server/zpubsub"
 )
 
 //当客户端建立连接的时候的hook函数
@@ -31,6 +39,23 @@ func OnConnecionAdd(conn ziface.IConn) {
 	fmt.Println("=====> Player pidId = ", player.Pid, " arrived ====")
 }
 
+//当客户端断线重连恢复会话的时候的hook函数，连接属性已经从旧连接恢复
+func OnSessionResume(conn ziface.IConn) {
+	pid, err := conn.GetPropertyInt32("pid")
+	if err != nil {
+		return
+	}
+	player := core.WorldMgrObj.GetPlayerByPid(pid)
+	if player == nil {
+		return
+	}
+
+	//玩家改用新的连接，断线期间的消息已经由框架补发
+	player.SetConn(conn)
+
+	fmt.Println("=====> Player pidId = ", player.Pid, " resumed ====")
+}
+
 //当客户端断开连接的时候的hook函数
 func OnConnectionLost(conn ziface.IConn) {
 	//获取当前连接的Pid属性
@@ -48,17 +73,72 @@ func OnConnectionLost(conn ziface.IConn) {
 }
 
 func main() {
+	//加载命令行参数中的配置，如 -conf /path/to/config.json -TcpPort=8999
+	if err := utils.GlobalObject.Load(os.Args[1:]); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	//创建服务器句柄
 	s := znet.NewServer()
 
 	//注册客户端连接建立和丢失函数
 	s.SetOnConnStart(OnConnecionAdd)
 	s.SetOnConnStop(OnConnectionLost)
+	//开启SessionGracePeriod时，断线重连的客户端恢复原来的玩家
+	s.SetOnSessionResume(OnSessionResume)
 
 	//注册路由
-	s.AddRouter(2, &api.WorldChatApi{})
-	s.AddRouter(3, &api.MoveApi{})
+	s.AddRouter(pb.MsgIDTalk, &api.WorldChatApi{})
+	s.AddRouter(pb.MsgIDMove, &api.MoveApi{})
+	//移动优先于聊天处理，聊天刷屏时不影响移动的延迟
+	s.GetMsgHandler().SetMsgPriority(pb.MsgIDMove, znet.PriorityHigh)
+	s.GetMsgHandler().SetMsgPriority(pb.MsgIDTalk, znet.PriorityLow)
+
+	//监听配置文件变更和SIGHUP信号，热更新配置
+	utils.GlobalObject.Watch()
+
+	//启动管理后台，系统广播以MsgID:200的聊天广播形式下发
+	admin := zadmin.NewAdmin(s)
+	admin.SetBroadcastEncoder(func(msgID uint32, text string) (uint32, []byte, error) {
+		data, err := proto.Marshal(&pb.BroadCast{
+			Pid:  0, //Pid 0 代表系统
+			Tp:   1, //TP 1 代表聊天广播
+			Data: &pb.BroadCast_Content{Content: text},
+		})
+		return pb.MsgIDBroadCast, data, err
+	})
+	if err := admin.Start(); err != nil {
+		fmt.Println("admin console not started: ", err)
+	}
+
+	//配置了PubSubBroker时世界聊天和系统广播经Broker分发到集群中的全部节点
+	if utils.GlobalObject.PubSubBroker != "" {
+		bus := zpubsub.Connect(utils.GlobalObject.PubSubBroker, nil)
+		core.WorldMgrObj.SetBus(bus)
+		admin.SetBroadcastBus(bus, "system.announce")
+	}
+
+	//配置了Registry时把本节点注册为game节点，负载为当前连接数
+	if utils.GlobalObject.Registry != "" {
+		registry := znet.NewRegistryClient(utils.GlobalObject.Registry, nil)
+		if err := registry.RegisterServer(s, "game", "chat"); err != nil {
+			fmt.Println("registry not registered: ", err)
+		}
+	}
+
+	//配置了CaptureFile时记录全部连接收发的消息，用zreplay回放复现客户端问题
+	if utils.GlobalObject.CaptureFile != "" {
+		if _, err := zcapture.Start(s, utils.GlobalObject.CaptureFile); err != nil {
+			fmt.Println("capture not started: ", err)
+		}
+	}
+
+	//开启LogMsgText时在Debug日志中打印收发消息的可读文本
+	if utils.GlobalObject.LogMsgText {
+		zproto.LogMsgs(s, zproto.DefaultRegistry)
+	}
 
 	//启动服务
 	s.Serve()
-}
\ No newline at end of file
+}
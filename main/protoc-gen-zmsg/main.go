@@ -0,0 +1,15 @@
+package main
+
+import (
+	"server/zplugin"
+	"server/zproto"
+)
+
+/*
+protoc插件：根据.proto中消息注释里的@msgid标注生成msgID常量、注册表、发送方法和处理接口
+go build -o $GOPATH/bin/protoc-gen-zmsg ./main/protoc-gen-zmsg
+protoc --go_out=. --zmsg_out=csharp:. msg.proto
+*/
+func main() {
+	zplugin.Run(zproto.GenerateMsgIDs)
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"server/zplugin"
+	"server/zrpc"
+)
+
+/*
+protoc插件：根据.proto中的service生成zrpc的服务端接口和客户端代码
+go build -o $GOPATH/bin/protoc-gen-zrpc ./main/protoc-gen-zrpc
+protoc --go_out=. --zrpc_out=. chat.proto
+*/
+func main() {
+	zplugin.Run(zrpc.GenerateStubs)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"server/utils"
+	"server/zpubsub"
+)
+
+/*
+发布订阅Broker：集群中各节点的PubSubBroker配置为它的地址
+go run ./main/zbroker -conf conf/broker.json -TcpPort=9990
+*/
+func main() {
+	//加载命令行参数中的配置，监听地址和端口使用Host和TcpPort
+	if err := utils.GlobalObject.Load(os.Args[1:]); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	zpubsub.NewBroker().Serve()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"server/utils"
+	"server/zcapture"
+	"server/znet"
+	"server/zproto"
+
+	//注册mmo_game的消息类型
+	_ "server/main/mmo_game/pb"
+)
+
+/*
+消息解码工具：将十六进制的数据包或者抓包文件解码为可读的protobuf文本
+go run ./main/zdecode -dir s2c -hex "0c000000 c8000000 0801 1001 1a04 6869 6869"
+echo "0c000000c8000000..." | go run ./main/zdecode -dir c2s
+go run ./main/zdecode -file capture.zcap
+加上 -msgid 200 时输入的十六进制只是消息数据，不带包头
+*/
+func main() {
+	file := flag.String("file", "", "capture file recorded with CaptureFile, decode all records")
+	hexData := flag.String("hex", "", "hex of one or more packed frames, read from stdin when empty")
+	dir := flag.String("dir", "s2c", "direction of the hex frames, c2s or s2c")
+	msgID := flag.Uint("msgid", 0, "msgID of the hex data when it is a message payload without frame head")
+	compact := flag.Bool("compact", false, "print each message in one line")
+	flag.Parse()
+
+	registry := zproto.DefaultRegistry
+	format := registry.FormatIndent
+	if *compact {
+		format = registry.Format
+	}
+
+	if *file != "" {
+		records, err := zcapture.ReadFile(*file)
+		for _, record := range records {
+			switch record.Dir {
+			case zcapture.DirIn:
+				fmt.Println(record.Time.Format("15:04:05.000000"), "ConnID =", record.ConnID, format(record.MsgID, zproto.ClientToServer, record.Data))
+			case zcapture.DirOut:
+				fmt.Println(record.Time.Format("15:04:05.000000"), "ConnID =", record.ConnID, format(record.MsgID, zproto.ServerToClient, record.Data))
+			default:
+				fmt.Println(record)
+			}
+		}
+		if err != nil {
+			fmt.Println("read capture file err: ", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	d, err := zproto.ParseDirection(*dir)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	input := *hexData
+	if input == "" {
+		stdin, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Println("read stdin err: ", err)
+			os.Exit(1)
+		}
+		input = string(stdin)
+	}
+	data, err := zproto.ParseHex(input)
+	if err != nil {
+		fmt.Println("parse hex err: ", err)
+		os.Exit(1)
+	}
+
+	if *msgID != 0 {
+		fmt.Println(format(uint32(*msgID), d, data))
+		return
+	}
+	//包长度不受MaxPacketSize限制
+	conf := utils.NewGlobalObj()
+	conf.MaxPacketSize = 0
+	msgs, err := zproto.SplitFrames(znet.NewDataPackWithConfig(conf), data)
+	for _, msg := range msgs {
+		fmt.Println(format(msg.GetMsgID(), d, msg.GetData()))
+	}
+	if err != nil {
+		fmt.Println("split frames err: ", err)
+		os.Exit(1)
+	}
+}
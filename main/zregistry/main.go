@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"server/utils"
+	"server/zregistry"
+)
+
+/*
+注册中心：集群中各节点的Registry配置为它的地址
+go run ./main/zregistry -conf conf/registry.json -TcpPort=9980
+*/
+func main() {
+	//加载命令行参数中的配置，监听地址和端口使用Host和TcpPort
+	if err := utils.GlobalObject.Load(os.Args[1:]); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	zregistry.NewRegistry().Serve()
+}
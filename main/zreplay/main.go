@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"server/zcapture"
+	"time"
+)
+
+/*
+抓包回放工具：用抓包文件驱动测试服务器，比较服务器的回复与抓包中的回复
+go run ./main/zreplay -file capture.zcap -addr 127.0.0.1:9999 -speed 2
+加上 -dump 只打印抓包内容，不回放
+*/
+func main() {
+	file := flag.String("file", "", "capture file recorded with CaptureFile")
+	addr := flag.String("addr", "127.0.0.1:9999", "address of the server to replay against")
+	speed := flag.Float64("speed", 1, "replay speed, 1 for original speed, 0 for as fast as possible")
+	drain := flag.Duration("drain", time.Second, "time to wait for replies after the last message of a connection")
+	compareData := flag.Bool("data", false, "compare reply payloads as well as msgIDs")
+	maxPacketSize := flag.Uint("maxpacket", 0, "fragment messages larger than the server's MaxPacketSize, 0 to never fragment")
+	dump := flag.Bool("dump", false, "print the records of the capture file without replaying")
+	flag.Parse()
+
+	if *file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	records, err := zcapture.ReadFile(*file)
+	if err != nil {
+		fmt.Println("read capture file err: ", err)
+		if len(records) == 0 {
+			os.Exit(1)
+		}
+		//文件末尾被截断(例如服务器异常退出)时，回放已经读到的记录
+		fmt.Println("replay ", len(records), " records read before the error")
+	}
+
+	if *dump {
+		for _, record := range records {
+			fmt.Println(record)
+		}
+		return
+	}
+
+	report, err := zcapture.Replay(records, *addr, zcapture.ReplayOptions{
+		Speed:         *speed,
+		Drain:         *drain,
+		CompareData:   *compareData,
+		MaxPacketSize: uint32(*maxPacketSize),
+	})
+	if err != nil {
+		fmt.Println("replay err: ", err)
+		os.Exit(1)
+	}
+	fmt.Printf("conns = %d, sent = %d, expected replies = %d, received replies = %d\n",
+		report.Conns, report.Sent, report.Expected, report.Received)
+	for _, m := range report.Mismatches {
+		fmt.Println("MISMATCH ", m)
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+	fmt.Println("OK")
+}
@@ -0,0 +1,410 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*
+	分层配置加载
+	优先级：默认值 < 配置文件(json/yaml/toml) < 环境变量 < 命令行参数
+
+	配置文件：根据扩展名选择格式，.json为json，.yaml/.yml为yaml，.toml为toml，
+		yaml和toml只支持一层的 key: value / key = value 形式，与GlobalObj的字段一一对应
+	环境变量：SERVER_ 加上大写的配置项名称，如 SERVER_TCPPORT=8999，
+		SERVER_CONF 指定配置文件路径
+	命令行参数：-配置项名称=值，如 -TcpPort=8999，-conf 指定配置文件路径
+*/
+
+//配置来源
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+//环境变量前缀
+const EnvPrefix = "SERVER_"
+
+//环境变量或命令行参数中的一个配置项
+type confOverride struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+//一个不合法的配置项
+type confError struct {
+	Key string
+	Msg string
+}
+
+//按 默认值 < 配置文件 < 环境变量 < 命令行参数 的优先级加载全部配置，全部配置项立即生效
+//args为命令行参数(不含程序名)，一般传入os.Args[1:]
+func (g *GlobalObj) Load(args []string) error {
+	overrides, confPath, confPathSource, err := parseOverrides(os.Environ(), args)
+	if err != nil {
+		return err
+	}
+
+	g.state.lock.Lock()
+	g.state.overrides = overrides
+	if confPath != "" {
+		g.ConfFilePath = confPath
+		g.state.confPathSource = confPathSource
+	}
+	g.state.lock.Unlock()
+
+	return g.reload(true)
+}
+
+//计算叠加之后的配置和每个配置项的来源，并做校验
+func (g *GlobalObj) layer() (*GlobalObj, map[string]string, error) {
+	g.state.lock.Lock()
+	confFilePath := g.ConfFilePath
+	confPathSource := g.state.confPathSource
+	overrides := g.state.overrides
+	g.state.lock.Unlock()
+
+	conf := defaultGlobalObj()
+	conf.ConfFilePath = confFilePath
+	conf.TcpServer = g.TcpServer
+	conf.state = g.state
+
+	sources := make(map[string]string)
+	for _, name := range confFieldNames() {
+		sources[name] = SourceDefault
+	}
+	if confPathSource != "" {
+		sources["ConfFilePath"] = confPathSource
+	}
+
+	//配置文件
+	if exists, _ := PathExists(confFilePath); exists {
+		if errs := parseConfFile(confFilePath, conf, sources); len(errs) > 0 {
+			return nil, nil, joinConfErrors(errs, sources)
+		}
+	} else if confPathSource != "" {
+		return nil, nil, fmt.Errorf("config file %s not found (from %s)", confFilePath, confPathSource)
+	}
+
+	//环境变量和命令行参数
+	var errs []confError
+	for _, o := range overrides {
+		field, name, ok := lookupConfField(conf, o.Key)
+		if !ok {
+			errs = append(errs, unknownKeyError(o.Key, o.Source))
+			continue
+		}
+		if err := setConfField(field, o.Value); err != nil {
+			errs = append(errs, confError{Key: name, Msg: fmt.Sprintf("%v (from %s)", err, o.Source)})
+			continue
+		}
+		sources[name] = o.Source
+	}
+	if len(errs) > 0 {
+		return nil, nil, joinConfErrors(errs, nil)
+	}
+
+	if errs := conf.validate(); len(errs) > 0 {
+		return nil, nil, joinConfErrors(errs, sources)
+	}
+	return conf, sources, nil
+}
+
+//从环境变量和命令行参数中解析配置项，同时返回显式指定的配置文件路径及其来源
+func parseOverrides(environ []string, args []string) ([]confOverride, string, string, error) {
+	overrides := make([]confOverride, 0)
+	confPath, confPathSource := "", ""
+
+	//环境变量
+	names := confFieldNames()
+	for _, kv := range environ {
+		i := strings.Index(kv, "=")
+		if i < 0 || !strings.HasPrefix(kv[:i], EnvPrefix) {
+			continue
+		}
+		key, value := kv[len(EnvPrefix):i], kv[i+1:]
+		if key == "CONF" {
+			confPath, confPathSource = value, SourceEnv+" "+kv[:i]
+			continue
+		}
+		for _, name := range names {
+			if strings.EqualFold(name, key) {
+				overrides = append(overrides, confOverride{Key: name, Value: value, Source: SourceEnv + " " + kv[:i]})
+				break
+			}
+		}
+	}
+
+	//命令行参数
+	if len(args) > 0 {
+		fs := flag.NewFlagSet("server", flag.ContinueOnError)
+		confFlag := fs.String("conf", "", "config file path (json/yaml/toml)")
+		flagValues := make(map[string]*confFlagValue)
+		fieldType := reflect.TypeOf(GlobalObj{})
+		for _, name := range names {
+			field, _ := fieldType.FieldByName(name)
+			v := &confFlagValue{isBool: field.Type.Kind() == reflect.Bool}
+			flagValues[name] = v
+			fs.Var(v, name, fmt.Sprintf("override config %s (%s)", name, field.Type.Kind()))
+		}
+		if err := fs.Parse(args); err != nil {
+			return nil, "", "", err
+		}
+		fs.Visit(func(f *flag.Flag) {
+			if v, ok := flagValues[f.Name]; ok {
+				overrides = append(overrides, confOverride{Key: f.Name, Value: v.value, Source: SourceFlag + " -" + f.Name})
+			}
+		})
+		if *confFlag != "" {
+			confPath, confPathSource = *confFlag, SourceFlag+" -conf"
+		}
+	}
+
+	return overrides, confPath, confPathSource, nil
+}
+
+//命令行参数的值，bool类型的配置项支持 -LogDebugClose 的简写形式
+type confFlagValue struct {
+	value  string
+	isBool bool
+}
+
+func (v *confFlagValue) String() string     { return v.value }
+func (v *confFlagValue) Set(s string) error { v.value = s; return nil }
+func (v *confFlagValue) IsBoolFlag() bool   { return v.isBool }
+
+//解析配置文件，格式由扩展名决定
+func parseConfFile(path string, conf *GlobalObj, sources map[string]string) []confError {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return []confError{{Key: "ConfFilePath", Msg: err.Error()}}
+	}
+
+	source := SourceFile + " " + path
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return parseFlatConf(data, ":", source, conf, sources)
+	case ".toml":
+		return parseFlatConf(data, "=", source, conf, sources)
+	default:
+		return parseJSONConf(data, source, conf, sources)
+	}
+}
+
+//解析json配置文件
+func parseJSONConf(data []byte, source string, conf *GlobalObj, sources map[string]string) []confError {
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return []confError{{Key: "ConfFilePath", Msg: fmt.Sprintf("%s is not valid json: %v", source, err)}}
+	}
+
+	var errs []confError
+	for key, value := range raw {
+		field, name, ok := lookupConfField(conf, key)
+		if !ok {
+			errs = append(errs, unknownKeyError(key, source))
+			continue
+		}
+		if err := json.Unmarshal(value, field.Addr().Interface()); err != nil {
+			errs = append(errs, confError{Key: name, Msg: fmt.Sprintf("expected %s, got %s (from %s)", field.Kind(), string(value), source)})
+			continue
+		}
+		sources[name] = source
+	}
+	return errs
+}
+
+//解析只有一层 key<sep>value 的yaml/toml配置文件
+func parseFlatConf(data []byte, sep string, source string, conf *GlobalObj, sources map[string]string) []confError {
+	var errs []confError
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "[") || line[0] == ' ' || line[0] == '\t' {
+			errs = append(errs, confError{Key: "ConfFilePath", Msg: fmt.Sprintf("%s line %d: nested config is not supported, keys must be flat", source, lineNo)})
+			continue
+		}
+		i := strings.Index(trimmed, sep)
+		if i < 0 {
+			errs = append(errs, confError{Key: "ConfFilePath", Msg: fmt.Sprintf("%s line %d: expected key %s value", source, lineNo, sep)})
+			continue
+		}
+		key := strings.TrimSpace(trimmed[:i])
+		value := unquoteConfValue(strings.TrimSpace(trimmed[i+1:]))
+
+		field, name, ok := lookupConfField(conf, key)
+		if !ok {
+			errs = append(errs, unknownKeyError(key, fmt.Sprintf("%s line %d", source, lineNo)))
+			continue
+		}
+		if err := setConfField(field, value); err != nil {
+			errs = append(errs, confError{Key: name, Msg: fmt.Sprintf("%v (from %s line %d)", err, source, lineNo)})
+			continue
+		}
+		sources[name] = source
+	}
+	return errs
+}
+
+//去掉值两侧的引号和行尾注释
+func unquoteConfValue(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+		if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+			return value[1 : end+1]
+		}
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value
+}
+
+//全部配置项名称
+func confFieldNames() []string {
+	names := make([]string, 0)
+	t := reflect.TypeOf(GlobalObj{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Name == "TcpServer" {
+			continue
+		}
+		names = append(names, field.Name)
+	}
+	return names
+}
+
+//按名称(不区分大小写)查找配置项
+func lookupConfField(conf *GlobalObj, key string) (reflect.Value, string, bool) {
+	for _, name := range confFieldNames() {
+		if strings.EqualFold(name, key) {
+			return reflect.ValueOf(conf).Elem().FieldByName(name), name, true
+		}
+	}
+	return reflect.Value{}, "", false
+}
+
+//将字符串形式的值写入配置项
+func setConfField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected int, got %q", value)
+		}
+		field.SetInt(v)
+	case reflect.Uint32:
+		v, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("expected uint32, got %q", value)
+		}
+		field.SetUint(v)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected bool, got %q", value)
+		}
+		field.SetBool(v)
+	default:
+		return fmt.Errorf("unsupported config type %s", field.Kind())
+	}
+	return nil
+}
+
+//未知配置项，给出最接近的配置项名称作为提示
+func unknownKeyError(key string, source string) confError {
+	msg := fmt.Sprintf("unknown config key (from %s)", source)
+	best, bestDist := "", 4
+	for _, name := range confFieldNames() {
+		if d := editDistance(strings.ToLower(key), strings.ToLower(name)); d < bestDist {
+			best, bestDist = name, d
+		}
+	}
+	if best != "" {
+		msg += fmt.Sprintf(", did you mean %s?", best)
+	}
+	return confError{Key: key, Msg: msg}
+}
+
+//两个字符串的编辑距离
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+//将多个配置错误合并为一个错误，sources不为空时附带配置项的来源
+func joinConfErrors(errs []confError, sources map[string]string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	lines := make([]string, 0, len(errs))
+	for _, e := range errs {
+		line := e.Key + ": " + e.Msg
+		if source, ok := sources[e.Key]; ok && !strings.Contains(e.Msg, "(from ") {
+			line += " (from " + source + ")"
+		}
+		lines = append(lines, line)
+	}
+	return errors.New("invalid config:\n  " + strings.Join(lines, "\n  "))
+}
+
+//输出当前生效的配置及其来源，AdminToken不会明文输出
+func (g *GlobalObj) Dump() string {
+	g.state.lock.Lock()
+	defer g.state.lock.Unlock()
+
+	buf := bytes.NewBufferString("effective config:\n")
+	value := reflect.ValueOf(g).Elem()
+	for _, name := range confFieldNames() {
+		v := fmt.Sprintf("%v", value.FieldByName(name).Interface())
+		if name == "AdminToken" && v != "" {
+			v = "******"
+		}
+		source := g.state.sources[name]
+		if source == "" {
+			source = SourceDefault
+		}
+		fmt.Fprintf(buf, "  %-25s = %-24s (%s)\n", name, v, source)
+	}
+	return buf.String()
+}
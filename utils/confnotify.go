@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"os"
+	"reflect"
+	"server/zlog"
+	"sync"
+	"time"
+)
+
+/*
+	配置热更新：配置变更通知与配置文件监听
+
+	运行期生效的配置(LiveReloadKeys)：
+		MaxConn        新的连接数上限对之后建立的连接生效
+		MaxPacketSize  对之后读取的数据包生效
+		MaxMsgChanLen  对之后建立的连接生效
+		LogDir、LogFile、LogDebugClose  立即生效
+
+	需要重启服务才生效的配置：
+		Name、Host、TcpPort、Version、WorkerPoolSize、MaxWorkerTaskLen、MaxMailboxLen、MaxMsgSize、
+		FragmentTimeout、MaxFragmentBuffer、MaxWorkerPoolSize、WorkerScaleInterval、
+		WorkerScaleUpQueuePercent、WorkerScaleUpLatency、ShedQueuePercent、OverloadMsgID、ConnIDGenerator、
+		NodeID、SessionGracePeriod、SessionResumeWait、ReliableDelivery、ReliableBufferLen、
+		RequireHandshake、HandshakeTimeout、ProtocolVersion、MinProtocolVersion、Capabilities、RequireAuth、
+		LoginTimeout、UnauthenticatedMsgID、PingInterval、CaptureFile、PubSubBroker、Registry、
+		RegistryLease、ConfFilePath、ConfWatchInterval、AdminHost、AdminHttpPort、AdminTelnetPort、AdminToken
+*/
+
+//运行期可以热更新的配置项
+var LiveReloadKeys = map[string]bool{
+	"MaxConn":       true,
+	"MaxPacketSize": true,
+	"MaxMsgChanLen": true,
+	"LogDir":        true,
+	"LogFile":       true,
+	"LogDebugClose": true,
+}
+
+//配置变更回调，changed为本次发生变更的配置项名称
+type ConfChangeFunc func(conf *GlobalObj, changed []string)
+
+type confSubscriber struct {
+	//关注的配置项，为空表示关注全部配置项
+	keys []string
+	fn   ConfChangeFunc
+}
+
+type confState struct {
+	//保证同一时刻只有一次Reload在执行，回调执行完之前不会有新的配置写入
+	reloadLock sync.Mutex
+	//保护配置写入和订阅者集合
+	lock sync.Mutex
+	//是否已经完成首次加载
+	loaded bool
+	//配置文件路径的来源，为空表示使用默认路径
+	confPathSource string
+	//环境变量和命令行参数中的配置，每次重新加载时都会叠加在配置文件之上
+	overrides []confOverride
+	//每个配置项当前生效值的来源
+	sources map[string]string
+	//订阅者集合
+	subs  map[int]*confSubscriber
+	subID int
+}
+
+func newConfState() *confState {
+	return &confState{
+		subs: make(map[int]*confSubscriber),
+	}
+}
+
+//订阅配置变更，keys为空时关注全部配置项，返回订阅ID用于取消订阅
+//回调在Reload所在的goroutine中同步执行，回调中可以安全读取conf中的配置
+func (g *GlobalObj) Subscribe(fn ConfChangeFunc, keys ...string) int {
+	g.state.lock.Lock()
+	defer g.state.lock.Unlock()
+
+	g.state.subID++
+	g.state.subs[g.state.subID] = &confSubscriber{keys: keys, fn: fn}
+	return g.state.subID
+}
+
+//取消订阅配置变更
+func (g *GlobalObj) Unsubscribe(id int) {
+	g.state.lock.Lock()
+	defer g.state.lock.Unlock()
+
+	delete(g.state.subs, id)
+}
+
+//将新配置写入当前配置，返回发生变更的配置项
+//all为false时只写入LiveReloadKeys中的配置，其余变更只打印警告
+func (g *GlobalObj) apply(conf *GlobalObj, all bool) []string {
+	changed := make([]string, 0)
+
+	oldValue := reflect.ValueOf(g).Elem()
+	newValue := reflect.ValueOf(conf).Elem()
+	for i := 0; i < oldValue.NumField(); i++ {
+		field := oldValue.Type().Field(i)
+		//跳过非配置字段
+		if field.PkgPath != "" || field.Name == "TcpServer" {
+			continue
+		}
+		if reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			continue
+		}
+		if all == false && LiveReloadKeys[field.Name] == false {
+			zlog.Warnf("config %s changed to %v, takes effect after restart\n", field.Name, newValue.Field(i).Interface())
+			continue
+		}
+		oldValue.Field(i).Set(newValue.Field(i))
+		changed = append(changed, field.Name)
+	}
+	return changed
+}
+
+//通知订阅者配置发生了变更
+func (g *GlobalObj) notify(changed []string) {
+	if len(changed) == 0 {
+		return
+	}
+
+	g.state.lock.Lock()
+	subs := make([]*confSubscriber, 0, len(g.state.subs))
+	for _, sub := range g.state.subs {
+		subs = append(subs, sub)
+	}
+	g.state.lock.Unlock()
+
+	for _, sub := range subs {
+		if len(sub.keys) == 0 || containsAny(changed, sub.keys...) {
+			sub.fn(g, changed)
+		}
+	}
+}
+
+//监听配置文件变更和SIGHUP信号，触发时重新加载配置，返回停止监听的方法
+func (g *GlobalObj) Watch() (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+
+	if g.ConfWatchInterval > 0 {
+		go g.watchFile(time.Duration(g.ConfWatchInterval)*time.Second, done)
+	}
+	watchSignal(g, done)
+
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+//定时检查配置文件的修改时间和大小，发生变化时重新加载
+func (g *GlobalObj) watchFile(interval time.Duration, done chan struct{}) {
+	var lastMod time.Time
+	var lastSize int64
+	if info, err := os.Stat(g.ConfFilePath); err == nil {
+		lastMod, lastSize = info.ModTime(), info.Size()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(g.ConfFilePath)
+			if err != nil {
+				continue
+			}
+			if info.ModTime().Equal(lastMod) && info.Size() == lastSize {
+				continue
+			}
+			lastMod, lastSize = info.ModTime(), info.Size()
+			g.reloadAndLog("config file changed")
+		}
+	}
+}
+
+func (g *GlobalObj) reloadAndLog(reason string) {
+	if err := g.Reload(); err != nil {
+		zlog.Error("[Config] ", reason, ", reload rejected: ", err)
+		return
+	}
+	zlog.Info("[Config] ", reason, ", reloaded from ", g.ConfFilePath)
+}
+
+//changed中是否包含keys中的任意一个
+func containsAny(changed []string, keys ...string) bool {
+	for _, c := range changed {
+		for _, k := range keys {
+			if c == k {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+//收到SIGHUP信号时重新加载配置
+func watchSignal(g *GlobalObj, done chan struct{}) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(sigChan)
+		for {
+			select {
+			case <-done:
+				return
+			case <-sigChan:
+				g.reloadAndLog("SIGHUP received")
+			}
+		}
+	}()
+}
@@ -0,0 +1,4 @@
+package utils
+
+//windows下没有SIGHUP信号，只能依赖配置文件监听或管理后台触发重新加载
+func watchSignal(g *GlobalObj, done chan struct{}) {}
@@ -1,15 +1,16 @@
 package utils
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"fmt"
+	"net"
 	"os"
+	"path/filepath"
 	"server/ziface"
 	"server/zlog"
 )
 
 //存储一切有关框架的全局参数，供其他模块使用
-//一些参数也可以通过 用户根据 config.json来配置
+//一些参数也可以通过 用户根据 config.json(或yaml/toml)、环境变量和命令行参数来配置
 type GlobalObj struct {
 	/*
 		Server
@@ -28,11 +29,81 @@ type GlobalObj struct {
 	WorkerPoolSize   uint32 //业务工作Worker池的数量
 	MaxWorkerTaskLen uint32 //业务工作Worker对应负责的任务队列最大任务存储数量
 	MaxMsgChanLen    uint32 //SendBuffMsg发送消息的缓冲最大长度
+	MaxMailboxLen    uint32 //WorkerPoolSize为0时，每个连接按顺序处理消息的信箱最大长度
+
+	/*
+		large message fragmentation
+	*/
+	MaxMsgSize        uint32 //开启分片时单条消息的最大长度，超过MaxPacketSize的消息拆成分片发送、接收后重组 默认0  -- 0表示不分片
+	FragmentTimeout   int    //分片重组的超时时间(毫秒)，超时未收齐的消息被丢弃 默认5000
+	MaxFragmentBuffer uint32 //每个连接重组中的消息占用内存的上限(字节)，超过时断开连接 默认4194304
+
+	/*
+		worker pool autoscaling & overload shedding
+	*/
+	MaxWorkerPoolSize         uint32 //Worker池自动扩容的上限 默认0  -- 0表示不自动扩缩容，Worker池固定为WorkerPoolSize
+	WorkerScaleInterval       int    //Worker池扩缩容检查间隔(毫秒) 默认1000
+	WorkerScaleUpQueuePercent uint32 //任意一个Worker任务队列占用达到该百分比时扩容 默认50
+	WorkerScaleUpLatency      int    //任务平均排队时间达到该值(毫秒)时扩容 默认50
+	ShedQueuePercent          uint32 //任务队列占用达到该百分比时拒绝低优先级消息 默认80
+	OverloadMsgID             uint32 //拒绝低优先级消息时回复给客户端的msgID，数据为被拒绝消息的msgID 默认0  -- 0表示不回复
+
+	/*
+		connection ID
+	*/
+	ConnIDGenerator string //连接ID生成方式 默认seq  -- seq：进程内自增，snowflake：编码节点ID和时间，集群内唯一
+	NodeID          int    //当前节点ID，snowflake生成连接ID时使用，范围[0, 1023]，集群内每个节点不能重复 默认0
+
+	/*
+		session resumption
+	*/
+	SessionGracePeriod int    //连接断开后会话保留的时间(秒)，保留期内客户端可以凭令牌恢复会话 默认0  -- 0表示不开启会话恢复
+	SessionResumeWait  int    //开启会话恢复时，新连接等待客户端发送恢复请求的时间(毫秒) 默认200
+	ReliableDelivery   bool   //是否开启可靠传输(消息编号、确认和断线重传)，需要开启会话恢复 默认false
+	ReliableBufferLen  uint32 //可靠传输中等待客户端确认的消息最大缓存数量 默认1024
+
+	/*
+		handshake
+	*/
+	RequireHandshake   bool   //是否要求客户端连接后的第一帧为握手请求，校验协议版本和能力位 默认false
+	HandshakeTimeout   int    //等待客户端握手请求的时间(毫秒) 默认3000
+	ProtocolVersion    uint32 //服务端的协议版本号 默认1
+	MinProtocolVersion uint32 //兼容的最低客户端协议版本号 默认1
+	Capabilities       uint32 //业务额外支持的能力位(如压缩)，与框架根据配置开启的能力位合并后参与协商 默认0
+
+	/*
+		authentication
+	*/
+	RequireAuth          bool   //是否要求连接先登录认证，认证之前只能发送SetLoginMsgIDs指定的msgID 默认false
+	LoginTimeout         int    //开启RequireAuth时，连接开始后完成认证的时间(毫秒)，超时断开 默认30000
+	UnauthenticatedMsgID uint32 //拒绝未认证连接的消息时回复给客户端的msgID，数据为被拒绝消息的msgID 默认0  -- 0表示不回复
+
+	/*
+		connection statistics
+	*/
+	PingInterval int //服务端向客户端发送ping测量RTT的间隔(毫秒) 默认0  -- 0表示不主动发送ping，只回复客户端的ping
+
+	/*
+		traffic capture
+	*/
+	CaptureFile string //抓包文件路径，记录全部连接收发的业务消息，用于zreplay回放 默认""  -- 空表示不抓包
+
+	/*
+		publish/subscribe
+	*/
+	PubSubBroker string //发布订阅Broker的地址(host:port)，世界聊天等广播经Broker分发到集群中的全部节点 默认""  -- 空表示只在进程内广播
+
+	/*
+		node registry
+	*/
+	Registry      string //注册中心的地址(host:port)，节点向注册中心登记地址、负载和能力并发现其他节点 默认""  -- 空表示不注册
+	RegistryLease int    //注册到注册中心的租约时间(毫秒)，超过该时间没有心跳时节点被删除，心跳间隔为租约的三分之一 默认10000
 
 	/*
 		config file path
 	*/
-	ConfFilePath string
+	ConfFilePath      string //配置文件路径
+	ConfWatchInterval int    //配置文件变更检查间隔(秒) 默认3  -- 0表示不监听配置文件变更
 
 	/*
 		logger
@@ -40,6 +111,18 @@ type GlobalObj struct {
 	LogDir        string //日志所在文件夹 默认"./log"
 	LogFile       string //日志文件名称   默认""  --如果没有设置日志文件，打印信息将打印至stderr
 	LogDebugClose bool   //是否关闭Debug日志级别调试信息 默认false  -- 默认打开debug信息
+	LogMsgText    bool   //是否在Debug日志中打印收发消息解码后的可读文本 默认false
+
+	/*
+		admin
+	*/
+	AdminHost       string //管理后台监听IP 默认"127.0.0.1"，只允许本机访问
+	AdminHttpPort   int    //管理后台HTTP/JSON接口端口 默认0  -- 0表示不开启
+	AdminTelnetPort int    //管理后台telnet控制台端口 默认0  -- 0表示不开启
+	AdminToken      string //管理后台访问令牌 默认""  -- 为空时管理后台拒绝启动
+
+	//配置加载状态与变更通知
+	state *confState
 }
 
 //定义一个供外部调用的全局对象
@@ -58,55 +141,190 @@ func PathExists(path string) (bool, error) {
 }
 
 //读取用户的配置文件
-func (g *GlobalObj) Reload() {
+//配置按 默认值 < 配置文件 < 环境变量 < 命令行参数 的优先级重新计算，通过校验后才会生效，
+//校验失败时返回错误，当前配置保持不变
+//首次加载之后，只有LiveReloadKeys中的配置会在运行期生效，其余配置变更需要重启服务
+func (g *GlobalObj) Reload() error {
+	return g.reload(false)
+}
 
-	if confFileExists, _ := PathExists(g.ConfFilePath); confFileExists != true {
-		//fmt.Println("Config File ", g.ConfFilePath , " is not exist!!")
-		return
-	}
+func (g *GlobalObj) reload(all bool) error {
+	g.state.reloadLock.Lock()
+	defer g.state.reloadLock.Unlock()
 
-	data, err := ioutil.ReadFile(g.ConfFilePath)
-	if err != nil {
-		panic(err)
-	}
-	//将json数据解析到struct中
-	err = json.Unmarshal(data, g)
+	//以默认值为底，依次叠加配置文件、环境变量和命令行参数
+	conf, sources, err := g.layer()
 	if err != nil {
-		panic(err)
+		return err
 	}
 
+	g.state.lock.Lock()
+	firstLoad := g.state.loaded == false
+	changed := g.apply(conf, all || firstLoad)
+	g.state.sources = sources
+	g.state.loaded = true
+	g.state.lock.Unlock()
+
 	//Logger 设置
-	if g.LogFile != "" {
-		zlog.SetLogFile(g.LogDir, g.LogFile)
+	if firstLoad || containsAny(changed, "LogDir", "LogFile", "LogDebugClose") {
+		if g.LogFile != "" {
+			zlog.SetLogFile(g.LogDir, g.LogFile)
+		}
+		if g.LogDebugClose == true {
+			zlog.CloseDebug()
+		} else {
+			zlog.OpenDebug()
+		}
 	}
-	if g.LogDebugClose == true {
-		zlog.CloseDebug()
+
+	g.notify(changed)
+	return nil
+}
+
+//校验配置是否合法，一次返回全部不合法的配置项
+func (g *GlobalObj) Validate() error {
+	return joinConfErrors(g.validate(), nil)
+}
+
+//校验配置，返回每个不合法配置项及其原因
+func (g *GlobalObj) validate() []confError {
+	var errs []confError
+	check := func(ok bool, key string, format string, v ...interface{}) {
+		if !ok {
+			errs = append(errs, confError{Key: key, Msg: fmt.Sprintf(format, v...)})
+		}
 	}
+	check(net.ParseIP(g.Host) != nil, "Host", "%q is not a valid IP", g.Host)
+	check(g.TcpPort > 0 && g.TcpPort <= 65535, "TcpPort", "%d out of range [1, 65535]", g.TcpPort)
+	check(g.MaxConn > 0, "MaxConn", "%d must be greater than 0", g.MaxConn)
+	check(g.WorkerPoolSize == 0 || g.MaxWorkerTaskLen > 0, "MaxWorkerTaskLen", "must be greater than 0 when WorkerPoolSize > 0")
+	check(g.MaxMsgChanLen > 0, "MaxMsgChanLen", "must be greater than 0")
+	check(g.MaxMsgSize == 0 || g.MaxMsgSize > g.MaxPacketSize, "MaxMsgSize", "%d must be 0, or greater than MaxPacketSize %d", g.MaxMsgSize, g.MaxPacketSize)
+	check(g.MaxMsgSize == 0 || g.FragmentTimeout > 0, "FragmentTimeout", "must be greater than 0 when MaxMsgSize > 0")
+	check(g.MaxMsgSize == 0 || g.MaxFragmentBuffer >= g.MaxMsgSize, "MaxFragmentBuffer", "%d must not be less than MaxMsgSize %d", g.MaxFragmentBuffer, g.MaxMsgSize)
+	check(g.WorkerPoolSize > 0 || g.MaxMailboxLen > 0, "MaxMailboxLen", "must be greater than 0 when WorkerPoolSize is 0")
+	check(g.MaxWorkerPoolSize == 0 || (g.WorkerPoolSize > 0 && g.MaxWorkerPoolSize >= g.WorkerPoolSize), "MaxWorkerPoolSize", "%d must be 0, or not less than WorkerPoolSize %d when the worker pool is enabled", g.MaxWorkerPoolSize, g.WorkerPoolSize)
+	check(g.WorkerScaleInterval > 0, "WorkerScaleInterval", "%d must be greater than 0", g.WorkerScaleInterval)
+	check(g.WorkerScaleUpQueuePercent > 0 && g.WorkerScaleUpQueuePercent <= 100, "WorkerScaleUpQueuePercent", "%d out of range [1, 100]", g.WorkerScaleUpQueuePercent)
+	check(g.WorkerScaleUpLatency > 0, "WorkerScaleUpLatency", "%d must be greater than 0", g.WorkerScaleUpLatency)
+	check(g.ShedQueuePercent > 0 && g.ShedQueuePercent <= 100, "ShedQueuePercent", "%d out of range [1, 100]", g.ShedQueuePercent)
+	check(g.ConnIDGenerator == "seq" || g.ConnIDGenerator == "snowflake", "ConnIDGenerator", "%q must be seq or snowflake", g.ConnIDGenerator)
+	check(g.NodeID >= 0 && g.NodeID <= 1023, "NodeID", "%d out of range [0, 1023]", g.NodeID)
+	check(g.SessionGracePeriod >= 0, "SessionGracePeriod", "%d must not be negative", g.SessionGracePeriod)
+	check(g.SessionGracePeriod == 0 || g.SessionResumeWait > 0, "SessionResumeWait", "must be greater than 0 when SessionGracePeriod > 0")
+	check(!g.ReliableDelivery || g.SessionGracePeriod > 0, "ReliableDelivery", "requires SessionGracePeriod > 0")
+	check(!g.ReliableDelivery || g.ReliableBufferLen > 0, "ReliableBufferLen", "must be greater than 0 when ReliableDelivery is enabled")
+	check(!g.RequireHandshake || g.HandshakeTimeout > 0, "HandshakeTimeout", "must be greater than 0 when RequireHandshake is enabled")
+	check(g.MinProtocolVersion <= g.ProtocolVersion, "MinProtocolVersion", "%d must not be greater than ProtocolVersion %d", g.MinProtocolVersion, g.ProtocolVersion)
+	check(!g.RequireAuth || g.LoginTimeout > 0, "LoginTimeout", "must be greater than 0 when RequireAuth is enabled")
+	check(g.PingInterval >= 0, "PingInterval", "%d must not be negative", g.PingInterval)
+	check(g.RegistryLease > 0, "RegistryLease", "%d must be greater than 0", g.RegistryLease)
+	check(g.LogFile == "" || g.LogDir != "", "LogDir", "must be set when LogFile is set")
+	check(g.AdminHttpPort >= 0 && g.AdminHttpPort <= 65535, "AdminHttpPort", "%d out of range [0, 65535]", g.AdminHttpPort)
+	check(g.AdminTelnetPort >= 0 && g.AdminTelnetPort <= 65535, "AdminTelnetPort", "%d out of range [0, 65535]", g.AdminTelnetPort)
+	check(g.ConfWatchInterval >= 0, "ConfWatchInterval", "%d must not be negative", g.ConfWatchInterval)
+	return errs
 }
 
-//提供init方法，默认加载
-func init() {
+//创建一份带默认值的配置
+func NewGlobalObj() *GlobalObj {
+	g := defaultGlobalObj()
+	g.state = newConfState()
+	return g
+}
+
+//默认配置
+func defaultGlobalObj() *GlobalObj {
 	pwd, err := os.Getwd()
 	if err != nil {
 		pwd = "."
 	}
-	//初始化GlobalObject变量，设置一些默认值
-	GlobalObject = &GlobalObj{
-		Name:             "ServerApp",
-		Version:          "V0.1",
-		TcpPort:          9999,
-		Host:             "0.0.0.0",
-		MaxConn:          12000,
-		MaxPacketSize:    4096,
-		ConfFilePath:     pwd + "/config/config.json",
-		WorkerPoolSize:   10,
-		MaxWorkerTaskLen: 1024,
-		MaxMsgChanLen:    1024,
-		LogDir:           pwd + "/log",
-		LogFile:          "",
-		LogDebugClose:    false,
+	return &GlobalObj{
+		Name:              "ServerApp",
+		Version:           "V0.1",
+		TcpPort:           9999,
+		Host:              "0.0.0.0",
+		MaxConn:           12000,
+		MaxPacketSize:     4096,
+		ConfFilePath:      defaultConfFilePath(pwd),
+		ConfWatchInterval: 3,
+		WorkerPoolSize:    10,
+		MaxWorkerTaskLen:  1024,
+		MaxMsgChanLen:     1024,
+		MaxMailboxLen:     1024,
+		LogDir:            pwd + "/log",
+		LogFile:           "",
+		LogDebugClose:     false,
+		LogMsgText:        false,
+		AdminHost:         "127.0.0.1",
+		AdminHttpPort:     0,
+		AdminTelnetPort:   0,
+		AdminToken:        "",
+
+		MaxMsgSize:        0,
+		FragmentTimeout:   5000,
+		MaxFragmentBuffer: 4 << 20,
+
+		MaxWorkerPoolSize:         0,
+		WorkerScaleInterval:       1000,
+		WorkerScaleUpQueuePercent: 50,
+		WorkerScaleUpLatency:      50,
+		ShedQueuePercent:          80,
+		OverloadMsgID:             0,
+
+		ConnIDGenerator: "seq",
+		NodeID:          0,
+
+		SessionGracePeriod: 0,
+		SessionResumeWait:  200,
+		ReliableDelivery:   false,
+		ReliableBufferLen:  1024,
+
+		RequireHandshake:   false,
+		HandshakeTimeout:   3000,
+		ProtocolVersion:    1,
+		MinProtocolVersion: 1,
+		Capabilities:       0,
+
+		RequireAuth:          false,
+		LoginTimeout:         30000,
+		UnauthenticatedMsgID: 0,
+
+		PingInterval: 0,
+
+		CaptureFile: "",
+
+		PubSubBroker: "",
+
+		Registry:      "",
+		RegistryLease: 10000,
 	}
+}
 
-	//从配置文件中加载一些用户配置的参数
-	GlobalObject.Reload()
+//默认配置文件路径：优先使用工作目录下的config/config.json，
+//不存在时再尝试可执行文件所在目录下的config/config.json，避免从其他目录启动时读不到配置
+func defaultConfFilePath(pwd string) string {
+	confFilePath := pwd + "/config/config.json"
+	if exists, _ := PathExists(confFilePath); exists {
+		return confFilePath
+	}
+	if exe, err := os.Executable(); err == nil {
+		exeConfFilePath := filepath.Join(filepath.Dir(exe), "config", "config.json")
+		if exists, _ := PathExists(exeConfFilePath); exists {
+			return exeConfFilePath
+		}
+	}
+	return confFilePath
+}
+
+//提供init方法，默认加载
+func init() {
+	//初始化GlobalObject变量，设置一些默认值
+	GlobalObject = NewGlobalObj()
+
+	//从配置文件和环境变量中加载一些用户配置的参数，配置不合法时保留默认值继续运行
+	//命令行参数需要由main调用GlobalObject.Load(os.Args[1:])加载
+	if err := GlobalObject.Load(nil); err != nil {
+		zlog.Error("load config err: ", err, ", using default config")
+	}
 }
@@ -0,0 +1,282 @@
+package zadmin
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"server/utils"
+	"server/ziface"
+	"server/zlog"
+	"server/zproto"
+	"server/zpubsub"
+	"sync"
+)
+
+/*
+	管理后台：供运维人员查看和操控正在运行的Server
+	提供本地HTTP/JSON接口和基于行的telnet控制台两种入口，两者共用同一套管理命令，
+	所有请求都需要携带AdminToken
+*/
+
+//连接信息
+type ConnInfo struct {
+	ConnID     uint64                 `json:"conn_id"`
+	RemoteAddr string                 `json:"remote_addr"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+//系统广播消息的编码方法，将运维输入的文本编码为发给客户端的msgID和数据
+type BroadcastEncoder func(msgID uint32, text string) (uint32, []byte, error)
+
+type Admin struct {
+	//被管理的Server
+	server ziface.IServer
+	//监听IP
+	host string
+	//HTTP/JSON接口端口，0表示不开启
+	httpPort int
+	//telnet控制台端口，0表示不开启
+	telnetPort int
+	//访问令牌
+	token string
+	//系统广播消息的编码方法
+	encoder BroadcastEncoder
+	//系统广播经过的发布订阅总线，为nil时只广播给本节点的连接
+	bus      zpubsub.Bus
+	busTopic string
+	//解码消息使用的消息类型注册表
+	registry *zproto.Registry
+
+	httpServer     *http.Server
+	telnetListener net.Listener
+	lock           sync.Mutex
+}
+
+//创建一个管理后台，监听地址和访问令牌从全局配置中读取
+func NewAdmin(server ziface.IServer) *Admin {
+	return &Admin{
+		server:     server,
+		host:       utils.GlobalObject.AdminHost,
+		httpPort:   utils.GlobalObject.AdminHttpPort,
+		telnetPort: utils.GlobalObject.AdminTelnetPort,
+		token:      utils.GlobalObject.AdminToken,
+		encoder:    rawTextEncoder,
+		registry:   zproto.DefaultRegistry,
+	}
+}
+
+//默认的广播编码：直接以文本作为数据发送
+func rawTextEncoder(msgID uint32, text string) (uint32, []byte, error) {
+	return msgID, []byte(text), nil
+}
+
+//设置系统广播消息的编码方法
+func (a *Admin) SetBroadcastEncoder(encoder BroadcastEncoder) {
+	a.encoder = encoder
+}
+
+//设置系统广播经过的发布订阅总线，广播发布到topic，集群中各节点收到后发给自己的全部连接
+func (a *Admin) SetBroadcastBus(bus zpubsub.Bus, topic string) {
+	bus.Subscribe(topic, func(topic string, data []byte) {
+		if len(data) < 4 {
+			return
+		}
+		a.sendToAll(binary.LittleEndian.Uint32(data), data[4:])
+	})
+	a.bus = bus
+	a.busTopic = topic
+}
+
+//设置解码消息使用的消息类型注册表，默认为zproto.DefaultRegistry
+func (a *Admin) SetMsgRegistry(registry *zproto.Registry) {
+	a.registry = registry
+}
+
+//启动管理后台
+func (a *Admin) Start() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.token == "" {
+		return errors.New("admin token is empty, refuse to start admin console")
+	}
+
+	//先监听全部端口再开始服务，任何一个端口监听失败时不留下已经打开的端口
+	var httpListener, telnetListener net.Listener
+	if a.httpPort > 0 {
+		listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", a.host, a.httpPort))
+		if err != nil {
+			return err
+		}
+		httpListener = listener
+	}
+	if a.telnetPort > 0 {
+		listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", a.host, a.telnetPort))
+		if err != nil {
+			if httpListener != nil {
+				_ = httpListener.Close()
+			}
+			return err
+		}
+		telnetListener = listener
+	}
+
+	if httpListener != nil {
+		a.httpServer = &http.Server{Handler: a.httpHandler()}
+		go func(server *http.Server) {
+			if err := server.Serve(httpListener); err != nil && err != http.ErrServerClosed {
+				zlog.Error("admin http serve err ", err)
+			}
+		}(a.httpServer)
+		zlog.Info("[Admin] http console listening at ", httpListener.Addr())
+	}
+	if telnetListener != nil {
+		a.telnetListener = telnetListener
+		go a.serveTelnet(telnetListener)
+		zlog.Info("[Admin] telnet console listening at ", telnetListener.Addr())
+	}
+
+	return nil
+}
+
+//停止管理后台
+func (a *Admin) Stop() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.httpServer != nil {
+		_ = a.httpServer.Close()
+		a.httpServer = nil
+	}
+	if a.telnetListener != nil {
+		_ = a.telnetListener.Close()
+		a.telnetListener = nil
+	}
+}
+
+//校验访问令牌
+func (a *Admin) checkToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+/*
+	管理命令，HTTP和telnet入口共用
+*/
+
+//列出全部连接及其属性
+func (a *Admin) ListConns() []ConnInfo {
+	conns := a.server.GetConnMgr().GetAllConns()
+	infos := make([]ConnInfo, 0, len(conns))
+	for _, conn := range conns {
+		info := ConnInfo{
+			ConnID:     conn.GetConnID(),
+			Properties: conn.GetProperties(),
+		}
+		if addr := conn.RemoteAddr(); addr != nil {
+			info.RemoteAddr = addr.String()
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+//踢掉指定连接
+func (a *Admin) Kick(connID uint64) error {
+	conn, err := a.server.GetConnMgr().Get(connID)
+	if err != nil {
+		return err
+	}
+	zlog.Info("[Admin] kick connection ConnID = ", connID)
+	conn.Stop()
+	return nil
+}
+
+//向全部连接广播系统消息，返回成功发送的连接个数
+//设置了广播总线时发布到总线，由各节点各自发送，返回0
+func (a *Admin) Broadcast(msgID uint32, text string) (int, error) {
+	msgID, data, err := a.encoder(msgID, text)
+	if err != nil {
+		return 0, err
+	}
+
+	if a.bus != nil {
+		//发布的数据为msgID(4，小端) + 消息数据
+		buf := make([]byte, 4+len(data))
+		binary.LittleEndian.PutUint32(buf, msgID)
+		copy(buf[4:], data)
+		return 0, a.bus.Publish(a.busTopic, buf)
+	}
+	return a.sendToAll(msgID, data), nil
+}
+
+//发送给本节点的全部连接，返回成功发送的连接个数
+func (a *Admin) sendToAll(msgID uint32, data []byte) int {
+	sent := 0
+	for _, conn := range a.server.GetConnMgr().GetAllConns() {
+		if err := conn.SendBuffMsg(msgID, data); err != nil {
+			zlog.Warn("[Admin] broadcast to ConnID = ", conn.GetConnID(), " err ", err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+//获取每个worker任务队列当前排队的任务数量
+func (a *Admin) WorkerQueues() []int {
+	return a.server.GetMsgHandler().GetTaskQueueLens()
+}
+
+//修改日志级别
+func (a *Admin) SetLogLevel(name string) error {
+	level, err := zlog.ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	zlog.SetLevel(level)
+	zlog.Info("[Admin] log level set to ", zlog.LevelName(level))
+	return nil
+}
+
+//注册的消息类型
+type MsgTypeInfo struct {
+	MsgID    uint32 `json:"msg_id"`
+	Dir      string `json:"dir"`
+	Name     string `json:"name"`
+	TypeName string `json:"type_name"`
+}
+
+//列出全部注册的消息类型
+func (a *Admin) MsgTypes() []MsgTypeInfo {
+	types := a.registry.Types()
+	infos := make([]MsgTypeInfo, 0, len(types))
+	for _, t := range types {
+		infos = append(infos, MsgTypeInfo{MsgID: t.MsgID, Dir: t.Dir.String(), Name: t.Name, TypeName: t.TypeName})
+	}
+	return infos
+}
+
+//将十六进制的消息数据解码为可读文本，dir为c2s或s2c
+func (a *Admin) Decode(dir string, msgID uint32, hexData string) (string, error) {
+	d, err := zproto.ParseDirection(dir)
+	if err != nil {
+		return "", err
+	}
+	data, err := zproto.ParseHex(hexData)
+	if err != nil {
+		return "", err
+	}
+	return a.registry.Format(msgID, d, data), nil
+}
+
+//重新加载配置文件
+func (a *Admin) Reload() error {
+	if err := utils.GlobalObject.Reload(); err != nil {
+		return err
+	}
+	zlog.Info("[Admin] config reloaded from ", utils.GlobalObject.ConfFilePath)
+	return nil
+}
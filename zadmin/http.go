@@ -0,0 +1,139 @@
+package zadmin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+/*
+	HTTP/JSON管理接口
+	令牌只通过请求头 X-Admin-Token 传递，不接受URL参数，避免令牌出现在访问日志和代理日志中
+
+	GET  /conns                          列出全部连接及其属性
+	POST /kick?conn_id=1                 踢掉指定连接
+	POST /broadcast?msg_id=200&text=xxx  广播系统消息
+	GET  /workers                        查看worker任务队列深度
+	POST /loglevel?level=info            修改日志级别
+	POST /reload                         重新加载配置文件
+	GET  /msgtypes                       列出注册的消息类型
+	GET  /decode?dir=s2c&msg_id=200&hex=xxx  将消息数据解码为可读文本
+*/
+
+//统一的JSON返回格式
+type httpResult struct {
+	Code int         `json:"code"`
+	Msg  string      `json:"msg"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+func (a *Admin) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/conns", a.auth(http.MethodGet, a.handleConns))
+	mux.HandleFunc("/kick", a.auth(http.MethodPost, a.handleKick))
+	mux.HandleFunc("/broadcast", a.auth(http.MethodPost, a.handleBroadcast))
+	mux.HandleFunc("/workers", a.auth(http.MethodGet, a.handleWorkers))
+	mux.HandleFunc("/loglevel", a.auth(http.MethodPost, a.handleLogLevel))
+	mux.HandleFunc("/reload", a.auth(http.MethodPost, a.handleReload))
+	mux.HandleFunc("/msgtypes", a.auth(http.MethodGet, a.handleMsgTypes))
+	mux.HandleFunc("/decode", a.auth(http.MethodGet, a.handleDecode))
+	return mux
+}
+
+//校验请求方法和访问令牌
+func (a *Admin) auth(method string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeJSON(w, http.StatusMethodNotAllowed, httpResult{Code: -1, Msg: "method not allowed"})
+			return
+		}
+		if !a.checkToken(r.Header.Get("X-Admin-Token")) {
+			writeJSON(w, http.StatusUnauthorized, httpResult{Code: -1, Msg: "invalid admin token"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, result httpResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func writeOK(w http.ResponseWriter, data interface{}) {
+	writeJSON(w, http.StatusOK, httpResult{Code: 0, Msg: "ok", Data: data})
+}
+
+func writeErr(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, httpResult{Code: -1, Msg: err.Error()})
+}
+
+func (a *Admin) handleConns(w http.ResponseWriter, r *http.Request) {
+	writeOK(w, a.ListConns())
+}
+
+func (a *Admin) handleKick(w http.ResponseWriter, r *http.Request) {
+	connID, err := strconv.ParseUint(r.FormValue("conn_id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := a.Kick(connID); err != nil {
+		writeErr(w, http.StatusNotFound, err)
+		return
+	}
+	writeOK(w, nil)
+}
+
+func (a *Admin) handleBroadcast(w http.ResponseWriter, r *http.Request) {
+	msgID, err := strconv.ParseUint(r.FormValue("msg_id"), 10, 32)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	sent, err := a.Broadcast(uint32(msgID), r.FormValue("text"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	writeOK(w, map[string]int{"sent": sent})
+}
+
+func (a *Admin) handleWorkers(w http.ResponseWriter, r *http.Request) {
+	writeOK(w, a.WorkerQueues())
+}
+
+func (a *Admin) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	if err := a.SetLogLevel(r.FormValue("level")); err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	writeOK(w, nil)
+}
+
+func (a *Admin) handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := a.Reload(); err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeOK(w, nil)
+}
+
+func (a *Admin) handleMsgTypes(w http.ResponseWriter, r *http.Request) {
+	writeOK(w, a.MsgTypes())
+}
+
+func (a *Admin) handleDecode(w http.ResponseWriter, r *http.Request) {
+	msgID, err := strconv.ParseUint(r.FormValue("msg_id"), 10, 32)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	text, err := a.Decode(r.FormValue("dir"), uint32(msgID), r.FormValue("hex"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	writeOK(w, text)
+}
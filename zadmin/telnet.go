@@ -0,0 +1,156 @@
+package zadmin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"server/zlog"
+	"strconv"
+	"strings"
+)
+
+/*
+	基于行的telnet控制台
+	连接后第一行必须是 auth <token>，之后每行一条命令，结果以一行JSON或者文本返回
+*/
+
+const telnetHelp = `commands:
+  conns                     list connections
+  kick <conn_id>            kick a connection
+  broadcast <msg_id> <text> broadcast a system message
+  workers                   dump worker queue depths
+  loglevel <level>          set log level (debug/info/warn/error)
+  reload                    reload config file
+  msgtypes                  list registered message types
+  decode <c2s|s2c> <msg_id> <hex>
+                            decode message data into readable text
+  help                      show this help
+  quit                      close the console`
+
+func (a *Admin) serveTelnet(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			//listener被Stop关闭
+			return
+		}
+		go a.handleTelnet(conn)
+	}
+}
+
+func (a *Admin) handleTelnet(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	writeLine := func(format string, v ...interface{}) {
+		_, _ = fmt.Fprintf(conn, format+"\r\n", v...)
+	}
+
+	//第一行必须完成令牌认证
+	writeLine("server admin console, please auth <token>")
+	if !scanner.Scan() {
+		return
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) != 2 || fields[0] != "auth" || !a.checkToken(fields[1]) {
+		writeLine("ERR invalid admin token")
+		return
+	}
+	zlog.Info("[Admin] telnet console authed from ", conn.RemoteAddr().String())
+	writeLine("OK")
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == "quit" || line == "exit" {
+			writeLine("bye")
+			return
+		}
+		result, err := a.execTelnetCmd(line)
+		if err != nil {
+			writeLine("ERR %s", err.Error())
+			continue
+		}
+		writeLine("%s", result)
+	}
+}
+
+//执行一行telnet命令
+func (a *Admin) execTelnetCmd(line string) (string, error) {
+	//broadcast的文本和decode的数据中可能带空格，所以最多只拆成3段
+	args := strings.SplitN(line, " ", 3)
+	switch args[0] {
+	case "help":
+		return telnetHelp, nil
+	case "conns":
+		return toJSON(a.ListConns())
+	case "kick":
+		if len(args) < 2 {
+			return "", fmt.Errorf("usage: kick <conn_id>")
+		}
+		connID, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return "", err
+		}
+		if err := a.Kick(connID); err != nil {
+			return "", err
+		}
+		return "OK", nil
+	case "broadcast":
+		if len(args) < 3 {
+			return "", fmt.Errorf("usage: broadcast <msg_id> <text>")
+		}
+		msgID, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return "", err
+		}
+		sent, err := a.Broadcast(uint32(msgID), args[2])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("OK sent = %d", sent), nil
+	case "workers":
+		return toJSON(a.WorkerQueues())
+	case "loglevel":
+		if len(args) < 2 {
+			return "", fmt.Errorf("usage: loglevel <level>")
+		}
+		if err := a.SetLogLevel(args[1]); err != nil {
+			return "", err
+		}
+		return "OK", nil
+	case "reload":
+		if err := a.Reload(); err != nil {
+			return "", err
+		}
+		return "OK", nil
+	case "msgtypes":
+		return toJSON(a.MsgTypes())
+	case "decode":
+		var rest []string
+		if len(args) == 3 {
+			rest = strings.SplitN(args[2], " ", 2)
+		}
+		if len(rest) < 2 {
+			return "", fmt.Errorf("usage: decode <c2s|s2c> <msg_id> <hex>")
+		}
+		msgID, err := strconv.ParseUint(rest[0], 10, 32)
+		if err != nil {
+			return "", err
+		}
+		return a.Decode(args[1], uint32(msgID), rest[1])
+	default:
+		return "", fmt.Errorf("unknown command %q, type help for usage", args[0])
+	}
+}
+
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,129 @@
+package zcapture
+
+import (
+	"os"
+	"server/ziface"
+	"server/znet"
+	"sync"
+	"time"
+)
+
+/*
+	抓包：订阅Server的事件总线，记录每个连接的开始、停止和收发的业务消息
+	系统消息(会话恢复、心跳等)由框架处理，不记录
+*/
+
+//缓冲的记录写入文件的间隔
+const flushInterval = time.Second
+
+type Capture struct {
+	server ziface.IServer
+	subID  int
+	file   *os.File
+	writer *Writer
+	lock   sync.Mutex
+	//写文件出错或者已经停止之后不再记录
+	err      error
+	stopped  bool
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+//开始抓包，记录写入path，文件已经存在时覆盖
+func Start(server ziface.IServer, path string) (*Capture, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := NewWriter(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	c := &Capture{
+		server: server,
+		file:   file,
+		writer: writer,
+		done:   make(chan struct{}),
+	}
+	c.subID = server.GetEventBus().Subscribe(c.onEvent,
+		ziface.EventConnStarted, ziface.EventConnStopped, ziface.EventMsgReceived, ziface.EventMsgSent)
+	go c.flushLoop()
+	server.GetLogger().Info("[Capture] capturing traffic to ", path)
+	return c, nil
+}
+
+func (c *Capture) onEvent(event *ziface.Event) {
+	record := Record{
+		Time:   event.Time,
+		ConnID: event.Conn.GetConnID(),
+		MsgID:  event.MsgID,
+		Data:   event.Data,
+	}
+	switch event.Type {
+	case ziface.EventConnStarted:
+		record.Dir = DirOpen
+	case ziface.EventConnStopped:
+		record.Dir = DirClose
+		record.Data = []byte(event.Reason)
+	case ziface.EventMsgReceived:
+		record.Dir = DirIn
+	case ziface.EventMsgSent:
+		if znet.IsSysMsgID(event.MsgID) {
+			return
+		}
+		record.Dir = DirOut
+	}
+	c.write(record)
+}
+
+func (c *Capture) write(record Record) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.err != nil || c.stopped {
+		return
+	}
+	if c.err = c.writer.Write(record); c.err != nil {
+		c.server.GetLogger().Error("[Capture] write capture file err ", c.err, ", capture stopped")
+	}
+}
+
+//定时将缓冲的记录写入文件，进程异常退出时最多丢失flushInterval内的记录
+func (c *Capture) flushLoop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.lock.Lock()
+			if c.err == nil {
+				c.err = c.writer.Flush()
+			}
+			c.lock.Unlock()
+		}
+	}
+}
+
+//停止抓包，将剩余的记录写入文件并关闭文件
+func (c *Capture) Stop() error {
+	c.stopOnce.Do(func() {
+		c.server.GetEventBus().Unsubscribe(c.subID)
+		close(c.done)
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		c.stopped = true
+		if c.err == nil {
+			c.err = c.writer.Flush()
+		}
+		if err := c.file.Close(); c.err == nil {
+			c.err = err
+		}
+	})
+	return c.err
+}
@@ -0,0 +1,213 @@
+package zcapture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+/*
+	抓包文件格式：
+	文件头为5字节 "ZCAP" + 版本号，之后是连续的记录，每条记录为：
+		时间戳    varint  与上一条记录的时间差(纳秒)，第一条记录为UnixNano
+		ConnID   uvarint
+		方向      1字节
+		msgID    uvarint
+		数据长度  uvarint
+		数据
+*/
+
+const (
+	fileMagic   = "ZCAP"
+	fileVersion = 1
+	//单条记录数据的最大长度，防止读取损坏的文件时申请过大的内存
+	maxRecordDataLen = 64 << 20
+)
+
+//记录的方向
+type Direction byte
+
+const (
+	//客户端发给服务端的消息
+	DirIn Direction = iota + 1
+	//服务端发给客户端的消息
+	DirOut
+	//连接开始工作
+	DirOpen
+	//连接停止，Data为停止原因
+	DirClose
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirIn:
+		return "in"
+	case DirOut:
+		return "out"
+	case DirOpen:
+		return "open"
+	case DirClose:
+		return "close"
+	}
+	return "unknown"
+}
+
+//一条抓包记录
+type Record struct {
+	Time   time.Time
+	ConnID uint64
+	Dir    Direction
+	MsgID  uint32
+	Data   []byte
+}
+
+func (r Record) String() string {
+	return fmt.Sprintf("%s ConnID=%d %s msgId=%d len=%d", r.Time.Format("15:04:05.000000"), r.ConnID, r.Dir, r.MsgID, len(r.Data))
+}
+
+//写抓包文件
+type Writer struct {
+	w        *bufio.Writer
+	lastTime int64
+	buf      [binary.MaxVarintLen64]byte
+}
+
+//创建抓包文件的Writer，并写入文件头
+func NewWriter(w io.Writer) (*Writer, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(fileMagic); err != nil {
+		return nil, err
+	}
+	if err := bw.WriteByte(fileVersion); err != nil {
+		return nil, err
+	}
+	return &Writer{w: bw}, nil
+}
+
+//写入一条记录
+func (w *Writer) Write(r Record) error {
+	ts := r.Time.UnixNano()
+	w.putVarint(ts - w.lastTime)
+	w.lastTime = ts
+	w.putUvarint(r.ConnID)
+	_ = w.w.WriteByte(byte(r.Dir))
+	w.putUvarint(uint64(r.MsgID))
+	w.putUvarint(uint64(len(r.Data)))
+	_, err := w.w.Write(r.Data)
+	return err
+}
+
+func (w *Writer) putVarint(v int64) {
+	n := binary.PutVarint(w.buf[:], v)
+	_, _ = w.w.Write(w.buf[:n])
+}
+
+func (w *Writer) putUvarint(v uint64) {
+	n := binary.PutUvarint(w.buf[:], v)
+	_, _ = w.w.Write(w.buf[:n])
+}
+
+//将缓冲的记录写入底层的io.Writer
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+//读抓包文件
+type Reader struct {
+	r        *bufio.Reader
+	lastTime int64
+}
+
+//创建抓包文件的Reader，并校验文件头
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	head := make([]byte, len(fileMagic)+1)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, err
+	}
+	if string(head[:len(fileMagic)]) != fileMagic {
+		return nil, errors.New("not a capture file")
+	}
+	if head[len(fileMagic)] != fileVersion {
+		return nil, fmt.Errorf("unsupported capture file version %d", head[len(fileMagic)])
+	}
+	return &Reader{r: br}, nil
+}
+
+//读取下一条记录，读完时返回io.EOF
+func (r *Reader) Next() (Record, error) {
+	var record Record
+	delta, err := binary.ReadVarint(r.r)
+	if err != nil {
+		return record, err
+	}
+	r.lastTime += delta
+	record.Time = time.Unix(0, r.lastTime)
+
+	if record.ConnID, err = binary.ReadUvarint(r.r); err != nil {
+		return record, unexpectedEOF(err)
+	}
+	dir, err := r.r.ReadByte()
+	if err != nil {
+		return record, unexpectedEOF(err)
+	}
+	record.Dir = Direction(dir)
+	msgID, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return record, unexpectedEOF(err)
+	}
+	record.MsgID = uint32(msgID)
+	dataLen, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return record, unexpectedEOF(err)
+	}
+	if dataLen > maxRecordDataLen {
+		return record, fmt.Errorf("record data len %d too large", dataLen)
+	}
+	record.Data = make([]byte, dataLen)
+	if _, err := io.ReadFull(r.r, record.Data); err != nil {
+		return record, unexpectedEOF(err)
+	}
+	return record, nil
+}
+
+//记录读到一半时文件结束，说明文件被截断
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+//读取全部记录
+func ReadAll(r io.Reader) ([]Record, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
+
+//读取抓包文件中的全部记录
+func ReadFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadAll(f)
+}
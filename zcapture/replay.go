@@ -0,0 +1,282 @@
+package zcapture
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"server/ziface"
+	"server/znet"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+	回放：按抓包中每个连接发送消息的时间，用新的TCP连接把客户端消息重新发给测试服务器，
+	并将服务器的回复和抓包中的回复按顺序逐条比较
+*/
+
+//回放参数
+type ReplayOptions struct {
+	//回放速度倍数，1为原速，2为两倍速，0表示不等待，尽快发送
+	Speed float64
+	//发送完最后一条消息之后等待服务器回复的时间，默认1秒
+	Drain time.Duration
+	//是否比较回复的数据，false时只比较msgID
+	CompareData bool
+	//封包拆包方式，默认使用znet.NewDataPack()
+	Packer ziface.IDataPack
+	//超过该长度的消息拆成分片发送，与测试服务器的MaxPacketSize一致，0表示不分片
+	MaxPacketSize uint32
+}
+
+//重组测试服务器回复的分片时，单条消息的最大长度和超时时间
+const (
+	replayMaxMsgSize      = 64 << 20
+	replayFragmentTimeout = time.Minute
+)
+
+//回复不一致
+type Mismatch struct {
+	ConnID uint64
+	//回复的序号
+	Index int
+	//抓包中的回复和测试服务器的回复，缺少时为nil
+	Want, Got *Record
+}
+
+func (m Mismatch) String() string {
+	describe := func(r *Record) string {
+		if r == nil {
+			return "<none>"
+		}
+		return fmt.Sprintf("msgId=%d len=%d", r.MsgID, len(r.Data))
+	}
+	return fmt.Sprintf("ConnID=%d reply #%d: want %s, got %s", m.ConnID, m.Index, describe(m.Want), describe(m.Got))
+}
+
+//回放结果
+type ReplayReport struct {
+	//回放的连接数
+	Conns int
+	//发送的消息数、抓包中的回复数、测试服务器的回复数
+	Sent, Expected, Received int
+	Mismatches               []Mismatch
+}
+
+//回放是否与抓包一致
+func (r *ReplayReport) OK() bool {
+	return len(r.Mismatches) == 0
+}
+
+//一个连接需要回放的记录
+type replayConn struct {
+	connID uint64
+	//客户端发送的消息
+	in []Record
+	//抓包中服务端的回复
+	out []Record
+	//连接开始、停止的时间
+	open, close time.Time
+}
+
+//将抓包记录按连接分组，没有客户端消息的连接不需要回放
+func groupByConn(records []Record) []*replayConn {
+	conns := make(map[uint64]*replayConn)
+	var order []*replayConn
+	for _, record := range records {
+		rc, ok := conns[record.ConnID]
+		if !ok {
+			rc = &replayConn{connID: record.ConnID, open: record.Time}
+			conns[record.ConnID] = rc
+			order = append(order, rc)
+		}
+		switch record.Dir {
+		case DirIn:
+			rc.in = append(rc.in, record)
+		case DirOut:
+			rc.out = append(rc.out, record)
+		case DirOpen:
+			rc.open = record.Time
+		case DirClose:
+			rc.close = record.Time
+		}
+	}
+
+	result := make([]*replayConn, 0, len(order))
+	for _, rc := range order {
+		if len(rc.in) > 0 {
+			result = append(result, rc)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].open.Before(result[j].open) })
+	return result
+}
+
+//回放抓包记录，addr为测试服务器地址
+func Replay(records []Record, addr string, opts ReplayOptions) (*ReplayReport, error) {
+	if opts.Drain <= 0 {
+		opts.Drain = time.Second
+	}
+	if opts.Packer == nil {
+		opts.Packer = znet.NewDataPack()
+	}
+	conns := groupByConn(records)
+	report := &ReplayReport{Conns: len(conns)}
+	if len(conns) == 0 {
+		return report, nil
+	}
+
+	player := &replayer{
+		addr:  addr,
+		opts:  opts,
+		base:  conns[0].open,
+		start: time.Now(),
+	}
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var firstErr error
+	for _, rc := range conns {
+		wg.Add(1)
+		go func(rc *replayConn) {
+			defer wg.Done()
+			got, err := player.play(rc)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			report.Sent += len(rc.in)
+			report.Expected += len(rc.out)
+			report.Received += len(got)
+			report.Mismatches = append(report.Mismatches, compare(rc.connID, rc.out, got, opts.CompareData)...)
+		}(rc)
+	}
+	wg.Wait()
+
+	sort.SliceStable(report.Mismatches, func(i, j int) bool {
+		return report.Mismatches[i].ConnID < report.Mismatches[j].ConnID
+	})
+	return report, firstErr
+}
+
+type replayer struct {
+	addr string
+	opts ReplayOptions
+	//抓包中第一个连接开始的时间和回放开始的时间，用于换算每条记录的回放时间
+	base, start time.Time
+}
+
+//等待到记录的回放时间
+func (p *replayer) waitUntil(t time.Time) {
+	if p.opts.Speed <= 0 {
+		return
+	}
+	offset := time.Duration(float64(t.Sub(p.base)) / p.opts.Speed)
+	if d := time.Until(p.start.Add(offset)); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+//回放一个连接，返回测试服务器的回复
+func (p *replayer) play(rc *replayConn) ([]Record, error) {
+	p.waitUntil(rc.open)
+	conn, err := net.Dial("tcp", p.addr)
+	if err != nil {
+		return nil, fmt.Errorf("replay ConnID = %d: %v", rc.connID, err)
+	}
+
+	var got []Record
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		got = p.readReplies(conn)
+	}()
+
+sending:
+	for i, record := range rc.in {
+		p.waitUntil(record.Time)
+		frames, err := znet.PackFragments(p.opts.Packer, record.MsgID, record.Data, p.opts.MaxPacketSize, uint32(i+1))
+		if err != nil {
+			conn.Close()
+			<-done
+			return nil, err
+		}
+		for _, frame := range frames {
+			if _, err := conn.Write(frame); err != nil {
+				//服务端主动断开，停止发送，比较已经收到的回复
+				break sending
+			}
+		}
+	}
+	if !rc.close.IsZero() {
+		p.waitUntil(rc.close)
+	}
+	time.Sleep(p.opts.Drain)
+	conn.Close()
+	<-done
+	return got, nil
+}
+
+//读取测试服务器的回复直到连接关闭，重组分片，忽略其他系统消息
+func (p *replayer) readReplies(conn net.Conn) []Record {
+	var replies []Record
+	fragments := znet.NewReassembler(replayMaxMsgSize, replayMaxMsgSize, replayFragmentTimeout)
+	headData := make([]byte, p.opts.Packer.GetHeadLen())
+	for {
+		if _, err := io.ReadFull(conn, headData); err != nil {
+			return replies
+		}
+		msg, err := p.opts.Packer.UnPack(headData)
+		if err != nil {
+			return replies
+		}
+		data := make([]byte, msg.GetDataLen())
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return replies
+		}
+		if msg.GetMsgID() == znet.MsgIDFragment {
+			full, err := fragments.Add(data)
+			if err != nil {
+				return replies
+			}
+			if full == nil {
+				continue
+			}
+			msg, data = full, full.GetData()
+		}
+		if znet.IsSysMsgID(msg.GetMsgID()) {
+			continue
+		}
+		replies = append(replies, Record{Time: time.Now(), Dir: DirOut, MsgID: msg.GetMsgID(), Data: data})
+	}
+}
+
+//按顺序逐条比较回复
+func compare(connID uint64, want, got []Record, compareData bool) []Mismatch {
+	var mismatches []Mismatch
+	n := len(want)
+	if len(got) > n {
+		n = len(got)
+	}
+	for i := 0; i < n; i++ {
+		m := Mismatch{ConnID: connID, Index: i}
+		if i < len(want) {
+			m.Want = &want[i]
+		}
+		if i < len(got) {
+			m.Got = &got[i]
+		}
+		if m.Want != nil && m.Got != nil && m.Want.MsgID == m.Got.MsgID &&
+			(!compareData || bytes.Equal(m.Want.Data, m.Got.Data)) {
+			continue
+		}
+		mismatches = append(mismatches, m)
+	}
+	return mismatches
+}
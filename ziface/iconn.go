@@ -1,6 +1,10 @@
 package ziface
 
-import "net"
+import (
+	"context"
+	"net"
+	"time"
+)
 
 //定义连接接口
 type IConn interface {
@@ -8,10 +12,12 @@ type IConn interface {
 	Start()
 	//停止连接，结束当前连接状态
 	Stop()
-	//从当前连接获取原始的socket
+	//从当前连接获取原始的socket，不是TCP连接时返回nil
 	GetTCPConn() *net.TCPConn
 	//获取当前连接ID
-	GetConnID() uint32
+	GetConnID() uint64
+	//获取连接的Context，连接停止时被取消
+	Context() context.Context
 	//获取远程客户端地址信息
 	RemoteAddr() net.Addr
 	//直接将Msg数据发送给远程的TCP客户端（无缓冲）
@@ -20,8 +26,29 @@ type IConn interface {
 	SendBuffMsg(msgID uint32, data []byte) error
 	//设置连接属性
 	SetProperty(key string, value interface{})
+	//设置有效期为ttl的连接属性，过期后自动删除
+	SetPropertyWithTTL(key string, value interface{}, ttl time.Duration)
 	//获取连接属性
 	GetProperty(key string) (interface{}, error)
+	//获取带类型的连接属性，属性不存在或者类型不一致时返回error
+	GetPropertyInt(key string) (int, error)
+	GetPropertyInt32(key string) (int32, error)
+	GetPropertyInt64(key string) (int64, error)
+	GetPropertyUint32(key string) (uint32, error)
+	GetPropertyUint64(key string) (uint64, error)
+	GetPropertyFloat64(key string) (float64, error)
+	GetPropertyString(key string) (string, error)
+	GetPropertyBool(key string) (bool, error)
 	//删除连接属性
 	DelProperty(key string)
+	//获取全部连接属性的拷贝
+	GetProperties() map[string]interface{}
+	//获取连接的统计信息
+	Stats() ConnStats
+	//获取连接握手协商的结果，没有开启握手时为零值
+	Handshake() Handshake
+	//标记连接已经通过认证，之后可以发送全部msgID
+	SetAuthenticated()
+	//连接是否已经通过认证，没有开启RequireAuth时总是true
+	IsAuthenticated() bool
 }
@@ -0,0 +1,7 @@
+package ziface
+
+//连接ID生成器抽象层
+type IConnIDGenerator interface {
+	//生成一个新的连接ID，connMgr为当前Server的连接管理器，用于跳过仍在使用中的ID
+	NextConnID(connMgr IConnMgr) (uint64, error)
+}
@@ -7,9 +7,13 @@ type IConnMgr interface {
 	//删除连接
 	Del(conn IConn)
 	//通过connID获取连接
-	Get(connID uint32) (IConn, error)
+	Get(connID uint64) (IConn, error)
 	//获取当前连接个数
 	Len() int
+	//获取当前全部连接
+	GetAllConns() []IConn
 	//删除并停止所有连接
 	ClearConn()
+	//汇总全部连接的统计信息
+	Stats() ConnMgrStats
 }
@@ -0,0 +1,34 @@
+package ziface
+
+import "time"
+
+//连接的统计信息
+type ConnStats struct {
+	ConnID uint64
+	//收发的字节数，包含消息头和系统消息
+	BytesIn, BytesOut uint64
+	//收发的业务消息帧数量，不包含系统消息，分片发送的大消息每个分片计一次
+	MsgsIn, MsgsOut uint64
+	//连接建立的时间和已经建立的时长
+	StartTime time.Time
+	Age       time.Duration
+	//最后一次读取、写出数据的时间，还没有读写过时为零值
+	LastReadTime, LastWriteTime time.Time
+	//发送缓冲中等待写出的消息数量
+	SendQueueLen int
+	//平滑后的往返时延，还没有测量过时为0
+	RTT time.Duration
+}
+
+//全部连接的汇总统计信息
+type ConnMgrStats struct {
+	//连接数量
+	Conns int
+	//全部连接收发的字节数和业务消息数量之和
+	BytesIn, BytesOut uint64
+	MsgsIn, MsgsOut   uint64
+	//全部连接发送缓冲中等待写出的消息数量之和
+	SendQueueLen int
+	//已经测量过RTT的连接的平均RTT和最大RTT
+	AvgRTT, MaxRTT time.Duration
+}
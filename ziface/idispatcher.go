@@ -0,0 +1,15 @@
+package ziface
+
+//worker工作池的负载信息，供消息分配策略选择worker
+type IWorkerPool interface {
+	//worker槽位的数量，扩缩容时不变，按连接取模分配的请求总是落在同一个槽位
+	Size() int
+	//指定槽位的任务队列中正在排队的任务数量
+	QueueLen(workerID int) int
+}
+
+//消息分配策略：决定一个请求交给worker工作池中的哪个worker处理
+type IDispatcher interface {
+	//返回处理该请求的workerID
+	Dispatch(request IRequest, pool IWorkerPool) int
+}
@@ -0,0 +1,88 @@
+package ziface
+
+import (
+	"net"
+	"time"
+)
+
+//事件类型
+type EventType int
+
+const (
+	//接受了一个新连接
+	EventConnAccepted EventType = iota
+	//拒绝了一个新连接(连接数超过上限等)，此时Conn为nil
+	EventConnRejected
+	//连接开始工作，OnConnStart或者OnSessionResume已经调用
+	EventConnStarted
+	//连接停止，Reason为停止原因
+	EventConnStopped
+	//收到一条业务消息
+	EventMsgReceived
+	//发送一条消息
+	EventMsgSent
+	//客户端数据解包失败
+	EventDecodeError
+	//业务处理方法panic，Panic为panic的值
+	EventHandlerPanic
+	//消息被拒绝处理(如未认证的连接发送了登录以外的消息)，Reason为拒绝原因
+	EventMsgRejected
+	//一条业务消息处理完成(包括没有找到路由、被拒绝和处理方法panic)，处理方法中发送的消息已经交给连接
+	EventMsgHandled
+
+	EventTypeCount
+)
+
+var eventTypeNames = [...]string{
+	EventConnAccepted: "conn_accepted",
+	EventConnRejected: "conn_rejected",
+	EventConnStarted:  "conn_started",
+	EventConnStopped:  "conn_stopped",
+	EventMsgReceived:  "msg_received",
+	EventMsgSent:      "msg_sent",
+	EventDecodeError:  "decode_error",
+	EventHandlerPanic: "handler_panic",
+	EventMsgRejected:  "msg_rejected",
+	EventMsgHandled:   "msg_handled",
+}
+
+func (t EventType) String() string {
+	if t >= 0 && t < EventTypeCount {
+		return eventTypeNames[t]
+	}
+	return "unknown"
+}
+
+//事件，不同类型的事件只填写相关的字段
+type Event struct {
+	Type EventType
+	Time time.Time
+	//相关的连接，EventConnRejected时为nil
+	Conn       IConn
+	RemoteAddr net.Addr
+	//消息相关事件的msgID和数据
+	MsgID uint32
+	Data  []byte
+	//连接被拒绝、停止的原因
+	Reason string
+	//解包错误
+	Err error
+	//业务处理方法panic的值和调用栈
+	Panic interface{}
+	Stack string
+}
+
+//事件处理方法，在产生事件的goroutine中同步调用，不能阻塞
+type EventHandler func(event *Event)
+
+//事件总线抽象层
+type IEventBus interface {
+	//订阅事件，types为空时订阅全部类型，返回订阅ID用于取消订阅
+	Subscribe(handler EventHandler, types ...EventType) int
+	//取消订阅
+	Unsubscribe(id int)
+	//发布事件
+	Publish(event *Event)
+	//是否有订阅者关注该类型的事件，没有时可以省去构造事件的开销
+	HasSubscribers(t EventType) bool
+}
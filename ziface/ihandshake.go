@@ -0,0 +1,16 @@
+package ziface
+
+//连接建立时握手协商的结果
+type Handshake struct {
+	//客户端的协议版本号
+	ProtocolVersion uint32
+	//客户端的构建版本
+	ClientBuild string
+	//双方都支持的能力位
+	Capabilities uint32
+}
+
+//是否协商了能力cap
+func (h Handshake) Has(cap uint32) bool {
+	return h.Capabilities&cap == cap
+}
@@ -6,8 +6,24 @@ type IMsgHandle interface {
 	DoMsgHandler(request IRequest)
 	//为消息添加具体的处理逻辑
 	AddRouter(msgID uint32, router IRouter)
+	//为msgID区间[min, max]添加处理逻辑，区间内单独注册过的msgID优先使用自己的处理方法
+	AddRouterRange(min, max uint32, router IRouter)
 	//启动worker工作池
 	StartWorkerPool()
+	//停止worker工作池
+	StopWorkerPool()
 	//将消息交给TaskQueue,由worker进行处理
 	SendMsgToTaskQueue(request IRequest)
+	//获取每个worker槽位的任务队列当前排队的任务数量
+	GetTaskQueueLens() []int
+	//设置默认的消息分配策略
+	SetDispatcher(dispatcher IDispatcher)
+	//为指定msgID设置消息分配策略，优先于默认策略
+	SetMsgDispatcher(msgID uint32, dispatcher IDispatcher)
+	//设置msgID的优先级，worker优先处理高优先级的消息
+	SetMsgPriority(msgID uint32, priority int)
+	//将msgID标记为可丢弃的低优先级消息，Worker池过载时直接拒绝而不是阻塞等待
+	SetSheddable(msgID uint32)
+	//设置未认证的连接可以发送的msgID，开启RequireAuth时其他msgID在认证之前被拒绝
+	SetLoginMsgIDs(msgIDs ...uint32)
 }
@@ -0,0 +1,60 @@
+package ziface
+
+//注册到注册中心的节点信息
+type NodeInfo struct {
+	//节点ID，集群内唯一
+	ID string `json:"id"`
+	//节点类型，如game、gateway
+	Kind string `json:"kind"`
+	//节点对外服务的地址(host:port)
+	Addr string `json:"addr"`
+	//节点当前的负载，如连接数，数值越小越空闲
+	Load int `json:"load"`
+	//节点支持的能力，如chat、rpc
+	Capabilities []string `json:"capabilities,omitempty"`
+	//业务自定义的附加信息
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+//节点是否支持该能力
+func (n *NodeInfo) HasCapability(capability string) bool {
+	for _, c := range n.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+//节点变更事件的类型
+type NodeEventType int
+
+const (
+	//发现了一个新节点
+	NodeAdded NodeEventType = iota
+	//节点的信息或负载发生变化
+	NodeUpdated
+	//节点注销或者租约过期
+	NodeRemoved
+)
+
+func (t NodeEventType) String() string {
+	switch t {
+	case NodeAdded:
+		return "added"
+	case NodeUpdated:
+		return "updated"
+	case NodeRemoved:
+		return "removed"
+	}
+	return "unknown"
+}
+
+//节点变更事件，NodeRemoved时Node为节点被删除前的信息
+type NodeEvent struct {
+	Type NodeEventType
+	Node NodeInfo
+}
+
+//节点变更的处理方法，在读取注册中心消息的goroutine中同步调用，不能阻塞
+type NodeWatcher func(event NodeEvent)
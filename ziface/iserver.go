@@ -1,5 +1,7 @@
 package ziface
 
+import "server/zlog"
+
 //定义服务器接口
 type IServer interface {
 	//启动服务器
@@ -10,14 +12,30 @@ type IServer interface {
 	Serve()
 	//得到连接管理
 	GetConnMgr() IConnMgr
+	//得到消息管理
+	GetMsgHandler() IMsgHandle
+	//得到日志对象
+	GetLogger() *zlog.Logger
+	//得到封包拆包方式
+	GetPacker() IDataPack
+	//得到事件总线，插件通过订阅事件感知连接生命周期和消息收发
+	GetEventBus() IEventBus
 	//设置该Server的连接创建时Hook函数
 	SetOnConnStart(func(IConn))
 	//设置该Server的连接断开时的Hook函数
 	SetOnConnStop(func(IConn))
+	//设置该Server的连接恢复会话时的Hook函数，恢复会话时代替OnConnStart调用
+	SetOnSessionResume(func(IConn))
+	//设置连接属性变更时的Hook函数，删除和过期时newValue为nil
+	SetOnPropertyChange(func(conn IConn, key string, oldValue, newValue interface{}))
 	//调用连接OnConnStart Hook函数
 	CallOnConnStart(conn IConn)
 	//调用连接OnConnStop Hook函数
 	CallOnConnStop(conn IConn)
+	//调用连接OnSessionResume Hook函数
+	CallOnSessionResume(conn IConn)
+	//调用连接属性变更Hook函数
+	CallOnPropertyChange(conn IConn, key string, oldValue, newValue interface{})
 	//路由功能：给当前服务注册一个路由业务方法，共客户端连接处理使用
 	AddRouter(msgID uint32, router IRouter)
 }
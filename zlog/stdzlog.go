@@ -1,6 +1,9 @@
 package zlog
 
-import "os"
+import (
+	"fmt"
+	"os"
+)
 
 //全局默认提供一个log对外句柄，可以直接使用API系列调用
 //全局日志对象 StdLog
@@ -41,67 +44,86 @@ func OpenDebug() {
 	StdLog.OpenDebug()
 }
 
+//设置StdLog允许输出的最低日志级别
+func SetLevel(level int) {
+	StdLog.SetLevel(level)
+}
+
+//获取StdLog当前允许输出的最低日志级别
+func Level() int {
+	return StdLog.Level()
+}
+
+//StdLog的包级方法比直接调用Logger方法多一层调用，所以这里直接以calledDepth=2调用outPutDepth，
+//保证打印出的调用文件名和行数是调用zlog包级方法的代码
+
 // ====> Debug <====
 func Debugf(format string, v ...interface{}) {
-	StdLog.Debugf(format, v...)
+	if StdLog.isDebugClosed() {
+		return
+	}
+	_ = StdLog.outPutDepth(2, LogDebug, fmt.Sprintf(format, v...))
 }
 
 func Debug(v ...interface{}) {
-	StdLog.Debug(v...)
+	if StdLog.isDebugClosed() {
+		return
+	}
+	_ = StdLog.outPutDepth(2, LogDebug, fmt.Sprintln(v...))
 }
 
 // ====> Info <====
 func Infof(format string, v ...interface{}) {
-	StdLog.Infof(format, v...)
+	_ = StdLog.outPutDepth(2, LogInfo, fmt.Sprintf(format, v...))
 }
 
 func Info(v ...interface{}) {
-	StdLog.Info(v...)
+	_ = StdLog.outPutDepth(2, LogInfo, fmt.Sprintln(v...))
 }
 
 // ====> Warn <====
 func Warnf(format string, v ...interface{}) {
-	StdLog.Warnf(format, v...)
+	_ = StdLog.outPutDepth(2, LogWarn, fmt.Sprintf(format, v...))
 }
 
 func Warn(v ...interface{}) {
-	StdLog.Warn(v...)
+	_ = StdLog.outPutDepth(2, LogWarn, fmt.Sprintln(v...))
 }
 
 // ====> Error <====
 func Errorf(format string, v ...interface{}) {
-	StdLog.Errorf(format, v...)
+	_ = StdLog.outPutDepth(2, LogError, fmt.Sprintf(format, v...))
 }
 
 func Error(v ...interface{}) {
-	StdLog.Error(v...)
+	_ = StdLog.outPutDepth(2, LogError, fmt.Sprintln(v...))
 }
 
 // ====> Panic  <====
 func Panicf(format string, v ...interface{}) {
-	StdLog.Panicf(format, v...)
+	s := fmt.Sprintf(format, v...)
+	_ = StdLog.outPutDepth(2, LogPanic, s)
+	panic(s)
 }
 
 func Panic(v ...interface{}) {
-	StdLog.Panic(v...)
+	s := fmt.Sprintln(v...)
+	_ = StdLog.outPutDepth(2, LogPanic, s)
+	panic(s)
 }
 
 // ====> Fatal 需要终止程序 <====
 func Fatalf(format string, v ...interface{}) {
-	StdLog.Fatalf(format, v...)
+	_ = StdLog.outPutDepth(2, LogFatal, fmt.Sprintf(format, v...))
+	os.Exit(1)
 }
 
 func Fatal(v ...interface{}) {
-	StdLog.Fatal(v...)
+	_ = StdLog.outPutDepth(2, LogFatal, fmt.Sprintln(v...))
+	os.Exit(1)
 }
 
 // ====> Stack  <====
 func Stack(v ...interface{}) {
-	StdLog.Stack(v...)
-}
-
-func init() {
-	//因为StdLog对象 对所有输出方法做了一层包裹，所以在打印调用函数的时候，比正常的logger对象多一层调用
-	//所以一般的Logger对象 calledDepth=2, StdLog的calldDepth=3
-	StdLog.calledDepth = 3
+	_ = StdLog.outPutDepth(2, LogError, stackString(v...))
 }
@@ -6,7 +6,9 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -61,8 +63,10 @@ type Logger struct {
 	buf bytes.Buffer
 	//当前日志绑定的输出文件
 	file *os.File
-	//是否打印调试debug信息
-	debugClose bool
+	//是否关闭调试debug信息，1为关闭，配置重载时会在运行中修改，通过atomic读写
+	debugClose int32
+	//当前允许输出的最低日志级别
+	level int
 	//获取日志文件名和代码上述的rutime.call的函数调用层数
 	calledDepth int
 }
@@ -80,7 +84,7 @@ func NewLogger(out io.Writer, prefix string, flag int) *Logger {
 		prefix:      prefix,
 		flag:        flag,
 		file:        nil,
-		debugClose:  false,
+		level:       LogDebug,
 		calledDepth: 2,
 	}
 	//设置log对象：回收资源 析构方法（不设置也可以，go的gc会自动回收，强迫症）
@@ -160,17 +164,28 @@ func (log *Logger) formatHeader(buf *bytes.Buffer, t time.Time, file string, lin
 
 //输出日志文件，原方法
 func (log *Logger) OutPut(level int, s string) error {
+	//多了一层outPutDepth调用
+	return log.outPutDepth(log.calledDepth+1, level, s)
+}
+
+//输出日志文件，calledDepth为调用日志接口的代码相对于本方法的调用层数
+func (log *Logger) outPutDepth(calledDepth int, level int, s string) error {
 	now := time.Now() // 得到当前时间
 	var file string   //当前调用日志接口的文件名称
 	var line int      //当前代码行数
 	log.mu.Lock()
 	defer log.mu.Unlock()
 
+	//低于当前日志级别的日志直接丢弃
+	if level < log.level {
+		return nil
+	}
+
 	if log.flag&(BitShortFile|BitLongFile) != 0 {
 		log.mu.Unlock()
 		var ok bool
 		//得到当前调用者的文件名称和执行到的代码行数
-		_, file, line, ok = runtime.Caller(log.calledDepth)
+		_, file, line, ok = runtime.Caller(calledDepth)
 		if !ok {
 			file = "unknown-file"
 			line = 0
@@ -199,14 +214,14 @@ func (log *Logger) OutPut(level int, s string) error {
 
 //Debug
 func (log *Logger) Debugf(format string, v ...interface{}) {
-	if log.debugClose == true {
+	if log.isDebugClosed() {
 		return
 	}
 	_ = log.OutPut(LogDebug, fmt.Sprintf(format, v...))
 }
 
 func (log *Logger) Debug(v ...interface{}) {
-	if log.debugClose == true {
+	if log.isDebugClosed() {
 		return
 	}
 	_ = log.OutPut(LogDebug, fmt.Sprintln(v...))
@@ -265,13 +280,18 @@ func (log *Logger) Fatal(v ...interface{}) {
 
 //Stack
 func (log *Logger) Stack(v ...interface{}) {
+	_ = log.OutPut(LogError, stackString(v...))
+}
+
+//拼接日志内容和当前堆栈信息
+func stackString(v ...interface{}) string {
 	s := fmt.Sprint(v...)
 	s += "\n"
 	buf := make([]byte, LOG_MAX_BUF)
 	n := runtime.Stack(buf, true) //得到当前堆栈信息
 	s += string(buf[:n])
 	s += "\n"
-	_ = log.OutPut(LogError, s)
+	return s
 }
 
 //获取当前日志bitmap标记
@@ -337,11 +357,48 @@ func (log *Logger) closeFile() {
 }
 
 func (log *Logger) CloseDebug() {
-	log.debugClose = true
+	atomic.StoreInt32(&log.debugClose, 1)
 }
 
 func (log *Logger) OpenDebug() {
-	log.debugClose = false
+	atomic.StoreInt32(&log.debugClose, 0)
+}
+
+//是否关闭了调试debug信息
+func (log *Logger) isDebugClosed() bool {
+	return atomic.LoadInt32(&log.debugClose) == 1
+}
+
+//设置允许输出的最低日志级别，低于该级别的日志将不再输出
+func (log *Logger) SetLevel(level int) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.level = level
+}
+
+//获取当前允许输出的最低日志级别
+func (log *Logger) Level() int {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	return log.level
+}
+
+//将日志级别名称(debug/info/warn/error/panic/fatal)转换为日志级别
+func ParseLevel(name string) (int, error) {
+	for i, l := range levels {
+		if strings.EqualFold("["+name+"]", l) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", name)
+}
+
+//将日志级别转换为名称
+func LevelName(level int) string {
+	if level < 0 || level >= len(levels) {
+		return "unknown"
+	}
+	return strings.ToLower(strings.Trim(levels[level], "[]"))
 }
 
 /*
@@ -0,0 +1,83 @@
+package znet
+
+import (
+	"encoding/binary"
+	"server/ziface"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	登录认证：开启RequireAuth时，新连接只能发送SetLoginMsgIDs指定的登录相关msgID，
+	登录路由调用conn.SetAuthenticated()之后才能发送其他msgID，
+	LoginTimeout内没有完成认证的连接被断开
+*/
+
+//标记连接已经通过认证
+func (c *Conn) SetAuthenticated() {
+	if !atomic.CompareAndSwapInt32(&c.authenticated, 0, 1) {
+		return
+	}
+	c.Lock()
+	if c.loginTimer != nil {
+		c.loginTimer.Stop()
+		c.loginTimer = nil
+	}
+	c.Unlock()
+	c.logger.Debug("ConnID = ", c.ConnID, " authenticated")
+}
+
+//连接是否已经通过认证，没有开启RequireAuth时总是true
+func (c *Conn) IsAuthenticated() bool {
+	return atomic.LoadInt32(&c.authenticated) == 1
+}
+
+//开始登录超时计时，超时仍未认证时断开连接
+func (c *Conn) startLoginTimer() {
+	if c.IsAuthenticated() {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.isClosed || c.loginTimer != nil {
+		return
+	}
+	c.loginTimer = c.clock.AfterFunc(time.Duration(c.config.LoginTimeout)*time.Millisecond, func() {
+		if !c.IsAuthenticated() {
+			c.logger.Info("ConnID = ", c.ConnID, " login timeout")
+			c.stop(false, "login timeout")
+		}
+	})
+}
+
+//设置未认证的连接可以发送的msgID，如登录、注册等
+func (mh *MsgHandle) SetLoginMsgIDs(msgIDs ...uint32) {
+	for _, msgID := range msgIDs {
+		mh.loginMsgIDs[msgID] = true
+	}
+}
+
+//连接是否可以发送该消息，在处理消息时检查，登录消息之后紧跟的消息在登录处理完成之后才检查
+func (mh *MsgHandle) authorized(request ziface.IRequest) bool {
+	return mh.loginMsgIDs[request.GetMsgID()] || request.GetConn().IsAuthenticated()
+}
+
+//拒绝未认证连接的消息，如果配置了UnauthenticatedMsgID则告知客户端被拒绝的msgID
+func (mh *MsgHandle) rejectUnauthenticated(request ziface.IRequest) {
+	mh.logger.Warn("unauthenticated ConnID = ", request.GetConn().GetConnID(), " send msgId = ", request.GetMsgID(), ", rejected")
+	mh.events.Publish(&ziface.Event{
+		Type:   ziface.EventMsgRejected,
+		Conn:   request.GetConn(),
+		MsgID:  request.GetMsgID(),
+		Data:   request.GetData(),
+		Reason: "unauthenticated",
+	})
+	if mh.unauthMsgID == 0 {
+		return
+	}
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, request.GetMsgID())
+	if err := request.GetConn().SendBuffMsg(mh.unauthMsgID, data); err != nil {
+		mh.logger.Warn("send unauthenticated msg to ConnID = ", request.GetConn().GetConnID(), " err ", err)
+	}
+}
@@ -0,0 +1,411 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"net"
+	"server/utils"
+	"server/ziface"
+	"server/zlog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	后端节点：接受网关的内部连接，处理网关转发来的客户端消息
+	路由中拿到的conn代表网关上的客户端连接，SendMsg/SendBuffMsg通过网关推送给客户端，
+	Stop通知网关断开客户端连接，SetAuthenticated通知网关该客户端已经通过认证
+	连接属性只保存在后端节点本地，客户端断开或者网关断开时释放
+*/
+
+var errBackendConnClosed = errors.New("client connection closed")
+
+type Backend struct {
+	//监听网关连接的地址
+	addr   string
+	config *utils.GlobalObj
+	logger *zlog.Logger
+	//处理转发来的消息，开启Worker池时按ConnID分配worker
+	msgHandler *MsgHandle
+	//客户端连接断开时的Hook函数
+	onConnClosed func(conn ziface.IConn)
+	listener     net.Listener
+	//全部网关的内部连接
+	links map[*gatewayLink]bool
+	//Backend停止时关闭
+	exitChan chan struct{}
+	lock     sync.Mutex
+}
+
+//创建后端节点，conf为nil时使用全局配置
+func NewBackend(addr string, conf *utils.GlobalObj) *Backend {
+	if conf == nil {
+		conf = utils.GlobalObject
+	}
+	return &Backend{
+		addr:       addr,
+		config:     conf,
+		logger:     zlog.StdLog,
+		msgHandler: newMsgHandle(conf, zlog.StdLog, NewEventBus(zlog.StdLog)),
+		links:      make(map[*gatewayLink]bool),
+		exitChan:   make(chan struct{}),
+	}
+}
+
+//为消息添加具体的处理逻辑
+func (b *Backend) AddRouter(msgID uint32, router ziface.IRouter) {
+	b.msgHandler.AddRouter(msgID, router)
+}
+
+//得到消息管理
+func (b *Backend) GetMsgHandler() ziface.IMsgHandle {
+	return b.msgHandler
+}
+
+//设置客户端连接断开时的Hook函数，需要在Start之前设置
+func (b *Backend) SetOnConnClosed(hookFunc func(conn ziface.IConn)) {
+	b.onConnClosed = hookFunc
+}
+
+//开始监听网关的连接，监听失败时返回error
+func (b *Backend) Start() error {
+	listener, err := net.Listen("tcp", b.addr)
+	if err != nil {
+		return err
+	}
+	b.lock.Lock()
+	b.listener = listener
+	b.lock.Unlock()
+
+	b.msgHandler.StartWorkerPool()
+	b.logger.Info("[Backend] listening at ", listener.Addr().String())
+	go b.serve(listener)
+	return nil
+}
+
+//实际监听的地址，addr的端口为0时用于获取系统分配的端口
+func (b *Backend) Addr() net.Addr {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.listener == nil {
+		return nil
+	}
+	return b.listener.Addr()
+}
+
+//停止监听并断开全部网关
+func (b *Backend) Stop() {
+	b.lock.Lock()
+	select {
+	case <-b.exitChan:
+		b.lock.Unlock()
+		return
+	default:
+		close(b.exitChan)
+	}
+	if b.listener != nil {
+		b.listener.Close()
+	}
+	for link := range b.links {
+		link.conn.Close()
+	}
+	b.lock.Unlock()
+
+	b.msgHandler.StopWorkerPool()
+}
+
+func (b *Backend) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-b.exitChan:
+				return
+			default:
+			}
+			b.logger.Error("[Backend] accept err ", err)
+			continue
+		}
+
+		link := &gatewayLink{backend: b, conn: conn, conns: make(map[uint64]*backendConn)}
+		b.lock.Lock()
+		select {
+		case <-b.exitChan:
+			b.lock.Unlock()
+			conn.Close()
+			return
+		default:
+			b.links[link] = true
+		}
+		b.lock.Unlock()
+
+		b.logger.Info("[Backend] gateway ", conn.RemoteAddr().String(), " connected")
+		go link.serve()
+	}
+}
+
+//一个网关的内部连接
+type gatewayLink struct {
+	backend *Backend
+	conn    net.Conn
+	//保护写入，多个worker会同时向同一个网关推送消息
+	writeLock sync.Mutex
+	//该网关上的客户端连接，只在serve中访问
+	conns map[uint64]*backendConn
+}
+
+func (l *gatewayLink) serve() {
+	defer func() {
+		l.conn.Close()
+		l.backend.lock.Lock()
+		delete(l.backend.links, l)
+		l.backend.lock.Unlock()
+		//网关断开，其上的客户端连接全部视为断开
+		for _, bc := range l.conns {
+			l.closeConn(bc)
+		}
+		l.backend.logger.Warn("[Backend] gateway ", l.conn.RemoteAddr().String(), " disconnected")
+	}()
+
+	for {
+		f, err := readClusterFrame(l.conn)
+		if err != nil {
+			return
+		}
+		switch f.kind {
+		case clusterForward:
+			bc, ok := l.conns[f.connID]
+			if !ok {
+				bc = newBackendConn(l, f.connID)
+				l.conns[f.connID] = bc
+			}
+			if f.flags&clusterFlagAuthenticated != 0 {
+				atomic.StoreInt32(&bc.authenticated, 1)
+			}
+			bc.stats.onRead(clusterHeadLen+len(f.data), true)
+			l.dispatch(&Request{conn: bc, msg: NewMsgPackage(f.msgID, f.data)})
+		case clusterClosed:
+			if bc, ok := l.conns[f.connID]; ok {
+				delete(l.conns, f.connID)
+				l.closeConn(bc)
+			}
+		default:
+			l.backend.logger.Warn("[Backend] unknown cluster frame kind ", f.kind)
+		}
+	}
+}
+
+//开启Worker池时交给worker处理，否则在当前goroutine中按到达顺序处理
+func (l *gatewayLink) dispatch(request ziface.IRequest) {
+	if l.backend.msgHandler.WorkerPoolSize > 0 {
+		l.backend.msgHandler.SendMsgToTaskQueue(request)
+	} else {
+		l.backend.msgHandler.DoMsgHandler(request)
+	}
+}
+
+func (l *gatewayLink) closeConn(bc *backendConn) {
+	if !bc.close() {
+		return
+	}
+	if l.backend.onConnClosed != nil {
+		l.backend.onConnClosed(bc)
+	}
+}
+
+func (l *gatewayLink) send(f *clusterFrame) error {
+	l.writeLock.Lock()
+	defer l.writeLock.Unlock()
+	if err := sendClusterFrame(l.conn, f); err != nil {
+		//写超时或者出错，关闭连接，由serve清理该网关上的客户端连接
+		l.conn.Close()
+		return err
+	}
+	return nil
+}
+
+//后端节点上代表网关上一个客户端连接的IConn
+type backendConn struct {
+	link   *gatewayLink
+	connID uint64
+	ctx    context.Context
+	cancel context.CancelFunc
+	//是否已经断开，断开后不能再推送消息
+	closed int32
+	//是否已经在网关上通过认证
+	authenticated int32
+	//连接属性，只保存在后端节点本地
+	property       map[string]interface{}
+	propertyTimers map[string]*time.Timer
+	propertyLock   sync.Mutex
+	typedProperties
+	stats *connStats
+}
+
+func newBackendConn(link *gatewayLink, connID uint64) *backendConn {
+	bc := &backendConn{
+		link:           link,
+		connID:         connID,
+		property:       make(map[string]interface{}),
+		propertyTimers: make(map[string]*time.Timer),
+		stats:          newConnStats(),
+	}
+	bc.typedProperties = typedProperties{getter: bc}
+	bc.ctx, bc.cancel = context.WithCancel(context.Background())
+	return bc
+}
+
+//客户端连接断开，释放本地状态，返回是否为第一次关闭
+func (bc *backendConn) close() bool {
+	if !atomic.CompareAndSwapInt32(&bc.closed, 0, 1) {
+		return false
+	}
+	bc.cancel()
+	bc.propertyLock.Lock()
+	for _, timer := range bc.propertyTimers {
+		timer.Stop()
+	}
+	bc.propertyTimers = make(map[string]*time.Timer)
+	bc.propertyLock.Unlock()
+	return true
+}
+
+func (bc *backendConn) isClosed() bool {
+	return atomic.LoadInt32(&bc.closed) == 1
+}
+
+//客户端连接由网关启动，这里什么也不做
+func (bc *backendConn) Start() {}
+
+//通知网关断开客户端连接
+func (bc *backendConn) Stop() {
+	if bc.isClosed() {
+		return
+	}
+	if err := bc.link.send(&clusterFrame{kind: clusterKick, connID: bc.connID}); err != nil {
+		bc.link.backend.logger.Warn("[Backend] kick ConnID = ", bc.connID, " err ", err)
+	}
+}
+
+//客户端的socket在网关上，后端节点拿不到，总是返回nil
+func (bc *backendConn) GetTCPConn() *net.TCPConn {
+	return nil
+}
+
+func (bc *backendConn) GetConnID() uint64 {
+	return bc.connID
+}
+
+//客户端连接断开或者网关断开时被取消
+func (bc *backendConn) Context() context.Context {
+	return bc.ctx
+}
+
+//网关的地址
+func (bc *backendConn) RemoteAddr() net.Addr {
+	return bc.link.conn.RemoteAddr()
+}
+
+//通过网关推送给客户端
+func (bc *backendConn) SendMsg(msgID uint32, data []byte) error {
+	if bc.isClosed() {
+		return errBackendConnClosed
+	}
+	if err := bc.link.send(&clusterFrame{kind: clusterPush, connID: bc.connID, msgID: msgID, data: data}); err != nil {
+		return err
+	}
+	bc.stats.onWrite(clusterHeadLen+len(data), true)
+	return nil
+}
+
+//内部连接没有单独的发送队列，和SendMsg相同
+func (bc *backendConn) SendBuffMsg(msgID uint32, data []byte) error {
+	return bc.SendMsg(msgID, data)
+}
+
+func (bc *backendConn) SetProperty(key string, value interface{}) {
+	bc.SetPropertyWithTTL(key, value, 0)
+}
+
+func (bc *backendConn) SetPropertyWithTTL(key string, value interface{}, ttl time.Duration) {
+	bc.propertyLock.Lock()
+	defer bc.propertyLock.Unlock()
+
+	bc.property[key] = value
+	if timer, ok := bc.propertyTimers[key]; ok {
+		timer.Stop()
+		delete(bc.propertyTimers, key)
+	}
+	if ttl > 0 && !bc.isClosed() {
+		var timer *time.Timer
+		timer = time.AfterFunc(ttl, func() {
+			bc.propertyLock.Lock()
+			defer bc.propertyLock.Unlock()
+			if bc.propertyTimers[key] == timer {
+				delete(bc.property, key)
+				delete(bc.propertyTimers, key)
+			}
+		})
+		bc.propertyTimers[key] = timer
+	}
+}
+
+func (bc *backendConn) GetProperty(key string) (interface{}, error) {
+	bc.propertyLock.Lock()
+	defer bc.propertyLock.Unlock()
+
+	if value, ok := bc.property[key]; ok {
+		return value, nil
+	}
+	return nil, errors.New("no property found")
+}
+
+func (bc *backendConn) DelProperty(key string) {
+	bc.propertyLock.Lock()
+	defer bc.propertyLock.Unlock()
+
+	delete(bc.property, key)
+	if timer, ok := bc.propertyTimers[key]; ok {
+		timer.Stop()
+		delete(bc.propertyTimers, key)
+	}
+}
+
+func (bc *backendConn) GetProperties() map[string]interface{} {
+	bc.propertyLock.Lock()
+	defer bc.propertyLock.Unlock()
+
+	properties := make(map[string]interface{}, len(bc.property))
+	for key, value := range bc.property {
+		properties[key] = value
+	}
+	return properties
+}
+
+//后端节点上统计的转发消息，RTT等socket相关的统计在网关上
+func (bc *backendConn) Stats() ziface.ConnStats {
+	stats := bc.stats.snapshot()
+	stats.ConnID = bc.connID
+	return stats
+}
+
+//握手在网关上完成，后端节点上总是零值
+func (bc *backendConn) Handshake() ziface.Handshake {
+	return ziface.Handshake{}
+}
+
+//通知网关该客户端已经通过认证
+func (bc *backendConn) SetAuthenticated() {
+	if !atomic.CompareAndSwapInt32(&bc.authenticated, 0, 1) || bc.isClosed() {
+		return
+	}
+	if err := bc.link.send(&clusterFrame{kind: clusterAuth, connID: bc.connID}); err != nil {
+		bc.link.backend.logger.Warn("[Backend] auth ConnID = ", bc.connID, " err ", err)
+	}
+}
+
+//后端节点开启RequireAuth时返回网关上的认证状态，否则总是true
+func (bc *backendConn) IsAuthenticated() bool {
+	return !bc.link.backend.config.RequireAuth || atomic.LoadInt32(&bc.authenticated) == 1
+}
@@ -0,0 +1,95 @@
+package znet
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+/*
+	网关与后端节点之间的内部协议
+	网关持有客户端连接，按msgID区间把客户端消息转发给后端节点，
+	后端节点通过网关向客户端推送消息、断开客户端连接、标记客户端已认证
+
+	每一帧：数据长度(4) + 类型(1) + 标志(1) + 客户端ConnID(8) + msgID(4) + 数据，整数均为小端
+*/
+
+//内部帧的类型
+const (
+	//网关->后端：客户端发来的消息
+	clusterForward uint8 = iota + 1
+	//网关->后端：客户端连接已经断开
+	clusterClosed
+	//后端->网关：推送给客户端的消息
+	clusterPush
+	//后端->网关：断开客户端连接
+	clusterKick
+	//后端->网关：标记客户端连接已经通过认证
+	clusterAuth
+)
+
+//clusterForward的标志：客户端连接已经通过认证
+const clusterFlagAuthenticated uint8 = 1
+
+//内部帧头的长度
+const clusterHeadLen = 18
+
+//内部帧数据的长度上限，超过时认为数据流已经错乱
+const clusterMaxDataLen = 64 << 20
+
+//内部帧的写超时，对端长时间不读取时不再阻塞转发和推送的goroutine
+const clusterWriteTimeout = 3 * time.Second
+
+type clusterFrame struct {
+	kind   uint8
+	flags  uint8
+	connID uint64
+	msgID  uint32
+	data   []byte
+}
+
+//编码内部帧
+func packClusterFrame(f *clusterFrame) []byte {
+	buf := make([]byte, clusterHeadLen+len(f.data))
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(f.data)))
+	buf[4] = f.kind
+	buf[5] = f.flags
+	binary.LittleEndian.PutUint64(buf[6:], f.connID)
+	binary.LittleEndian.PutUint32(buf[14:], f.msgID)
+	copy(buf[clusterHeadLen:], f.data)
+	return buf
+}
+
+//在clusterWriteTimeout内一次写出一帧，调用方需要保证同一连接上的写入不会交错
+//出错时可能只写出了一部分，调用方需要关闭连接
+func sendClusterFrame(conn net.Conn, f *clusterFrame) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(clusterWriteTimeout))
+	_, err := conn.Write(packClusterFrame(f))
+	return err
+}
+
+//读取一个内部帧
+func readClusterFrame(r io.Reader) (*clusterFrame, error) {
+	head := make([]byte, clusterHeadLen)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	dataLen := binary.LittleEndian.Uint32(head[0:])
+	if dataLen > clusterMaxDataLen {
+		return nil, errors.New("cluster frame too large: " + strconv.FormatUint(uint64(dataLen), 10))
+	}
+	f := &clusterFrame{
+		kind:   head[4],
+		flags:  head[5],
+		connID: binary.LittleEndian.Uint64(head[6:]),
+		msgID:  binary.LittleEndian.Uint32(head[14:]),
+		data:   make([]byte, dataLen),
+	}
+	if _, err := io.ReadFull(r, f.data); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -3,21 +3,24 @@ package znet
 import (
 	"context"
 	"errors"
-	"fmt"
 	"io"
 	"net"
 	"server/utils"
 	"server/ziface"
+	"server/zlog"
+	"server/ztimer"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Conn struct {
 	//当前Conn属于哪个Server
 	TcpServer ziface.IServer
 	//当前连接的socket套接字
-	Conn *net.TCPConn
+	Conn net.Conn
 	//当前连接的ID（也可以称作为seccionID，iD全局唯一）
-	ConnID uint32
+	ConnID uint64
 	//告知该链接已经退出/停止的channel
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -26,31 +29,87 @@ type Conn struct {
 	//消息管理MsgID和对应的处理方法的消息管理模块
 	MsgHandler ziface.IMsgHandle
 	//无缓冲管道，用于读写两个goroutine之间的消息通信
-	msgChan chan []byte
+	msgChan chan outFrame
 	//有缓冲管道，用于读、写两个goroutine之间的消息通信
-	msgBuffChan chan []byte
+	msgBuffChan chan outFrame
+	//没有启动工作池时，按到达顺序依次处理当前连接消息的信箱
+	mailbox chan ziface.IRequest
 	//读写锁
 	sync.RWMutex
 	//连接属性
 	property map[string]interface{}
 	//保护当前property的锁
 	propertyLock sync.Mutex
+	//设置了有效期的连接属性的过期定时器
+	propertyTimers map[string]*propertyTimer
+	//带类型的连接属性读取方法
+	typedProperties
+	//所属Server的配置
+	config *utils.GlobalObj
+	//日志对象
+	logger *zlog.Logger
+	//封包拆包方式
+	packer ziface.IDataPack
+	//会话管理，没有开启会话恢复时为nil
+	sessions *sessionMgr
+	//当前连接持有的会话
+	session     *session
+	sessionLock sync.Mutex
+	//所属Server的事件总线
+	events ziface.IEventBus
+	//收发统计
+	stats *connStats
+	//发送大消息的分片ID，原子递增
+	fragID uint32
+	//客户端分片的重组，只在Reader中使用，没有开启分片时为nil
+	fragments *Reassembler
+	//握手协商的结果
+	handshakeInfo ziface.Handshake
+	//是否已经调用过OnConnStart，没有调用过的连接停止时不调用OnConnStop
+	started int32
+	//是否已经通过登录认证
+	authenticated int32
+	//登录超时的定时器
+	loginTimer ztimer.ClockTimer
+	//连接属性有效期和登录超时使用的时钟
+	clock ztimer.Clock
 }
 
 //创建连接的方法
-func NewConn(server ziface.IServer, conn *net.TCPConn, connID uint32, msghandler ziface.IMsgHandle) *Conn {
+func NewConn(server ziface.IServer, conn net.Conn, connID uint64, msghandler ziface.IMsgHandle) *Conn {
+	config := configOf(server)
 	//初始化Conn属性
 	c := &Conn{
-		TcpServer:   server,
-		Conn:        conn,
-		ConnID:      connID,
-		isClosed:    false,
-		MsgHandler:  msghandler,
-		msgChan:     make(chan []byte),
-		msgBuffChan: make(chan []byte, utils.GlobalObject.MaxMsgChanLen),
-		property:    make(map[string]interface{}),
+		TcpServer:      server,
+		Conn:           conn,
+		ConnID:         connID,
+		isClosed:       false,
+		MsgHandler:     msghandler,
+		msgChan:        make(chan outFrame),
+		msgBuffChan:    make(chan outFrame, liveConfOf(config).MaxMsgChanLen()),
+		property:       make(map[string]interface{}),
+		propertyTimers: make(map[string]*propertyTimer),
+		config:         config,
+		logger:         server.GetLogger(),
+		packer:         server.GetPacker(),
+		sessions:       sessionMgrOf(server),
+		events:         server.GetEventBus(),
+		stats:          newConnStats(),
+		clock:          clockOf(server),
 	}
 
+	c.typedProperties = typedProperties{getter: c}
+	if !config.RequireAuth {
+		c.authenticated = 1
+	}
+	if config.MaxMsgSize > 0 {
+		c.fragments = NewReassembler(config.MaxMsgSize, config.MaxFragmentBuffer,
+			time.Duration(config.FragmentTimeout)*time.Millisecond)
+	}
+
+	//连接停止时取消，业务中的异步操作可以通过Context()感知连接已经断开
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
 	//将新创建的conn添加到连接管理器中
 	c.TcpServer.GetConnMgr().Add(c)
 	return c
@@ -58,131 +117,371 @@ func NewConn(server ziface.IServer, conn *net.TCPConn, connID uint32, msghandler
 
 //写消息的goroutine，用户将数据发送给客户端
 func (c *Conn) Writer() {
-	fmt.Println("[Writer Goroutine is running]")
-	defer fmt.Println(c.RemoteAddr().String(), "[conn Writer exit!]")
+	c.logger.Debug("[Writer Goroutine is running]")
+	defer c.logger.Debug(c.RemoteAddr().String(), " [conn Writer exit!]")
+
+	//配置了PingInterval时定时发送ping测量RTT
+	var pingC <-chan time.Time
+	if c.config.PingInterval > 0 {
+		ticker := time.NewTicker(time.Duration(c.config.PingInterval) * time.Millisecond)
+		defer ticker.Stop()
+		pingC = ticker.C
+	}
 
 	for {
 		select {
-		case data := <-c.msgChan:
+		case data, ok := <-c.msgChan:
+			if !ok {
+				//连接已经关闭
+				return
+			}
 			//有（无缓冲）数据要写给客户端
-			if _, err := c.Conn.Write(data); err != nil {
-				fmt.Println("Send Data error:, ", err, " Conn Writer exit")
+			if err := c.write(data); err != nil {
+				c.logger.Error("Send Data error:, ", err, " Conn Writer exit")
 				return
 			}
 		case data, ok := <-c.msgBuffChan:
 			if ok {
 				//有数据要写给客户端
-				if _, err := c.Conn.Write(data); err != nil {
-					fmt.Println("Send Buff Data error:, ", err, " Conn Writer exit")
+				if err := c.write(data); err != nil {
+					c.logger.Error("Send Buff Data error:, ", err, " Conn Writer exit")
 					return
 				}
 			} else {
-				fmt.Println("msgBuffChan is Closed")
+				c.logger.Debug("msgBuffChan is Closed")
 				break
 			}
+		case <-pingC:
+			if err := c.write(outFrame{data: c.packPing()}); err != nil {
+				c.logger.Error("Send Ping error:, ", err, " Conn Writer exit")
+				return
+			}
 		case <-c.ctx.Done():
 			return
 		}
 	}
 }
 
+//待写出的一帧数据
+type outFrame struct {
+	data []byte
+	//是否为承载业务数据的帧，开启可靠传输时需要编号，系统消息和已经编号的重传消息为false
+	dataFrame bool
+}
+
+//将一帧数据写给客户端，开启可靠传输时为业务消息编号
+func (c *Conn) write(f outFrame) error {
+	data := f.data
+	if s := c.getSession(); s != nil && s.reliable != nil && f.dataFrame {
+		frame, ok := s.sequence(c, data, c.packSeq)
+		if !ok {
+			//连接已经断开，交给会话缓存，恢复后由新连接发送
+			if !c.sessions.buffer(s, c, f) {
+				c.logger.Debug("ConnID = ", c.ConnID, " lost session, drop msg")
+			}
+			return nil
+		}
+		data = frame
+	}
+	n, err := c.Conn.Write(data)
+	c.stats.onWrite(n, err == nil && f.dataFrame)
+	return err
+}
+
+//生成可靠传输的MsgIDSeq帧
+func (c *Conn) packSeq(seq uint64) []byte {
+	frame, _ := c.packer.Pack(NewMsgPackage(MsgIDSeq, encodeSeq(seq)))
+	return frame
+}
+
 //读消息的goroutine，用于从客户端读取数据
 func (c *Conn) Reader() {
-	fmt.Println("[Reader Goroutine is running]")
-	defer fmt.Println(c.RemoteAddr().String(), "[conn Reader exit!]")
-	defer c.Stop()
+	c.logger.Debug("[Reader Goroutine is running]")
+	defer c.logger.Debug(c.RemoteAddr().String(), " [conn Reader exit!]")
+	//网络原因断开的连接，开启会话恢复时会话进入保留期
+	reason := "connection closed"
+	defer func() { c.stop(true, reason) }()
+	if c.mailbox != nil {
+		//Reader是信箱唯一的写入方，退出时关闭信箱，已经收到的消息处理完后信箱goroutine退出
+		defer close(c.mailbox)
+	}
+
+	//开启握手时，客户端的第一帧必须是握手请求
+	if c.config.RequireHandshake {
+		if err := c.handshake(); err != nil {
+			reason = err.Error()
+			return
+		}
+	}
+	//开启会话恢复时，根据客户端的下一帧决定恢复会话还是新建会话
+	if c.sessions != nil {
+		if !c.startSession() {
+			return
+		}
+	} else if c.config.RequireHandshake {
+		c.onStarted()
+	}
 
 	for {
 		select {
 		case <-c.ctx.Done():
 			return
 		default:
-			//创建拆包解包的对象
-			dp := NewDataPack()
+			//拆包解包的对象
+			dp := c.packer
 			//读取客户端的MsgHead
 			headData := make([]byte, dp.GetHeadLen())
-			if _, err := io.ReadFull(c.Conn, headData); err != nil {
-				fmt.Println("read msg head error ", err)
+			if err := c.readHead(headData); err != nil {
+				c.logger.Debug("read msg head error ", err)
+				if err == io.EOF {
+					reason = "closed by client"
+				} else {
+					reason = err.Error()
+				}
 				return
 			}
-			//拆包得到msgID和dataLen 放在msg中
-			msg, err := dp.UnPack(headData)
+			msg, err := c.readBody(headData)
 			if err != nil {
-				fmt.Println("unpack error ", err)
+				reason = err.Error()
 				return
 			}
-			//根据 dataLen 读取 data，放在msg.Data中
-			var data []byte
-			if msg.GetDataLen() > 0 {
-				data = make([]byte, msg.GetDataLen())
-				if _, err := io.ReadFull(c.Conn, data); err != nil {
-					fmt.Println("read msg data error ", err)
-					return
-				}
-			}
-			msg.SetData(data)
-			//得到当前客户端请求的Request数据
-			req := Request{
-				conn: c,
-				msg:  msg,
-			}
-			if utils.GlobalObject.WorkerPoolSize > 0 {
-				//已经启动工作池机制，将消息交给Worker处理（阻塞排队执行）
-				c.MsgHandler.SendMsgToTaskQueue(&req)
-			} else {
-				//从绑定好的消息和对应的处理方法中执行对应的Handle方法（有请求立即执行）
-				go c.MsgHandler.DoMsgHandler(&req)
+			if err := c.processMsg(msg); err != nil {
+				reason = err.Error()
+				return
 			}
 		}
 	}
 }
 
+//根据已经读取的MsgHead拆包，并读取data
+func (c *Conn) readBody(headData []byte) (ziface.IMsg, error) {
+	//拆包得到msgID和dataLen 放在msg中
+	msg, err := c.packer.UnPack(headData)
+	if err != nil {
+		c.logger.Error("unpack error ", err)
+		c.publish(&ziface.Event{Type: ziface.EventDecodeError, Data: headData, Err: err})
+		return nil, err
+	}
+	//根据 dataLen 读取 data，放在msg.Data中
+	var data []byte
+	if msg.GetDataLen() > 0 {
+		data = make([]byte, msg.GetDataLen())
+		if _, err := io.ReadFull(c.Conn, data); err != nil {
+			c.logger.Error("read msg data error ", err)
+			return nil, err
+		}
+	}
+	msg.SetData(data)
+	c.stats.onRead(len(headData)+len(data), isDataFrame(msg.GetMsgID()))
+	return msg, nil
+}
+
+//处理一条客户端消息，返回error表示需要停止连接
+func (c *Conn) processMsg(msg ziface.IMsg) error {
+	s := c.getSession()
+	//系统消息由框架处理，不交给业务
+	if !isDataFrame(msg.GetMsgID()) {
+		c.handleSysMsg(msg, s)
+		return nil
+	}
+	if s != nil {
+		dup, ack := s.receive()
+		if ack > 0 {
+			_ = c.SendBuffMsg(MsgIDAck, encodeSeq(ack))
+		}
+		if dup {
+			c.logger.Debug("ConnID = ", c.ConnID, " drop duplicate msgId = ", msg.GetMsgID())
+			return nil
+		}
+	}
+	if msg.GetMsgID() == MsgIDFragment {
+		full, err := c.reassemble(msg.GetData())
+		if err != nil {
+			c.logger.Error("ConnID = ", c.ConnID, " reassemble error ", err)
+			c.publish(&ziface.Event{Type: ziface.EventDecodeError, MsgID: msg.GetMsgID(), Err: err})
+			return err
+		}
+		if full == nil {
+			//还需要更多分片
+			return nil
+		}
+		msg = full
+	}
+	if c.events.HasSubscribers(ziface.EventMsgReceived) {
+		c.publish(&ziface.Event{Type: ziface.EventMsgReceived, MsgID: msg.GetMsgID(), Data: msg.GetData()})
+	}
+	if !c.handleMsg(msg) {
+		return errConnStopped
+	}
+	return nil
+}
+
+//处理系统消息
+func (c *Conn) handleSysMsg(msg ziface.IMsg, s *session) {
+	switch msg.GetMsgID() {
+	case MsgIDPing:
+		//客户端测量RTT，原样回复
+		_ = c.SendBuffMsg(MsgIDPong, msg.GetData())
+	case MsgIDPong:
+		c.stats.onPong(msg.GetData())
+	default:
+		if s != nil {
+			s.onSysMsg(msg.GetMsgID(), msg.GetData())
+		}
+	}
+}
+
+//连接已经停止
+var errConnStopped = errors.New("connection stopped")
+
+//将消息交给业务处理，返回false表示连接已经停止
+func (c *Conn) handleMsg(msg ziface.IMsg) bool {
+	//得到当前客户端请求的Request数据
+	req := Request{
+		conn: c,
+		msg:  msg,
+	}
+	if c.config.WorkerPoolSize > 0 {
+		//已经启动工作池机制，将消息交给Worker处理（阻塞排队执行）
+		c.MsgHandler.SendMsgToTaskQueue(&req)
+		return true
+	}
+	//交给当前连接的信箱按顺序处理，信箱满时阻塞当前连接的读取
+	select {
+	case c.mailbox <- &req:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+//处理信箱消息的goroutine，同一个连接的消息按到达顺序依次处理
+func (c *Conn) MailboxLoop() {
+	c.logger.Debug("[Mailbox Goroutine is running]")
+	defer c.logger.Debug("ConnID = ", c.ConnID, " [conn Mailbox exit!]")
+
+	for req := range c.mailbox {
+		c.MsgHandler.DoMsgHandler(req)
+	}
+}
+
 //启动连接，让当前连接开始工作
 func (c *Conn) Start() {
-	c.ctx, c.cancel = context.WithCancel(context.Background())
+	//0.没有启动工作池时，开启按顺序处理当前连接消息的goroutine，不同连接之间并行处理
+	if c.config.WorkerPoolSize == 0 {
+		c.mailbox = make(chan ziface.IRequest, c.config.MaxMailboxLen)
+		go c.MailboxLoop()
+	}
 	//1.开启用户从客户端读取数据的goroutine
 	go c.Reader()
 	//2.开启用于写回客户端数据流程的goroutine
 	go c.Writer()
 	//按照用户传递进来的创建连接时需要处理的业务，执行Hook方法
+	//开启握手时握手成功之后才调用，开启会话恢复时由Reader收到第一帧之后决定调用OnConnStart还是OnSessionResume
+	if c.sessions == nil && !c.config.RequireHandshake {
+		c.onStarted()
+	}
+}
+
+//调用OnConnStart并发布连接开始的事件
+func (c *Conn) onStarted() {
+	atomic.StoreInt32(&c.started, 1)
+	c.startLoginTimer()
 	c.TcpServer.CallOnConnStart(c)
+	c.publish(&ziface.Event{Type: ziface.EventConnStarted})
 }
 
 //停止连接，结束当前连接状态
 func (c *Conn) Stop() {
-	fmt.Println("Conn Stop()...ConnID = ", c.ConnID)
+	//服务端主动停止的连接不保留会话
+	c.stop(false, "stopped by server")
+}
+
+//停止连接，resumable为true时会话进入保留期，保留期结束仍未恢复才调用OnConnStop
+//reason为停止原因，随EventConnStopped事件发布
+func (c *Conn) stop(resumable bool, reason string) {
+	c.logger.Debug("Conn Stop()...ConnID = ", c.ConnID)
+	if !c.close(resumable) {
+		return
+	}
+	//释放锁之后再发布事件，订阅者中可以继续调用当前连接的方法
+	c.publish(&ziface.Event{Type: ziface.EventConnStopped, Reason: reason})
+}
+
+//关闭连接并清理，连接已经关闭时返回false
+func (c *Conn) close(resumable bool) bool {
+	//先结束writer，唤醒阻塞在发送队列上并持有读锁的发送方
+	c.cancel()
 	c.Lock()
 	defer c.Unlock()
 
-	//如果用户注册了该链接的关闭回调业务，那么在此刻应该显示调用
-	c.TcpServer.CallOnConnStop(c)
-
 	//如果当前链接已经关闭
 	if c.isClosed == true {
-		return
+		return false
 	}
 	c.isClosed = true
 	//关闭socket链接
 	c.Conn.Close()
-	//关闭writer
-	c.cancel()
+	if c.loginTimer != nil {
+		c.loginTimer.Stop()
+	}
 	//将该连接从连接管理器中删除
 	c.TcpServer.GetConnMgr().Del(c)
+
+	if c.sessions == nil {
+		//如果用户注册了该链接的关闭回调业务，那么在此刻应该显示调用
+		if atomic.LoadInt32(&c.started) == 1 {
+			c.TcpServer.CallOnConnStop(c)
+		}
+	} else if s := c.getSession(); s != nil {
+		if resumable {
+			c.sessions.park(s, c.drainBuffered())
+		} else {
+			c.sessions.close(s)
+			c.TcpServer.CallOnConnStop(c)
+		}
+	}
 	//关闭该连接全部管道
 	close(c.msgBuffChan)
 	close(c.msgChan)
+	return true
+}
+
+//发布当前连接的事件
+func (c *Conn) publish(event *ziface.Event) {
+	event.Conn = c
+	c.events.Publish(event)
+}
+
+//取出尚未写出的缓冲消息
+func (c *Conn) drainBuffered() []outFrame {
+	var buffered []outFrame
+	for {
+		select {
+		case data := <-c.msgBuffChan:
+			buffered = append(buffered, data)
+		default:
+			return buffered
+		}
+	}
 }
 
-//从当前连接获取原始的socket
+//从当前连接获取原始的socket，不是TCP连接(如net.Pipe)时返回nil
 func (c *Conn) GetTCPConn() *net.TCPConn {
-	return c.Conn
+	tcpConn, _ := c.Conn.(*net.TCPConn)
+	return tcpConn
 }
 
 //获取当前连接ID
-func (c *Conn) GetConnID() uint32 {
+func (c *Conn) GetConnID() uint64 {
 	return c.ConnID
 }
 
+//获取连接的Context，连接停止时被取消
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
 //获取远程客户端地址信息
 func (c *Conn) RemoteAddr() net.Addr {
 	return c.Conn.RemoteAddr()
@@ -190,48 +489,160 @@ func (c *Conn) RemoteAddr() net.Addr {
 
 //直接将Msg数据发送给远程的TCP客户端（无缓冲）
 func (c *Conn) SendMsg(msgID uint32, data []byte) error {
+	if err := c.sendMsg(msgID, data); err != nil {
+		return err
+	}
+	c.publishSent(msgID, data)
+	return nil
+}
+
+func (c *Conn) sendMsg(msgID uint32, data []byte) error {
 	c.RLock()
 	defer c.RUnlock()
+	//将data封包并发送，超过MaxPacketSize的大消息拆成多个分片
+	frames, err := c.packFrames(msgID, data)
+	if err != nil {
+		c.logger.Error("Pack error msg id = ", msgID, " err ", err)
+		return errors.New("Pack error msg ")
+	}
 	if c.isClosed == true {
+		//连接断开但会话仍在保留期内，缓存起来等待恢复后补发
+		if s := c.getSession(); s != nil && c.sessions.buffer(s, c, frames...) {
+			return nil
+		}
 		return errors.New("connection closed when send msg")
 	}
-	//将data封包并发送
-	dp := NewDataPack()
-	msg, err := dp.Pack(NewMsgPackage(msgID, data))
-	if err != nil {
-		fmt.Println("Pack error msg id = ", msgID)
-		return errors.New("Pack error msg ")
+	//写回客户端，连接停止时不再阻塞
+	for _, frame := range frames {
+		select {
+		case c.msgChan <- frame:
+		case <-c.ctx.Done():
+			return errConnStopped
+		}
 	}
-	//写回客户端
-	c.msgChan <- msg
 	return nil
 }
 
 //直接将Message数据发送给远程的TCP客户端(有缓冲)
 func (c *Conn) SendBuffMsg(msgID uint32, data []byte) error {
+	if err := c.sendBuffMsg(msgID, data); err != nil {
+		return err
+	}
+	c.publishSent(msgID, data)
+	return nil
+}
+
+func (c *Conn) sendBuffMsg(msgID uint32, data []byte) error {
 	c.RLock()
 	defer c.RUnlock()
+	//将data封包并发送，超过MaxPacketSize的大消息拆成多个分片
+	frames, err := c.packFrames(msgID, data)
+	if err != nil {
+		c.logger.Error("Pack error msg id = ", msgID, " err ", err)
+		return errors.New("Pack error msg ")
+	}
 	if c.isClosed == true {
+		//连接断开但会话仍在保留期内，缓存起来等待恢复后补发
+		if s := c.getSession(); s != nil && c.sessions.buffer(s, c, frames...) {
+			return nil
+		}
 		return errors.New("Connection closed when send buff msg")
 	}
-	//将data封包并发送
-	dp := NewDataPack()
-	msg, err := dp.Pack(NewMsgPackage(msgID, data))
-	if err != nil {
-		fmt.Println("Pack error msg id = ", msgID)
-		return errors.New("Pack error msg ")
+	//写回客户端，发送队列满时阻塞，连接停止时不再阻塞
+	for _, frame := range frames {
+		select {
+		case c.msgBuffChan <- frame:
+		case <-c.ctx.Done():
+			return errConnStopped
+		}
 	}
-	//写回客户端
-	c.msgBuffChan <- msg
 	return nil
 }
 
+//发布消息发送事件，在释放连接的锁之后调用
+func (c *Conn) publishSent(msgID uint32, data []byte) {
+	if c.events.HasSubscribers(ziface.EventMsgSent) {
+		c.publish(&ziface.Event{Type: ziface.EventMsgSent, MsgID: msgID, Data: data})
+	}
+}
+
 //设置连接属性
 func (c *Conn) SetProperty(key string, value interface{}) {
+	c.setProperty(key, value, 0)
+}
+
+//设置有效期为ttl的连接属性，过期后自动删除
+func (c *Conn) SetPropertyWithTTL(key string, value interface{}, ttl time.Duration) {
+	c.setProperty(key, value, ttl)
+}
+
+//设置了有效期的连接属性的过期定时器
+type propertyTimer struct {
+	timer ztimer.ClockTimer
+	//过期时间，恢复会话时按剩余的有效期在新连接上重新计时
+	deadline time.Time
+}
+
+func (c *Conn) setProperty(key string, value interface{}, ttl time.Duration) {
+	c.propertyLock.Lock()
+	oldValue := c.property[key]
+	c.property[key] = value
+	if timer, ok := c.propertyTimers[key]; ok {
+		timer.timer.Stop()
+		delete(c.propertyTimers, key)
+	}
+	if ttl > 0 {
+		//过期回调拿到propertyLock之后才读取timer，所以一定能看到timer的赋值
+		timer := &propertyTimer{deadline: c.clock.Now().Add(ttl)}
+		timer.timer = c.clock.AfterFunc(ttl, func() {
+			c.expireProperty(key, timer)
+		})
+		c.propertyTimers[key] = timer
+	}
+	c.propertyLock.Unlock()
+
+	c.TcpServer.CallOnPropertyChange(c, key, oldValue, value)
+}
+
+//取出全部连接属性和有效期属性的剩余时间，并停止过期定时器，恢复会话时由新连接接管
+//没有有效期的属性剩余时间为0
+func (c *Conn) takeProperties() (map[string]interface{}, map[string]time.Duration) {
 	c.propertyLock.Lock()
 	defer c.propertyLock.Unlock()
 
-	c.property[key] = value
+	properties := make(map[string]interface{}, len(c.property))
+	ttls := make(map[string]time.Duration, len(c.propertyTimers))
+	now := c.clock.Now()
+	for key, value := range c.property {
+		properties[key] = value
+	}
+	for key, timer := range c.propertyTimers {
+		timer.timer.Stop()
+		if ttl := timer.deadline.Sub(now); ttl > 0 {
+			ttls[key] = ttl
+		} else {
+			//已经到期，过期回调还没来得及执行
+			delete(properties, key)
+		}
+	}
+	c.propertyTimers = make(map[string]*propertyTimer)
+	return properties, ttls
+}
+
+//连接属性过期
+func (c *Conn) expireProperty(key string, timer *propertyTimer) {
+	c.propertyLock.Lock()
+	if c.propertyTimers[key] != timer {
+		//过期之前已经被重新设置或者删除
+		c.propertyLock.Unlock()
+		return
+	}
+	oldValue := c.property[key]
+	delete(c.property, key)
+	delete(c.propertyTimers, key)
+	c.propertyLock.Unlock()
+
+	c.TcpServer.CallOnPropertyChange(c, key, oldValue, nil)
 }
 
 //获取连接属性
@@ -248,8 +659,28 @@ func (c *Conn) GetProperty(key string) (interface{}, error) {
 
 //删除连接属性
 func (c *Conn) DelProperty(key string) {
+	c.propertyLock.Lock()
+	oldValue, ok := c.property[key]
+	delete(c.property, key)
+	if timer, exist := c.propertyTimers[key]; exist {
+		timer.timer.Stop()
+		delete(c.propertyTimers, key)
+	}
+	c.propertyLock.Unlock()
+
+	if ok {
+		c.TcpServer.CallOnPropertyChange(c, key, oldValue, nil)
+	}
+}
+
+//获取全部连接属性的拷贝
+func (c *Conn) GetProperties() map[string]interface{} {
 	c.propertyLock.Lock()
 	defer c.propertyLock.Unlock()
 
-	delete(c.property, key)
+	properties := make(map[string]interface{}, len(c.property))
+	for key, value := range c.property {
+		properties[key] = value
+	}
+	return properties
 }
@@ -0,0 +1,123 @@
+package znet
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"server/ziface"
+	"sync"
+	"time"
+)
+
+/*
+	连接ID生成器
+	SeqConnIDGenerator        单进程内自增，回绕时跳过仍在使用中的ID(默认)
+	SnowflakeConnIDGenerator  雪花算法，ID中编码节点ID和时间，整个服务器集群内唯一
+*/
+
+//自增连接ID生成器，ID达到上限后从0开始回绕，跳过连接管理器中仍在使用的ID
+type SeqConnIDGenerator struct {
+	//下一个待分配的ID
+	next uint64
+	//ID上限(包含)
+	max  uint64
+	lock sync.Mutex
+}
+
+//创建自增连接ID生成器，max为ID上限，0表示使用math.MaxUint32
+func NewSeqConnIDGenerator(max uint64) *SeqConnIDGenerator {
+	if max == 0 {
+		max = math.MaxUint32
+	}
+	return &SeqConnIDGenerator{max: max}
+}
+
+func (g *SeqConnIDGenerator) NextConnID(connMgr ziface.IConnMgr) (uint64, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	//最多尝试当前连接数+1次，一定能找到一个没有被使用的ID
+	for i := 0; i <= connMgr.Len(); i++ {
+		id := g.next
+		if g.next == g.max {
+			g.next = 0
+		} else {
+			g.next++
+		}
+		if _, err := connMgr.Get(id); err != nil {
+			return id, nil
+		}
+	}
+	return 0, errors.New("no free conn id")
+}
+
+//雪花算法各部分占用的位数：41位毫秒时间戳 + 10位节点ID + 12位序号
+const (
+	snowflakeNodeBits = 10
+	snowflakeSeqBits  = 12
+	//节点ID的最大值
+	SnowflakeMaxNodeID = 1<<snowflakeNodeBits - 1
+	snowflakeMaxSeq    = 1<<snowflakeSeqBits - 1
+)
+
+//雪花算法的起始时间 2020-01-01 00:00:00 UTC
+var snowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+//雪花算法连接ID生成器，不同节点使用不同的节点ID时生成的ID全局唯一，重启后也不会重复
+type SnowflakeConnIDGenerator struct {
+	nodeID uint64
+	//上次生成ID的毫秒时间戳(相对snowflakeEpoch)
+	lastMs int64
+	//同一毫秒内的序号
+	seq  uint64
+	lock sync.Mutex
+	//获取当前时间，测试时可以替换
+	now func() time.Time
+}
+
+//创建雪花算法连接ID生成器，nodeID范围[0, SnowflakeMaxNodeID]
+func NewSnowflakeConnIDGenerator(nodeID int) (*SnowflakeConnIDGenerator, error) {
+	if nodeID < 0 || nodeID > SnowflakeMaxNodeID {
+		return nil, fmt.Errorf("snowflake node id %d out of range [0, %d]", nodeID, SnowflakeMaxNodeID)
+	}
+	return &SnowflakeConnIDGenerator{nodeID: uint64(nodeID), now: time.Now}, nil
+}
+
+func (g *SnowflakeConnIDGenerator) NextConnID(connMgr ziface.IConnMgr) (uint64, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	ms := g.now().Sub(snowflakeEpoch).Nanoseconds() / int64(time.Millisecond)
+	switch {
+	case ms > g.lastMs:
+		g.lastMs = ms
+		g.seq = 0
+	case g.seq < snowflakeMaxSeq:
+		//同一毫秒内，或者时钟回拨时沿用上次的时间戳继续递增序号
+		g.seq++
+	default:
+		//序号用完，借用下一毫秒，保证ID单调递增
+		g.lastMs++
+		g.seq = 0
+	}
+	return uint64(g.lastMs)<<(snowflakeNodeBits+snowflakeSeqBits) | g.nodeID<<snowflakeSeqBits | g.seq, nil
+}
+
+//从雪花算法生成的连接ID中解析出节点ID和生成时间
+func ParseSnowflakeConnID(connID uint64) (nodeID int, t time.Time) {
+	nodeID = int(connID >> snowflakeSeqBits & SnowflakeMaxNodeID)
+	ms := int64(connID >> (snowflakeNodeBits + snowflakeSeqBits))
+	return nodeID, snowflakeEpoch.Add(time.Duration(ms) * time.Millisecond)
+}
+
+//根据配置创建连接ID生成器
+func newConnIDGenerator(kind string, nodeID int) (ziface.IConnIDGenerator, error) {
+	switch kind {
+	case "", "seq":
+		return NewSeqConnIDGenerator(0), nil
+	case "snowflake":
+		return NewSnowflakeConnIDGenerator(nodeID)
+	default:
+		return nil, fmt.Errorf("unknown conn id generator %q", kind)
+	}
+}
@@ -2,23 +2,31 @@ package znet
 
 import (
 	"errors"
-	"fmt"
 	"server/ziface"
+	"server/zlog"
 	"sync"
+	"time"
 )
 
 //连接管理模块
 type ConnMgr struct {
 	//管理的连接信息
-	conns map[uint32]ziface.IConn
+	conns map[uint64]ziface.IConn
 	//读写连接的读写锁
 	connsLock sync.RWMutex
+	//日志对象
+	logger *zlog.Logger
 }
 
 //创建一个连接管理
 func NewConnMgr() *ConnMgr {
+	return newConnMgr(zlog.StdLog)
+}
+
+func newConnMgr(logger *zlog.Logger) *ConnMgr {
 	return &ConnMgr{
-		conns: make(map[uint32]ziface.IConn),
+		conns:  make(map[uint64]ziface.IConn),
+		logger: logger,
 	}
 }
 
@@ -29,7 +37,7 @@ func (cm *ConnMgr) Add(conn ziface.IConn) {
 
 	//将conn添加到cm.conns中管理
 	cm.conns[conn.GetConnID()] = conn
-	fmt.Println("connection add to ConnManager successfully: conn num = ", cm.Len())
+	cm.logger.Debug("connection add to ConnManager successfully: conn num = ", len(cm.conns))
 }
 
 //删除连接
@@ -39,11 +47,11 @@ func (cm *ConnMgr) Del(conn ziface.IConn) {
 
 	//删除连接信息
 	delete(cm.conns, conn.GetConnID())
-	fmt.Println("connection Remove ConnID=", conn.GetConnID(), " successfully: conn num = ", cm.Len())
+	cm.logger.Debug("connection Remove ConnID=", conn.GetConnID(), " successfully: conn num = ", len(cm.conns))
 }
 
 //通过connID获取连接
-func (cm *ConnMgr) Get(connID uint32) (ziface.IConn, error) {
+func (cm *ConnMgr) Get(connID uint64) (ziface.IConn, error) {
 	//保护共享资源Map 加读锁
 	cm.connsLock.RLock()
 	defer cm.connsLock.RUnlock()
@@ -57,19 +65,60 @@ func (cm *ConnMgr) Get(connID uint32) (ziface.IConn, error) {
 
 //获取当前连接个数
 func (cm *ConnMgr) Len() int {
+	cm.connsLock.RLock()
+	defer cm.connsLock.RUnlock()
+
 	return len(cm.conns)
 }
 
+//获取当前全部连接
+func (cm *ConnMgr) GetAllConns() []ziface.IConn {
+	cm.connsLock.RLock()
+	defer cm.connsLock.RUnlock()
+
+	conns := make([]ziface.IConn, 0, len(cm.conns))
+	for _, conn := range cm.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
 //删除并停止所有连接
 func (cm *ConnMgr) ClearConn() {
-	cm.connsLock.Lock()
-	defer cm.connsLock.Unlock()
-
-	for connID, conn := range cm.conns {
+	//conn.Stop()中会调用Del删除自己，所以不能在持有锁的时候停止连接
+	conns := cm.GetAllConns()
+	for _, conn := range conns {
 		//停止
 		conn.Stop()
 		//删除
-		delete(cm.conns, connID)
+		cm.Del(conn)
+	}
+	cm.logger.Debug("Clear All Connections successfully: conn num = ", cm.Len())
+}
+
+//汇总全部连接的统计信息
+func (cm *ConnMgr) Stats() ziface.ConnMgrStats {
+	var stats ziface.ConnMgrStats
+	var rttSum time.Duration
+	rttConns := 0
+	for _, conn := range cm.GetAllConns() {
+		connStats := conn.Stats()
+		stats.Conns++
+		stats.BytesIn += connStats.BytesIn
+		stats.BytesOut += connStats.BytesOut
+		stats.MsgsIn += connStats.MsgsIn
+		stats.MsgsOut += connStats.MsgsOut
+		stats.SendQueueLen += connStats.SendQueueLen
+		if connStats.RTT > 0 {
+			rttSum += connStats.RTT
+			rttConns++
+			if connStats.RTT > stats.MaxRTT {
+				stats.MaxRTT = connStats.RTT
+			}
+		}
+	}
+	if rttConns > 0 {
+		stats.AvgRTT = rttSum / time.Duration(rttConns)
 	}
-	fmt.Println("Clear All Connections successfully: conn num = ", cm.Len())
+	return stats
 }
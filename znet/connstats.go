@@ -0,0 +1,120 @@
+package znet
+
+import (
+	"encoding/binary"
+	"server/ziface"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	连接统计：收发字节数和消息数量、最后读写时间，以及通过MsgIDPing/MsgIDPong测量的平滑RTT
+	Reader、Writer和查询统计的goroutine并发访问，全部使用原子操作
+*/
+
+type connStats struct {
+	//64位原子操作的字段放在最前面，保证在32位平台上8字节对齐
+	bytesIn, bytesOut uint64
+	msgsIn, msgsOut   uint64
+	//最后一次读写的时间(UnixNano)
+	lastRead, lastWrite int64
+	//平滑后的RTT(纳秒)
+	rtt int64
+	//最后一次发送、还没有收到pong的ping时间戳，只接受与它相同的pong，防止客户端伪造RTT
+	lastPing int64
+	//连接建立的时间，ping的时间戳以此为起点，使用单调时钟计算RTT
+	startTime time.Time
+}
+
+func newConnStats() *connStats {
+	return &connStats{startTime: time.Now()}
+}
+
+//读取了n字节，business表示是否为业务消息
+func (st *connStats) onRead(n int, business bool) {
+	atomic.AddUint64(&st.bytesIn, uint64(n))
+	if business {
+		atomic.AddUint64(&st.msgsIn, 1)
+	}
+	atomic.StoreInt64(&st.lastRead, time.Now().UnixNano())
+}
+
+//写出了n字节，business表示是否为业务消息
+func (st *connStats) onWrite(n int, business bool) {
+	atomic.AddUint64(&st.bytesOut, uint64(n))
+	if business {
+		atomic.AddUint64(&st.msgsOut, 1)
+	}
+	atomic.StoreInt64(&st.lastWrite, time.Now().UnixNano())
+}
+
+//记录一次RTT采样，取指数加权平均，第一次采样直接作为RTT
+func (st *connStats) observeRTT(sample time.Duration) {
+	for {
+		old := atomic.LoadInt64(&st.rtt)
+		rtt := int64(sample)
+		if old > 0 {
+			rtt = old + (int64(sample)-old)/8
+		}
+		if atomic.CompareAndSwapInt64(&st.rtt, old, rtt) {
+			return
+		}
+	}
+}
+
+//生成ping的数据：连接建立以来经过的纳秒数，并记录为等待pong的ping
+func (st *connStats) pingData() []byte {
+	sent := int64(time.Since(st.startTime))
+	atomic.StoreInt64(&st.lastPing, sent)
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(sent))
+	return data
+}
+
+//收到pong，时间戳与等待中的ping相同时计算RTT，每个ping只采样一次
+//服务端没有发送过的、重复的或者过期的pong直接忽略
+func (st *connStats) onPong(data []byte) {
+	if len(data) != 8 {
+		return
+	}
+	sent := int64(binary.LittleEndian.Uint64(data))
+	if sent <= 0 || !atomic.CompareAndSwapInt64(&st.lastPing, sent, 0) {
+		return
+	}
+	if sample := time.Since(st.startTime) - time.Duration(sent); sample >= 0 {
+		st.observeRTT(sample)
+	}
+}
+
+func (st *connStats) snapshot() ziface.ConnStats {
+	stats := ziface.ConnStats{
+		BytesIn:   atomic.LoadUint64(&st.bytesIn),
+		BytesOut:  atomic.LoadUint64(&st.bytesOut),
+		MsgsIn:    atomic.LoadUint64(&st.msgsIn),
+		MsgsOut:   atomic.LoadUint64(&st.msgsOut),
+		StartTime: st.startTime,
+		Age:       time.Since(st.startTime),
+		RTT:       time.Duration(atomic.LoadInt64(&st.rtt)),
+	}
+	if t := atomic.LoadInt64(&st.lastRead); t > 0 {
+		stats.LastReadTime = time.Unix(0, t)
+	}
+	if t := atomic.LoadInt64(&st.lastWrite); t > 0 {
+		stats.LastWriteTime = time.Unix(0, t)
+	}
+	return stats
+}
+
+//获取连接的统计信息
+func (c *Conn) Stats() ziface.ConnStats {
+	stats := c.stats.snapshot()
+	stats.ConnID = c.ConnID
+	stats.SendQueueLen = len(c.msgBuffChan)
+	return stats
+}
+
+//生成MsgIDPing帧
+func (c *Conn) packPing() []byte {
+	frame, _ := c.packer.Pack(NewMsgPackage(MsgIDPing, c.stats.pingData()))
+	return frame
+}
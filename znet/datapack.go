@@ -8,13 +8,22 @@ import (
 	"server/ziface"
 )
 
-//封包拆包类实例，暂时不需要成员字段
+//封包拆包类实例
 type DataPack struct {
+	//运行期可以热更新的配置，用于读取最大包长度
+	live *liveConf
 }
 
-//封包拆包实例初始化
+//封包拆包实例初始化，使用全局配置中的最大包长度
 func NewDataPack() *DataPack {
-	return &DataPack{}
+	return NewDataPackWithConfig(utils.GlobalObject)
+}
+
+//使用指定配置中的最大包长度创建封包拆包实例
+func NewDataPackWithConfig(conf *utils.GlobalObj) *DataPack {
+	return &DataPack{
+		live: liveConfOf(conf),
+	}
 }
 
 //获取包头长度
@@ -65,7 +74,7 @@ func (dp *DataPack) UnPack(binaryData []byte) (ziface.IMsg, error) {
 	}
 
 	//判断dataLen的长度是否超出我们允许的最大包长度
-	if utils.GlobalObject.MaxPacketSize > 0 && msg.Len > utils.GlobalObject.MaxPacketSize {
+	if maxSize := dp.live.MaxPacketSize(); maxSize > 0 && msg.Len > maxSize {
 		return nil, errors.New("too large msg data received")
 	}
 
@@ -0,0 +1,60 @@
+package znet
+
+import (
+	"fmt"
+	"hash/fnv"
+	"server/ziface"
+)
+
+//单线程逻辑循环的workerID，分配到这里的请求全部由同一个独立的goroutine串行处理
+const LogicLoopWorkerID = -1
+
+//按ConnID分配：同一个连接的消息总是由同一个worker处理，保证单个连接内的消息顺序(默认策略)
+type ConnIDDispatcher struct{}
+
+func (d *ConnIDDispatcher) Dispatch(request ziface.IRequest, pool ziface.IWorkerPool) int {
+	return int(request.GetConn().GetConnID() % uint64(pool.Size()))
+}
+
+//按连接属性分配：属性值相同的连接(如同一个玩家pid、同一个场景ID)由同一个worker处理，
+//连接上没有该属性时退化为按ConnID分配
+type PropertyDispatcher struct {
+	Key string
+}
+
+//创建一个按连接属性key分配的策略
+func NewPropertyDispatcher(key string) *PropertyDispatcher {
+	return &PropertyDispatcher{Key: key}
+}
+
+func (d *PropertyDispatcher) Dispatch(request ziface.IRequest, pool ziface.IWorkerPool) int {
+	value, err := request.GetConn().GetProperty(d.Key)
+	if err != nil {
+		return int(request.GetConn().GetConnID() % uint64(pool.Size()))
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(fmt.Sprint(value)))
+	return int(h.Sum32() % uint32(pool.Size()))
+}
+
+//按负载分配：交给当前排队任务最少的worker处理
+//注意：同一个连接的消息可能被不同的worker并发处理，不保证消息顺序，只适用于彼此无关的无状态消息
+type LeastLoadedDispatcher struct{}
+
+func (d *LeastLoadedDispatcher) Dispatch(request ziface.IRequest, pool ziface.IWorkerPool) int {
+	best, bestLen := 0, -1
+	for i := 0; i < pool.Size(); i++ {
+		if l := pool.QueueLen(i); bestLen < 0 || l < bestLen {
+			best, bestLen = i, l
+		}
+	}
+	return best
+}
+
+//单线程逻辑循环：全部请求交给同一个独立的goroutine串行处理，
+//适用于跨连接的游戏逻辑(如两个玩家之间的交互)，业务代码中不需要再加锁
+type LogicLoopDispatcher struct{}
+
+func (d *LogicLoopDispatcher) Dispatch(request ziface.IRequest, pool ziface.IWorkerPool) int {
+	return LogicLoopWorkerID
+}
@@ -0,0 +1,114 @@
+package znet
+
+import (
+	"server/ziface"
+	"server/zlog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	事件总线：连接生命周期和消息收发事件的多订阅者分发
+	指标统计、审计、反作弊等插件通过Server.GetEventBus().Subscribe订阅，不需要修改业务代码
+	事件在产生事件的goroutine(Reader、Worker、调用SendMsg的goroutine等)中同步分发，
+	订阅者的处理方法需要尽快返回，耗时的操作应该交给自己的goroutine处理
+*/
+
+type eventSub struct {
+	handler ziface.EventHandler
+	//关注的事件类型，为空表示全部类型
+	types map[ziface.EventType]bool
+}
+
+type EventBus struct {
+	lock  sync.RWMutex
+	subs  map[int]*eventSub
+	subID int
+	//每种事件类型的订阅者数量
+	counts [ziface.EventTypeCount]int32
+	logger *zlog.Logger
+}
+
+//创建一个事件总线
+func NewEventBus(logger *zlog.Logger) *EventBus {
+	return &EventBus{
+		subs:   make(map[int]*eventSub),
+		logger: logger,
+	}
+}
+
+func (bus *EventBus) Subscribe(handler ziface.EventHandler, types ...ziface.EventType) int {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+
+	sub := &eventSub{handler: handler}
+	if len(types) > 0 {
+		sub.types = make(map[ziface.EventType]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+	bus.subID++
+	bus.subs[bus.subID] = sub
+	bus.count(sub, 1)
+	return bus.subID
+}
+
+func (bus *EventBus) Unsubscribe(id int) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+
+	if sub, ok := bus.subs[id]; ok {
+		delete(bus.subs, id)
+		bus.count(sub, -1)
+	}
+}
+
+//更新每种事件类型的订阅者数量
+func (bus *EventBus) count(sub *eventSub, delta int32) {
+	for t := ziface.EventType(0); t < ziface.EventTypeCount; t++ {
+		if sub.types == nil || sub.types[t] {
+			atomic.AddInt32(&bus.counts[t], delta)
+		}
+	}
+}
+
+func (bus *EventBus) HasSubscribers(t ziface.EventType) bool {
+	return t >= 0 && t < ziface.EventTypeCount && atomic.LoadInt32(&bus.counts[t]) > 0
+}
+
+func (bus *EventBus) Publish(event *ziface.Event) {
+	if !bus.HasSubscribers(event.Type) {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.RemoteAddr == nil && event.Conn != nil {
+		event.RemoteAddr = event.Conn.RemoteAddr()
+	}
+
+	bus.lock.RLock()
+	handlers := make([]ziface.EventHandler, 0, len(bus.subs))
+	for _, sub := range bus.subs {
+		if sub.types == nil || sub.types[event.Type] {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	bus.lock.RUnlock()
+
+	for _, handler := range handlers {
+		bus.dispatch(handler, event)
+	}
+}
+
+//调用订阅者的处理方法，订阅者panic不影响产生事件的业务
+func (bus *EventBus) dispatch(handler ziface.EventHandler, event *ziface.Event) {
+	defer func() {
+		if err := recover(); err != nil {
+			bus.logger.Error("event handler panic, event = ", event.Type, " err ", err)
+		}
+	}()
+	handler(event)
+}
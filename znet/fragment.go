@@ -0,0 +1,254 @@
+package znet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"server/ziface"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	大消息分片：超过MaxPacketSize的消息拆成多个MsgIDFragment帧发送，接收方重组后再交给业务
+	每个分片的数据为 分片ID(4字节) + 原msgID(4字节) + 消息总长度(4字节) + 本分片的数据，均为小端
+	同一条消息的分片按顺序连续发送，不同消息的分片可能交错，接收方按分片ID区分
+	注意：断线时尚未重组完成的消息会被丢弃
+*/
+
+//分片数据中分片头的长度
+const fragmentHeadLen = 12
+
+//将消息封包为一帧，超过maxPacketSize时封包为多个分片帧，maxPacketSize为0表示不分片
+//fragID为本条消息的分片ID，同一个连接上尚未重组完成的消息之间不能重复
+func PackFragments(packer ziface.IDataPack, msgID uint32, data []byte, maxPacketSize uint32, fragID uint32) ([][]byte, error) {
+	if maxPacketSize == 0 || uint32(len(data)) <= maxPacketSize {
+		frame, err := packer.Pack(NewMsgPackage(msgID, data))
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{frame}, nil
+	}
+	if maxPacketSize <= fragmentHeadLen {
+		return nil, fmt.Errorf("MaxPacketSize %d too small to fragment", maxPacketSize)
+	}
+
+	chunkLen := int(maxPacketSize - fragmentHeadLen)
+	frames := make([][]byte, 0, (len(data)+chunkLen-1)/chunkLen)
+	for offset := 0; offset < len(data); offset += chunkLen {
+		end := offset + chunkLen
+		if end > len(data) {
+			end = len(data)
+		}
+		fragment := make([]byte, fragmentHeadLen+end-offset)
+		binary.LittleEndian.PutUint32(fragment, fragID)
+		binary.LittleEndian.PutUint32(fragment[4:], msgID)
+		binary.LittleEndian.PutUint32(fragment[8:], uint32(len(data)))
+		copy(fragment[fragmentHeadLen:], data[offset:end])
+
+		frame, err := packer.Pack(NewMsgPackage(MsgIDFragment, fragment))
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+//重组中的消息
+type partialMsg struct {
+	msgID uint32
+	//第一个分片声明的消息总长度
+	total uint32
+	//已经收到的数据，随着分片到达增长，不按声明的总长度预先分配
+	data     []byte
+	deadline time.Time
+}
+
+//分片重组，只能在一个goroutine中使用
+type Reassembler struct {
+	//重组后单条消息的最大长度
+	maxMsgSize uint32
+	//重组中的消息已经收到的数据长度之和的上限
+	maxBuffered uint32
+	//单条消息从收到第一个分片到重组完成的时间上限
+	timeout time.Duration
+	//重组中的消息，key为分片ID
+	partial map[uint32]*partialMsg
+	//重组中的消息已经收到的数据长度之和
+	buffered uint32
+}
+
+//创建分片重组
+func NewReassembler(maxMsgSize, maxBuffered uint32, timeout time.Duration) *Reassembler {
+	return &Reassembler{
+		maxMsgSize:  maxMsgSize,
+		maxBuffered: maxBuffered,
+		timeout:     timeout,
+		partial:     make(map[uint32]*partialMsg),
+	}
+}
+
+//加入一个MsgIDFragment帧的数据，消息重组完成时返回完整的消息，否则返回nil
+//分片格式错误、消息过大或者超过内存上限时返回error，此时应该断开连接
+func (r *Reassembler) Add(data []byte) (ziface.IMsg, error) {
+	if len(data) < fragmentHeadLen {
+		return nil, errors.New("fragment too short")
+	}
+	fragID := binary.LittleEndian.Uint32(data)
+	msgID := binary.LittleEndian.Uint32(data[4:])
+	total := binary.LittleEndian.Uint32(data[8:])
+	chunk := data[fragmentHeadLen:]
+
+	p, ok := r.partial[fragID]
+	if !ok {
+		if total > r.maxMsgSize {
+			return nil, fmt.Errorf("fragmented msg size %d exceeds MaxMsgSize %d", total, r.maxMsgSize)
+		}
+		p = &partialMsg{
+			msgID:    msgID,
+			total:    total,
+			deadline: time.Now().Add(r.timeout),
+		}
+		r.partial[fragID] = p
+	}
+	if p.msgID != msgID || p.total != total {
+		return nil, fmt.Errorf("fragment %d header mismatch", fragID)
+	}
+	if uint32(len(p.data)+len(chunk)) > total {
+		return nil, fmt.Errorf("fragment %d overflows msg size %d", fragID, total)
+	}
+	//按实际收到的数据计入内存上限，只发送第一个分片不能占用声明的总长度
+	if r.buffered+uint32(len(chunk)) > r.maxBuffered {
+		return nil, fmt.Errorf("fragment buffer exceeds %d bytes", r.maxBuffered)
+	}
+
+	p.data = appendChunk(p.data, chunk, total)
+	r.buffered += uint32(len(chunk))
+	if uint32(len(p.data)) < total {
+		return nil, nil
+	}
+	delete(r.partial, fragID)
+	r.buffered -= total
+	return NewMsgPackage(msgID, p.data), nil
+}
+
+//追加分片数据，容量按已经收到的数据倍增，不超过消息总长度
+func appendChunk(data, chunk []byte, total uint32) []byte {
+	n := len(data) + len(chunk)
+	if n > cap(data) {
+		size := 2 * cap(data)
+		if size < n {
+			size = n
+		}
+		if uint32(size) > total {
+			size = int(total)
+		}
+		grown := make([]byte, len(data), size)
+		copy(grown, data)
+		data = grown
+	}
+	return append(data, chunk...)
+}
+
+//丢弃超时未完成重组的消息，返回被丢弃消息的msgID
+func (r *Reassembler) Expire(now time.Time) []uint32 {
+	var expired []uint32
+	for fragID, p := range r.partial {
+		if !now.Before(p.deadline) {
+			delete(r.partial, fragID)
+			r.buffered -= uint32(len(p.data))
+			expired = append(expired, p.msgID)
+		}
+	}
+	return expired
+}
+
+//重组中的消息数量
+func (r *Reassembler) Pending() int {
+	return len(r.partial)
+}
+
+//最早超时的重组中消息的截止时间，没有重组中的消息时返回false
+func (r *Reassembler) NextDeadline() (time.Time, bool) {
+	var next time.Time
+	for _, p := range r.partial {
+		if next.IsZero() || p.deadline.Before(next) {
+			next = p.deadline
+		}
+	}
+	return next, !next.IsZero()
+}
+
+//将消息封包为待发送的帧，开启分片时超过MaxPacketSize的消息拆成多个分片帧
+func (c *Conn) packFrames(msgID uint32, data []byte) ([]outFrame, error) {
+	dataFrame := isDataFrame(msgID)
+	if c.config.MaxMsgSize == 0 {
+		frame, err := c.packer.Pack(NewMsgPackage(msgID, data))
+		if err != nil {
+			return nil, err
+		}
+		return []outFrame{{data: frame, dataFrame: dataFrame}}, nil
+	}
+	if uint32(len(data)) > c.config.MaxMsgSize {
+		return nil, fmt.Errorf("msg size %d exceeds MaxMsgSize %d", len(data), c.config.MaxMsgSize)
+	}
+	packed, err := PackFragments(c.packer, msgID, data, liveConfOf(c.config).MaxPacketSize(), atomic.AddUint32(&c.fragID, 1))
+	if err != nil {
+		return nil, err
+	}
+	frames := make([]outFrame, len(packed))
+	for i, frame := range packed {
+		frames[i] = outFrame{data: frame, dataFrame: dataFrame}
+	}
+	return frames, nil
+}
+
+//重组客户端发来的分片，消息完整时返回重组后的消息，否则返回nil
+func (c *Conn) reassemble(data []byte) (ziface.IMsg, error) {
+	if c.fragments == nil {
+		return nil, errors.New("fragmentation is disabled")
+	}
+	c.expireFragments()
+	return c.fragments.Add(data)
+}
+
+//丢弃超时未完成重组的消息，释放占用的重组缓存
+func (c *Conn) expireFragments() {
+	for _, msgID := range c.fragments.Expire(time.Now()) {
+		err := fmt.Errorf("reassemble msgId = %d timeout", msgID)
+		c.logger.Warn("ConnID = ", c.ConnID, " ", err)
+		c.publish(&ziface.Event{Type: ziface.EventDecodeError, MsgID: msgID, Err: err})
+	}
+}
+
+//读取客户端的MsgHead，有重组中的消息时以最早的重组截止时间作为读超时，
+//客户端不再发送分片时也能按时丢弃超时的消息
+func (c *Conn) readHead(headData []byte) error {
+	for {
+		var deadline time.Time
+		if c.fragments != nil {
+			deadline, _ = c.fragments.NextDeadline()
+		}
+		if deadline.IsZero() {
+			_, err := io.ReadFull(c.Conn, headData)
+			return err
+		}
+
+		_ = c.Conn.SetReadDeadline(deadline)
+		n, err := io.ReadFull(c.Conn, headData)
+		_ = c.Conn.SetReadDeadline(time.Time{})
+		ne, ok := err.(net.Error)
+		if !ok || !ne.Timeout() {
+			return err
+		}
+		c.expireFragments()
+		if n > 0 {
+			//读到一半超时，继续读完MsgHead
+			_, err = io.ReadFull(c.Conn, headData[n:])
+			return err
+		}
+	}
+}
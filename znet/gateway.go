@@ -0,0 +1,186 @@
+package znet
+
+import (
+	"net"
+	"server/ziface"
+	"server/zlog"
+	"sync"
+)
+
+/*
+	网关模式：Server持有客户端连接，AddRoute指定的msgID区间的消息不在本地处理，
+	通过常驻的内部连接转发给后端节点(Backend)，帧中携带客户端的ConnID，
+	后端节点可以通过网关向客户端推送消息、断开客户端连接、标记客户端已经认证
+
+	同一个客户端连接的消息总是按ConnID转发给区间内固定的一个后端节点，保持消息顺序；
+	区间之外的msgID仍然由网关本地的路由处理，登录认证(RequireAuth)也在网关上检查，
+	登录消息转发给后端节点处理时，客户端需要等到登录回复之后再发送其他消息
+	多个网关连接同一个后端节点时，需要使用snowflake等全局唯一的ConnID
+*/
+
+type Gateway struct {
+	//持有客户端连接的Server
+	server ziface.IServer
+	logger *zlog.Logger
+	//全部后端节点的内部连接，同一个地址只建立一个连接
+	links map[string]*backendLink
+	//订阅连接停止事件的ID
+	subID int
+	//是否已经停止
+	stopped bool
+	lock    sync.Mutex
+}
+
+//为Server创建网关，需要在Server启动之前调用AddRoute
+func NewGateway(server ziface.IServer) *Gateway {
+	return &Gateway{
+		server: server,
+		logger: server.GetLogger(),
+		links:  make(map[string]*backendLink),
+	}
+}
+
+//将msgID区间[min, max]的消息转发给backends中的一个后端节点
+func (g *Gateway) AddRoute(min, max uint32, backends ...string) {
+	if len(backends) == 0 {
+		panic("gateway route without backend")
+	}
+	g.lock.Lock()
+	router := &forwardRouter{}
+	for _, addr := range backends {
+		link, ok := g.links[addr]
+		if !ok {
+			link = newBackendLink(g, addr)
+			g.links[addr] = link
+		}
+		router.links = append(router.links, link)
+	}
+	g.lock.Unlock()
+
+	g.server.GetMsgHandler().AddRouterRange(min, max, router)
+}
+
+//连接全部后端节点，断开后自动重连
+func (g *Gateway) Start() {
+	g.subID = g.server.GetEventBus().Subscribe(g.onConnStopped, ziface.EventConnStopped)
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, link := range g.links {
+		link.Start()
+	}
+}
+
+//断开全部后端节点
+func (g *Gateway) Stop() {
+	g.server.GetEventBus().Unsubscribe(g.subID)
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.stopped {
+		return
+	}
+	g.stopped = true
+	for _, link := range g.links {
+		link.Close()
+	}
+}
+
+//是否已经连接上全部后端节点
+func (g *Gateway) Ready() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, link := range g.links {
+		if !link.Connected() {
+			return false
+		}
+	}
+	return true
+}
+
+//客户端连接断开，通知全部后端节点释放该连接的状态
+func (g *Gateway) onConnStopped(event *ziface.Event) {
+	g.lock.Lock()
+	links := make([]*backendLink, 0, len(g.links))
+	for _, link := range g.links {
+		links = append(links, link)
+	}
+	g.lock.Unlock()
+
+	for _, link := range links {
+		_ = link.send(&clusterFrame{kind: clusterClosed, connID: event.Conn.GetConnID()})
+	}
+}
+
+//处理后端节点发来的帧
+func (g *Gateway) handleFrame(f *clusterFrame) {
+	conn, err := g.server.GetConnMgr().Get(f.connID)
+	if err != nil {
+		//客户端已经断开
+		return
+	}
+	switch f.kind {
+	case clusterPush:
+		if err := conn.SendBuffMsg(f.msgID, f.data); err != nil {
+			g.logger.Warn("[Gateway] push msgId = ", f.msgID, " to ConnID = ", f.connID, " err ", err)
+		}
+	case clusterKick:
+		g.logger.Info("[Gateway] backend kick ConnID = ", f.connID)
+		conn.Stop()
+	case clusterAuth:
+		conn.SetAuthenticated()
+	default:
+		g.logger.Warn("[Gateway] unknown cluster frame kind ", f.kind)
+	}
+}
+
+//转发到后端节点的路由，按ConnID选择后端节点
+type forwardRouter struct {
+	BaseRouter
+	links []*backendLink
+}
+
+func (r *forwardRouter) Handle(request ziface.IRequest) {
+	conn := request.GetConn()
+	link := r.links[conn.GetConnID()%uint64(len(r.links))]
+	f := &clusterFrame{
+		kind:   clusterForward,
+		connID: conn.GetConnID(),
+		msgID:  request.GetMsgID(),
+		data:   request.GetData(),
+	}
+	if conn.IsAuthenticated() {
+		f.flags |= clusterFlagAuthenticated
+	}
+	if err := link.send(f); err != nil {
+		link.gw.logger.Warn("[Gateway] forward msgId = ", f.msgID, " of ConnID = ", f.connID, " to ", link.Addr(), " err ", err)
+	}
+}
+
+//网关到一个后端节点的常驻连接
+type backendLink struct {
+	*Link
+	gw *Gateway
+}
+
+func newBackendLink(gw *Gateway, addr string) *backendLink {
+	l := &backendLink{gw: gw}
+	l.Link = NewLink(addr, "[Gateway] backend", gw.logger, nil, l.read)
+	return l
+}
+
+//读取后端节点发来的帧
+func (l *backendLink) read(conn net.Conn) {
+	for {
+		f, err := readClusterFrame(conn)
+		if err != nil {
+			return
+		}
+		l.gw.handleFrame(f)
+	}
+}
+
+//转发一帧给后端节点，多个worker会同时向同一个后端节点转发消息
+func (l *backendLink) send(f *clusterFrame) error {
+	return l.Send(packClusterFrame(f))
+}
@@ -0,0 +1,171 @@
+package znet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"server/ziface"
+	"time"
+)
+
+/*
+	连接握手：开启RequireHandshake时，客户端连接后的第一帧必须是MsgIDHandshake，
+	服务端校验魔数、协议版本和能力位，不兼容的客户端收到带原因的拒绝回复后被断开
+	请求数据：魔数(4字节) + 协议版本(4字节) + 客户端能力位(4字节) + 客户端构建版本(其余字节)
+	回复数据：结果(1字节，0接受 1拒绝) + 服务端协议版本(4字节) + 协商后的能力位(4字节) + 拒绝原因(其余字节)
+*/
+
+//握手请求的魔数 "ZINX"
+const HandshakeMagic uint32 = 0x584E495A
+
+//能力位
+const (
+	//消息压缩，由业务自己实现，框架只负责协商
+	CapCompression uint32 = 1 << iota
+	//可靠传输的消息序号(MsgIDSeq/MsgIDAck)
+	CapSequence
+	//大消息分片(MsgIDFragment)
+	CapFragment
+)
+
+//握手请求和回复的最小长度
+const (
+	handshakeLen      = 12
+	handshakeReplyLen = 9
+)
+
+//握手结果
+const (
+	handshakeAccepted byte = iota
+	handshakeRejected
+)
+
+//握手回复
+type HandshakeReply struct {
+	Accepted bool
+	//服务端的协议版本号
+	ProtocolVersion uint32
+	//协商后的能力位
+	Capabilities uint32
+	//拒绝的原因
+	Reason string
+}
+
+//生成握手请求的数据，供客户端使用
+func EncodeHandshake(version uint32, caps uint32, build string) []byte {
+	data := make([]byte, handshakeLen+len(build))
+	binary.LittleEndian.PutUint32(data, HandshakeMagic)
+	binary.LittleEndian.PutUint32(data[4:], version)
+	binary.LittleEndian.PutUint32(data[8:], caps)
+	copy(data[handshakeLen:], build)
+	return data
+}
+
+//解析握手回复的数据，供客户端使用
+func DecodeHandshakeReply(data []byte) (HandshakeReply, error) {
+	if len(data) < handshakeReplyLen {
+		return HandshakeReply{}, errors.New("handshake reply too short")
+	}
+	return HandshakeReply{
+		Accepted:        data[0] == handshakeAccepted,
+		ProtocolVersion: binary.LittleEndian.Uint32(data[1:]),
+		Capabilities:    binary.LittleEndian.Uint32(data[5:]),
+		Reason:          string(data[handshakeReplyLen:]),
+	}, nil
+}
+
+func encodeHandshakeReply(result byte, version uint32, caps uint32, reason string) []byte {
+	data := make([]byte, handshakeReplyLen+len(reason))
+	data[0] = result
+	binary.LittleEndian.PutUint32(data[1:], version)
+	binary.LittleEndian.PutUint32(data[5:], caps)
+	copy(data[handshakeReplyLen:], reason)
+	return data
+}
+
+//服务端支持的能力位，以及客户端必须支持的能力位
+func (c *Conn) serverCapabilities() (supported uint32, required uint32) {
+	supported = c.config.Capabilities
+	if c.config.ReliableDelivery {
+		supported |= CapSequence
+		required |= CapSequence
+	}
+	if c.config.MaxMsgSize > 0 {
+		supported |= CapFragment
+		required |= CapFragment
+	}
+	return supported | required, required
+}
+
+//等待并校验客户端的握手请求，失败时回复拒绝原因并返回error
+func (c *Conn) handshake() error {
+	msg, err := c.readFirstMsg(time.Duration(c.config.HandshakeTimeout) * time.Millisecond)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return c.rejectHandshake("handshake timeout")
+		}
+		return err
+	}
+	if msg.GetMsgID() != MsgIDHandshake {
+		return c.rejectHandshake(fmt.Sprintf("first msg must be handshake, got msgId = %d", msg.GetMsgID()))
+	}
+
+	data := msg.GetData()
+	if len(data) < handshakeLen || binary.LittleEndian.Uint32(data) != HandshakeMagic {
+		return c.rejectHandshake("bad handshake magic")
+	}
+	h := ziface.Handshake{
+		ProtocolVersion: binary.LittleEndian.Uint32(data[4:]),
+		Capabilities:    binary.LittleEndian.Uint32(data[8:]),
+		ClientBuild:     string(data[handshakeLen:]),
+	}
+	if h.ProtocolVersion < c.config.MinProtocolVersion || h.ProtocolVersion > c.config.ProtocolVersion {
+		return c.rejectHandshake(fmt.Sprintf("protocol version %d not supported, server supports [%d, %d], please update the client",
+			h.ProtocolVersion, c.config.MinProtocolVersion, c.config.ProtocolVersion))
+	}
+	supported, required := c.serverCapabilities()
+	if missing := required &^ h.Capabilities; missing != 0 {
+		return c.rejectHandshake(fmt.Sprintf("client lacks required capabilities 0x%x", missing))
+	}
+	h.Capabilities &= supported
+
+	c.Lock()
+	c.handshakeInfo = h
+	c.Unlock()
+	c.logger.Debug("ConnID = ", c.ConnID, " handshake ok, version ", h.ProtocolVersion, " build ", h.ClientBuild, " capabilities ", h.Capabilities)
+	return c.SendBuffMsg(MsgIDHandshake, encodeHandshakeReply(handshakeAccepted, c.config.ProtocolVersion, h.Capabilities, ""))
+}
+
+//回复拒绝原因，返回握手失败的error
+func (c *Conn) rejectHandshake(reason string) error {
+	c.logger.Warn("ConnID = ", c.ConnID, " handshake rejected: ", reason)
+	//连接马上就要关闭，直接写socket，保证客户端能收到拒绝原因
+	frame, err := c.packer.Pack(NewMsgPackage(MsgIDHandshake,
+		encodeHandshakeReply(handshakeRejected, c.config.ProtocolVersion, 0, reason)))
+	if err == nil {
+		_ = c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+		_, _ = c.Conn.Write(frame)
+	}
+	return errors.New("handshake rejected: " + reason)
+}
+
+//读取客户端的第一帧，超过wait仍没有读完时返回超时错误
+func (c *Conn) readFirstMsg(wait time.Duration) (ziface.IMsg, error) {
+	headData := make([]byte, c.packer.GetHeadLen())
+	_ = c.Conn.SetReadDeadline(time.Now().Add(wait))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	if _, err := io.ReadFull(c.Conn, headData); err != nil {
+		return nil, err
+	}
+	return c.readBody(headData)
+}
+
+//获取连接握手协商的结果，没有开启握手时为零值
+func (c *Conn) Handshake() ziface.Handshake {
+	c.RLock()
+	defer c.RUnlock()
+	return c.handshakeInfo
+}
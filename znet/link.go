@@ -0,0 +1,162 @@
+package znet
+
+import (
+	"errors"
+	"net"
+	"server/zlog"
+	"sync"
+	"time"
+)
+
+/*
+	常驻连接：到一个地址的内部TCP连接，在后台建立并在断开后自动重连，直到Close
+	网关到后端节点、注册中心客户端、发布订阅总线到Broker都通过常驻连接通信
+
+	写入不持有连接状态的锁并且有写超时，对端长时间不读取时关闭连接由后台重连，
+	不会一直阻塞发送的goroutine，也不影响连接状态的查询和Close
+*/
+
+//断开之后重连的间隔
+const linkRetryInterval = time.Second
+
+//建立连接的超时时间
+const linkDialTimeout = 3 * time.Second
+
+//写超时
+const linkWriteTimeout = 3 * time.Second
+
+//ErrLinkNotConnected 常驻连接当前没有连上对端
+var ErrLinkNotConnected = errors.New("link not connected")
+
+type Link struct {
+	addr string
+	//日志中的名称
+	name   string
+	logger *zlog.Logger
+	//连接建立之后、开始读取之前调用，用于重新发送注册、订阅等恢复状态的帧，可以为nil
+	onConnect func()
+	//读取对端发来的数据，直到连接断开或者出错时返回
+	read func(conn net.Conn)
+
+	//当前的连接，断开时为nil
+	conn net.Conn
+	//保护conn
+	lock sync.Mutex
+	//保护写入，同一连接上多个goroutine的帧不能交错
+	writeLock sync.Mutex
+	//Close时关闭
+	exitChan  chan struct{}
+	closeOnce sync.Once
+}
+
+//创建到addr的常驻连接，调用Start之后开始连接，name为日志中的名称
+func NewLink(addr, name string, logger *zlog.Logger, onConnect func(), read func(conn net.Conn)) *Link {
+	return &Link{
+		addr:      addr,
+		name:      name,
+		logger:    logger,
+		onConnect: onConnect,
+		read:      read,
+		exitChan:  make(chan struct{}),
+	}
+}
+
+//在后台连接对端，断开后自动重连，直到Close
+func (l *Link) Start() {
+	go l.run()
+}
+
+//对端地址
+func (l *Link) Addr() string {
+	return l.addr
+}
+
+//是否已经连上对端
+func (l *Link) Connected() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.conn != nil
+}
+
+//在写超时之内写出一帧，没有连接时返回ErrLinkNotConnected，写超时或者出错时关闭连接，由后台重连
+func (l *Link) Send(frame []byte) error {
+	l.lock.Lock()
+	conn := l.conn
+	l.lock.Unlock()
+	if conn == nil {
+		return ErrLinkNotConnected
+	}
+
+	l.writeLock.Lock()
+	defer l.writeLock.Unlock()
+	_ = conn.SetWriteDeadline(time.Now().Add(linkWriteTimeout))
+	if _, err := conn.Write(frame); err != nil {
+		//可能只写出了一部分，不能继续使用
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+//断开连接，不再重连
+func (l *Link) Close() {
+	l.closeOnce.Do(func() {
+		close(l.exitChan)
+	})
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+}
+
+//连接对端并读取，断开后重连，直到Close
+func (l *Link) run() {
+	for {
+		conn, err := net.DialTimeout("tcp", l.addr, linkDialTimeout)
+		if err == nil && l.attach(conn) {
+			l.logger.Info(l.name, " ", l.addr, " connected")
+			if l.onConnect != nil {
+				l.onConnect()
+			}
+			l.read(conn)
+			conn.Close()
+			l.detach(conn)
+			l.logger.Warn(l.name, " ", l.addr, " disconnected")
+		} else if err != nil {
+			l.logger.Debug(l.name, " dial ", l.addr, " err ", err)
+		}
+
+		select {
+		case <-l.exitChan:
+			return
+		case <-time.After(linkRetryInterval):
+		}
+	}
+}
+
+//使用新连接，已经Close时关闭新连接并返回false
+func (l *Link) attach(conn net.Conn) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	select {
+	case <-l.exitChan:
+		conn.Close()
+		return false
+	default:
+	}
+	l.conn = conn
+	return true
+}
+
+func (l *Link) detach(conn net.Conn) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.conn == conn {
+		l.conn = nil
+	}
+}
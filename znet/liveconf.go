@@ -0,0 +1,58 @@
+package znet
+
+import (
+	"server/utils"
+	"sync"
+	"sync/atomic"
+)
+
+//运行期可以热更新的配置，由配置变更通知写入，读取时使用原子操作，避免读写配置产生竞争
+type liveConf struct {
+	maxConn       int64
+	maxPacketSize uint32
+	maxMsgChanLen uint32
+}
+
+var (
+	//每份配置对应的liveConf，多个Server共用同一份配置时只订阅一次
+	liveConfs     = make(map[*utils.GlobalObj]*liveConf)
+	liveConfsLock sync.Mutex
+)
+
+//获取配置对应的liveConf，首次获取时订阅该配置的变更通知
+func liveConfOf(conf *utils.GlobalObj) *liveConf {
+	liveConfsLock.Lock()
+	defer liveConfsLock.Unlock()
+
+	if live, ok := liveConfs[conf]; ok {
+		return live
+	}
+	live := &liveConf{}
+	live.load(conf)
+	conf.Subscribe(func(conf *utils.GlobalObj, changed []string) {
+		live.load(conf)
+	}, "MaxConn", "MaxPacketSize", "MaxMsgChanLen")
+	liveConfs[conf] = live
+	return live
+}
+
+func (l *liveConf) load(conf *utils.GlobalObj) {
+	atomic.StoreInt64(&l.maxConn, int64(conf.MaxConn))
+	atomic.StoreUint32(&l.maxPacketSize, conf.MaxPacketSize)
+	atomic.StoreUint32(&l.maxMsgChanLen, conf.MaxMsgChanLen)
+}
+
+//当前允许的最大连接个数
+func (l *liveConf) MaxConn() int {
+	return int(atomic.LoadInt64(&l.maxConn))
+}
+
+//当前允许的最大数据包长度
+func (l *liveConf) MaxPacketSize() uint32 {
+	return atomic.LoadUint32(&l.maxPacketSize)
+}
+
+//当前SendBuffMsg发送消息的缓冲最大长度
+func (l *liveConf) MaxMsgChanLen() uint32 {
+	return atomic.LoadUint32(&l.maxMsgChanLen)
+}
@@ -1,84 +1,312 @@
 package znet
 
 import (
-	"fmt"
+	"runtime/debug"
 	"server/utils"
 	"server/ziface"
+	"server/zlog"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type MsgHandle struct {
 	//存放每个MsgID所对应所对应的处理方法的map属性
 	APIS map[uint32]ziface.IRouter
+	//按msgID区间注册的处理方法，没有精确匹配的处理方法时使用
+	rangeAPIS []routeRange
 	//处理业务工作Worker池的数量
 	WorkerPoolSize uint32
-	//Worker负责取任务的消息队列
-	TaskQueue []chan ziface.IRequest
+	//每个Worker对应负责的任务队列最大任务存储数量
+	maxWorkerTaskLen uint32
+	//日志对象
+	logger *zlog.Logger
+	//业务处理方法panic时发布事件
+	events ziface.IEventBus
+	//默认的消息分配策略
+	dispatcher ziface.IDispatcher
+	//每个msgID单独指定的消息分配策略
+	msgDispatchers map[uint32]ziface.IDispatcher
+	//单线程逻辑循环的worker，第一次有请求分配到逻辑循环时启动
+	logicSlot *slot
+	logicOnce sync.Once
+
+	//全部槽位，数量固定为Worker数量的上限
+	slots []*slot
+	//有任务等待处理的槽位
+	ready chan *slot
+	//缩容时通知一个goroutine退出
+	retire chan struct{}
+	//当前处理任务的goroutine数量
+	size int32
+	//goroutine数量的上下限
+	minSize, maxSize int
+	//扩缩容检查间隔
+	scaleInterval time.Duration
+	//扩容阈值：队列平均占用百分比、任务平均排队时间
+	scaleUpQueuePercent int
+	scaleUpLatency      time.Duration
+	//队列占用达到该百分比时拒绝可丢弃的消息
+	shedQueuePercent int
+	//拒绝消息时回复给客户端的msgID，0表示不回复
+	overloadMsgID uint32
+	//每个msgID的优先级，未指定的为PriorityNormal
+	priorities map[uint32]int
+	//可丢弃的低优先级msgID
+	sheddable map[uint32]bool
+	//未认证的连接可以发送的msgID
+	loginMsgIDs map[uint32]bool
+	//拒绝未认证连接的消息时回复给客户端的msgID，0表示不回复
+	unauthMsgID uint32
+	//任务平均排队时间(纳秒)、已处理任务数、已拒绝任务数
+	avgWait   int64
+	processed int64
+	shedCount uint64
+	//停止Worker池时关闭
+	exitChan chan struct{}
+	stopOnce sync.Once
 }
 
+//使用全局配置创建消息管理模块
 func NewMsgHandle() *MsgHandle {
+	return newMsgHandle(utils.GlobalObject, zlog.StdLog, NewEventBus(zlog.StdLog))
+}
+
+func newMsgHandle(conf *utils.GlobalObj, logger *zlog.Logger, events ziface.IEventBus) *MsgHandle {
+	maxSize := int(conf.MaxWorkerPoolSize)
+	if maxSize < int(conf.WorkerPoolSize) {
+		//没有配置扩容上限，Worker池大小固定
+		maxSize = int(conf.WorkerPoolSize)
+	}
+	slots := make([]*slot, maxSize)
+	for i := range slots {
+		slots[i] = newSlot(i, conf.MaxWorkerTaskLen)
+	}
 	return &MsgHandle{
-		APIS:           make(map[uint32]ziface.IRouter),
-		WorkerPoolSize: utils.GlobalObject.WorkerPoolSize,
-		//一个worker对应一个queue
-		TaskQueue: make([]chan ziface.IRequest, utils.GlobalObject.WorkerPoolSize),
+		APIS:                make(map[uint32]ziface.IRouter),
+		WorkerPoolSize:      conf.WorkerPoolSize,
+		maxWorkerTaskLen:    conf.MaxWorkerTaskLen,
+		logger:              logger,
+		events:              events,
+		dispatcher:          &ConnIDDispatcher{},
+		msgDispatchers:      make(map[uint32]ziface.IDispatcher),
+		slots:               slots,
+		ready:               make(chan *slot, maxSize),
+		retire:              make(chan struct{}, maxSize),
+		minSize:             int(conf.WorkerPoolSize),
+		maxSize:             maxSize,
+		scaleInterval:       time.Duration(conf.WorkerScaleInterval) * time.Millisecond,
+		scaleUpQueuePercent: int(conf.WorkerScaleUpQueuePercent),
+		scaleUpLatency:      time.Duration(conf.WorkerScaleUpLatency) * time.Millisecond,
+		shedQueuePercent:    int(conf.ShedQueuePercent),
+		overloadMsgID:       conf.OverloadMsgID,
+		priorities:          make(map[uint32]int),
+		sheddable:           make(map[uint32]bool),
+		loginMsgIDs:         make(map[uint32]bool),
+		unauthMsgID:         conf.UnauthenticatedMsgID,
+		exitChan:            make(chan struct{}),
 	}
 }
 
 //马上以非阻塞方式处理消息
 func (mh *MsgHandle) DoMsgHandler(request ziface.IRequest) {
-	handler, ok := mh.APIS[request.GetMsgID()]
+	if mh.events.HasSubscribers(ziface.EventMsgHandled) {
+		defer mh.events.Publish(&ziface.Event{Type: ziface.EventMsgHandled, Conn: request.GetConn(), MsgID: request.GetMsgID()})
+	}
+	handler, ok := mh.routerOf(request.GetMsgID())
 	if !ok {
-		fmt.Println("APIS msgId = ", request.GetMsgID(), " is not FOUND!")
+		mh.logger.Warn("APIS msgId = ", request.GetMsgID(), " is not FOUND!")
+		return
+	}
+	if !mh.authorized(request) {
+		mh.rejectUnauthenticated(request)
 		return
 	}
+	//业务处理方法panic时只影响当前消息，不影响worker和其他连接
+	defer mh.recoverHandler(request)
 	//执行对应处理方法
 	handler.PreHandle(request)
 	handler.Handle(request)
 	handler.PostHandle(request)
 }
 
+func (mh *MsgHandle) recoverHandler(request ziface.IRequest) {
+	err := recover()
+	if err == nil {
+		return
+	}
+	stack := string(debug.Stack())
+	mh.logger.Error("handle msgId = ", request.GetMsgID(), " ConnID = ", request.GetConn().GetConnID(), " panic: ", err, "\n", stack)
+	mh.events.Publish(&ziface.Event{
+		Type:  ziface.EventHandlerPanic,
+		Conn:  request.GetConn(),
+		MsgID: request.GetMsgID(),
+		Data:  request.GetData(),
+		Panic: err,
+		Stack: stack,
+	})
+}
+
 //为消息添加具体的处理逻辑
 func (mh *MsgHandle) AddRouter(msgID uint32, router ziface.IRouter) {
+	if IsSysMsgID(msgID) {
+		panic("reserved system msgId = " + strconv.FormatUint(uint64(msgID), 10))
+	}
 	//1.判断当前msg绑定的API处理方法是否已经存在
 	if _, ok := mh.APIS[msgID]; ok {
 		panic("repeated api , msgId = " + strconv.Itoa(int(msgID)))
 	}
 	//2.添加msg与API的绑定关系
 	mh.APIS[msgID] = router
-	fmt.Println("Add API msgId = ", msgID)
+	mh.logger.Debug("Add API msgId = ", msgID)
+}
+
+//msgID区间[min, max]的处理方法
+type routeRange struct {
+	min, max uint32
+	router   ziface.IRouter
+}
+
+//为msgID区间[min, max]添加处理逻辑，区间内单独注册过的msgID仍然使用自己的处理方法
+func (mh *MsgHandle) AddRouterRange(min, max uint32, router ziface.IRouter) {
+	if min > max {
+		panic("invalid msgId range " + strconv.FormatUint(uint64(min), 10) + " - " + strconv.FormatUint(uint64(max), 10))
+	}
+	if IsSysMsgID(max) {
+		panic("msgId range overlaps reserved system msgId, max = " + strconv.FormatUint(uint64(max), 10))
+	}
+	for _, r := range mh.rangeAPIS {
+		if min <= r.max && r.min <= max {
+			panic("overlapped msgId range " + strconv.FormatUint(uint64(min), 10) + " - " + strconv.FormatUint(uint64(max), 10))
+		}
+	}
+	mh.rangeAPIS = append(mh.rangeAPIS, routeRange{min: min, max: max, router: router})
+	mh.logger.Debug("Add API msgId range = ", min, " - ", max)
+}
+
+//查找msgID的处理方法，精确匹配优先于区间
+func (mh *MsgHandle) routerOf(msgID uint32) (ziface.IRouter, bool) {
+	if router, ok := mh.APIS[msgID]; ok {
+		return router, true
+	}
+	for _, r := range mh.rangeAPIS {
+		if msgID >= r.min && msgID <= r.max {
+			return r.router, true
+		}
+	}
+	return nil, false
 }
 
 //启动worker工作池
 func (mh *MsgHandle) StartWorkerPool() {
-	//遍历需要启动worker的数量，依此启动
-	for i := 0; i < int(mh.WorkerPoolSize); i++ {
-		//给当前worker对应的任务队列开辟空间
-		mh.TaskQueue[i] = make(chan ziface.IRequest, utils.GlobalObject.MaxWorkerTaskLen)
-		//启动当前worker，阻塞的等待对应的任务队列是否有消息传递进来
-		go mh.StartOneWorker(i, mh.TaskQueue[i])
+	//启动最少数量的goroutine，阻塞的等待有任务的槽位
+	for i := 0; i < mh.minSize; i++ {
+		mh.grow()
+	}
+
+	if mh.maxSize > mh.minSize {
+		go mh.autoScale()
 	}
 }
 
+//停止worker工作池，阻塞等待入队的请求会被丢弃
+func (mh *MsgHandle) StopWorkerPool() {
+	mh.stopOnce.Do(func() {
+		close(mh.exitChan)
+	})
+}
+
 //将消息交给TaskQueue,由worker进行处理
 func (mh *MsgHandle) SendMsgToTaskQueue(request ziface.IRequest) {
-	//根据ConnID来分配当前的连接应该由哪个worker负责处理
-	//轮询的平均分配法则（求余）
-
-	//得到需要处理此条连接的workerID
-	workerID := request.GetConn().GetConnID() % mh.WorkerPoolSize
-	//将请求消息发送给人物队列
-	mh.TaskQueue[workerID] <- request
-}
-
-func (mh *MsgHandle) StartOneWorker(workerID int, taskQueue chan ziface.IRequest) {
-	fmt.Println("Worker ID = ", workerID, " is started.")
-	//不断的等待队列中的消息
-	for {
-		select {
-		//有消息则取出队列的Request，并执行绑定的业务方法
-		case request := <-taskQueue:
-			mh.DoMsgHandler(request)
-		}
+	//根据消息分配策略得到需要处理此条消息的workerID，默认按ConnID求余分配
+	dispatcher, ok := mh.msgDispatchers[request.GetMsgID()]
+	if !ok {
+		dispatcher = mh.dispatcher
+	}
+
+	workerID := dispatcher.Dispatch(request, mh)
+	if workerID == LogicLoopWorkerID {
+		mh.logicOnce.Do(mh.startLogicLoop)
+		mh.enqueue(mh.logicSlot, request)
+		return
+	}
+	//将请求消息发送给槽位的任务队列，槽位数量不随扩缩容变化，同一个连接总是在同一个槽位
+	mh.enqueue(mh.slots[mh.workerIndex(workerID, request)], request)
+}
+
+//将分配策略返回的workerID映射到槽位上，自定义策略返回的负数按取模归一化
+func (mh *MsgHandle) workerIndex(workerID int, request ziface.IRequest) int {
+	size := mh.Size()
+	if workerID < 0 {
+		mh.logger.Error("dispatcher returned invalid workerID = ", workerID, " for msgId = ", request.GetMsgID(), " ConnID = ", request.GetConn().GetConnID())
+		return (workerID%size + size) % size
+	}
+	return workerID % size
+}
+
+//设置默认的消息分配策略，需要在Server启动之前设置
+func (mh *MsgHandle) SetDispatcher(dispatcher ziface.IDispatcher) {
+	mh.dispatcher = dispatcher
+}
+
+//为指定msgID设置消息分配策略，需要在Server启动之前设置
+func (mh *MsgHandle) SetMsgDispatcher(msgID uint32, dispatcher ziface.IDispatcher) {
+	mh.msgDispatchers[msgID] = dispatcher
+}
+
+//设置msgID的优先级(PriorityHigh/PriorityNormal/PriorityLow)，需要在Server启动之前设置
+func (mh *MsgHandle) SetMsgPriority(msgID uint32, priority int) {
+	if priority < PriorityHigh || priority >= priorityClasses {
+		panic("invalid priority " + strconv.Itoa(priority) + " , msgId = " + strconv.Itoa(int(msgID)))
+	}
+	mh.priorities[msgID] = priority
+}
+
+//msgID的优先级
+func (mh *MsgHandle) priorityOf(msgID uint32) int {
+	if priority, ok := mh.priorities[msgID]; ok {
+		return priority
+	}
+	return PriorityNormal
+}
+
+//将msgID标记为可丢弃的低优先级消息，任务队列拥堵时直接拒绝，需要在Server启动之前设置
+func (mh *MsgHandle) SetSheddable(msgID uint32) {
+	mh.sheddable[msgID] = true
+}
+
+//槽位的数量，分配策略按该数量取模，扩缩容时不变
+func (mh *MsgHandle) Size() int {
+	return len(mh.slots)
+}
+
+//当前处理任务的goroutine数量，随扩缩容变化
+func (mh *MsgHandle) Workers() int {
+	return int(atomic.LoadInt32(&mh.size))
+}
+
+//指定槽位的任务队列中正在排队的任务数量
+func (mh *MsgHandle) QueueLen(workerID int) int {
+	return mh.slots[workerID].queueLen()
+}
+
+//因为过载被拒绝的消息数量
+func (mh *MsgHandle) ShedCount() uint64 {
+	return atomic.LoadUint64(&mh.shedCount)
+}
+
+//启动单线程逻辑循环
+func (mh *MsgHandle) startLogicLoop() {
+	mh.logicSlot = newSlot(LogicLoopWorkerID, mh.maxWorkerTaskLen)
+	go mh.runLogicLoop(mh.logicSlot)
+}
+
+//获取每个槽位的任务队列当前排队的任务数量
+func (mh *MsgHandle) GetTaskQueueLens() []int {
+	lens := make([]int, mh.Size())
+	for i := range lens {
+		lens[i] = mh.QueueLen(i)
 	}
+	return lens
 }
@@ -0,0 +1,73 @@
+package znet
+
+import (
+	"server/utils"
+	"server/ziface"
+	"server/zlog"
+	"server/ztimer"
+)
+
+//Server的可选配置，通过NewServer(opts...)传入
+//不传入任何Option时，Server使用全局配置utils.GlobalObject、全局日志zlog.StdLog和默认封包拆包方式
+type Option func(s *Server)
+
+//使用独立的配置创建Server，同一进程中的多个Server可以各自使用不同的配置
+func WithConfig(conf *utils.GlobalObj) Option {
+	return func(s *Server) {
+		s.config = conf
+	}
+}
+
+//使用独立的日志对象
+func WithLogger(logger *zlog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+//使用自定义的封包拆包方式
+func WithPacker(packer ziface.IDataPack) Option {
+	return func(s *Server) {
+		s.packer = packer
+	}
+}
+
+//使用自定义的连接ID生成器，不传入时根据配置ConnIDGenerator创建
+func WithConnIDGenerator(gen ziface.IConnIDGenerator) Option {
+	return func(s *Server) {
+		s.connIDGen = gen
+	}
+}
+
+//使用自定义的时钟计算连接属性的有效期和登录超时，测试中替换为手动推进的时钟
+func WithClock(clock ztimer.Clock) Option {
+	return func(s *Server) {
+		s.clock = clock
+	}
+}
+
+//Server内部需要读取配置的模块通过该接口获取所属Server的配置
+//(ziface不能依赖utils，所以配置不放在IServer接口中)
+type configGetter interface {
+	GetConfig() *utils.GlobalObj
+}
+
+//获取Server的配置，不是znet.Server时使用全局配置
+func configOf(server ziface.IServer) *utils.GlobalObj {
+	if getter, ok := server.(configGetter); ok {
+		return getter.GetConfig()
+	}
+	return utils.GlobalObject
+}
+
+type clockGetter interface {
+	GetClock() ztimer.Clock
+}
+
+//获取Server的时钟，不是znet.Server时使用系统时钟
+func clockOf(server ziface.IServer) ztimer.Clock {
+	if getter, ok := server.(clockGetter); ok {
+		return getter.GetClock()
+	}
+	return ztimer.SystemClock
+}
@@ -0,0 +1,20 @@
+package znet
+
+/*
+消息优先级
+每个worker为每个优先级维护一个任务队列，优先处理高优先级的任务，
+同一个连接同一个优先级的消息按到达顺序处理，不同优先级之间不保证顺序
+*/
+const (
+	//高优先级，如移动、战斗等对延迟敏感的消息
+	PriorityHigh = iota
+	//普通优先级，未指定优先级的消息默认为普通优先级
+	PriorityNormal
+	//低优先级，如聊天等允许延迟的消息
+	PriorityLow
+
+	priorityClasses
+)
+
+//低优先级的任务在有任务的情况下最多连续被跳过多少次，保证不会被高优先级饿死
+const priorityMaxSkip = 8
@@ -0,0 +1,128 @@
+package znet
+
+import (
+	"fmt"
+)
+
+/*
+	带类型的连接属性读取方法
+	属性不存在或者类型不一致时返回error，不会像类型断言一样panic
+	Conn和后端节点上代表客户端连接的backendConn共用，嵌入后只需要实现GetProperty
+*/
+
+type propertyGetter interface {
+	GetProperty(key string) (interface{}, error)
+}
+
+//基于GetProperty实现全部带类型的读取方法
+type typedProperties struct {
+	getter propertyGetter
+}
+
+func propertyTypeError(key string, value interface{}, want string) error {
+	return fmt.Errorf("property %s is %T, not %s", key, value, want)
+}
+
+//获取int类型的连接属性
+func (p typedProperties) GetPropertyInt(key string) (int, error) {
+	value, err := p.getter.GetProperty(key)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := value.(int)
+	if !ok {
+		return 0, propertyTypeError(key, value, "int")
+	}
+	return v, nil
+}
+
+//获取int32类型的连接属性
+func (p typedProperties) GetPropertyInt32(key string) (int32, error) {
+	value, err := p.getter.GetProperty(key)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := value.(int32)
+	if !ok {
+		return 0, propertyTypeError(key, value, "int32")
+	}
+	return v, nil
+}
+
+//获取int64类型的连接属性
+func (p typedProperties) GetPropertyInt64(key string) (int64, error) {
+	value, err := p.getter.GetProperty(key)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := value.(int64)
+	if !ok {
+		return 0, propertyTypeError(key, value, "int64")
+	}
+	return v, nil
+}
+
+//获取uint32类型的连接属性
+func (p typedProperties) GetPropertyUint32(key string) (uint32, error) {
+	value, err := p.getter.GetProperty(key)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := value.(uint32)
+	if !ok {
+		return 0, propertyTypeError(key, value, "uint32")
+	}
+	return v, nil
+}
+
+//获取uint64类型的连接属性
+func (p typedProperties) GetPropertyUint64(key string) (uint64, error) {
+	value, err := p.getter.GetProperty(key)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := value.(uint64)
+	if !ok {
+		return 0, propertyTypeError(key, value, "uint64")
+	}
+	return v, nil
+}
+
+//获取float64类型的连接属性
+func (p typedProperties) GetPropertyFloat64(key string) (float64, error) {
+	value, err := p.getter.GetProperty(key)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := value.(float64)
+	if !ok {
+		return 0, propertyTypeError(key, value, "float64")
+	}
+	return v, nil
+}
+
+//获取string类型的连接属性
+func (p typedProperties) GetPropertyString(key string) (string, error) {
+	value, err := p.getter.GetProperty(key)
+	if err != nil {
+		return "", err
+	}
+	v, ok := value.(string)
+	if !ok {
+		return "", propertyTypeError(key, value, "string")
+	}
+	return v, nil
+}
+
+//获取bool类型的连接属性
+func (p typedProperties) GetPropertyBool(key string) (bool, error) {
+	value, err := p.getter.GetProperty(key)
+	if err != nil {
+		return false, err
+	}
+	v, ok := value.(bool)
+	if !ok {
+		return false, propertyTypeError(key, value, "bool")
+	}
+	return v, nil
+}
@@ -0,0 +1,365 @@
+package znet
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"server/utils"
+	"server/ziface"
+	"server/zlog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+	注册中心客户端：节点向注册中心(zregistry.Registry)登记自己的地址、负载和能力，
+	并按租约周期发送心跳续约，同时发现集群中的其他节点
+	心跳中断超过租约时间后注册中心删除该节点，客户端断线重连后自动重新注册
+
+	与注册中心之间使用普通的数据包(msgID + 数据)，数据为JSON
+*/
+
+//注册中心协议的msgID
+const (
+	//客户端->注册中心：注册节点，数据为RegistryRegister
+	RegistryMsgRegister uint32 = iota + 1
+	//客户端->注册中心：心跳续约，数据为RegistryHeartbeat
+	RegistryMsgHeartbeat
+	//客户端->注册中心：注销节点，数据为节点ID
+	RegistryMsgDeregister
+	//客户端->注册中心：订阅节点变更，注册中心随后推送全量同步和之后的变更
+	RegistryMsgWatch
+	//注册中心->客户端：全量同步开始，之后是每个节点的RegistryMsgNodeUp
+	RegistryMsgSyncBegin
+	//注册中心->客户端：全量同步结束，同步中没有出现的节点已经不存在
+	RegistryMsgSyncEnd
+	//注册中心->客户端：节点注册或者信息变化，数据为NodeInfo
+	RegistryMsgNodeUp
+	//注册中心->客户端：节点注销或者租约过期，数据为节点ID
+	RegistryMsgNodeDown
+	//注册中心->客户端：心跳的节点不存在(租约已经过期)，客户端需要重新注册，数据为节点ID
+	RegistryMsgUnknownNode
+)
+
+//注册请求
+type RegistryRegister struct {
+	Node ziface.NodeInfo `json:"node"`
+	//租约时间(毫秒)，超过该时间没有心跳时节点被删除
+	Lease int `json:"lease"`
+}
+
+//心跳请求
+type RegistryHeartbeat struct {
+	ID   string `json:"id"`
+	Load int    `json:"load"`
+}
+
+type RegistryClient struct {
+	packer ziface.IDataPack
+	logger *zlog.Logger
+	//到注册中心的常驻连接
+	link *Link
+	//租约时间
+	lease time.Duration
+	//本节点的注册信息，为nil时只发现其他节点
+	self *ziface.NodeInfo
+	//获取本节点当前负载，心跳时调用
+	loadFunc func() int
+	//发现的全部节点
+	nodes map[string]ziface.NodeInfo
+	//全量同步中收到的节点，为nil表示不在同步中
+	syncing map[string]bool
+	//节点变更的处理方法
+	watchers map[int]ziface.NodeWatcher
+	watchID  int
+	//保护以上字段，写入注册中心时不持有
+	lock sync.Mutex
+	//Close时关闭
+	exitChan chan struct{}
+}
+
+//连接注册中心，连接在后台建立并在断开后自动重连
+//conf需要与注册中心的MaxPacketSize一致，租约时间使用RegistryLease，为nil时使用全局配置
+func NewRegistryClient(addr string, conf *utils.GlobalObj) *RegistryClient {
+	if conf == nil {
+		conf = utils.GlobalObject
+	}
+	c := &RegistryClient{
+		packer:   NewDataPackWithConfig(conf),
+		logger:   zlog.StdLog,
+		lease:    time.Duration(conf.RegistryLease) * time.Millisecond,
+		nodes:    make(map[string]ziface.NodeInfo),
+		watchers: make(map[int]ziface.NodeWatcher),
+		exitChan: make(chan struct{}),
+	}
+	c.link = NewLink(addr, "[Registry]", c.logger, c.onConnect, c.read)
+	c.link.Start()
+	go c.heartbeat()
+	return c
+}
+
+//注册本节点，已经连接时立即注册，否则连接建立后注册
+//loadFunc在每次心跳时调用获取当前负载，为nil时使用node.Load
+func (c *RegistryClient) Register(node ziface.NodeInfo, loadFunc func() int) error {
+	if node.ID == "" {
+		return errors.New("registry node without ID")
+	}
+	c.lock.Lock()
+	c.self = &node
+	c.loadFunc = loadFunc
+	c.lock.Unlock()
+	return c.sendRegister()
+}
+
+//将Server注册为kind类型的节点，ID为Name，负载为当前连接数
+func (c *RegistryClient) RegisterServer(server ziface.IServer, kind string, capabilities ...string) error {
+	conf := configOf(server)
+	return c.Register(ziface.NodeInfo{
+		ID:           conf.Name,
+		Kind:         kind,
+		Addr:         net.JoinHostPort(conf.Host, strconv.Itoa(conf.TcpPort)),
+		Capabilities: capabilities,
+	}, server.GetConnMgr().Len)
+}
+
+//注销本节点，之后仍然可以发现其他节点
+func (c *RegistryClient) Deregister() error {
+	c.lock.Lock()
+	self := c.self
+	c.self = nil
+	c.lock.Unlock()
+
+	if self == nil {
+		return nil
+	}
+	frame, err := c.packer.Pack(NewMsgPackage(RegistryMsgDeregister, []byte(self.ID)))
+	if err != nil {
+		return err
+	}
+	return c.link.Send(frame)
+}
+
+//订阅节点变更，返回订阅ID用于取消订阅
+func (c *RegistryClient) Watch(watcher ziface.NodeWatcher) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.watchID++
+	c.watchers[c.watchID] = watcher
+	return c.watchID
+}
+
+//取消订阅节点变更
+func (c *RegistryClient) Unwatch(id int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.watchers, id)
+}
+
+//发现的kind类型的全部节点，kind为空时返回全部节点，按ID排序
+func (c *RegistryClient) Nodes(kind string) []ziface.NodeInfo {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	nodes := make([]ziface.NodeInfo, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		if kind == "" || node.Kind == kind {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+//选出kind类型中支持capability且负载最小的节点，capability为空时不限制能力
+func (c *RegistryClient) Pick(kind, capability string) (ziface.NodeInfo, bool) {
+	var best ziface.NodeInfo
+	found := false
+	for _, node := range c.Nodes(kind) {
+		if capability != "" && !node.HasCapability(capability) {
+			continue
+		}
+		if !found || node.Load < best.Load {
+			best, found = node, true
+		}
+	}
+	return best, found
+}
+
+//是否已经连接上注册中心
+func (c *RegistryClient) Ready() bool {
+	return c.link.Connected()
+}
+
+//注销本节点并断开注册中心，不再重连
+func (c *RegistryClient) Close() {
+	c.lock.Lock()
+	select {
+	case <-c.exitChan:
+		c.lock.Unlock()
+		return
+	default:
+		close(c.exitChan)
+	}
+	self := c.self
+	c.lock.Unlock()
+
+	if self != nil {
+		_ = c.send(RegistryMsgDeregister, []byte(self.ID))
+	}
+	c.link.Close()
+}
+
+//发送注册请求，没有注册本节点或者没有连接时忽略，连接建立后由onConnect注册
+//负载在锁外获取，loadFunc可能需要其他锁
+func (c *RegistryClient) sendRegister() error {
+	c.lock.Lock()
+	self, loadFunc := c.self, c.loadFunc
+	c.lock.Unlock()
+	if self == nil {
+		return nil
+	}
+	node := *self
+	if loadFunc != nil {
+		node.Load = loadFunc()
+	}
+	data, err := json.Marshal(&RegistryRegister{Node: node, Lease: int(c.lease / time.Millisecond)})
+	if err != nil {
+		return err
+	}
+	return c.send(RegistryMsgRegister, data)
+}
+
+//写一帧给注册中心，不持有lock，没有连接时忽略，写超时或者失败时关闭连接由常驻连接重连
+func (c *RegistryClient) send(msgID uint32, data []byte) error {
+	frame, err := c.packer.Pack(NewMsgPackage(msgID, data))
+	if err != nil {
+		return err
+	}
+	if err := c.link.Send(frame); err != nil && err != ErrLinkNotConnected {
+		return err
+	}
+	return nil
+}
+
+//按租约的三分之一周期发送心跳，直到Close
+func (c *RegistryClient) heartbeat() {
+	ticker := time.NewTicker(c.lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.exitChan:
+			return
+		case <-ticker.C:
+		}
+
+		//负载在锁外获取，loadFunc可能需要其他锁
+		c.lock.Lock()
+		self, loadFunc := c.self, c.loadFunc
+		c.lock.Unlock()
+		if self == nil {
+			continue
+		}
+		load := self.Load
+		if loadFunc != nil {
+			load = loadFunc()
+		}
+		data, err := json.Marshal(&RegistryHeartbeat{ID: self.ID, Load: load})
+		if err != nil {
+			continue
+		}
+
+		_ = c.send(RegistryMsgHeartbeat, data)
+	}
+}
+
+//连接注册中心之后重新注册本节点并订阅节点变更
+func (c *RegistryClient) onConnect() {
+	if err := c.sendRegister(); err == nil {
+		_ = c.send(RegistryMsgWatch, nil)
+	}
+}
+
+//读取注册中心推送的消息，直到连接断开
+func (c *RegistryClient) read(conn net.Conn) {
+	headData := make([]byte, c.packer.GetHeadLen())
+	for {
+		if _, err := io.ReadFull(conn, headData); err != nil {
+			return
+		}
+		msg, err := c.packer.UnPack(headData)
+		if err != nil {
+			return
+		}
+		data := make([]byte, msg.GetDataLen())
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		c.handle(msg.GetMsgID(), data)
+	}
+}
+
+//处理注册中心推送的一条消息，节点变更的处理方法在锁外调用
+func (c *RegistryClient) handle(msgID uint32, data []byte) {
+	var events []ziface.NodeEvent
+	reregister := false
+
+	c.lock.Lock()
+	switch msgID {
+	case RegistryMsgSyncBegin:
+		c.syncing = make(map[string]bool)
+	case RegistryMsgSyncEnd:
+		for id, node := range c.nodes {
+			if c.syncing != nil && !c.syncing[id] {
+				delete(c.nodes, id)
+				events = append(events, ziface.NodeEvent{Type: ziface.NodeRemoved, Node: node})
+			}
+		}
+		c.syncing = nil
+	case RegistryMsgNodeUp:
+		var node ziface.NodeInfo
+		if err := json.Unmarshal(data, &node); err != nil {
+			c.logger.Warn("[Registry] bad node from ", c.link.Addr(), " err ", err)
+			break
+		}
+		if c.syncing != nil {
+			c.syncing[node.ID] = true
+		}
+		eventType := ziface.NodeAdded
+		if _, ok := c.nodes[node.ID]; ok {
+			eventType = ziface.NodeUpdated
+		}
+		c.nodes[node.ID] = node
+		events = append(events, ziface.NodeEvent{Type: eventType, Node: node})
+	case RegistryMsgNodeDown:
+		if node, ok := c.nodes[string(data)]; ok {
+			delete(c.nodes, node.ID)
+			events = append(events, ziface.NodeEvent{Type: ziface.NodeRemoved, Node: node})
+		}
+	case RegistryMsgUnknownNode:
+		//租约已经过期(如长时间断线或者注册中心重启)，重新注册
+		if c.self != nil && c.self.ID == string(data) {
+			c.logger.Warn("[Registry] node ", c.self.ID, " lease expired, register again")
+			reregister = true
+		}
+	}
+	watchers := make([]ziface.NodeWatcher, 0, len(c.watchers))
+	for _, watcher := range c.watchers {
+		watchers = append(watchers, watcher)
+	}
+	c.lock.Unlock()
+
+	if reregister {
+		_ = c.sendRegister()
+	}
+	for _, event := range events {
+		for _, watcher := range watchers {
+			watcher(event)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package znet
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+/*
+	可靠传输
+	开启ReliableDelivery(需要同时开启会话恢复)后，双方发出的每条业务消息之前都有一帧MsgIDSeq，
+	数据为8字节小端序的消息序号，序号从1开始连续递增：
+		服务端把已经写出但客户端尚未确认的消息保存在重传缓存中(最多ReliableBufferLen条)，
+		客户端通过MsgIDAck(数据为已经连续收到的最大序号)确认之后从缓存中删除
+		恢复会话时客户端在令牌之后附带8字节已经收到的最大序号，服务端重传之后的全部消息，
+		重传缓存溢出之后客户端要求重传的消息可能已经被丢弃，这时恢复失败，
+		服务端发送MsgIDResync后关闭连接并销毁会话，客户端需要重新登录同步状态
+		服务端每收到reliableAckEvery条客户端消息以及恢复会话时发送MsgIDAck，
+		序号不大于已处理序号的客户端消息视为重传的重复消息，直接丢弃
+	不带MsgIDSeq的业务消息按普通消息处理
+*/
+
+//每收到多少条客户端消息确认一次
+const reliableAckEvery = 8
+
+//客户端需要的消息已经不在重传缓存中
+var errResyncRequired = errors.New("unacked msgs lost, resync required")
+
+//已经编号的消息，data中包含MsgIDSeq帧
+type reliableFrame struct {
+	seq  uint64
+	data []byte
+}
+
+//可靠传输状态，属于会话，恢复会话后由新连接继续使用，由session.lock保护
+type reliableState struct {
+	//重传缓存的最大数量
+	max int
+	//最后一条发出消息的序号
+	outSeq uint64
+	//已经写出但尚未确认的消息
+	unacked []reliableFrame
+	//已经处理的客户端消息的最大序号
+	inSeq uint64
+	//已经处理但尚未确认的客户端消息数量
+	inUnacked int
+	//刚收到的MsgIDSeq中的序号，0表示下一帧不是可靠消息
+	inNext uint64
+	//重传缓存是否溢出过
+	overflowed bool
+}
+
+func newReliableState(max int) *reliableState {
+	return &reliableState{max: max}
+}
+
+//为消息编号并放入重传缓存，pack用于生成MsgIDSeq帧
+//缓存已满时丢弃最早的消息，第一次溢出时overflow返回true，之后断线恢复需要这些消息时恢复失败
+func (r *reliableState) sequence(data []byte, pack func(seq uint64) []byte) (frame []byte, overflow bool) {
+	r.outSeq++
+	frame = append(pack(r.outSeq), data...)
+	if len(r.unacked) >= r.max {
+		r.unacked = r.unacked[1:]
+		overflow = !r.overflowed
+		r.overflowed = true
+	}
+	r.unacked = append(r.unacked, reliableFrame{seq: r.outSeq, data: frame})
+	return frame, overflow
+}
+
+//重传缓存中最早的消息序号
+func (r *reliableState) oldestSeq() uint64 {
+	if len(r.unacked) == 0 {
+		return r.outSeq + 1
+	}
+	return r.unacked[0].seq
+}
+
+//客户端确认收到seq及之前的全部消息
+func (r *reliableState) ack(seq uint64) {
+	i := 0
+	for i < len(r.unacked) && r.unacked[i].seq <= seq {
+		i++
+	}
+	r.unacked = r.unacked[i:]
+}
+
+//客户端已经收到ack及之前的消息，返回需要重传的消息，缓存中缺少需要重传的消息时返回errResyncRequired
+func (r *reliableState) replay(ack uint64) ([][]byte, error) {
+	if ack > r.outSeq {
+		ack = r.outSeq
+	}
+	if ack < r.outSeq && r.oldestSeq() > ack+1 {
+		return nil, errResyncRequired
+	}
+	r.ack(ack)
+	frames := make([][]byte, 0, len(r.unacked))
+	for _, f := range r.unacked {
+		frames = append(frames, f.data)
+	}
+	return frames, nil
+}
+
+//收到一条客户端业务消息，返回是否为重复消息，以及是否需要发送确认
+func (r *reliableState) receive() (dup bool, ackNow bool) {
+	seq := r.inNext
+	r.inNext = 0
+	if seq == 0 {
+		return false, false
+	}
+	if seq <= r.inSeq {
+		return true, false
+	}
+	r.inSeq = seq
+	r.inUnacked++
+	if r.inUnacked >= reliableAckEvery {
+		r.inUnacked = 0
+		return false, true
+	}
+	return false, false
+}
+
+/*
+	会话上的可靠传输操作，没有开启可靠传输时什么都不做
+*/
+
+//为conn写出的业务消息编号，conn已经不再持有会话时返回false
+func (s *session) sequence(conn *Conn, data []byte, pack func(seq uint64) []byte) ([]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conn != conn || s.parked {
+		return nil, false
+	}
+	frame, overflow := s.reliable.sequence(data, pack)
+	if overflow {
+		conn.logger.Warn("ConnID = ", conn.ConnID, " reliable buffer overflow, resume will require resync if unacked msgs are lost")
+	}
+	return frame, true
+}
+
+//处理可靠传输的系统消息
+func (s *session) onSysMsg(msgID uint32, data []byte) {
+	if s.reliable == nil || len(data) < 8 {
+		return
+	}
+	seq := binary.LittleEndian.Uint64(data)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch msgID {
+	case MsgIDSeq:
+		s.reliable.inNext = seq
+	case MsgIDAck:
+		s.reliable.ack(seq)
+	}
+}
+
+//收到一条客户端业务消息，返回是否为重复消息，以及需要确认的序号(0表示不需要确认)
+func (s *session) receive() (bool, uint64) {
+	if s.reliable == nil {
+		return false, 0
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	dup, ackNow := s.reliable.receive()
+	if ackNow {
+		return dup, s.reliable.inSeq
+	}
+	return dup, 0
+}
+
+//已经处理的客户端消息的最大序号
+func (s *session) inSeq() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.reliable.inSeq
+}
+
+func encodeSeq(seq uint64) []byte {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, seq)
+	return data
+}
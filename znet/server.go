@@ -1,10 +1,15 @@
 package znet
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"server/utils"
 	"server/ziface"
+	"server/zlog"
+	"server/ztimer"
+	"sync"
+	"time"
 )
 
 //IServer接口实现，定义一个Server服务类
@@ -25,18 +30,70 @@ type Server struct {
 	OnConnStart func(conn ziface.IConn)
 	//该Server的连接断开时的Hook函数
 	OnConnStop func(conn ziface.IConn)
+	//该Server的连接恢复会话时的Hook函数
+	OnSessionResume func(conn ziface.IConn)
+	//该Server的连接属性变更时的Hook函数
+	OnPropertyChange func(conn ziface.IConn, key string, oldValue, newValue interface{})
+	//当前Server的配置
+	config *utils.GlobalObj
+	//当前Server运行期可以热更新的配置
+	live *liveConf
+	//当前Server的日志对象
+	logger *zlog.Logger
+	//当前Server的封包拆包方式
+	packer ziface.IDataPack
+	//当前Server的连接ID生成器
+	connIDGen ziface.IConnIDGenerator
+	//连接属性有效期和登录超时使用的时钟
+	clock ztimer.Clock
+	//会话管理，没有开启会话恢复时为nil
+	sessions *sessionMgr
+	//连接生命周期和消息收发的事件总线
+	events *EventBus
+	//当前Server的监听socket
+	listener *net.TCPListener
+	//Server停止时关闭
+	exitChan chan struct{}
+	//保护listener和exitChan的锁
+	lock sync.Mutex
 }
 
 //创建一个服务器句柄
-func NewServer() ziface.IServer {
+func NewServer(opts ...Option) ziface.IServer {
 	s := &Server{
-		Name:       utils.GlobalObject.Name,
-		IPVersion:  "tcp4",
-		IP:         utils.GlobalObject.Host,
-		Port:       utils.GlobalObject.TcpPort,
-		msgHandler: NewMsgHandle(),
-		ConnMgr:    NewConnMgr(),
+		IPVersion: "tcp4",
+		exitChan:  make(chan struct{}),
+		config:    utils.GlobalObject,
+		logger:    zlog.StdLog,
+		clock:     ztimer.SystemClock,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.Name = s.config.Name
+	s.IP = s.config.Host
+	s.Port = s.config.TcpPort
+	s.live = liveConfOf(s.config)
+	if s.packer == nil {
+		s.packer = NewDataPackWithConfig(s.config)
+	}
+	if s.connIDGen == nil {
+		gen, err := newConnIDGenerator(s.config.ConnIDGenerator, s.config.NodeID)
+		if err != nil {
+			s.logger.Error("create conn id generator err: ", err, ", use seq instead")
+			gen = NewSeqConnIDGenerator(0)
+		}
+		s.connIDGen = gen
+	}
+	if s.config.SessionGracePeriod > 0 {
+		s.sessions = newSessionMgr(s,
+			time.Duration(s.config.SessionGracePeriod)*time.Second,
+			time.Duration(s.config.SessionResumeWait)*time.Millisecond)
+	}
+	s.events = NewEventBus(s.logger)
+	s.msgHandler = newMsgHandle(s.config, s.logger, s.events)
+	s.ConnMgr = newConnMgr(s.logger)
 	return s
 }
 
@@ -44,7 +101,8 @@ func NewServer() ziface.IServer {
 
 //开启网络环境
 func (s *Server) Start() {
-	fmt.Printf("[START] Server name: %s,listenner at IP: %s, Port %d is starting\n", s.Name, s.IP, s.Port)
+	s.logger.Infof("[START] Server name: %s,listenner at IP: %s, Port %d is starting\n", s.Name, s.IP, s.Port)
+	s.logger.Info(s.config.Dump())
 
 	//开启一个go去做服务端Linster业务
 	go func() {
@@ -54,53 +112,116 @@ func (s *Server) Start() {
 		//1.获取一个TCP的Addr
 		addr, err := net.ResolveTCPAddr(s.IPVersion, fmt.Sprintf("%s:%d", s.IP, s.Port))
 		if err != nil {
-			fmt.Println("resolve tcp addr err: ", err)
+			s.logger.Error("resolve tcp addr err: ", err)
 			return
 		}
 		//2.监听服务器地址
 		listener, err := net.ListenTCP(s.IPVersion, addr)
 		if err != nil {
-			fmt.Println("listen", s.IPVersion, "err", err)
+			s.logger.Error("listen ", s.IPVersion, " err ", err)
+			return
+		}
+		s.lock.Lock()
+		select {
+		case <-s.exitChan:
+			//监听成功之前Server已经被停止
+			s.lock.Unlock()
+			listener.Close()
 			return
+		default:
+			s.listener = listener
 		}
+		s.lock.Unlock()
 		//已经监听成功
-		fmt.Println("start Server  ", s.Name, " succ, now listenning...")
-
-		//生成连接ID
-		var connID uint32
-		connID = 0
+		s.logger.Info("start Server  ", s.Name, " succ, now listenning...")
 
 		//3.启动server网络连接业务
 		for {
 			//3.1阻塞等待客户端建立连接请求
 			conn, err := listener.AcceptTCP()
 			if err != nil {
-				fmt.Println("Accept err ", err)
+				select {
+				case <-s.exitChan:
+					//Server已经停止，监听socket已关闭
+					return
+				default:
+				}
+				s.logger.Error("Accept err ", err)
 				continue
 			}
-			fmt.Println("Get conn remote addr = ", conn.RemoteAddr().String())
+			s.logger.Debug("Get conn remote addr = ", conn.RemoteAddr().String())
 
-			//3.2设置服务器最大连接控制，如果超过最大连接，则关闭此当前新连接
-			if s.ConnMgr.Len() > utils.GlobalObject.MaxConn {
-				conn.Close()
+			//3.2处理该新连接请求的 业务 方法， 此时应该有 handler 和 conn是绑定的
+			dealConn, err := s.newConn(conn)
+			if err != nil {
 				continue
 			}
-			//3.3处理该新连接请求的 业务 方法， 此时应该有 handler 和 conn是绑定的
-			dealConn := NewConn(s, conn, connID, s.msgHandler)
-			connID++
-
-			//3.4启动当前链接的处理业务
+			//3.3启动当前链接的处理业务
 			go dealConn.Start()
 		}
 	}()
 }
 
+//在已经建立的conn上创建连接，用于自定义的监听方式以及测试中使用net.Pipe
+//返回时连接已经启动，没有开启握手和会话恢复时OnConnStart已经调用
+//超过最大连接数或者无法生成连接ID时关闭conn并返回error
+func (s *Server) ServeConn(conn net.Conn) (ziface.IConn, error) {
+	dealConn, err := s.newConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	dealConn.Start()
+	return dealConn, nil
+}
+
+//检查连接数上限并生成连接ID，创建连接并发布EventConnAccepted
+func (s *Server) newConn(conn net.Conn) (*Conn, error) {
+	//设置服务器最大连接控制，如果超过最大连接，则关闭此当前新连接
+	if s.ConnMgr.Len() > s.live.MaxConn() {
+		s.reject(conn, "too many connections")
+		return nil, errors.New("too many connections")
+	}
+	//生成连接ID，跳过仍在使用中的ID
+	connID, err := s.connIDGen.NextConnID(s.ConnMgr)
+	if err != nil {
+		s.logger.Error("generate conn id err ", err)
+		s.reject(conn, "generate conn id err: "+err.Error())
+		return nil, err
+	}
+	dealConn := NewConn(s, conn, connID, s.msgHandler)
+	s.events.Publish(&ziface.Event{Type: ziface.EventConnAccepted, Conn: dealConn})
+	return dealConn, nil
+}
+
+//拒绝新连接
+func (s *Server) reject(conn net.Conn, reason string) {
+	s.events.Publish(&ziface.Event{
+		Type:       ziface.EventConnRejected,
+		RemoteAddr: conn.RemoteAddr(),
+		Reason:     reason,
+	})
+	conn.Close()
+}
+
 //停止网络并清理
 func (s *Server) Stop() {
-	fmt.Println("[STOP] Server , name ", s.Name)
+	s.logger.Info("[STOP] Server , name ", s.Name)
+
+	//关闭监听socket，不再接收新的连接
+	s.lock.Lock()
+	select {
+	case <-s.exitChan:
+	default:
+		close(s.exitChan)
+	}
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.lock.Unlock()
 
 	//将其他需要清理的连接信息或者其他信息 也要一并停止或者清理
 	s.ConnMgr.ClearConn()
+	s.msgHandler.StopWorkerPool()
 }
 
 //运行服务器
@@ -117,6 +238,36 @@ func (s *Server) GetConnMgr() ziface.IConnMgr {
 	return s.ConnMgr
 }
 
+//得到消息管理
+func (s *Server) GetMsgHandler() ziface.IMsgHandle {
+	return s.msgHandler
+}
+
+//得到当前Server的配置
+func (s *Server) GetConfig() *utils.GlobalObj {
+	return s.config
+}
+
+//得到当前Server的时钟
+func (s *Server) GetClock() ztimer.Clock {
+	return s.clock
+}
+
+//得到当前Server的日志对象
+func (s *Server) GetLogger() *zlog.Logger {
+	return s.logger
+}
+
+//得到当前Server的封包拆包方式
+func (s *Server) GetPacker() ziface.IDataPack {
+	return s.packer
+}
+
+//得到当前Server的事件总线
+func (s *Server) GetEventBus() ziface.IEventBus {
+	return s.events
+}
+
 //设置该Server的连接创建时Hook函数
 func (s *Server) SetOnConnStart(hookFunc func(ziface.IConn)) {
 	s.OnConnStart = hookFunc
@@ -127,10 +278,20 @@ func (s *Server) SetOnConnStop(hookFunc func(ziface.IConn)) {
 	s.OnConnStop = hookFunc
 }
 
+//设置该Server的连接恢复会话时的Hook函数
+func (s *Server) SetOnSessionResume(hookFunc func(ziface.IConn)) {
+	s.OnSessionResume = hookFunc
+}
+
+//设置该Server的连接属性变更时的Hook函数
+func (s *Server) SetOnPropertyChange(hookFunc func(conn ziface.IConn, key string, oldValue, newValue interface{})) {
+	s.OnPropertyChange = hookFunc
+}
+
 //调用连接OnConnStart Hook函数
 func (s *Server) CallOnConnStart(conn ziface.IConn) {
 	if s.OnConnStart != nil {
-		fmt.Println("---> CallOnConnStart....")
+		s.logger.Debug("---> CallOnConnStart....")
 		s.OnConnStart(conn)
 	}
 }
@@ -138,11 +299,31 @@ func (s *Server) CallOnConnStart(conn ziface.IConn) {
 //调用连接OnConnStop Hook函数
 func (s *Server) CallOnConnStop(conn ziface.IConn) {
 	if s.OnConnStop != nil {
-		fmt.Println("---> CallOnConnStop....")
+		s.logger.Debug("---> CallOnConnStop....")
 		s.OnConnStop(conn)
 	}
 }
 
+//调用连接OnSessionResume Hook函数
+func (s *Server) CallOnSessionResume(conn ziface.IConn) {
+	if s.OnSessionResume != nil {
+		s.logger.Debug("---> CallOnSessionResume....")
+		s.OnSessionResume(conn)
+	}
+}
+
+//调用连接属性变更Hook函数
+func (s *Server) CallOnPropertyChange(conn ziface.IConn, key string, oldValue, newValue interface{}) {
+	if s.OnPropertyChange != nil {
+		s.OnPropertyChange(conn, key, oldValue, newValue)
+	}
+}
+
+//得到会话管理
+func (s *Server) sessionMgr() *sessionMgr {
+	return s.sessions
+}
+
 func (s *Server) AddRouter(msgID uint32, router ziface.IRouter) {
 	s.msgHandler.AddRouter(msgID, router)
 }
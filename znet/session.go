@@ -0,0 +1,364 @@
+package znet
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"server/ziface"
+	"server/ztimer"
+	"sync"
+	"time"
+)
+
+/*
+	会话恢复
+	开启SessionGracePeriod后，服务端在连接建立时下发恢复令牌(MsgIDResume)，
+	连接因为网络原因断开后会话保留SessionGracePeriod秒，保留期内：
+		连接属性保持不变，发给该连接的消息和尚未写出的缓冲消息被缓存起来
+		客户端重连后第一帧发送MsgIDResume携带令牌，新连接接管会话，
+		缓存的消息补发给客户端，并调用OnSessionResume而不是OnConnStart
+	保留期结束仍未恢复时才调用OnConnStop
+	服务端主动Stop的连接(踢人、停服)不保留会话
+
+	新连接在SessionResumeWait毫秒内没有收到第一帧时按新会话处理，
+	不需要恢复的客户端可以在连接后立即发送一个空的MsgIDResume跳过等待
+*/
+
+//令牌无效或者会话已经过期
+var errResumeInvalid = errors.New("invalid or expired resume token")
+
+type session struct {
+	//恢复令牌，每次恢复后更换，由sessionMgr.lock保护
+	token string
+	//保护以下字段
+	lock sync.Mutex
+	//当前持有会话的连接
+	conn *Conn
+	//连接已断开，处于保留期
+	parked bool
+	//保留期内缓存的待发送消息
+	pending []outFrame
+	//保留期结束的定时器
+	timer ztimer.ClockTimer
+	//可靠传输状态，没有开启可靠传输时为nil
+	reliable *reliableState
+}
+
+//会话管理
+type sessionMgr struct {
+	server *Server
+	//断开后会话保留时间
+	grace time.Duration
+	//新连接等待恢复请求的时间
+	resumeWait time.Duration
+	//保留期计时使用的时钟
+	clock ztimer.Clock
+	//是否开启可靠传输，以及未确认消息的最大缓存数量
+	reliable    bool
+	reliableLen int
+	//保护sessions
+	lock     sync.Mutex
+	sessions map[string]*session
+}
+
+func newSessionMgr(server *Server, grace, resumeWait time.Duration) *sessionMgr {
+	return &sessionMgr{
+		server:      server,
+		grace:       grace,
+		resumeWait:  resumeWait,
+		clock:       server.clock,
+		reliable:    server.config.ReliableDelivery,
+		reliableLen: int(server.config.ReliableBufferLen),
+		sessions:    make(map[string]*session),
+	}
+}
+
+//Server内部通过该接口获取会话管理，没有开启会话恢复时返回nil
+type sessionMgrGetter interface {
+	sessionMgr() *sessionMgr
+}
+
+func sessionMgrOf(server ziface.IServer) *sessionMgr {
+	if getter, ok := server.(sessionMgrGetter); ok {
+		return getter.sessionMgr()
+	}
+	return nil
+}
+
+//恢复令牌的长度
+const resumeTokenLen = 32
+
+func newResumeToken() string {
+	buf := make([]byte, resumeTokenLen/2)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+//为新连接创建会话
+func (sm *sessionMgr) open(conn *Conn) *session {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	s := &session{token: newResumeToken(), conn: conn}
+	if sm.reliable {
+		s.reliable = newReliableState(sm.reliableLen)
+	}
+	sm.sessions[s.token] = s
+	return s
+}
+
+//恢复会话的结果
+type resumeResult struct {
+	session *session
+	//被接管的旧连接
+	old *Conn
+	//需要按顺序补发的消息：已经编号的重传消息在前，尚未写出的消息在后
+	replay  [][]byte
+	pending []outFrame
+	//需要重新同步时服务端还能重传的最小序号
+	oldestSeq uint64
+}
+
+//用令牌恢复会话，ack为客户端已经收到的消息序号
+//令牌无效或者会话已过期时返回errResumeInvalid，无法完整重传时销毁会话并返回errResyncRequired
+func (sm *sessionMgr) resume(token string, ack uint64, conn *Conn) (resumeResult, error) {
+	sm.lock.Lock()
+	s, ok := sm.sessions[token]
+	sm.lock.Unlock()
+	if !ok {
+		return resumeResult{}, errResumeInvalid
+	}
+
+	s.lock.Lock()
+	old, parked := s.conn, s.parked
+	s.lock.Unlock()
+	//旧连接还没有发现网络断开(常见于移动网络切换)，由新连接接管，旧连接进入保留期
+	if !parked {
+		old.stop(true, "taken over by resumed connection")
+	}
+
+	sm.lock.Lock()
+	s.lock.Lock()
+	if sm.sessions[token] != s || !s.parked {
+		//期间会话已经过期
+		s.lock.Unlock()
+		sm.lock.Unlock()
+		return resumeResult{}, errResumeInvalid
+	}
+	var replay [][]byte
+	if s.reliable != nil {
+		var err error
+		if replay, err = s.reliable.replay(ack); err != nil {
+			//会话已经无法恢复，马上销毁而不是等待保留期结束
+			result := resumeResult{old: old, oldestSeq: s.reliable.oldestSeq()}
+			s.timer.Stop()
+			delete(sm.sessions, token)
+			s.lock.Unlock()
+			sm.lock.Unlock()
+
+			sm.server.logger.Warn("session of ConnID = ", old.ConnID, " lost unacked msgs after seq ", ack, ", resync required")
+			sm.server.CallOnConnStop(old)
+			return result, err
+		}
+	}
+	defer sm.lock.Unlock()
+	defer s.lock.Unlock()
+	s.timer.Stop()
+	delete(sm.sessions, token)
+
+	result := resumeResult{session: s, old: old, replay: replay, pending: s.pending}
+	s.token = newResumeToken()
+	s.conn = conn
+	s.parked = false
+	s.pending = nil
+	s.timer = nil
+	sm.sessions[s.token] = s
+	return result, nil
+}
+
+//连接断开，会话进入保留期，buffered为连接断开时尚未写出的缓冲消息
+func (sm *sessionMgr) park(s *session, buffered []outFrame) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.parked = true
+	s.pending = append(s.pending, buffered...)
+	s.timer = sm.clock.AfterFunc(sm.grace, func() {
+		sm.expire(s)
+	})
+}
+
+//保留期结束仍未恢复，销毁会话并调用OnConnStop
+func (sm *sessionMgr) expire(s *session) {
+	sm.lock.Lock()
+	s.lock.Lock()
+	if sm.sessions[s.token] != s || !s.parked {
+		s.lock.Unlock()
+		sm.lock.Unlock()
+		return
+	}
+	delete(sm.sessions, s.token)
+	conn := s.conn
+	s.lock.Unlock()
+	sm.lock.Unlock()
+
+	sm.server.logger.Debug("session of ConnID = ", conn.GetConnID(), " expired")
+	sm.server.CallOnConnStop(conn)
+}
+
+//连接被服务端主动关闭，直接销毁会话
+func (sm *sessionMgr) close(s *session) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	if sm.sessions[s.token] == s {
+		delete(sm.sessions, s.token)
+	}
+}
+
+//保留期内发给旧连接的消息缓存起来，恢复后补发，返回是否缓存成功
+//一条大消息的多个分片要么全部缓存，要么全部不缓存
+func (sm *sessionMgr) buffer(s *session, conn *Conn, frames ...outFrame) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.parked || s.conn != conn || uint32(len(s.pending)+len(frames)) > liveConfOf(sm.server.config).MaxMsgChanLen() {
+		return false
+	}
+	s.pending = append(s.pending, frames...)
+	return true
+}
+
+//连接是否仍然持有会话
+func (s *session) ownedBy(conn *Conn) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.conn == conn && !s.parked
+}
+
+//等待客户端的第一帧：携带有效令牌的MsgIDResume恢复会话，否则新建会话，返回false表示连接已经断开
+func (c *Conn) startSession() bool {
+	headData := make([]byte, c.packer.GetHeadLen())
+	_ = c.Conn.SetReadDeadline(time.Now().Add(c.sessions.resumeWait))
+	n, err := io.ReadFull(c.Conn, headData)
+	_ = c.Conn.SetReadDeadline(time.Time{})
+
+	if ne, ok := err.(net.Error); ok && ne.Timeout() && n > 0 {
+		//读到一半超时，继续读完MsgHead
+		_, err = io.ReadFull(c.Conn, headData[n:])
+	}
+
+	var msg ziface.IMsg
+	switch ne, ok := err.(net.Error); {
+	case ok && ne.Timeout():
+		//等待超时，客户端没有发送第一帧，按新会话处理
+	case err != nil:
+		c.logger.Debug("read msg head error ", err)
+		return false
+	default:
+		if msg, err = c.readBody(headData); err != nil {
+			return false
+		}
+	}
+
+	if msg != nil && msg.GetMsgID() == MsgIDResume && msg.GetDataLen() >= resumeTokenLen {
+		err := c.resumeSession(msg.GetData())
+		switch err {
+		case nil:
+			return true
+		case errResyncRequired:
+			//丢失了客户端需要的消息，不能悄悄按新会话继续，通知客户端重新同步
+			return false
+		}
+		c.logger.Debug("ConnID = ", c.ConnID, " resume session failed: ", err, ", start a new session")
+	}
+
+	s := c.sessions.open(c)
+	c.setSession(s)
+	_ = c.SendBuffMsg(MsgIDResume, []byte(s.token))
+	c.onStarted()
+
+	if msg != nil && msg.GetMsgID() != MsgIDResume {
+		return c.processMsg(msg) == nil
+	}
+	return true
+}
+
+//接管令牌对应的会话，恢复连接属性并补发缓存的消息
+//data为恢复令牌，开启可靠传输时令牌之后附带8字节客户端已经收到的最大消息序号
+//需要重新同步时发送MsgIDResync并关闭连接，返回errResyncRequired
+func (c *Conn) resumeSession(data []byte) error {
+	token := string(data[:resumeTokenLen])
+	var ack uint64
+	if len(data) >= resumeTokenLen+8 {
+		ack = binary.LittleEndian.Uint64(data[resumeTokenLen:])
+	}
+	result, err := c.sessions.resume(token, ack, c)
+	if err == errResyncRequired {
+		//连接还没有开始处理消息，直接写出后关闭
+		if frame, err := c.packer.Pack(NewMsgPackage(MsgIDResync, encodeSeq(result.oldestSeq))); err == nil {
+			_, _ = c.Conn.Write(frame)
+		}
+		c.stop(false, "resync required")
+	}
+	if err != nil {
+		return err
+	}
+	s, old := result.session, result.old
+	c.setSession(s)
+
+	//接管旧连接的属性，有效期属性按剩余时间重新计时，并调用属性变更Hook
+	properties, ttls := old.takeProperties()
+	for key, value := range properties {
+		c.setProperty(key, value, ttls[key])
+	}
+
+	//依次发送新令牌、服务端已经处理的客户端消息序号、重传消息、断线期间缓存的消息
+	_ = c.SendBuffMsg(MsgIDResume, []byte(s.token))
+	if s.reliable != nil {
+		_ = c.SendBuffMsg(MsgIDAck, encodeSeq(s.inSeq()))
+	}
+	//重传消息已经带有MsgIDSeq帧，不再编号
+	frames := make([]outFrame, 0, len(result.replay)+len(result.pending))
+	for _, frame := range result.replay {
+		frames = append(frames, outFrame{data: frame})
+	}
+	for _, frame := range append(frames, result.pending...) {
+		select {
+		case c.msgBuffChan <- frame:
+		case <-c.ctx.Done():
+			return errConnStopped
+		}
+	}
+
+	//恢复旧连接的认证状态
+	if old.IsAuthenticated() {
+		c.SetAuthenticated()
+	} else {
+		c.startLoginTimer()
+	}
+
+	c.logger.Info("ConnID = ", c.ConnID, " resumed session of ConnID = ", old.ConnID)
+	c.TcpServer.CallOnSessionResume(c)
+	c.publish(&ziface.Event{Type: ziface.EventConnStarted, Reason: "session resumed"})
+	return nil
+}
+
+//获取当前连接持有的会话
+func (c *Conn) getSession() *session {
+	c.sessionLock.Lock()
+	defer c.sessionLock.Unlock()
+
+	return c.session
+}
+
+func (c *Conn) setSession(s *session) {
+	c.sessionLock.Lock()
+	defer c.sessionLock.Unlock()
+
+	c.session = s
+}
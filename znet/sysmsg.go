@@ -0,0 +1,36 @@
+package znet
+
+/*
+框架内部使用的系统消息ID，占用msgID最高的一段，业务消息不能使用
+*/
+const (
+	//系统消息ID的起始值
+	SysMsgIDBase uint32 = 0xFFFFFF00
+
+	//会话恢复：服务端下发恢复令牌，客户端重连后的第一帧携带令牌恢复会话
+	MsgIDResume = SysMsgIDBase + 1
+	//可靠传输：紧跟其后的业务消息的序号
+	MsgIDSeq = SysMsgIDBase + 2
+	//可靠传输：确认已经连续收到的最大序号
+	MsgIDAck = SysMsgIDBase + 3
+	//心跳：数据为发送方的时间戳，收到MsgIDPing的一方原样回复MsgIDPong，用于测量RTT
+	MsgIDPing = SysMsgIDBase + 4
+	MsgIDPong = SysMsgIDBase + 5
+	//大消息分片：数据为分片头和一段业务消息数据，接收方重组后按原msgID处理
+	MsgIDFragment = SysMsgIDBase + 6
+	//连接握手：开启RequireHandshake时客户端的第一帧，服务端回复握手结果
+	MsgIDHandshake = SysMsgIDBase + 7
+	//可靠传输：重传缓存中已经没有客户端需要的消息，会话无法恢复，数据为8字节服务端还能重传的最小序号，
+	//服务端发送后关闭连接，客户端需要重新登录并同步全部状态
+	MsgIDResync = SysMsgIDBase + 8
+)
+
+//是否为系统消息ID
+func IsSysMsgID(msgID uint32) bool {
+	return msgID >= SysMsgIDBase
+}
+
+//是否为承载业务数据的帧：业务消息和业务消息的分片，需要可靠传输的编号和确认
+func isDataFrame(msgID uint32) bool {
+	return !IsSysMsgID(msgID) || msgID == MsgIDFragment
+}
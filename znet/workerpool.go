@@ -0,0 +1,301 @@
+package znet
+
+import (
+	"encoding/binary"
+	"server/ziface"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	弹性Worker池
+	请求由分配策略分到固定数量的槽位中(MaxWorkerPoolSize，没有配置扩容时为WorkerPoolSize)，每个槽位有自己的任务队列；
+	处理任务的goroutine数量在[WorkerPoolSize, MaxWorkerPoolSize]之间根据任务队列占用和任务排队时间自动扩缩容：
+	任意一个队列的占用达到WorkerScaleUpQueuePercent或者任务平均排队时间达到WorkerScaleUpLatency时扩容一个goroutine，
+	连续几次检查全部队列为空时缩容一个goroutine；
+	被标记为可丢弃(SetSheddable)的低优先级消息在任务队列拥堵时直接拒绝，不再阻塞连接的Reader
+
+	有任务的槽位进入就绪队列，goroutine从就绪队列取出一个槽位，处理最多slotBatch个任务后再放回就绪队列，
+	同一时刻一个槽位只由一个goroutine处理。扩缩容只改变goroutine的数量，不改变请求到槽位的映射，
+	所以同一个连接(同一个槽位)的消息在扩缩容时仍然按到达顺序依次处理
+*/
+
+//连续多少次检查空闲之后缩容
+const workerIdleTicksToShrink = 3
+
+//goroutine取出一个槽位后最多连续处理的任务数量，之后放回就绪队列，避免繁忙的槽位饿死其他槽位
+const slotBatch = 16
+
+//排队中的任务
+type task struct {
+	request ziface.IRequest
+	//进入任务队列的时间，用于统计排队时间
+	enqueueTime time.Time
+}
+
+//任务槽位，分配策略返回的workerID即槽位的下标
+type slot struct {
+	id int
+	//每个优先级一个任务队列
+	queues [priorityClasses]chan task
+	//每个优先级在有任务的情况下连续被跳过的次数，只由持有槽位的goroutine读写
+	skipped [priorityClasses]int
+	//槽位是否已经在就绪队列中或者正在被处理，为1时其他goroutine不会再取到该槽位
+	scheduled int32
+	//单线程逻辑循环的槽位由专用goroutine处理，有新任务入队时唤醒
+	signal chan struct{}
+}
+
+func newSlot(id int, queueLen uint32) *slot {
+	s := &slot{
+		id:     id,
+		signal: make(chan struct{}, 1),
+	}
+	for i := range s.queues {
+		s.queues[i] = make(chan task, queueLen)
+	}
+	return s
+}
+
+//唤醒逻辑循环的goroutine
+func (s *slot) notify() {
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+//全部队列中排队的任务数量
+func (s *slot) queueLen() int {
+	n := 0
+	for _, queue := range s.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+//最拥堵的队列的占用百分比
+func (s *slot) fillPercent() int {
+	fill := 0
+	for _, queue := range s.queues {
+		if cap(queue) > 0 && len(queue)*100/cap(queue) > fill {
+			fill = len(queue) * 100 / cap(queue)
+		}
+	}
+	return fill
+}
+
+//取出下一个要处理的任务：优先处理高优先级的任务，
+//低优先级连续被跳过priorityMaxSkip次之后处理一次，避免饿死
+//只有持有槽位的goroutine会从队列中取任务，所以队列长度大于0时一定能取到
+func (s *slot) next() (task, bool) {
+	pick := -1
+	for class, queue := range s.queues {
+		if len(queue) == 0 {
+			continue
+		}
+		if pick < 0 {
+			pick = class
+		}
+		if s.skipped[class] >= priorityMaxSkip {
+			pick = class
+			break
+		}
+	}
+	if pick < 0 {
+		return task{}, false
+	}
+
+	for class := pick + 1; class < priorityClasses; class++ {
+		if len(s.queues[class]) > 0 {
+			s.skipped[class]++
+		}
+	}
+	s.skipped[pick] = 0
+	return <-s.queues[pick], true
+}
+
+//处理任务的goroutine，不断从就绪队列中取出槽位处理，被缩容时退出
+func (mh *MsgHandle) runWorker(id int) {
+	mh.logger.Debug("Worker ID = ", id, " is started.")
+	defer mh.logger.Debug("Worker ID = ", id, " is stopped.")
+
+	for {
+		select {
+		case s := <-mh.ready:
+			mh.runSlot(s)
+		case <-mh.retire:
+			return
+		case <-mh.exitChan:
+			return
+		}
+	}
+}
+
+//处理一个槽位中最多slotBatch个任务，之后释放槽位
+func (mh *MsgHandle) runSlot(s *slot) {
+	for i := 0; i < slotBatch; i++ {
+		t, ok := s.next()
+		if !ok {
+			break
+		}
+		mh.doTask(t)
+	}
+	atomic.StoreInt32(&s.scheduled, 0)
+	//释放之前入队的任务没能调度槽位，释放之后再检查一次
+	if s.queueLen() > 0 {
+		mh.schedule(s)
+	}
+}
+
+//槽位有新任务，没有在就绪队列中时放入就绪队列
+func (mh *MsgHandle) schedule(s *slot) {
+	if s.id == LogicLoopWorkerID {
+		s.notify()
+		return
+	}
+	if atomic.CompareAndSwapInt32(&s.scheduled, 0, 1) {
+		//每个槽位最多在就绪队列中出现一次，就绪队列的容量为槽位数量，不会阻塞
+		mh.ready <- s
+	}
+}
+
+//单线程逻辑循环，由专用goroutine处理逻辑循环槽位的任务
+func (mh *MsgHandle) runLogicLoop(s *slot) {
+	mh.logger.Debug("Logic loop is started.")
+	defer mh.logger.Debug("Logic loop is stopped.")
+
+	for {
+		if t, ok := s.next(); ok {
+			mh.doTask(t)
+			continue
+		}
+		select {
+		case <-s.signal:
+		case <-mh.exitChan:
+			return
+		}
+	}
+}
+
+func (mh *MsgHandle) doTask(t task) {
+	mh.observeWait(time.Since(t.enqueueTime))
+	mh.DoMsgHandler(t.request)
+}
+
+//记录任务排队时间，取指数加权平均
+func (mh *MsgHandle) observeWait(wait time.Duration) {
+	atomic.AddInt64(&mh.processed, 1)
+	for {
+		old := atomic.LoadInt64(&mh.avgWait)
+		avg := old + (int64(wait)-old)/8
+		if atomic.CompareAndSwapInt64(&mh.avgWait, old, avg) {
+			return
+		}
+	}
+}
+
+//将请求写入槽位的任务队列
+func (mh *MsgHandle) enqueue(s *slot, request ziface.IRequest) {
+	t := task{request: request, enqueueTime: time.Now()}
+	queue := s.queues[mh.priorityOf(request.GetMsgID())]
+	sheddable := mh.sheddable[request.GetMsgID()]
+
+	if sheddable && s.fillPercent() >= mh.shedQueuePercent {
+		mh.shed(request)
+		return
+	}
+	select {
+	case queue <- t:
+		mh.schedule(s)
+		return
+	default:
+	}
+	if sheddable {
+		mh.shed(request)
+		return
+	}
+
+	//队列已满，阻塞等待
+	select {
+	case queue <- t:
+		mh.schedule(s)
+	case <-mh.exitChan:
+	}
+}
+
+//拒绝低优先级消息，如果配置了OverloadMsgID则告知客户端被拒绝的msgID
+func (mh *MsgHandle) shed(request ziface.IRequest) {
+	atomic.AddUint64(&mh.shedCount, 1)
+	mh.logger.Warn("worker pool overloaded, shed msgId = ", request.GetMsgID(), " ConnID = ", request.GetConn().GetConnID())
+	if mh.overloadMsgID == 0 {
+		return
+	}
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, request.GetMsgID())
+	if err := request.GetConn().SendBuffMsg(mh.overloadMsgID, data); err != nil {
+		mh.logger.Warn("send overloaded msg to ConnID = ", request.GetConn().GetConnID(), " err ", err)
+	}
+}
+
+//扩容一个处理任务的goroutine，只在启动和autoScale中调用
+func (mh *MsgHandle) grow() {
+	size := atomic.AddInt32(&mh.size, 1)
+	go mh.runWorker(int(size) - 1)
+}
+
+//缩容一个处理任务的goroutine，空闲的goroutine处理完手上的槽位后退出
+func (mh *MsgHandle) shrink() {
+	atomic.AddInt32(&mh.size, -1)
+	mh.retire <- struct{}{}
+}
+
+//定时检查任务队列的占用和排队时间，在[minSize, maxSize]之间扩缩容
+func (mh *MsgHandle) autoScale() {
+	ticker := time.NewTicker(mh.scaleInterval)
+	defer ticker.Stop()
+
+	idleTicks := 0
+	var lastProcessed int64
+	for {
+		select {
+		case <-mh.exitChan:
+			return
+		case <-ticker.C:
+		}
+
+		//一个周期内没有处理过任务，排队时间清零
+		processed := atomic.LoadInt64(&mh.processed)
+		if processed == lastProcessed {
+			atomic.StoreInt64(&mh.avgWait, 0)
+		}
+		lastProcessed = processed
+		avgWait := time.Duration(atomic.LoadInt64(&mh.avgWait))
+
+		size := mh.Workers()
+		//按最拥堵的队列判断，一个槽位被慢任务占住时，其他槽位需要更多的goroutine处理
+		queued, fill := 0, 0
+		for _, s := range mh.slots {
+			queued += s.queueLen()
+			if f := s.fillPercent(); f > fill {
+				fill = f
+			}
+		}
+
+		switch {
+		case (fill >= mh.scaleUpQueuePercent || avgWait >= mh.scaleUpLatency) && size < mh.maxSize:
+			idleTicks = 0
+			mh.grow()
+			mh.logger.Info("worker pool scale up to ", size+1, ", queue fill ", fill, "%, avg wait ", avgWait)
+		case queued == 0 && avgWait < mh.scaleUpLatency/2 && size > mh.minSize:
+			idleTicks++
+			if idleTicks >= workerIdleTicksToShrink {
+				idleTicks = 0
+				mh.shrink()
+				mh.logger.Info("worker pool scale down to ", size-1)
+			}
+		default:
+			idleTicks = 0
+		}
+	}
+}
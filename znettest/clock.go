@@ -0,0 +1,113 @@
+package znettest
+
+import (
+	"server/ztimer"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+	手动推进的时钟，实现ztimer.Clock
+	定时回调只在Advance中按到期时间依次调用，测试不需要真实等待
+*/
+type FakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+	//尚未触发的定时器
+	timers []*fakeTimer
+	//定时器的创建序号，到期时间相同的定时器按创建顺序触发
+	seq uint64
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	deadline time.Time
+	seq      uint64
+	f        func()
+}
+
+//创建时钟，当前时间为now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+//当前时间
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+//d之后调用f，f在推进时间的Advance中调用
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) ztimer.ClockTimer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.seq++
+	t := &fakeTimer{clock: c, deadline: c.now.Add(d), seq: c.seq, f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+//取消定时器
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, timer := range c.timers {
+		if timer == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+//将时间推进d，按到期时间依次调用期间到期的定时回调，回调中创建的到期定时器也会被调用
+//调用回调时当前时间为该定时器的到期时间
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	end := c.now.Add(d)
+	c.lock.Unlock()
+
+	for {
+		c.lock.Lock()
+		t := c.popDue(end)
+		if t == nil {
+			c.now = end
+			c.lock.Unlock()
+			return
+		}
+		c.now = t.deadline
+		c.lock.Unlock()
+
+		//释放锁之后调用，回调中可以继续使用时钟
+		t.f()
+	}
+}
+
+//取出最早在end之前到期的定时器
+func (c *FakeClock) popDue(end time.Time) *fakeTimer {
+	if len(c.timers) == 0 {
+		return nil
+	}
+	sort.Slice(c.timers, func(i, j int) bool {
+		if !c.timers[i].deadline.Equal(c.timers[j].deadline) {
+			return c.timers[i].deadline.Before(c.timers[j].deadline)
+		}
+		return c.timers[i].seq < c.timers[j].seq
+	})
+	t := c.timers[0]
+	if t.deadline.After(end) {
+		return nil
+	}
+	c.timers = c.timers[1:]
+	return t
+}
+
+//尚未触发的定时器数量
+func (c *FakeClock) Pending() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.timers)
+}
@@ -0,0 +1,344 @@
+package znettest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"server/ziface"
+	"server/znet"
+	"server/zproto"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	测试连接：服务端是运行在net.Pipe()上的真实znet.Conn，属性、认证、统计等方法直接调用服务端连接，
+	客户端一端由测试读写：服务端发送的消息按顺序记录在发件箱中，由Recv/Expect取出断言
+
+	Send之后通过MsgIDPing同步：收到对应的Pong说明之前的帧已经被服务端读取，
+	再等待这些消息处理完成(EventMsgHandled)并同步一次，处理方法中发送的消息就都已经进入发件箱
+*/
+type Conn struct {
+	//服务端的真实连接
+	ziface.IConn
+	server *Server
+	//客户端一端的管道
+	pipe   net.Conn
+	packer ziface.IDataPack
+	//保护写入，同一条消息的分片不能和其他写入交错
+	writeLock sync.Mutex
+	//发送大消息的分片ID，由writeLock保护
+	fragID uint32
+	//重组服务端发来的分片，只在read中使用，没有开启分片时为nil
+	fragments *znet.Reassembler
+	//同步Ping的序号，原子递增
+	syncSeq uint64
+
+	//保护以下字段
+	lock sync.Mutex
+	//服务端发送、尚未被取出的消息
+	outbox []ziface.IMsg
+	//收到的最大同步Pong序号
+	synced uint64
+	//服务端已经交给处理方法、已经处理完成的消息数量
+	received, handled int
+	//服务端连接已经停止
+	stopped bool
+	//管道已经断开
+	closed bool
+	//以上状态变化时关闭并替换，通知等待中的goroutine
+	changed chan struct{}
+}
+
+//等待服务端同步的超时时间
+const syncTimeout = 5 * time.Second
+
+//同步Ping的数据前缀，之后是8字节的序号
+var syncMagic = []byte("znettest")
+
+var (
+	//连接已经停止
+	errConnClosed = errors.New("connection closed")
+	//服务端没有在syncTimeout之内处理完消息
+	errSyncTimeout = errors.New("sync timeout")
+)
+
+func newConn(server *Server, pipe net.Conn) *Conn {
+	c := &Conn{
+		server:  server,
+		pipe:    pipe,
+		packer:  server.GetPacker(),
+		changed: make(chan struct{}),
+	}
+	if config := server.GetConfig(); config.MaxMsgSize > 0 {
+		c.fragments = znet.NewReassembler(config.MaxMsgSize, config.MaxFragmentBuffer,
+			time.Duration(config.FragmentTimeout)*time.Millisecond)
+	}
+	return c
+}
+
+//读取服务端发来的帧直到管道断开，同步Pong之外的消息放入发件箱
+func (c *Conn) read() {
+	defer func() {
+		c.lock.Lock()
+		c.closed = true
+		c.notify()
+		c.lock.Unlock()
+	}()
+
+	headData := make([]byte, c.packer.GetHeadLen())
+	for {
+		if _, err := io.ReadFull(c.pipe, headData); err != nil {
+			return
+		}
+		msg, err := c.packer.UnPack(headData)
+		if err != nil {
+			return
+		}
+		data := make([]byte, msg.GetDataLen())
+		if _, err := io.ReadFull(c.pipe, data); err != nil {
+			return
+		}
+		msg.SetData(data)
+		if msg.GetMsgID() == znet.MsgIDFragment && c.fragments != nil {
+			full, err := c.fragments.Add(data)
+			if err != nil {
+				return
+			}
+			if full == nil {
+				continue
+			}
+			msg = full
+		}
+
+		c.lock.Lock()
+		if seq, ok := syncSeqOf(msg); ok {
+			c.synced = seq
+		} else {
+			c.outbox = append(c.outbox, msg)
+		}
+		c.notify()
+		c.lock.Unlock()
+	}
+}
+
+//同步Pong的序号，不是同步Pong时返回false
+func syncSeqOf(msg ziface.IMsg) (uint64, bool) {
+	data := msg.GetData()
+	if msg.GetMsgID() != znet.MsgIDPong || len(data) != len(syncMagic)+8 || !bytes.HasPrefix(data, syncMagic) {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(data[len(syncMagic):]), true
+}
+
+//服务端连接的事件
+func (c *Conn) onServerEvent(t ziface.EventType) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	switch t {
+	case ziface.EventMsgReceived:
+		c.received++
+	case ziface.EventMsgHandled:
+		c.handled++
+	case ziface.EventConnStopped:
+		c.stopped = true
+	}
+	c.notify()
+}
+
+//通知等待中的goroutine，调用时需要持有c.lock
+func (c *Conn) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+//等待cond成立，cond在持有c.lock时调用，timeout之内没有成立返回false
+func (c *Conn) wait(cond func() bool, timeout time.Duration) bool {
+	var deadline <-chan time.Time
+	for {
+		c.lock.Lock()
+		if cond() {
+			c.lock.Unlock()
+			return true
+		}
+		changed := c.changed
+		c.lock.Unlock()
+
+		if timeout <= 0 {
+			return false
+		}
+		if deadline == nil {
+			deadline = time.After(timeout)
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+//客户端写入原始字节，可以是不完整的帧，不等待服务端处理，需要时调用Sync
+func (c *Conn) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	n, err := c.pipe.Write(p)
+	if err != nil {
+		return n, errConnClosed
+	}
+	return n, nil
+}
+
+//写入一条消息，开启分片时超过MaxPacketSize的消息拆成多个分片
+func (c *Conn) writeMsg(msgID uint32, data []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	var maxPacketSize uint32
+	if config := c.server.GetConfig(); config.MaxMsgSize > 0 {
+		maxPacketSize = config.MaxPacketSize
+	}
+	c.fragID++
+	frames, err := znet.PackFragments(c.packer, msgID, data, maxPacketSize, c.fragID)
+	if err != nil {
+		return err
+	}
+	for _, frame := range frames {
+		if _, err := c.pipe.Write(frame); err != nil {
+			return errConnClosed
+		}
+	}
+	return nil
+}
+
+//客户端发送一条消息，返回时消息已经处理完成，处理方法中发送的消息已经在发件箱中
+func (c *Conn) Send(msgID uint32, data []byte) error {
+	if err := c.writeMsg(msgID, data); err != nil {
+		return err
+	}
+	return c.Sync()
+}
+
+//客户端发送一条protobuf消息
+func (c *Conn) SendProto(msgID uint32, msg proto.Message) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.Send(msgID, data)
+}
+
+//等待之前写入的消息全部处理完成，处理方法中同步发送的消息全部进入发件箱
+//同步使用MsgIDPing，需要在完整的帧之后调用，开启握手时需要在握手之后调用
+func (c *Conn) Sync() error {
+	if err := c.ping(); err != nil {
+		return err
+	}
+	if !c.wait(func() bool { return c.handled >= c.received || c.closed }, syncTimeout) {
+		return errSyncTimeout
+	}
+	return c.ping()
+}
+
+//发送同步Ping并等待对应的Pong，服务端按顺序读取，收到Pong时之前写入的帧都已经读取
+func (c *Conn) ping() error {
+	seq := atomic.AddUint64(&c.syncSeq, 1)
+	data := make([]byte, len(syncMagic)+8)
+	copy(data, syncMagic)
+	binary.LittleEndian.PutUint64(data[len(syncMagic):], seq)
+	if err := c.writeMsg(znet.MsgIDPing, data); err != nil {
+		return err
+	}
+
+	var closed bool
+	done := c.wait(func() bool {
+		closed = c.closed
+		return c.synced >= seq || closed
+	}, syncTimeout)
+	switch {
+	case !done:
+		return errSyncTimeout
+	case closed:
+		return errConnClosed
+	}
+	return nil
+}
+
+//客户端断开连接，返回时服务端连接已经停止
+func (c *Conn) Close() {
+	c.pipe.Close()
+	c.wait(func() bool { return c.stopped }, syncTimeout)
+}
+
+//服务端连接是否已经停止
+func (c *Conn) IsClosed() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.stopped
+}
+
+//取出发件箱中最早的一条消息，发件箱为空时最多等待timeout
+//处理方法在其他goroutine中异步回复时使用timeout，同步回复时timeout为0不等待
+func (c *Conn) Recv(timeout time.Duration) (ziface.IMsg, bool) {
+	var msg ziface.IMsg
+	c.wait(func() bool {
+		if len(c.outbox) == 0 {
+			return c.closed
+		}
+		msg = c.outbox[0]
+		c.outbox = c.outbox[1:]
+		return true
+	}, timeout)
+	return msg, msg != nil
+}
+
+//取出发件箱中的全部消息
+func (c *Conn) Drain() []ziface.IMsg {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	msgs := c.outbox
+	c.outbox = nil
+	return msgs
+}
+
+//取出发件箱中最早的一条消息，断言它的msgID，没有消息或者msgID不同时测试失败
+func (c *Conn) Expect(t testing.TB, msgID uint32) ziface.IMsg {
+	t.Helper()
+	msg, ok := c.Recv(0)
+	if !ok {
+		t.Fatalf("ConnID = %d expect msgId = %d, got nothing", c.GetConnID(), msgID)
+	}
+	if msg.GetMsgID() != msgID {
+		t.Fatalf("ConnID = %d expect msgId = %d, got %s", c.GetConnID(), msgID, format(msg))
+	}
+	return msg
+}
+
+//断言最早的一条消息的msgID，并将数据解码到out中
+func (c *Conn) ExpectProto(t testing.TB, msgID uint32, out proto.Message) {
+	t.Helper()
+	msg := c.Expect(t, msgID)
+	if err := proto.Unmarshal(msg.GetData(), out); err != nil {
+		t.Fatalf("ConnID = %d unmarshal msgId = %d to %s err: %v", c.GetConnID(), msgID, proto.MessageName(out), err)
+	}
+}
+
+//断言发件箱中没有消息
+func (c *Conn) ExpectNone(t testing.TB) {
+	t.Helper()
+	if msgs := c.Drain(); len(msgs) > 0 {
+		t.Fatalf("ConnID = %d expect no msg, got %d msgs, first %s", c.GetConnID(), len(msgs), format(msgs[0]))
+	}
+}
+
+//消息的可读文本，注册过的消息类型解码为protobuf文本
+func format(msg ziface.IMsg) string {
+	return zproto.DefaultRegistry.Format(msg.GetMsgID(), zproto.ServerToClient, msg.GetData())
+}
@@ -0,0 +1,116 @@
+package znettest
+
+import (
+	"net"
+	"server/utils"
+	"server/ziface"
+	"server/znet"
+	"sync"
+	"time"
+)
+
+/*
+	内存中的测试服务器，不监听端口也不需要sleep等待：
+		ts := znettest.NewServer()
+		ts.AddRouter(2, &api.WorldChatApi{})
+		ts.SetOnConnStart(OnConnecionAdd)
+		conn := ts.Connect()
+		conn.SendProto(2, &pb.Talk{Content: "hi"})
+		conn.ExpectProto(t, 200, &pb.BroadCast{})
+
+	每个连接都是运行在net.Pipe()上的真实znet.Conn，路由、Hook、消息管理、连接管理、事件总线、
+	握手、会话恢复、分片、认证和连接属性都是znet的真实实现
+	连接属性的有效期、登录超时和会话保留期使用FakeClock计时，通过Clock().Advance推进
+*/
+type Server struct {
+	//真实的Server，不调用它的Start
+	ziface.IServer
+	server *znet.Server
+	clock  *FakeClock
+
+	//测试连接，key为服务端一端的管道
+	conns map[net.Conn]*Conn
+	lock  sync.Mutex
+}
+
+//创建测试服务器，opts与znet.NewServer相同，但是不要传入WithClock
+//没有指定配置时使用默认配置而不是全局配置，并且不使用Worker池，每个连接的消息在信箱中按顺序处理
+func NewServer(opts ...znet.Option) *Server {
+	conf := utils.NewGlobalObj()
+	conf.WorkerPoolSize = 0
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	opts = append([]znet.Option{znet.WithConfig(conf), znet.WithClock(clock)}, opts...)
+	s := znet.NewServer(opts...).(*znet.Server)
+
+	ts := &Server{
+		IServer: s,
+		server:  s,
+		clock:   clock,
+		conns:   make(map[net.Conn]*Conn),
+	}
+	//跟踪每个连接的消息处理进度，Send据此等待消息处理完成
+	s.GetEventBus().Subscribe(ts.onConnEvent, ziface.EventMsgReceived, ziface.EventMsgHandled, ziface.EventConnStopped)
+	if s.GetConfig().WorkerPoolSize > 0 {
+		s.GetMsgHandler().StartWorkerPool()
+	}
+	return ts
+}
+
+//测试服务器的时钟
+func (s *Server) Clock() *FakeClock {
+	return s.clock
+}
+
+//得到当前Server的配置
+func (s *Server) GetConfig() *utils.GlobalObj {
+	return s.server.GetConfig()
+}
+
+//不监听端口，通过Connect建立连接，不需要启动
+func (s *Server) Start() {}
+
+//不阻塞
+func (s *Server) Serve() {}
+
+//建立一个新连接，返回时OnConnStart已经调用，其中发送的消息已经在连接的发件箱中
+//开启握手或者会话恢复时，OnConnStart在客户端发送第一帧之后才调用，需要先Send握手或者恢复消息
+func (s *Server) Connect() *Conn {
+	serverEnd, clientEnd := net.Pipe()
+	c := newConn(s, clientEnd)
+	//OnConnStart中发送的消息需要客户端读取之后才能写完，先开始读取再建立连接
+	go c.read()
+	s.lock.Lock()
+	s.conns[serverEnd] = c
+	s.lock.Unlock()
+
+	conn, err := s.server.ServeConn(serverEnd)
+	if err != nil {
+		clientEnd.Close()
+		panic("znettest: connect err " + err.Error())
+	}
+	c.IConn = conn
+
+	conf := s.GetConfig()
+	if !conf.RequireHandshake && conf.SessionGracePeriod == 0 {
+		_ = c.Sync()
+	}
+	return c
+}
+
+//连接的消息处理进度和停止事件
+func (s *Server) onConnEvent(event *ziface.Event) {
+	conn, ok := event.Conn.(*znet.Conn)
+	if !ok {
+		return
+	}
+	s.lock.Lock()
+	c, ok := s.conns[conn.Conn]
+	if ok && event.Type == ziface.EventConnStopped {
+		delete(s.conns, conn.Conn)
+	}
+	s.lock.Unlock()
+
+	if ok {
+		c.onServerEvent(event.Type)
+	}
+}
@@ -0,0 +1,224 @@
+package zplugin
+
+import "github.com/golang/protobuf/proto"
+
+/*
+	protoc插件协议中用到的descriptor.proto和plugin.proto的子集
+	字段编号与官方定义一致，没有列出的字段在解析时忽略，
+	这样插件只依赖github.com/golang/protobuf/proto，不需要protoc-gen-go的descriptor包
+*/
+
+type FileDescriptorProto struct {
+	Name             *string                   `protobuf:"bytes,1,opt,name=name"`
+	Package          *string                   `protobuf:"bytes,2,opt,name=package"`
+	Dependency       []string                  `protobuf:"bytes,3,rep,name=dependency"`
+	MessageType      []*DescriptorProto        `protobuf:"bytes,4,rep,name=message_type"`
+	EnumType         []*EnumDescriptorProto    `protobuf:"bytes,5,rep,name=enum_type"`
+	Service          []*ServiceDescriptorProto `protobuf:"bytes,6,rep,name=service"`
+	Options          *FileOptions              `protobuf:"bytes,8,opt,name=options"`
+	SourceCodeInfo   *SourceCodeInfo           `protobuf:"bytes,9,opt,name=source_code_info"`
+	Syntax           *string                   `protobuf:"bytes,12,opt,name=syntax"`
+	XXX_unrecognized []byte                    `json:"-"`
+}
+
+func (m *FileDescriptorProto) Reset()         { *m = FileDescriptorProto{} }
+func (m *FileDescriptorProto) String() string { return proto.CompactTextString(m) }
+func (*FileDescriptorProto) ProtoMessage()    {}
+
+func (m *FileDescriptorProto) GetName() string {
+	if m != nil && m.Name != nil {
+		return *m.Name
+	}
+	return ""
+}
+
+func (m *FileDescriptorProto) GetPackage() string {
+	if m != nil && m.Package != nil {
+		return *m.Package
+	}
+	return ""
+}
+
+type FileOptions struct {
+	GoPackage        *string `protobuf:"bytes,11,opt,name=go_package"`
+	CsharpNamespace  *string `protobuf:"bytes,37,opt,name=csharp_namespace"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *FileOptions) Reset()         { *m = FileOptions{} }
+func (m *FileOptions) String() string { return proto.CompactTextString(m) }
+func (*FileOptions) ProtoMessage()    {}
+
+func (m *FileOptions) GetGoPackage() string {
+	if m != nil && m.GoPackage != nil {
+		return *m.GoPackage
+	}
+	return ""
+}
+
+func (m *FileOptions) GetCsharpNamespace() string {
+	if m != nil && m.CsharpNamespace != nil {
+		return *m.CsharpNamespace
+	}
+	return ""
+}
+
+type DescriptorProto struct {
+	Name             *string                `protobuf:"bytes,1,opt,name=name"`
+	NestedType       []*DescriptorProto     `protobuf:"bytes,3,rep,name=nested_type"`
+	EnumType         []*EnumDescriptorProto `protobuf:"bytes,4,rep,name=enum_type"`
+	XXX_unrecognized []byte                 `json:"-"`
+}
+
+func (m *DescriptorProto) Reset()         { *m = DescriptorProto{} }
+func (m *DescriptorProto) String() string { return proto.CompactTextString(m) }
+func (*DescriptorProto) ProtoMessage()    {}
+
+func (m *DescriptorProto) GetName() string {
+	if m != nil && m.Name != nil {
+		return *m.Name
+	}
+	return ""
+}
+
+type EnumDescriptorProto struct {
+	Name             *string                     `protobuf:"bytes,1,opt,name=name"`
+	Value            []*EnumValueDescriptorProto `protobuf:"bytes,2,rep,name=value"`
+	XXX_unrecognized []byte                      `json:"-"`
+}
+
+func (m *EnumDescriptorProto) Reset()         { *m = EnumDescriptorProto{} }
+func (m *EnumDescriptorProto) String() string { return proto.CompactTextString(m) }
+func (*EnumDescriptorProto) ProtoMessage()    {}
+
+func (m *EnumDescriptorProto) GetName() string {
+	if m != nil && m.Name != nil {
+		return *m.Name
+	}
+	return ""
+}
+
+type EnumValueDescriptorProto struct {
+	Name             *string `protobuf:"bytes,1,opt,name=name"`
+	Number           *int32  `protobuf:"varint,2,opt,name=number"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *EnumValueDescriptorProto) Reset()         { *m = EnumValueDescriptorProto{} }
+func (m *EnumValueDescriptorProto) String() string { return proto.CompactTextString(m) }
+func (*EnumValueDescriptorProto) ProtoMessage()    {}
+
+type ServiceDescriptorProto struct {
+	Name             *string                  `protobuf:"bytes,1,opt,name=name"`
+	Method           []*MethodDescriptorProto `protobuf:"bytes,2,rep,name=method"`
+	XXX_unrecognized []byte                   `json:"-"`
+}
+
+func (m *ServiceDescriptorProto) Reset()         { *m = ServiceDescriptorProto{} }
+func (m *ServiceDescriptorProto) String() string { return proto.CompactTextString(m) }
+func (*ServiceDescriptorProto) ProtoMessage()    {}
+
+func (m *ServiceDescriptorProto) GetName() string {
+	if m != nil && m.Name != nil {
+		return *m.Name
+	}
+	return ""
+}
+
+type MethodDescriptorProto struct {
+	Name             *string `protobuf:"bytes,1,opt,name=name"`
+	InputType        *string `protobuf:"bytes,2,opt,name=input_type"`
+	OutputType       *string `protobuf:"bytes,3,opt,name=output_type"`
+	ClientStreaming  *bool   `protobuf:"varint,5,opt,name=client_streaming"`
+	ServerStreaming  *bool   `protobuf:"varint,6,opt,name=server_streaming"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *MethodDescriptorProto) Reset()         { *m = MethodDescriptorProto{} }
+func (m *MethodDescriptorProto) String() string { return proto.CompactTextString(m) }
+func (*MethodDescriptorProto) ProtoMessage()    {}
+
+func (m *MethodDescriptorProto) GetName() string {
+	if m != nil && m.Name != nil {
+		return *m.Name
+	}
+	return ""
+}
+
+func (m *MethodDescriptorProto) GetInputType() string {
+	if m != nil && m.InputType != nil {
+		return *m.InputType
+	}
+	return ""
+}
+
+func (m *MethodDescriptorProto) GetOutputType() string {
+	if m != nil && m.OutputType != nil {
+		return *m.OutputType
+	}
+	return ""
+}
+
+//是否为流式方法
+func (m *MethodDescriptorProto) IsStreaming() bool {
+	return (m.ClientStreaming != nil && *m.ClientStreaming) || (m.ServerStreaming != nil && *m.ServerStreaming)
+}
+
+type SourceCodeInfo struct {
+	Location         []*SourceCodeInfo_Location `protobuf:"bytes,1,rep,name=location"`
+	XXX_unrecognized []byte                     `json:"-"`
+}
+
+func (m *SourceCodeInfo) Reset()         { *m = SourceCodeInfo{} }
+func (m *SourceCodeInfo) String() string { return proto.CompactTextString(m) }
+func (*SourceCodeInfo) ProtoMessage()    {}
+
+type SourceCodeInfo_Location struct {
+	Path             []int32 `protobuf:"varint,1,rep,packed,name=path"`
+	Span             []int32 `protobuf:"varint,2,rep,packed,name=span"`
+	LeadingComments  *string `protobuf:"bytes,3,opt,name=leading_comments"`
+	TrailingComments *string `protobuf:"bytes,4,opt,name=trailing_comments"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *SourceCodeInfo_Location) Reset()         { *m = SourceCodeInfo_Location{} }
+func (m *SourceCodeInfo_Location) String() string { return proto.CompactTextString(m) }
+func (*SourceCodeInfo_Location) ProtoMessage()    {}
+
+type CodeGeneratorRequest struct {
+	FileToGenerate   []string               `protobuf:"bytes,1,rep,name=file_to_generate"`
+	Parameter        *string                `protobuf:"bytes,2,opt,name=parameter"`
+	ProtoFile        []*FileDescriptorProto `protobuf:"bytes,15,rep,name=proto_file"`
+	XXX_unrecognized []byte                 `json:"-"`
+}
+
+func (m *CodeGeneratorRequest) Reset()         { *m = CodeGeneratorRequest{} }
+func (m *CodeGeneratorRequest) String() string { return proto.CompactTextString(m) }
+func (*CodeGeneratorRequest) ProtoMessage()    {}
+
+func (m *CodeGeneratorRequest) GetParameter() string {
+	if m != nil && m.Parameter != nil {
+		return *m.Parameter
+	}
+	return ""
+}
+
+type CodeGeneratorResponse struct {
+	Error            *string                       `protobuf:"bytes,1,opt,name=error"`
+	File             []*CodeGeneratorResponse_File `protobuf:"bytes,15,rep,name=file"`
+	XXX_unrecognized []byte                        `json:"-"`
+}
+
+func (m *CodeGeneratorResponse) Reset()         { *m = CodeGeneratorResponse{} }
+func (m *CodeGeneratorResponse) String() string { return proto.CompactTextString(m) }
+func (*CodeGeneratorResponse) ProtoMessage()    {}
+
+type CodeGeneratorResponse_File struct {
+	Name             *string `protobuf:"bytes,1,opt,name=name"`
+	Content          *string `protobuf:"bytes,15,opt,name=content"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *CodeGeneratorResponse_File) Reset()         { *m = CodeGeneratorResponse_File{} }
+func (m *CodeGeneratorResponse_File) String() string { return proto.CompactTextString(m) }
+func (*CodeGeneratorResponse_File) ProtoMessage()    {}
@@ -0,0 +1,230 @@
+package zplugin
+
+import (
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	编写protoc插件的公共方法
+	protoc通过stdin传入CodeGeneratorRequest，插件将CodeGeneratorResponse写到stdout
+*/
+
+//生成代码的方法，返回要写出的文件
+type Generator func(req *CodeGeneratorRequest) ([]*CodeGeneratorResponse_File, error)
+
+//作为protoc插件运行，生成失败时通过CodeGeneratorResponse.Error报告给protoc
+func Run(gen Generator) {
+	if err := run(os.Stdin, os.Stdout, gen); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(r io.Reader, w io.Writer, gen Generator) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	req := &CodeGeneratorRequest{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		return fmt.Errorf("parse code generator request: %v", err)
+	}
+
+	resp := &CodeGeneratorResponse{}
+	files, err := gen(req)
+	if err != nil {
+		resp.Error = proto.String(err.Error())
+	} else {
+		resp.File = files
+	}
+	out, err := proto.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+//创建要写出的文件
+func NewFile(name, content string) *CodeGeneratorResponse_File {
+	return &CodeGeneratorResponse_File{Name: proto.String(name), Content: proto.String(content)}
+}
+
+//格式化生成的Go代码，格式化失败时返回带行号的源码便于定位生成器的问题
+func FormatGo(name string, src []byte) (string, error) {
+	formatted, err := format.Source(src)
+	if err != nil {
+		lines := strings.Split(string(src), "\n")
+		for i := range lines {
+			lines[i] = fmt.Sprintf("%4d %s", i+1, lines[i])
+		}
+		return "", fmt.Errorf("format %s: %v\n%s", name, err, strings.Join(lines, "\n"))
+	}
+	return string(formatted), nil
+}
+
+//解析插件参数，格式为 key1=value1,key2=value2
+func Params(req *CodeGeneratorRequest) map[string]string {
+	params := make(map[string]string)
+	for _, item := range strings.Split(req.GetParameter(), ",") {
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = kv[1]
+		} else {
+			params[kv[0]] = ""
+		}
+	}
+	return params
+}
+
+//需要生成代码的文件
+func FilesToGenerate(req *CodeGeneratorRequest) []*FileDescriptorProto {
+	files := make([]*FileDescriptorProto, 0, len(req.FileToGenerate))
+	for _, name := range req.FileToGenerate {
+		for _, f := range req.ProtoFile {
+			if f.GetName() == name {
+				files = append(files, f)
+				break
+			}
+		}
+	}
+	return files
+}
+
+//生成文件的名称：去掉.proto后缀再加上suffix，如msg.proto -> msg.zrpc.go
+func OutputName(f *FileDescriptorProto, suffix string) string {
+	return strings.TrimSuffix(f.GetName(), ".proto") + suffix
+}
+
+//Go包名，与protoc-gen-go的规则一致：go_package的最后一段，否则为proto包名
+func GoPackageName(f *FileDescriptorProto) string {
+	if goPackage := f.Options.GetGoPackage(); goPackage != "" {
+		if i := strings.LastIndex(goPackage, ";"); i >= 0 {
+			return goPackage[i+1:]
+		}
+		return goIdent(path.Base(goPackage))
+	}
+	if f.GetPackage() != "" {
+		return goIdent(f.GetPackage())
+	}
+	return goIdent(strings.TrimSuffix(path.Base(f.GetName()), ".proto"))
+}
+
+func goIdent(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+//将.proto中的全名(如.pb.Talk)转换为同一个包中的Go类型名，嵌套类型用下划线连接
+func GoTypeName(f *FileDescriptorProto, fullName string) (string, error) {
+	prefix := "."
+	if f.GetPackage() != "" {
+		prefix = "." + f.GetPackage() + "."
+	}
+	if !strings.HasPrefix(fullName, prefix) {
+		return "", fmt.Errorf("type %s is not in package %s", fullName, f.GetPackage())
+	}
+	parts := strings.Split(strings.TrimPrefix(fullName, prefix), ".")
+	for i := range parts {
+		parts[i] = CamelCase(parts[i])
+	}
+	return strings.Join(parts, "_"), nil
+}
+
+//转换为导出的Go标识符，与protoc-gen-go的CamelCase相同：
+//去掉小写字母前面的下划线并将该字母大写，开头的下划线改为X，数字之后的小写字母大写
+func CamelCase(s string) string {
+	if s == "" {
+		return ""
+	}
+	t := make([]byte, 0, 32)
+	i := 0
+	if s[0] == '_' {
+		t = append(t, 'X')
+		i++
+	}
+	for ; i < len(s); i++ {
+		c := s[i]
+		if c == '_' && i+1 < len(s) && isASCIILower(s[i+1]) {
+			continue
+		}
+		if isASCIIDigit(c) {
+			t = append(t, c)
+			continue
+		}
+		//一个单词的开头大写，之后的小写字母原样保留
+		if isASCIILower(c) {
+			c ^= ' '
+		}
+		t = append(t, c)
+		for i+1 < len(s) && isASCIILower(s[i+1]) {
+			i++
+			t = append(t, s[i])
+		}
+	}
+	return string(t)
+}
+
+func isASCIILower(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
+
+func isASCIIDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+//获取path对应的定义前面的注释，path与SourceCodeInfo.Location.Path相同，如message为[4, 下标]
+func Comments(f *FileDescriptorProto, path ...int32) string {
+	if f.SourceCodeInfo == nil {
+		return ""
+	}
+	for _, loc := range f.SourceCodeInfo.Location {
+		if equalPath(loc.Path, path) {
+			if loc.LeadingComments != nil {
+				return strings.TrimSpace(*loc.LeadingComments)
+			}
+			if loc.TrailingComments != nil {
+				return strings.TrimSpace(*loc.TrailingComments)
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+func equalPath(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//FileDescriptorProto中各类定义的字段编号，用于拼接Comments的path
+const (
+	PathMessageType int32 = 4
+	PathEnumType    int32 = 5
+	PathService     int32 = 6
+	//ServiceDescriptorProto中method的字段编号
+	PathMethod int32 = 2
+)
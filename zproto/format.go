@@ -0,0 +1,112 @@
+package zproto
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"server/ziface"
+	"server/znet"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	将消息格式化为可读的protobuf文本，用于调试日志、管理后台和zdecode命令行工具
+	注册过的消息格式化为 "s2c msgId=200 BroadCast {Pid:1 Tp:1 Content:"hi"}"，
+	没有注册或者解码失败的消息只打印长度和数据的十六进制
+*/
+
+//没有注册的消息最多打印的数据字节数
+const maxHexBytes = 64
+
+//将一条消息格式化为一行文本
+func (r *Registry) Format(msgID uint32, dir Direction, data []byte) string {
+	return r.format(msgID, dir, data, func(msg proto.Message) string {
+		return "{" + strings.TrimSpace(proto.CompactTextString(msg)) + "}"
+	})
+}
+
+//将一条消息格式化为多行文本，消息内容每个字段一行
+func (r *Registry) FormatIndent(msgID uint32, dir Direction, data []byte) string {
+	return r.format(msgID, dir, data, func(msg proto.Message) string {
+		return "{\n" + indent(proto.MarshalTextString(msg)) + "}"
+	})
+}
+
+func (r *Registry) format(msgID uint32, dir Direction, data []byte, text func(proto.Message) string) string {
+	head := fmt.Sprintf("%s msgId=%d", dir, msgID)
+	if znet.IsSysMsgID(msgID) {
+		return fmt.Sprintf("%s sys len=%d %s", head, len(data), hexString(data))
+	}
+	t, ok := r.Lookup(msgID, dir)
+	if !ok {
+		return fmt.Sprintf("%s unknown len=%d %s", head, len(data), hexString(data))
+	}
+	msg := t.New()
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return fmt.Sprintf("%s %s decode err: %v len=%d %s", head, t.Name, err, len(data), hexString(data))
+	}
+	return fmt.Sprintf("%s %s %s", head, t.Name, text(msg))
+}
+
+//十六进制数据，超过maxHexBytes时截断
+func hexString(data []byte) string {
+	if len(data) > maxHexBytes {
+		return "hex=" + hex.EncodeToString(data[:maxHexBytes]) + "..."
+	}
+	return "hex=" + hex.EncodeToString(data)
+}
+
+func indent(s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i := range lines {
+		lines[i] = "  " + lines[i] + "\n"
+	}
+	return strings.Join(lines, "")
+}
+
+//解析十六进制文本，忽略空白、冒号、逗号和0x前缀，
+//可以直接粘贴Wireshark等工具复制出的hex stream或者以空格分隔的字节
+func ParseHex(s string) ([]byte, error) {
+	s = strings.NewReplacer("0x", "", "0X", "", ":", "", ",", "").Replace(s)
+	s = strings.Join(strings.Fields(s), "")
+	return hex.DecodeString(s)
+}
+
+var errTruncatedFrame = errors.New("truncated frame")
+
+//按packer的格式把连续的数据拆成多个消息，数据末尾不完整的帧返回已经拆出的消息和error
+func SplitFrames(packer ziface.IDataPack, buf []byte) ([]ziface.IMsg, error) {
+	headLen := int(packer.GetHeadLen())
+	var msgs []ziface.IMsg
+	for len(buf) > 0 {
+		if len(buf) < headLen {
+			return msgs, errTruncatedFrame
+		}
+		msg, err := packer.UnPack(buf[:headLen])
+		if err != nil {
+			return msgs, err
+		}
+		buf = buf[headLen:]
+		if uint32(len(buf)) < msg.GetDataLen() {
+			return msgs, errTruncatedFrame
+		}
+		msg.SetData(buf[:msg.GetDataLen()])
+		buf = buf[msg.GetDataLen():]
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+//在连接收发消息的Debug日志中打印消息的可读文本，返回事件总线的订阅ID
+func LogMsgs(server ziface.IServer, registry *Registry) int {
+	logger := server.GetLogger()
+	return server.GetEventBus().Subscribe(func(event *ziface.Event) {
+		dir := ClientToServer
+		if event.Type == ziface.EventMsgSent {
+			dir = ServerToClient
+		}
+		logger.Debug("[Msg] ConnID = ", event.Conn.GetConnID(), " ", registry.Format(event.MsgID, dir, event.Data))
+	}, ziface.EventMsgReceived, ziface.EventMsgSent)
+}
@@ -0,0 +1,241 @@
+package zproto
+
+import (
+	"bytes"
+	"fmt"
+	"server/znet"
+	"server/zplugin"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+	根据.proto中消息注释里的@msgid标注生成msgID常量、注册表、发送方法和处理接口，由protoc-gen-zmsg调用：
+		protoc --go_out=. --zmsg_out=csharp:. msg.proto
+
+	标注写在消息前面的注释中，每行一个，一个消息可以有多个msgID：
+		//同步客户端玩家ID
+		//@msgid 1 s2c
+		//@msgid 201 s2c PlayerOffline 玩家下线或者离开视野，在客户端中消失
+		message SyncPid{...}
+	格式为 @msgid <msgID> <c2s|s2c> [名称 [说明]]，名称默认为消息名，生成常量MsgID<名称>，
+	说明默认为消息注释中标注以外的内容
+	s2c消息生成Send<名称>发送方法，c2s消息生成<名称>Handler处理接口和Register<名称>Handler注册方法
+
+	插件参数：
+		csharp         同时生成Unity客户端使用的C#常量和解析器表 <文件名>.msgid.cs
+		zproto_import  zproto包的导入路径，默认为 server/zproto
+		ziface_import  ziface包的导入路径，默认为 server/ziface
+*/
+
+//默认的导入路径
+const (
+	defaultImportPath      = "server/zproto"
+	defaultIfaceImportPath = "server/ziface"
+)
+
+//消息注释中msgID标注的前缀
+const msgIDAnnotation = "@msgid"
+
+//一个msgID标注
+type msgIDDecl struct {
+	msgID uint32
+	dir   Direction
+	//常量名称的后缀
+	name string
+	//消息的Go类型名和.proto中的名称
+	goType, protoType string
+	//消息注释中标注以外的内容
+	comments string
+}
+
+//protoc-gen-zmsg的生成方法，没有@msgid标注的文件不生成
+func GenerateMsgIDs(req *zplugin.CodeGeneratorRequest) ([]*zplugin.CodeGeneratorResponse_File, error) {
+	params := zplugin.Params(req)
+	importPath := params["zproto_import"]
+	if importPath == "" {
+		importPath = defaultImportPath
+	}
+	ifaceImportPath := params["ziface_import"]
+	if ifaceImportPath == "" {
+		ifaceImportPath = defaultIfaceImportPath
+	}
+	_, csharp := params["csharp"]
+
+	var files []*zplugin.CodeGeneratorResponse_File
+	for _, f := range zplugin.FilesToGenerate(req) {
+		decls, err := parseMsgIDs(f)
+		if err != nil {
+			return nil, err
+		}
+		if len(decls) == 0 {
+			continue
+		}
+		content, err := generateGo(f, decls, importPath, ifaceImportPath)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, zplugin.NewFile(zplugin.OutputName(f, ".zmsg.go"), content))
+		if csharp {
+			files = append(files, zplugin.NewFile(zplugin.OutputName(f, ".msgid.cs"), generateCSharp(f, decls)))
+		}
+	}
+	return files, nil
+}
+
+//解析文件中全部顶层消息的@msgid标注，按msgID和方向排序
+func parseMsgIDs(f *zplugin.FileDescriptorProto) ([]*msgIDDecl, error) {
+	var decls []*msgIDDecl
+	names := make(map[string]bool)
+	ids := make(map[msgKey]string)
+	for i, msg := range f.MessageType {
+		var annotations, comments []string
+		for _, line := range strings.Split(zplugin.Comments(f, zplugin.PathMessageType, int32(i)), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, msgIDAnnotation) {
+				annotations = append(annotations, line)
+			} else if line != "" {
+				comments = append(comments, line)
+			}
+		}
+
+		for _, annotation := range annotations {
+			decl, err := parseAnnotation(annotation)
+			if err != nil {
+				return nil, fmt.Errorf("%s: message %s: %v", f.GetName(), msg.GetName(), err)
+			}
+			if decl.name == "" {
+				decl.name = zplugin.CamelCase(msg.GetName())
+			}
+			decl.goType = zplugin.CamelCase(msg.GetName())
+			decl.protoType = msg.GetName()
+			if decl.comments == "" {
+				decl.comments = strings.Join(comments, "\n")
+			}
+
+			if names[decl.name] {
+				return nil, fmt.Errorf("%s: message %s: repeated name MsgID%s", f.GetName(), msg.GetName(), decl.name)
+			}
+			key := msgKey{msgID: decl.msgID, dir: decl.dir}
+			if other, ok := ids[key]; ok {
+				return nil, fmt.Errorf("%s: message %s: msgid %d %s already used by %s", f.GetName(), msg.GetName(), decl.msgID, decl.dir, other)
+			}
+			names[decl.name] = true
+			ids[key] = msg.GetName()
+			decls = append(decls, decl)
+		}
+	}
+	sort.Slice(decls, func(i, j int) bool {
+		if decls[i].msgID != decls[j].msgID {
+			return decls[i].msgID < decls[j].msgID
+		}
+		return decls[i].dir < decls[j].dir
+	})
+	return decls, nil
+}
+
+//解析一行 @msgid <msgID> <c2s|s2c> [名称 [说明]]
+func parseAnnotation(line string) (*msgIDDecl, error) {
+	fields := strings.Fields(line)
+	if fields[0] != msgIDAnnotation || len(fields) < 3 {
+		return nil, fmt.Errorf("bad annotation %q, want %s <msgID> <c2s|s2c> [name [comment]]", line, msgIDAnnotation)
+	}
+	msgID, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad msgid %q", fields[1])
+	}
+	if znet.IsSysMsgID(uint32(msgID)) {
+		return nil, fmt.Errorf("msgid %d is reserved for system messages", msgID)
+	}
+	dir, err := ParseDirection(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	decl := &msgIDDecl{msgID: uint32(msgID), dir: dir}
+	if len(fields) >= 4 {
+		decl.name = zplugin.CamelCase(fields[3])
+		decl.comments = strings.Join(fields[4:], " ")
+	}
+	return decl, nil
+}
+
+func generateGo(f *zplugin.FileDescriptorProto, decls []*msgIDDecl, importPath, ifaceImportPath string) (string, error) {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "// Code generated by protoc-gen-zmsg. DO NOT EDIT.\n// source: %s\n\n", f.GetName())
+	fmt.Fprintf(buf, "package %s\n\n", zplugin.GoPackageName(f))
+	fmt.Fprintf(buf, "import (\n\tproto \"github.com/golang/protobuf/proto\"\n\tziface %q\n\tzproto %q\n)\n\n", ifaceImportPath, importPath)
+
+	//msgID常量
+	fmt.Fprintf(buf, "const (\n")
+	for _, d := range decls {
+		writeComments(buf, "\t", d.comments)
+		fmt.Fprintf(buf, "\tMsgID%s uint32 = %d // %s %s\n", d.name, d.msgID, d.dir, d.protoType)
+	}
+	fmt.Fprintf(buf, ")\n\n")
+
+	//注册到zproto.DefaultRegistry
+	fmt.Fprintf(buf, "func init() {\n")
+	for _, d := range decls {
+		fmt.Fprintf(buf, "\tzproto.Register(MsgID%s, zproto.%s, %q, func() proto.Message { return new(%s) })\n", d.name, dirIdent(d.dir), d.name, d.goType)
+	}
+	fmt.Fprintf(buf, "}\n\n")
+
+	for _, d := range decls {
+		if d.dir == ServerToClient {
+			fmt.Fprintf(buf, "// Send%s sends msg to conn as MsgID%s\n", d.name, d.name)
+			fmt.Fprintf(buf, "func Send%s(conn ziface.IConn, msg *%s) error {\n\treturn zproto.Send(conn, MsgID%s, msg)\n}\n\n", d.name, d.goType, d.name)
+			continue
+		}
+		fmt.Fprintf(buf, "// %sHandler handles MsgID%s from clients\n", d.name, d.name)
+		fmt.Fprintf(buf, "type %sHandler interface {\n\tHandle%s(request ziface.IRequest, msg *%s)\n}\n\n", d.name, d.name, d.goType)
+		fmt.Fprintf(buf, "// Register%sHandler registers h as the router of MsgID%s\n", d.name, d.name)
+		fmt.Fprintf(buf, "func Register%sHandler(s ziface.IServer, h %sHandler) {\n", d.name, d.name)
+		fmt.Fprintf(buf, "\ts.AddRouter(MsgID%s, zproto.NewRouter(func() proto.Message { return new(%s) }, func(request ziface.IRequest, msg proto.Message) {\n", d.name, d.goType)
+		fmt.Fprintf(buf, "\t\th.Handle%s(request, msg.(*%s))\n\t}))\n}\n\n", d.name, d.goType)
+	}
+	return zplugin.FormatGo(zplugin.OutputName(f, ".zmsg.go"), buf.Bytes())
+}
+
+//Unity客户端使用的msgID常量，以及服务端消息的msgID到解析器的映射
+func generateCSharp(f *zplugin.FileDescriptorProto, decls []*msgIDDecl) string {
+	namespace := f.Options.GetCsharpNamespace()
+	if namespace == "" {
+		namespace = zplugin.CamelCase(f.GetPackage())
+	}
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "// Code generated by protoc-gen-zmsg. DO NOT EDIT.\n// source: %s\n\n", f.GetName())
+	fmt.Fprintf(buf, "using System.Collections.Generic;\nusing Google.Protobuf;\n\n")
+	fmt.Fprintf(buf, "namespace %s {\n\n", namespace)
+	fmt.Fprintf(buf, "  public static class MsgId {\n")
+	for _, d := range decls {
+		writeComments(buf, "    ", d.comments)
+		fmt.Fprintf(buf, "    public const uint %s = %d; // %s %s\n", d.name, d.msgID, d.dir, d.protoType)
+	}
+	fmt.Fprintf(buf, "\n    // Parsers of messages sent by the server, keyed by msgID\n")
+	fmt.Fprintf(buf, "    public static readonly Dictionary<uint, MessageParser> ServerParsers = new Dictionary<uint, MessageParser> {\n")
+	for _, d := range decls {
+		if d.dir == ServerToClient {
+			fmt.Fprintf(buf, "      { %s, global::%s.%s.Parser },\n", d.name, namespace, d.goType)
+		}
+	}
+	fmt.Fprintf(buf, "    };\n  }\n\n}\n")
+	return buf.String()
+}
+
+func dirIdent(dir Direction) string {
+	if dir == ClientToServer {
+		return "ClientToServer"
+	}
+	return "ServerToClient"
+}
+
+//将.proto中的注释写为注释
+func writeComments(buf *bytes.Buffer, indent, comments string) {
+	if comments == "" {
+		return
+	}
+	for _, line := range strings.Split(comments, "\n") {
+		fmt.Fprintf(buf, "%s// %s\n", indent, line)
+	}
+}
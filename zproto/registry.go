@@ -0,0 +1,158 @@
+package zproto
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	消息类型注册表：msgID和消息方向到protobuf消息类型的映射
+	protoc-gen-zmsg生成的代码在init中把.proto里标注了@msgid的消息注册到DefaultRegistry，
+	同一个msgID在两个方向上可以对应不同的消息类型(如请求和回复)，同一个消息类型也可以对应多个msgID
+*/
+
+//消息方向
+type Direction uint8
+
+const (
+	//客户端发给服务端
+	ClientToServer Direction = iota + 1
+	//服务端发给客户端
+	ServerToClient
+)
+
+func (d Direction) String() string {
+	switch d {
+	case ClientToServer:
+		return "c2s"
+	case ServerToClient:
+		return "s2c"
+	}
+	return "unknown"
+}
+
+//解析c2s、s2c形式的消息方向
+func ParseDirection(s string) (Direction, error) {
+	switch s {
+	case "c2s":
+		return ClientToServer, nil
+	case "s2c":
+		return ServerToClient, nil
+	}
+	return 0, fmt.Errorf("unknown direction %q, want c2s or s2c", s)
+}
+
+//一个msgID在一个方向上对应的消息类型
+type MsgType struct {
+	MsgID uint32
+	Dir   Direction
+	//生成常量时使用的名称，如MsgIDPlayerOffline的PlayerOffline
+	Name string
+	//protobuf消息全名，如pb.SyncPid
+	TypeName string
+	//创建一个空消息
+	New func() proto.Message
+}
+
+type msgKey struct {
+	msgID uint32
+	dir   Direction
+}
+
+type Registry struct {
+	lock  sync.RWMutex
+	types map[msgKey]*MsgType
+}
+
+//默认的注册表，生成的代码注册到这里
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{types: make(map[msgKey]*MsgType)}
+}
+
+//注册消息类型，同一个msgID和方向重复注册时panic
+func (r *Registry) Register(msgID uint32, dir Direction, name string, newMsg func() proto.Message) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := msgKey{msgID: msgID, dir: dir}
+	if old, ok := r.types[key]; ok {
+		panic("repeated msg type, msgId = " + strconv.FormatUint(uint64(msgID), 10) + " " + dir.String() + " " + old.Name + " and " + name)
+	}
+	r.types[key] = &MsgType{
+		MsgID:    msgID,
+		Dir:      dir,
+		Name:     name,
+		TypeName: proto.MessageName(newMsg()),
+		New:      newMsg,
+	}
+}
+
+//查找msgID在dir方向上的消息类型
+func (r *Registry) Lookup(msgID uint32, dir Direction) (*MsgType, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	t, ok := r.types[msgKey{msgID: msgID, dir: dir}]
+	return t, ok
+}
+
+//消息类型在dir方向上对应的全部msgID，从小到大排序
+func (r *Registry) MsgIDs(msg proto.Message, dir Direction) []uint32 {
+	typeName := proto.MessageName(msg)
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	var ids []uint32
+	for key, t := range r.types {
+		if key.dir == dir && t.TypeName == typeName {
+			ids = append(ids, key.msgID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+//全部注册的消息类型，按msgID和方向排序
+func (r *Registry) Types() []*MsgType {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	types := make([]*MsgType, 0, len(r.types))
+	for _, t := range r.types {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if types[i].MsgID != types[j].MsgID {
+			return types[i].MsgID < types[j].MsgID
+		}
+		return types[i].Dir < types[j].Dir
+	})
+	return types
+}
+
+var ErrUnknownMsgType = errors.New("unknown msg type")
+
+//按msgID和方向解码消息数据
+func (r *Registry) Decode(msgID uint32, dir Direction, data []byte) (proto.Message, error) {
+	t, ok := r.Lookup(msgID, dir)
+	if !ok {
+		return nil, ErrUnknownMsgType
+	}
+	msg := t.New()
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+//注册到DefaultRegistry
+func Register(msgID uint32, dir Direction, name string, newMsg func() proto.Message) {
+	DefaultRegistry.Register(msgID, dir, name, newMsg)
+}
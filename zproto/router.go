@@ -0,0 +1,41 @@
+package zproto
+
+import (
+	"server/ziface"
+	"server/zlog"
+
+	"github.com/golang/protobuf/proto"
+)
+
+//序列化msg并发送给连接
+func Send(conn ziface.IConn, msgID uint32, msg proto.Message) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.SendMsg(msgID, data)
+}
+
+//将收到的数据解码为protobuf消息再处理的路由，生成的RegisterXxxHandler使用
+type msgRouter struct {
+	newMsg func() proto.Message
+	handle func(request ziface.IRequest, msg proto.Message)
+}
+
+//创建路由，收到的数据解码失败时丢弃该消息
+func NewRouter(newMsg func() proto.Message, handle func(request ziface.IRequest, msg proto.Message)) ziface.IRouter {
+	return &msgRouter{newMsg: newMsg, handle: handle}
+}
+
+func (r *msgRouter) PreHandle(request ziface.IRequest) {}
+
+func (r *msgRouter) Handle(request ziface.IRequest) {
+	msg := r.newMsg()
+	if err := proto.Unmarshal(request.GetData(), msg); err != nil {
+		zlog.Warn("[ZProto] ", proto.MessageName(msg), " unmarshal msgId = ", request.GetMsgID(), " ConnID = ", request.GetConn().GetConnID(), " err ", err)
+		return
+	}
+	r.handle(request, msg)
+}
+
+func (r *msgRouter) PostHandle(request ziface.IRequest) {}
@@ -0,0 +1,166 @@
+package zpubsub
+
+import (
+	"encoding/binary"
+	"errors"
+	"server/ziface"
+	"server/znet"
+	"sync"
+)
+
+/*
+	Broker：基于znet.Server的轻量消息中转，节点通过ClusterBus连接Broker
+	节点订阅主题后，任意节点发布到该主题的消息都会转发给它，包括发布者自己
+
+	MsgIDSubscribe/MsgIDUnsubscribe 数据为主题
+	MsgIDPublish/MsgIDMessage       数据为主题长度(2，小端) + 主题 + 消息
+	同一个节点发布的消息按发布顺序送达每个订阅者
+	Broker的配置不要开启RequireHandshake、RequireAuth、SessionGracePeriod和MaxMsgSize，
+	消息长度受MaxPacketSize限制
+*/
+
+const (
+	MsgIDSubscribe   uint32 = 1
+	MsgIDUnsubscribe uint32 = 2
+	MsgIDPublish     uint32 = 3
+	//Broker转发给订阅者的消息
+	MsgIDMessage uint32 = 4
+)
+
+//主题长度上限
+const maxTopicLen = 0xFFFF
+
+var errBadFrame = errors.New("bad pubsub frame")
+
+func encodeMessage(topic string, data []byte) []byte {
+	buf := make([]byte, 2+len(topic)+len(data))
+	binary.LittleEndian.PutUint16(buf, uint16(len(topic)))
+	n := copy(buf[2:], topic)
+	copy(buf[2+n:], data)
+	return buf
+}
+
+func decodeMessage(buf []byte) (string, []byte, error) {
+	if len(buf) < 2 {
+		return "", nil, errBadFrame
+	}
+	topicLen := int(binary.LittleEndian.Uint16(buf))
+	if len(buf) < 2+topicLen {
+		return "", nil, errBadFrame
+	}
+	return string(buf[2 : 2+topicLen]), buf[2+topicLen:], nil
+}
+
+type Broker struct {
+	server ziface.IServer
+	//订阅的主题到订阅者连接的映射
+	subs map[string]map[uint64]ziface.IConn
+	lock sync.RWMutex
+}
+
+//创建Broker，opts与znet.NewServer相同
+func NewBroker(opts ...znet.Option) *Broker {
+	b := &Broker{
+		server: znet.NewServer(opts...),
+		subs:   make(map[string]map[uint64]ziface.IConn),
+	}
+	b.server.AddRouter(MsgIDSubscribe, &brokerRouter{handle: b.subscribe})
+	b.server.AddRouter(MsgIDUnsubscribe, &brokerRouter{handle: b.unsubscribe})
+	b.server.AddRouter(MsgIDPublish, &brokerRouter{handle: b.publish})
+	b.server.GetEventBus().Subscribe(func(event *ziface.Event) {
+		b.removeConn(event.Conn)
+	}, ziface.EventConnStopped)
+	return b
+}
+
+//得到底层的znet.Server
+func (b *Broker) GetServer() ziface.IServer {
+	return b.server
+}
+
+func (b *Broker) Start() {
+	b.server.Start()
+}
+
+func (b *Broker) Stop() {
+	b.server.Stop()
+}
+
+func (b *Broker) Serve() {
+	b.server.Serve()
+}
+
+func (b *Broker) subscribe(conn ziface.IConn, data []byte) {
+	pattern := string(data)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	conns, ok := b.subs[pattern]
+	if !ok {
+		conns = make(map[uint64]ziface.IConn)
+		b.subs[pattern] = conns
+	}
+	conns[conn.GetConnID()] = conn
+}
+
+func (b *Broker) unsubscribe(conn ziface.IConn, data []byte) {
+	pattern := string(data)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if conns, ok := b.subs[pattern]; ok {
+		delete(conns, conn.GetConnID())
+		if len(conns) == 0 {
+			delete(b.subs, pattern)
+		}
+	}
+}
+
+//节点断开，删除它的全部订阅
+func (b *Broker) removeConn(conn ziface.IConn) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for pattern, conns := range b.subs {
+		delete(conns, conn.GetConnID())
+		if len(conns) == 0 {
+			delete(b.subs, pattern)
+		}
+	}
+}
+
+//将消息转发给订阅了匹配主题的节点，一个节点的多个订阅匹配时只转发一次
+func (b *Broker) publish(conn ziface.IConn, data []byte) {
+	topic, _, err := decodeMessage(data)
+	if err != nil {
+		b.server.GetLogger().Warn("[PubSub] bad publish from ConnID = ", conn.GetConnID())
+		return
+	}
+
+	b.lock.RLock()
+	targets := make(map[uint64]ziface.IConn)
+	for pattern, conns := range b.subs {
+		if !Match(pattern, topic) {
+			continue
+		}
+		for connID, c := range conns {
+			targets[connID] = c
+		}
+	}
+	b.lock.RUnlock()
+
+	for _, target := range targets {
+		if err := target.SendBuffMsg(MsgIDMessage, data); err != nil {
+			b.server.GetLogger().Warn("[PubSub] forward topic ", topic, " to ConnID = ", target.GetConnID(), " err ", err)
+		}
+	}
+}
+
+type brokerRouter struct {
+	znet.BaseRouter
+	handle func(conn ziface.IConn, data []byte)
+}
+
+func (r *brokerRouter) Handle(request ziface.IRequest) {
+	r.handle(request.GetConn(), request.GetData())
+}
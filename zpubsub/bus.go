@@ -0,0 +1,100 @@
+package zpubsub
+
+import (
+	"strings"
+	"sync"
+)
+
+/*
+	发布订阅总线：按主题订阅和发布消息
+	LocalBus只在进程内分发；ClusterBus通过Broker在集群中的全部节点之间分发
+
+	主题用.分隔层级，订阅时可以使用通配：
+		"world.chat"   只匹配world.chat
+		"world.*"      匹配以world.开头的全部主题
+		"*"            匹配全部主题
+*/
+
+//消息处理方法，在分发消息的goroutine中同步执行，不要长时间阻塞
+type Handler func(topic string, data []byte)
+
+//发布订阅总线
+type Bus interface {
+	//订阅主题，返回订阅ID用于取消订阅
+	Subscribe(pattern string, handler Handler) int
+	//取消订阅
+	Unsubscribe(id int)
+	//发布消息
+	Publish(topic string, data []byte) error
+}
+
+//主题是否匹配订阅
+func Match(pattern, topic string) bool {
+	if pattern == "*" || pattern == topic {
+		return true
+	}
+	return strings.HasSuffix(pattern, ".*") && strings.HasPrefix(topic, pattern[:len(pattern)-1])
+}
+
+type subscription struct {
+	pattern string
+	handler Handler
+}
+
+//进程内的发布订阅总线
+type LocalBus struct {
+	lock  sync.RWMutex
+	subs  map[int]*subscription
+	subID int
+}
+
+func NewLocalBus() *LocalBus {
+	return &LocalBus{subs: make(map[int]*subscription)}
+}
+
+//订阅主题
+func (b *LocalBus) Subscribe(pattern string, handler Handler) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.subID++
+	b.subs[b.subID] = &subscription{pattern: pattern, handler: handler}
+	return b.subID
+}
+
+//取消订阅
+func (b *LocalBus) Unsubscribe(id int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.subs, id)
+}
+
+//在当前goroutine中依次调用匹配的处理方法
+func (b *LocalBus) Publish(topic string, data []byte) error {
+	b.lock.RLock()
+	handlers := make([]Handler, 0, len(b.subs))
+	for _, sub := range b.subs {
+		if Match(sub.pattern, topic) {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	b.lock.RUnlock()
+
+	for _, handler := range handlers {
+		handler(topic, data)
+	}
+	return nil
+}
+
+//订阅ID对应的主题
+func (b *LocalBus) patternOf(id int) (string, bool) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	sub, ok := b.subs[id]
+	if !ok {
+		return "", false
+	}
+	return sub.pattern, true
+}
@@ -0,0 +1,141 @@
+package zpubsub
+
+import (
+	"errors"
+	"io"
+	"net"
+	"server/utils"
+	"server/ziface"
+	"server/zlog"
+	"server/znet"
+	"sync"
+)
+
+/*
+	ClusterBus：通过Broker在集群中的全部节点之间分发的总线
+	本节点发布的消息也经过Broker再分发给本节点的订阅者，全部节点看到的同一个发布者的消息顺序一致
+	与Broker断开期间或者写超时时发布返回error，重新连接后自动恢复全部订阅，断开期间的消息不会补发
+*/
+
+type ClusterBus struct {
+	packer ziface.IDataPack
+	//到Broker的常驻连接
+	link *znet.Link
+	//本节点的订阅，收到Broker转发的消息后在本地分发
+	local *LocalBus
+	//本节点订阅的主题和订阅次数，第一次订阅时通知Broker，最后一个订阅取消时通知Broker
+	patterns map[string]int
+	//保护patterns，同一个主题的订阅、取消订阅和重连后的恢复订阅按顺序发给Broker
+	//只在订阅变化时持有，发布不需要该锁
+	subLock sync.Mutex
+}
+
+//连接Broker，连接在后台建立并在断开后自动重连，conf需要与Broker的MaxPacketSize一致，为nil时使用全局配置
+func Connect(addr string, conf *utils.GlobalObj) *ClusterBus {
+	if conf == nil {
+		conf = utils.GlobalObject
+	}
+	b := &ClusterBus{
+		packer:   znet.NewDataPackWithConfig(conf),
+		local:    NewLocalBus(),
+		patterns: make(map[string]int),
+	}
+	b.link = znet.NewLink(addr, "[PubSub] broker", zlog.StdLog, b.resubscribe, b.read)
+	b.link.Start()
+	return b
+}
+
+//订阅主题
+func (b *ClusterBus) Subscribe(pattern string, handler Handler) int {
+	b.subLock.Lock()
+	defer b.subLock.Unlock()
+
+	id := b.local.Subscribe(pattern, handler)
+	b.patterns[pattern]++
+	if b.patterns[pattern] == 1 {
+		_ = b.send(MsgIDSubscribe, []byte(pattern))
+	}
+	return id
+}
+
+//取消订阅
+func (b *ClusterBus) Unsubscribe(id int) {
+	b.subLock.Lock()
+	defer b.subLock.Unlock()
+
+	pattern, ok := b.local.patternOf(id)
+	if !ok {
+		return
+	}
+	b.local.Unsubscribe(id)
+	b.patterns[pattern]--
+	if b.patterns[pattern] == 0 {
+		delete(b.patterns, pattern)
+		_ = b.send(MsgIDUnsubscribe, []byte(pattern))
+	}
+}
+
+//发布到集群中的全部节点，与Broker断开或者写超时时返回error
+func (b *ClusterBus) Publish(topic string, data []byte) error {
+	if len(topic) > maxTopicLen {
+		return errors.New("pubsub topic too long")
+	}
+	return b.send(MsgIDPublish, encodeMessage(topic, data))
+}
+
+//是否已经连接上Broker
+func (b *ClusterBus) Ready() bool {
+	return b.link.Connected()
+}
+
+//断开Broker，不再重连
+func (b *ClusterBus) Close() {
+	b.link.Close()
+}
+
+//写一帧给Broker，没有连接时返回znet.ErrLinkNotConnected
+func (b *ClusterBus) send(msgID uint32, data []byte) error {
+	frame, err := b.packer.Pack(znet.NewMsgPackage(msgID, data))
+	if err != nil {
+		return err
+	}
+	return b.link.Send(frame)
+}
+
+//连接Broker之后恢复全部订阅
+func (b *ClusterBus) resubscribe() {
+	b.subLock.Lock()
+	defer b.subLock.Unlock()
+
+	for pattern := range b.patterns {
+		if err := b.send(MsgIDSubscribe, []byte(pattern)); err != nil {
+			return
+		}
+	}
+}
+
+//读取Broker转发的消息并在本地分发，直到连接断开
+func (b *ClusterBus) read(conn net.Conn) {
+	headData := make([]byte, b.packer.GetHeadLen())
+	for {
+		if _, err := io.ReadFull(conn, headData); err != nil {
+			return
+		}
+		msg, err := b.packer.UnPack(headData)
+		if err != nil {
+			return
+		}
+		data := make([]byte, msg.GetDataLen())
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		if msg.GetMsgID() != MsgIDMessage {
+			continue
+		}
+		topic, payload, err := decodeMessage(data)
+		if err != nil {
+			return
+		}
+		_ = b.local.Publish(topic, payload)
+	}
+}
@@ -0,0 +1,292 @@
+package zregistry
+
+import (
+	"encoding/json"
+	"server/ziface"
+	"server/zlog"
+	"server/znet"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+	注册中心：基于znet.Server的轻量节点注册与发现服务，可以嵌入到任意进程中，也可以用main/zregistry单独运行
+	节点通过znet.RegistryClient注册并按租约发送心跳，租约过期或者主动注销时删除节点，
+	订阅了节点变更的连接先收到一次全量同步，之后收到每一次节点的注册、变化和删除
+
+	节点与注册中心断开时不会立即删除，租约内重新连接并注册即可保持节点不变
+	注册中心的配置不要开启RequireHandshake、RequireAuth和SessionGracePeriod
+*/
+
+//注册请求没有携带租约时使用的租约时间
+const defaultLease = 10 * time.Second
+
+//已注册的节点
+type entry struct {
+	info  ziface.NodeInfo
+	lease time.Duration
+	//租约到期时删除节点，每次心跳重置
+	timer *time.Timer
+}
+
+type Registry struct {
+	server ziface.IServer
+	//节点ID到节点的映射
+	nodes map[string]*entry
+	//订阅了节点变更的连接
+	watchers map[uint64]*watcher
+	//保护nodes和watchers，持有锁时将变更放入每个订阅者的队列，保证每个订阅者看到的变更顺序一致
+	lock sync.Mutex
+}
+
+//订阅了节点变更的连接，变更由各自的goroutine在锁外按顺序推送
+//SendBuffMsg在连接的发送队列满时阻塞，慢的订阅者不会阻塞注册、心跳、租约过期和其他订阅者
+type watcher struct {
+	conn ziface.IConn
+	//待推送的变更
+	pending []*znet.Msg
+	lock    sync.Mutex
+	//有新的待推送变更
+	notifyChan chan struct{}
+	//取消订阅时关闭
+	exitChan chan struct{}
+}
+
+func newWatcher(conn ziface.IConn) *watcher {
+	return &watcher{
+		conn:       conn,
+		notifyChan: make(chan struct{}, 1),
+		exitChan:   make(chan struct{}),
+	}
+}
+
+//将变更放入队列，不阻塞
+func (w *watcher) push(msgID uint32, data []byte) {
+	w.lock.Lock()
+	w.pending = append(w.pending, znet.NewMsgPackage(msgID, data))
+	w.lock.Unlock()
+
+	select {
+	case w.notifyChan <- struct{}{}:
+	default:
+	}
+}
+
+//按顺序推送队列中的变更，直到stop
+func (w *watcher) run(logger *zlog.Logger) {
+	for {
+		select {
+		case <-w.exitChan:
+			return
+		case <-w.notifyChan:
+		}
+
+		w.lock.Lock()
+		pending := w.pending
+		w.pending = nil
+		w.lock.Unlock()
+
+		for _, msg := range pending {
+			if err := w.conn.SendBuffMsg(msg.GetMsgID(), msg.GetData()); err != nil {
+				logger.Warn("[Registry] notify ConnID = ", w.conn.GetConnID(), " err ", err)
+			}
+		}
+	}
+}
+
+//停止推送，丢弃未推送的变更
+func (w *watcher) stop() {
+	close(w.exitChan)
+}
+
+//创建注册中心，opts与znet.NewServer相同
+func NewRegistry(opts ...znet.Option) *Registry {
+	r := &Registry{
+		server:   znet.NewServer(opts...),
+		nodes:    make(map[string]*entry),
+		watchers: make(map[uint64]*watcher),
+	}
+	r.server.AddRouter(znet.RegistryMsgRegister, &registryRouter{handle: r.register})
+	r.server.AddRouter(znet.RegistryMsgHeartbeat, &registryRouter{handle: r.heartbeat})
+	r.server.AddRouter(znet.RegistryMsgDeregister, &registryRouter{handle: r.deregister})
+	r.server.AddRouter(znet.RegistryMsgWatch, &registryRouter{handle: r.watch})
+	r.server.GetEventBus().Subscribe(func(event *ziface.Event) {
+		r.removeWatcher(event.Conn)
+	}, ziface.EventConnStopped)
+	return r
+}
+
+//得到底层的znet.Server
+func (r *Registry) GetServer() ziface.IServer {
+	return r.server
+}
+
+func (r *Registry) Start() {
+	r.server.Start()
+}
+
+//停止服务并清空全部节点和订阅者
+func (r *Registry) Stop() {
+	r.server.Stop()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for id, e := range r.nodes {
+		e.timer.Stop()
+		delete(r.nodes, id)
+	}
+	for id, w := range r.watchers {
+		w.stop()
+		delete(r.watchers, id)
+	}
+}
+
+func (r *Registry) Serve() {
+	r.server.Serve()
+}
+
+//当前注册的kind类型的全部节点，kind为空时返回全部节点，按ID排序
+func (r *Registry) Nodes(kind string) []ziface.NodeInfo {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	nodes := make([]ziface.NodeInfo, 0, len(r.nodes))
+	for _, e := range r.nodes {
+		if kind == "" || e.info.Kind == kind {
+			nodes = append(nodes, e.info)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+//注册节点，同一个ID重复注册时覆盖之前的信息(如节点重启)
+func (r *Registry) register(conn ziface.IConn, data []byte) {
+	var req znet.RegistryRegister
+	if err := json.Unmarshal(data, &req); err != nil || req.Node.ID == "" {
+		r.server.GetLogger().Warn("[Registry] bad register from ConnID = ", conn.GetConnID())
+		return
+	}
+	lease := time.Duration(req.Lease) * time.Millisecond
+	if lease <= 0 {
+		lease = defaultLease
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if old, ok := r.nodes[req.Node.ID]; ok {
+		old.timer.Stop()
+	} else {
+		r.server.GetLogger().Info("[Registry] node ", req.Node.ID, " ", req.Node.Kind, " ", req.Node.Addr, " registered")
+	}
+	e := &entry{info: req.Node, lease: lease}
+	e.timer = time.AfterFunc(lease, func() {
+		r.expire(e)
+	})
+	r.nodes[e.info.ID] = e
+	r.notify(znet.RegistryMsgNodeUp, r.encodeNode(&e.info))
+}
+
+//续约，负载变化时推送给订阅者，节点不存在时通知客户端重新注册
+func (r *Registry) heartbeat(conn ziface.IConn, data []byte) {
+	var req znet.RegistryHeartbeat
+	if err := json.Unmarshal(data, &req); err != nil {
+		r.server.GetLogger().Warn("[Registry] bad heartbeat from ConnID = ", conn.GetConnID())
+		return
+	}
+
+	r.lock.Lock()
+	e, ok := r.nodes[req.ID]
+	if ok {
+		e.timer.Reset(e.lease)
+		if e.info.Load != req.Load {
+			e.info.Load = req.Load
+			r.notify(znet.RegistryMsgNodeUp, r.encodeNode(&e.info))
+		}
+	}
+	r.lock.Unlock()
+
+	if !ok {
+		_ = conn.SendBuffMsg(znet.RegistryMsgUnknownNode, []byte(req.ID))
+	}
+}
+
+//主动注销节点
+func (r *Registry) deregister(conn ziface.IConn, data []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if e, ok := r.nodes[string(data)]; ok {
+		r.remove(e, "deregistered")
+	}
+}
+
+//租约到期，节点在此期间重新注册过时忽略
+func (r *Registry) expire(e *entry) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.nodes[e.info.ID] == e {
+		r.remove(e, "lease expired")
+	}
+}
+
+//删除节点并通知订阅者，需要持有lock
+func (r *Registry) remove(e *entry, reason string) {
+	e.timer.Stop()
+	delete(r.nodes, e.info.ID)
+	r.server.GetLogger().Info("[Registry] node ", e.info.ID, " ", reason)
+	r.notify(znet.RegistryMsgNodeDown, []byte(e.info.ID))
+}
+
+//订阅节点变更，先推送全量同步
+func (r *Registry) watch(conn ziface.IConn, data []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	w, ok := r.watchers[conn.GetConnID()]
+	if !ok {
+		w = newWatcher(conn)
+		r.watchers[conn.GetConnID()] = w
+		go w.run(r.server.GetLogger())
+	}
+	w.push(znet.RegistryMsgSyncBegin, nil)
+	for _, e := range r.nodes {
+		w.push(znet.RegistryMsgNodeUp, r.encodeNode(&e.info))
+	}
+	w.push(znet.RegistryMsgSyncEnd, nil)
+}
+
+//连接断开，不再推送节点变更，该连接注册的节点等待租约过期
+func (r *Registry) removeWatcher(conn ziface.IConn) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if w, ok := r.watchers[conn.GetConnID()]; ok {
+		w.stop()
+		delete(r.watchers, conn.GetConnID())
+	}
+}
+
+//放入全部订阅者的推送队列，需要持有lock
+func (r *Registry) notify(msgID uint32, data []byte) {
+	for _, w := range r.watchers {
+		w.push(msgID, data)
+	}
+}
+
+func (r *Registry) encodeNode(info *ziface.NodeInfo) []byte {
+	data, _ := json.Marshal(info)
+	return data
+}
+
+type registryRouter struct {
+	znet.BaseRouter
+	handle func(conn ziface.IConn, data []byte)
+}
+
+func (rr *registryRouter) Handle(request ziface.IRequest) {
+	rr.handle(request.GetConn(), request.GetData())
+}
@@ -0,0 +1,241 @@
+package zrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"server/utils"
+	"server/ziface"
+	"server/znet"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	RPC客户端：一个TCP连接上可以同时进行多个调用
+	Context的截止时间随请求发给服务端，Context被取消时通知服务端取消对应的处理
+*/
+
+//连接服务端的超时时间
+const dialTimeout = 3 * time.Second
+
+//通知服务端取消调用的写超时
+const cancelTimeout = time.Second
+
+//ErrClientClosed 连接已经断开，进行中和之后的调用都返回该错误
+var ErrClientClosed = errors.New("zrpc client closed")
+
+//发起调用的接口，Client和Pool都实现了该接口，生成的客户端代码通过该接口调用
+type Caller interface {
+	//调用method，resp为回复解析的目标
+	Call(ctx context.Context, method string, req, resp proto.Message) error
+}
+
+type Client struct {
+	conn   net.Conn
+	packer ziface.IDataPack
+	//超过该长度的请求拆成分片发送，没有开启MaxMsgSize时为0
+	maxPacketSize uint32
+	//重组服务端回复的分片，没有开启MaxMsgSize时为nil
+	fragments *znet.Reassembler
+	fragID    uint32
+	//保护写入
+	writeLock sync.Mutex
+
+	//调用ID，原子递增
+	callID uint64
+	//等待回复的调用
+	pending map[uint64]chan *response
+	lock    sync.Mutex
+	//连接断开时关闭
+	done chan struct{}
+}
+
+//连接RPC服务端，conf需要与服务端的MaxPacketSize、MaxMsgSize一致，为nil时使用全局配置
+func Dial(addr string, conf *utils.GlobalObj) (*Client, error) {
+	return DialContext(context.Background(), addr, conf)
+}
+
+//连接RPC服务端，连接在ctx的截止时间或者dialTimeout之前没有完成时返回error，ctx被取消时立即返回
+func DialContext(ctx context.Context, addr string, conf *utils.GlobalObj) (*Client, error) {
+	if conf == nil {
+		conf = utils.GlobalObject
+	}
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:    conn,
+		packer:  znet.NewDataPackWithConfig(conf),
+		pending: make(map[uint64]chan *response),
+		done:    make(chan struct{}),
+	}
+	if conf.MaxMsgSize > 0 {
+		c.maxPacketSize = conf.MaxPacketSize
+		c.fragments = znet.NewReassembler(conf.MaxMsgSize, conf.MaxFragmentBuffer,
+			time.Duration(conf.FragmentTimeout)*time.Millisecond)
+	}
+	go c.read()
+	return c, nil
+}
+
+//调用method，ctx的截止时间会传递给服务端，也作为发送请求的写超时，ctx被取消时立即返回ctx.Err()
+func (c *Client) Call(ctx context.Context, method string, req, resp proto.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(method) > maxMessageLen {
+		return errors.New("zrpc method name too long")
+	}
+	payload, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r := &request{
+		callID:  atomic.AddUint64(&c.callID, 1),
+		method:  method,
+		payload: payload,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+		//不足1毫秒的按1毫秒计算，0表示没有超时
+		r.timeout = uint32((timeout + time.Millisecond - 1) / time.Millisecond)
+	}
+
+	ch := make(chan *response, 1)
+	c.lock.Lock()
+	select {
+	case <-c.done:
+		c.lock.Unlock()
+		return ErrClientClosed
+	default:
+	}
+	c.pending[r.callID] = ch
+	c.lock.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	if err := c.send(MsgIDRequest, r.encode(), deadline); err != nil {
+		c.forget(r.callID)
+		return err
+	}
+
+	select {
+	case result := <-ch:
+		if result.code != CodeOK {
+			return &Error{Code: result.code, Message: result.message}
+		}
+		return proto.Unmarshal(result.payload, resp)
+	case <-ctx.Done():
+		c.forget(r.callID)
+		//通知服务端取消，失败时服务端在超时或者连接断开时也会取消
+		_ = c.send(MsgIDCancel, encodeCallID(r.callID), time.Now().Add(cancelTimeout))
+		return ctx.Err()
+	case <-c.done:
+		return ErrClientClosed
+	}
+}
+
+//关闭连接，进行中的调用返回ErrClientClosed
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+//连接是否已经断开
+func (c *Client) Closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) forget(callID uint64) {
+	c.lock.Lock()
+	delete(c.pending, callID)
+	c.lock.Unlock()
+}
+
+//在deadline之前写出，deadline为零值表示不超时
+//写超时时可能只写出了一部分，连接不能继续使用，关闭连接并返回context.DeadlineExceeded
+func (c *Client) send(msgID uint32, data []byte, deadline time.Time) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.fragID++
+	frames, err := znet.PackFragments(c.packer, msgID, data, c.maxPacketSize, c.fragID)
+	if err != nil {
+		return err
+	}
+	_ = c.conn.SetWriteDeadline(deadline)
+	for _, frame := range frames {
+		if _, err := c.conn.Write(frame); err != nil {
+			c.conn.Close()
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return context.DeadlineExceeded
+			}
+			return ErrClientClosed
+		}
+	}
+	return nil
+}
+
+//读取服务端的回复直到连接断开，重组分片，忽略其他系统消息
+func (c *Client) read() {
+	defer func() {
+		c.conn.Close()
+		c.lock.Lock()
+		close(c.done)
+		c.pending = make(map[uint64]chan *response)
+		c.lock.Unlock()
+	}()
+
+	headData := make([]byte, c.packer.GetHeadLen())
+	for {
+		if _, err := io.ReadFull(c.conn, headData); err != nil {
+			return
+		}
+		msg, err := c.packer.UnPack(headData)
+		if err != nil {
+			return
+		}
+		data := make([]byte, msg.GetDataLen())
+		if _, err := io.ReadFull(c.conn, data); err != nil {
+			return
+		}
+		if msg.GetMsgID() == znet.MsgIDFragment && c.fragments != nil {
+			full, err := c.fragments.Add(data)
+			if err != nil {
+				return
+			}
+			if full == nil {
+				continue
+			}
+			msg, data = full, full.GetData()
+		}
+		if msg.GetMsgID() != MsgIDResponse {
+			continue
+		}
+
+		result, err := decodeResponse(data)
+		if err != nil {
+			return
+		}
+		c.lock.Lock()
+		ch, ok := c.pending[result.callID]
+		delete(c.pending, result.callID)
+		c.lock.Unlock()
+		if ok {
+			ch <- result
+		}
+	}
+}
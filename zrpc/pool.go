@@ -0,0 +1,116 @@
+package zrpc
+
+import (
+	"context"
+	"server/utils"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	连接池：到同一个服务端的多个连接，调用轮流使用，断开的连接在下次使用时重新连接
+*/
+
+type Pool struct {
+	addr    string
+	conf    *utils.GlobalObj
+	clients []*Client
+	//正在重新连接的槽位，连接结束时关闭
+	dialing []chan struct{}
+	next    int
+	closed  bool
+	lock    sync.Mutex
+}
+
+//创建到addr的连接池，连接在第一次使用时建立
+func NewPool(addr string, size int, conf *utils.GlobalObj) *Pool {
+	if size <= 0 {
+		size = 1
+	}
+	return &Pool{
+		addr:    addr,
+		conf:    conf,
+		clients: make([]*Client, size),
+		dialing: make([]chan struct{}, size),
+	}
+}
+
+//使用池中的下一个连接调用method
+func (p *Pool) Call(ctx context.Context, method string, req, resp proto.Message) error {
+	client, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+	return client.Call(ctx, method, req, resp)
+}
+
+//取出下一个连接，没有建立或者已经断开时重新连接，连接受ctx的截止时间和取消控制
+//连接时不持有锁，同一个槽位同时只有一个调用在连接，服务端不可用时其他调用等待连接结果或者自己的ctx结束，
+//而不是同时发起大量连接
+func (p *Pool) get(ctx context.Context) (*Client, error) {
+	p.lock.Lock()
+	i := p.next
+	p.next = (p.next + 1) % len(p.clients)
+	p.lock.Unlock()
+
+	for {
+		p.lock.Lock()
+		if p.closed {
+			p.lock.Unlock()
+			return nil, ErrClientClosed
+		}
+		if client := p.clients[i]; client != nil && !client.Closed() {
+			p.lock.Unlock()
+			return client, nil
+		}
+		if wait := p.dialing[i]; wait != nil {
+			p.lock.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		done := make(chan struct{})
+		p.dialing[i] = done
+		p.lock.Unlock()
+
+		client, err := DialContext(ctx, p.addr, p.conf)
+
+		p.lock.Lock()
+		p.dialing[i] = nil
+		close(done)
+		if err == nil && p.closed {
+			client.Close()
+			err = ErrClientClosed
+		}
+		if err == nil {
+			p.clients[i] = client
+		}
+		p.lock.Unlock()
+
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, err
+		}
+		return client, nil
+	}
+}
+
+//关闭全部连接
+func (p *Pool) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.closed = true
+	for i, client := range p.clients {
+		if client != nil {
+			client.Close()
+			p.clients[i] = nil
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package zrpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+/*
+	服务间RPC：复用znet的封包格式，一次调用为一个请求帧和一个回复帧，通过调用ID对应
+
+	请求帧   MsgIDRequest:  调用ID(8) + 剩余超时毫秒(4，0表示没有超时) + 方法名长度(2) + 方法名 + protobuf请求
+	回复帧   MsgIDResponse: 调用ID(8) + 错误码(1) + 错误信息长度(2) + 错误信息 + protobuf回复
+	取消帧   MsgIDCancel:   调用ID(8)，调用方的Context被取消时通知服务端取消对应的处理
+	整数均为小端，方法名为 "proto包名.服务名/方法名"
+*/
+
+//RPC使用的msgID
+const (
+	MsgIDRequest  uint32 = 1
+	MsgIDResponse uint32 = 2
+	MsgIDCancel   uint32 = 3
+)
+
+//错误码
+type Code uint8
+
+const (
+	CodeOK Code = iota
+	//处理方法返回了普通的error
+	CodeUnknown
+	//服务或者方法不存在
+	CodeNotFound
+	//请求无法解析
+	CodeBadRequest
+	//调用方取消了调用
+	CodeCanceled
+	//超过了调用方的截止时间
+	CodeDeadlineExceeded
+	//处理方法panic
+	CodeInternal
+	//连接上进行中的调用超过了服务端的上限
+	CodeResourceExhausted
+)
+
+var codeNames = [...]string{"OK", "Unknown", "NotFound", "BadRequest", "Canceled", "DeadlineExceeded", "Internal", "ResourceExhausted"}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return fmt.Sprintf("Code(%d)", uint8(c))
+}
+
+//服务端返回的错误
+type Error struct {
+	Code    Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "zrpc " + e.Code.String() + ": " + e.Message
+}
+
+//创建带错误码的错误，处理方法返回该错误时调用方可以拿到对应的错误码
+func Errorf(code Code, format string, args ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+//获取错误的错误码，nil为CodeOK，Context的错误转换为对应的错误码
+func CodeOf(err error) Code {
+	switch e := err.(type) {
+	case nil:
+		return CodeOK
+	case *Error:
+		return e.Code
+	}
+	switch err {
+	case context.Canceled:
+		return CodeCanceled
+	case context.DeadlineExceeded:
+		return CodeDeadlineExceeded
+	}
+	return CodeUnknown
+}
+
+//方法名和错误信息的长度上限
+const maxMessageLen = 0xFFFF
+
+var errShortFrame = errors.New("zrpc frame too short")
+
+type request struct {
+	callID  uint64
+	timeout uint32
+	method  string
+	payload []byte
+}
+
+func (r *request) encode() []byte {
+	buf := make([]byte, 14+len(r.method)+len(r.payload))
+	binary.LittleEndian.PutUint64(buf[0:], r.callID)
+	binary.LittleEndian.PutUint32(buf[8:], r.timeout)
+	binary.LittleEndian.PutUint16(buf[12:], uint16(len(r.method)))
+	n := copy(buf[14:], r.method)
+	copy(buf[14+n:], r.payload)
+	return buf
+}
+
+func decodeRequest(data []byte) (*request, error) {
+	if len(data) < 14 {
+		return nil, errShortFrame
+	}
+	r := &request{
+		callID:  binary.LittleEndian.Uint64(data[0:]),
+		timeout: binary.LittleEndian.Uint32(data[8:]),
+	}
+	methodLen := int(binary.LittleEndian.Uint16(data[12:]))
+	if len(data) < 14+methodLen {
+		return nil, errShortFrame
+	}
+	r.method = string(data[14 : 14+methodLen])
+	r.payload = data[14+methodLen:]
+	return r, nil
+}
+
+type response struct {
+	callID  uint64
+	code    Code
+	message string
+	payload []byte
+}
+
+func (r *response) encode() []byte {
+	if len(r.message) > maxMessageLen {
+		r.message = r.message[:maxMessageLen]
+	}
+	buf := make([]byte, 11+len(r.message)+len(r.payload))
+	binary.LittleEndian.PutUint64(buf[0:], r.callID)
+	buf[8] = byte(r.code)
+	binary.LittleEndian.PutUint16(buf[9:], uint16(len(r.message)))
+	n := copy(buf[11:], r.message)
+	copy(buf[11+n:], r.payload)
+	return buf
+}
+
+func decodeResponse(data []byte) (*response, error) {
+	if len(data) < 11 {
+		return nil, errShortFrame
+	}
+	r := &response{
+		callID: binary.LittleEndian.Uint64(data[0:]),
+		code:   Code(data[8]),
+	}
+	messageLen := int(binary.LittleEndian.Uint16(data[9:]))
+	if len(data) < 11+messageLen {
+		return nil, errShortFrame
+	}
+	r.message = string(data[11 : 11+messageLen])
+	r.payload = data[11+messageLen:]
+	return r, nil
+}
+
+func encodeCallID(callID uint64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, callID)
+	return buf
+}
+
+func decodeCallID(data []byte) uint64 {
+	return binary.LittleEndian.Uint64(data)
+}
@@ -0,0 +1,231 @@
+package zrpc
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"server/ziface"
+	"server/zlog"
+	"server/znet"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	RPC服务端：基于znet.Server，每个调用在独立的goroutine中执行，
+	处理方法的Context在调用方超时、取消或者连接断开时被取消，
+	处理方法中使用该Context继续调用其他服务时，截止时间和取消会继续向下传递
+	每个连接同时进行的调用数量有上限，超过时直接回复CodeResourceExhausted
+
+	RPC服务端的配置不要开启RequireHandshake、RequireAuth、SessionGracePeriod和ReliableDelivery，
+	Client不支持这些客户端协议
+*/
+
+//处理方法，req为NewRequest创建并解析好的请求
+type Handler func(ctx context.Context, req proto.Message) (proto.Message, error)
+
+//服务的一个方法
+type MethodDesc struct {
+	Name string
+	//创建空的请求，用于解析请求数据
+	NewRequest func() proto.Message
+	Handler    Handler
+}
+
+//服务描述，通常由protoc-gen-zrpc根据.proto中的service生成
+type ServiceDesc struct {
+	//服务全名，"proto包名.服务名"
+	Name    string
+	Methods []MethodDesc
+}
+
+//每个连接同时进行的调用数量的默认上限
+const DefaultMaxInflight = 256
+
+//进行中的调用
+type callKey struct {
+	connID, callID uint64
+}
+
+type Server struct {
+	server ziface.IServer
+	logger *zlog.Logger
+	//方法全名到方法的映射，需要在Start之前注册
+	methods map[string]*MethodDesc
+	//进行中的调用的取消方法
+	calls map[callKey]context.CancelFunc
+	//每个连接进行中的调用数量
+	inflight map[uint64]int
+	//每个连接同时进行的调用数量上限
+	maxInflight int
+	callLock    sync.Mutex
+}
+
+//创建RPC服务端，opts与znet.NewServer相同
+func NewServer(opts ...znet.Option) *Server {
+	s := &Server{
+		server:      znet.NewServer(opts...),
+		methods:     make(map[string]*MethodDesc),
+		calls:       make(map[callKey]context.CancelFunc),
+		inflight:    make(map[uint64]int),
+		maxInflight: DefaultMaxInflight,
+	}
+	s.logger = s.server.GetLogger()
+	s.server.AddRouter(MsgIDRequest, &requestRouter{s: s})
+	s.server.AddRouter(MsgIDCancel, &cancelRouter{s: s})
+	return s
+}
+
+//注册服务，方法名重复时panic
+func (s *Server) RegisterService(desc *ServiceDesc) {
+	for i := range desc.Methods {
+		method := &desc.Methods[i]
+		name := desc.Name + "/" + method.Name
+		if _, ok := s.methods[name]; ok {
+			panic("repeated rpc method " + name)
+		}
+		s.methods[name] = method
+		s.logger.Debug("[RPC] register method ", name)
+	}
+}
+
+//设置每个连接同时进行的调用数量上限，需要在Start之前调用
+func (s *Server) SetMaxInflight(n int) {
+	s.maxInflight = n
+}
+
+//得到底层的znet.Server，用于设置Hook函数等
+func (s *Server) GetServer() ziface.IServer {
+	return s.server
+}
+
+func (s *Server) Start() {
+	s.server.Start()
+}
+
+func (s *Server) Stop() {
+	s.server.Stop()
+}
+
+func (s *Server) Serve() {
+	s.server.Serve()
+}
+
+//处理请求帧
+func (s *Server) handleRequest(conn ziface.IConn, data []byte) {
+	req, err := decodeRequest(data)
+	if err != nil {
+		s.logger.Warn("[RPC] bad request from ConnID = ", conn.GetConnID(), " err ", err)
+		return
+	}
+	method, ok := s.methods[req.method]
+	if !ok {
+		s.reply(conn, req.callID, nil, Errorf(CodeNotFound, "method %s not found", req.method))
+		return
+	}
+	msg := method.NewRequest()
+	if err := proto.Unmarshal(req.payload, msg); err != nil {
+		s.reply(conn, req.callID, nil, Errorf(CodeBadRequest, "unmarshal request: %v", err))
+		return
+	}
+
+	//连接断开时取消全部进行中的调用
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if req.timeout > 0 {
+		ctx, cancel = context.WithTimeout(conn.Context(), time.Duration(req.timeout)*time.Millisecond)
+	} else {
+		ctx, cancel = context.WithCancel(conn.Context())
+	}
+	key := callKey{connID: conn.GetConnID(), callID: req.callID}
+	s.callLock.Lock()
+	if s.inflight[key.connID] >= s.maxInflight {
+		s.callLock.Unlock()
+		cancel()
+		s.reply(conn, req.callID, nil, Errorf(CodeResourceExhausted, "too many inflight calls, limit %d", s.maxInflight))
+		return
+	}
+	s.inflight[key.connID]++
+	s.calls[key] = cancel
+	s.callLock.Unlock()
+
+	go func() {
+		defer func() {
+			s.callLock.Lock()
+			delete(s.calls, key)
+			if s.inflight[key.connID]--; s.inflight[key.connID] == 0 {
+				delete(s.inflight, key.connID)
+			}
+			s.callLock.Unlock()
+			cancel()
+		}()
+		resp, err := s.invoke(ctx, req.method, method, msg)
+		if err == nil && ctx.Err() != nil {
+			//处理方法没有感知Context，仍然按调用方的超时或取消回复
+			err = ctx.Err()
+		}
+		s.reply(conn, req.callID, resp, err)
+	}()
+}
+
+//执行处理方法，panic时返回CodeInternal
+func (s *Server) invoke(ctx context.Context, name string, method *MethodDesc, req proto.Message) (resp proto.Message, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error("[RPC] method ", name, " panic: ", r, "\n", string(debug.Stack()))
+			resp, err = nil, Errorf(CodeInternal, "method %s panic: %v", name, r)
+		}
+	}()
+	return method.Handler(ctx, req)
+}
+
+//回复调用方，调用方已经取消时回复会被忽略
+func (s *Server) reply(conn ziface.IConn, callID uint64, resp proto.Message, err error) {
+	r := &response{callID: callID, code: CodeOf(err)}
+	if err != nil {
+		r.message = err.Error()
+	} else if resp != nil {
+		payload, merr := proto.Marshal(resp)
+		if merr != nil {
+			r.code, r.message = CodeInternal, fmt.Sprintf("marshal response: %v", merr)
+		}
+		r.payload = payload
+	}
+	if err := conn.SendBuffMsg(MsgIDResponse, r.encode()); err != nil {
+		s.logger.Warn("[RPC] reply to ConnID = ", conn.GetConnID(), " err ", err)
+	}
+}
+
+//处理取消帧
+func (s *Server) handleCancel(conn ziface.IConn, data []byte) {
+	if len(data) < 8 {
+		return
+	}
+	key := callKey{connID: conn.GetConnID(), callID: decodeCallID(data)}
+	s.callLock.Lock()
+	cancel, ok := s.calls[key]
+	s.callLock.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+type requestRouter struct {
+	znet.BaseRouter
+	s *Server
+}
+
+func (r *requestRouter) Handle(request ziface.IRequest) {
+	r.s.handleRequest(request.GetConn(), request.GetData())
+}
+
+type cancelRouter struct {
+	znet.BaseRouter
+	s *Server
+}
+
+func (r *cancelRouter) Handle(request ziface.IRequest) {
+	r.s.handleCancel(request.GetConn(), request.GetData())
+}
@@ -0,0 +1,135 @@
+package zrpc
+
+import (
+	"bytes"
+	"fmt"
+	"server/zplugin"
+	"strings"
+)
+
+/*
+	根据.proto中的service生成服务端接口、注册方法和客户端代码，由protoc-gen-zrpc调用：
+		protoc --go_out=. --zrpc_out=. chat.proto
+	生成的 chat.zrpc.go 与 protoc-gen-go 生成的消息在同一个包中，请求和回复需要是同一个proto包中的消息
+	插件参数 zrpc_import 指定zrpc包的导入路径，默认为 server/zrpc
+*/
+
+//默认的zrpc包导入路径
+const defaultImportPath = "server/zrpc"
+
+//protoc-gen-zrpc的生成方法，没有service的文件不生成
+func GenerateStubs(req *zplugin.CodeGeneratorRequest) ([]*zplugin.CodeGeneratorResponse_File, error) {
+	importPath := zplugin.Params(req)["zrpc_import"]
+	if importPath == "" {
+		importPath = defaultImportPath
+	}
+
+	var files []*zplugin.CodeGeneratorResponse_File
+	for _, f := range zplugin.FilesToGenerate(req) {
+		if len(f.Service) == 0 {
+			continue
+		}
+		content, err := generateFile(f, importPath)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, zplugin.NewFile(zplugin.OutputName(f, ".zrpc.go"), content))
+	}
+	return files, nil
+}
+
+func generateFile(f *zplugin.FileDescriptorProto, importPath string) (string, error) {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "// Code generated by protoc-gen-zrpc. DO NOT EDIT.\n// source: %s\n\n", f.GetName())
+	fmt.Fprintf(buf, "package %s\n\n", zplugin.GoPackageName(f))
+	fmt.Fprintf(buf, "import (\n\tcontext \"context\"\n\tproto \"github.com/golang/protobuf/proto\"\n\tzrpc %q\n)\n\n", importPath)
+	fmt.Fprintf(buf, "var _ = proto.Marshal\n")
+
+	for i, service := range f.Service {
+		if err := generateService(buf, f, i, service); err != nil {
+			return "", err
+		}
+	}
+	return zplugin.FormatGo(zplugin.OutputName(f, ".zrpc.go"), buf.Bytes())
+}
+
+//一个方法的Go名称和请求、回复类型
+type stubMethod struct {
+	name, protoName, input, output string
+}
+
+func generateService(buf *bytes.Buffer, f *zplugin.FileDescriptorProto, index int, service *zplugin.ServiceDescriptorProto) error {
+	serviceName := zplugin.CamelCase(service.GetName())
+	fullName := service.GetName()
+	if f.GetPackage() != "" {
+		fullName = f.GetPackage() + "." + fullName
+	}
+
+	methods := make([]stubMethod, 0, len(service.Method))
+	for _, method := range service.Method {
+		if method.IsStreaming() {
+			return fmt.Errorf("%s.%s: streaming methods are not supported", fullName, method.GetName())
+		}
+		input, err := zplugin.GoTypeName(f, method.GetInputType())
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", fullName, method.GetName(), err)
+		}
+		output, err := zplugin.GoTypeName(f, method.GetOutputType())
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", fullName, method.GetName(), err)
+		}
+		methods = append(methods, stubMethod{
+			name:      zplugin.CamelCase(method.GetName()),
+			protoName: method.GetName(),
+			input:     input,
+			output:    output,
+		})
+	}
+
+	//服务端接口
+	writeComments(buf, zplugin.Comments(f, zplugin.PathService, int32(index)), serviceName+"Server is the server API for "+fullName)
+	fmt.Fprintf(buf, "type %sServer interface {\n", serviceName)
+	for i, m := range methods {
+		writeComments(buf, zplugin.Comments(f, zplugin.PathService, int32(index), zplugin.PathMethod, int32(i)), "")
+		fmt.Fprintf(buf, "\t%s(ctx context.Context, req *%s) (*%s, error)\n", m.name, m.input, m.output)
+	}
+	fmt.Fprintf(buf, "}\n\n")
+
+	//注册方法
+	fmt.Fprintf(buf, "func Register%sServer(s *zrpc.Server, impl %sServer) {\n", serviceName, serviceName)
+	fmt.Fprintf(buf, "\ts.RegisterService(&zrpc.ServiceDesc{\n\t\tName: %q,\n\t\tMethods: []zrpc.MethodDesc{\n", fullName)
+	for _, m := range methods {
+		fmt.Fprintf(buf, "\t\t\t{\n\t\t\t\tName: %q,\n", m.protoName)
+		fmt.Fprintf(buf, "\t\t\t\tNewRequest: func() proto.Message { return new(%s) },\n", m.input)
+		fmt.Fprintf(buf, "\t\t\t\tHandler: func(ctx context.Context, req proto.Message) (proto.Message, error) {\n")
+		fmt.Fprintf(buf, "\t\t\t\t\tresp, err := impl.%s(ctx, req.(*%s))\n", m.name, m.input)
+		fmt.Fprintf(buf, "\t\t\t\t\tif err != nil || resp == nil {\n\t\t\t\t\t\treturn nil, err\n\t\t\t\t\t}\n")
+		fmt.Fprintf(buf, "\t\t\t\t\treturn resp, nil\n\t\t\t\t},\n\t\t\t},\n")
+	}
+	fmt.Fprintf(buf, "\t\t},\n\t})\n}\n\n")
+
+	//客户端
+	fmt.Fprintf(buf, "// %sClient is the client API for %s\n", serviceName, fullName)
+	fmt.Fprintf(buf, "type %sClient struct {\n\tcc zrpc.Caller\n}\n\n", serviceName)
+	fmt.Fprintf(buf, "func New%sClient(cc zrpc.Caller) *%sClient {\n\treturn &%sClient{cc: cc}\n}\n\n", serviceName, serviceName, serviceName)
+	for _, m := range methods {
+		fmt.Fprintf(buf, "func (c *%sClient) %s(ctx context.Context, req *%s) (*%s, error) {\n", serviceName, m.name, m.input, m.output)
+		fmt.Fprintf(buf, "\tresp := new(%s)\n", m.output)
+		fmt.Fprintf(buf, "\tif err := c.cc.Call(ctx, %q, req, resp); err != nil {\n\t\treturn nil, err\n\t}\n", fullName+"/"+m.protoName)
+		fmt.Fprintf(buf, "\treturn resp, nil\n}\n\n")
+	}
+	return nil
+}
+
+//将.proto中的注释写为Go注释，没有注释时使用fallback
+func writeComments(buf *bytes.Buffer, comments, fallback string) {
+	if comments == "" {
+		comments = fallback
+	}
+	if comments == "" {
+		return
+	}
+	for _, line := range strings.Split(comments, "\n") {
+		fmt.Fprintf(buf, "// %s\n", strings.TrimSpace(line))
+	}
+}
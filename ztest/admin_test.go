@@ -0,0 +1,121 @@
+package ztest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"server/utils"
+	"server/zadmin"
+	"server/znet"
+	"strings"
+	"testing"
+)
+
+/*
+	管理后台单元测试
+	go test -v ./ztest -run=TestAdmin
+*/
+
+func TestAdmin(t *testing.T) {
+	utils.GlobalObject.AdminHost = "127.0.0.1"
+	utils.GlobalObject.AdminHttpPort = 9901
+	utils.GlobalObject.AdminTelnetPort = 9902
+	utils.GlobalObject.AdminToken = "secret"
+	defer func() {
+		utils.GlobalObject.AdminHttpPort = 0
+		utils.GlobalObject.AdminTelnetPort = 0
+		utils.GlobalObject.AdminToken = ""
+	}()
+
+	admin := zadmin.NewAdmin(znet.NewServer())
+	if err := admin.Start(); err != nil {
+		t.Fatal("admin start err: ", err)
+	}
+	defer admin.Stop()
+
+	//没有令牌的请求应该被拒绝
+	resp, err := http.Get("http://127.0.0.1:9901/conns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("no token: status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	//URL参数中的令牌不被接受
+	resp, err = http.Get("http://127.0.0.1:9901/conns?token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("query token: status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:9901/conns", nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("with token: status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	//telnet控制台
+	conn, err := net.Dial("tcp", "127.0.0.1:9902")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	_, _ = reader.ReadString('\n')
+
+	fmt.Fprintf(conn, "auth secret\r\n")
+	if line, _ := reader.ReadString('\n'); strings.TrimSpace(line) != "OK" {
+		t.Fatalf("auth reply = %q", line)
+	}
+
+	fmt.Fprintf(conn, "kick 12345\r\n")
+	if line, _ := reader.ReadString('\n'); !strings.HasPrefix(line, "ERR") {
+		t.Errorf("kick unknown conn reply = %q", line)
+	}
+
+	fmt.Fprintf(conn, "loglevel debug\r\n")
+	if line, _ := reader.ReadString('\n'); strings.TrimSpace(line) != "OK" {
+		t.Errorf("loglevel reply = %q", line)
+	}
+}
+
+//telnet端口监听失败时不留下已经打开的HTTP端口
+func TestAdminStartFailure(t *testing.T) {
+	utils.GlobalObject.AdminHost = "127.0.0.1"
+	utils.GlobalObject.AdminHttpPort = 9903
+	utils.GlobalObject.AdminTelnetPort = 9904
+	utils.GlobalObject.AdminToken = "secret"
+	defer func() {
+		utils.GlobalObject.AdminHttpPort = 0
+		utils.GlobalObject.AdminTelnetPort = 0
+		utils.GlobalObject.AdminToken = ""
+	}()
+
+	busy, err := net.Listen("tcp", "127.0.0.1:9904")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := zadmin.NewAdmin(znet.NewServer())
+	if err := admin.Start(); err == nil {
+		admin.Stop()
+		t.Fatal("start with busy telnet port succeeded")
+	}
+	busy.Close()
+
+	//端口释放之后可以再次启动
+	if err := admin.Start(); err != nil {
+		t.Fatal("restart err: ", err)
+	}
+	admin.Stop()
+}
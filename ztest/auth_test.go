@@ -0,0 +1,86 @@
+package ztest
+
+import (
+	"encoding/binary"
+	"io"
+	"server/utils"
+	"server/ziface"
+	"server/znet"
+	"testing"
+	"time"
+)
+
+/*
+	登录认证：未认证的连接只能发送登录消息
+	go test -v ./ztest -run=TestAuth
+*/
+
+//密码正确时标记连接已认证
+type LoginRouter struct {
+	znet.BaseRouter
+}
+
+func (r *LoginRouter) Handle(request ziface.IRequest) {
+	if string(request.GetData()) != "secret" {
+		_ = request.GetConn().SendMsg(request.GetMsgID(), []byte("denied"))
+		return
+	}
+	request.GetConn().SetAuthenticated()
+	_ = request.GetConn().SendMsg(request.GetMsgID(), []byte("ok"))
+}
+
+func TestAuth(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9897
+	conf.RequireAuth = true
+	conf.LoginTimeout = 300
+	conf.UnauthenticatedMsgID = 99
+
+	s := znet.NewServer(znet.WithConfig(conf))
+	s.AddRouter(1, &LoginRouter{})
+	s.AddRouter(2, &EchoNameRouter{name: "s"})
+	s.GetMsgHandler().SetLoginMsgIDs(1)
+	s.Start()
+	defer s.Stop()
+
+	conn := dialRetry(t, "127.0.0.1:9897")
+	defer conn.Close()
+
+	//认证之前的业务消息被拒绝
+	reply := sendAndRecv(t, conn, 2, []byte("x"))
+	if reply.GetMsgID() != 99 || binary.LittleEndian.Uint32(reply.GetData()) != 2 {
+		t.Fatalf("reply msgId = %d data %v, want rejected msgId 2", reply.GetMsgID(), reply.GetData())
+	}
+	if reply := sendAndRecv(t, conn, 1, []byte("wrong")); string(reply.GetData()) != "denied" {
+		t.Fatalf("login reply = %s, want denied", reply.GetData())
+	}
+
+	//登录消息之后紧跟的消息在登录完成之后处理
+	sendMsg(t, conn, 1, []byte("secret"))
+	sendMsg(t, conn, 2, []byte("y"))
+	if reply := recvMsg(t, conn); string(reply.GetData()) != "ok" {
+		t.Fatalf("login reply = %s, want ok", reply.GetData())
+	}
+	if reply := recvMsg(t, conn); reply.GetMsgID() != 2 || string(reply.GetData()) != "s:y" {
+		t.Fatalf("reply msgId = %d data %s, want echo", reply.GetMsgID(), reply.GetData())
+	}
+
+	//认证之后超过登录超时也不会被断开
+	time.Sleep(400 * time.Millisecond)
+	if reply := sendAndRecv(t, conn, 2, []byte("z")); string(reply.GetData()) != "s:z" {
+		t.Fatalf("reply = %s after login timeout", reply.GetData())
+	}
+
+	//没有登录的连接超时后被断开
+	straggler := dialRetry(t, "127.0.0.1:9897")
+	defer straggler.Close()
+	start := time.Now()
+	_ = straggler.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := straggler.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("straggler read err = %v, want EOF", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("straggler disconnected after %v, before login timeout", elapsed)
+	}
+}
@@ -0,0 +1,150 @@
+package ztest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"server/utils"
+	"server/zcapture"
+	"server/znet"
+	"testing"
+	"time"
+)
+
+/*
+	抓包和回放
+	go test -v ./ztest -run=TestCapture
+*/
+
+func TestCaptureFile(t *testing.T) {
+	start := time.Now()
+	records := []zcapture.Record{
+		{Time: start, ConnID: 1, Dir: zcapture.DirOpen},
+		{Time: start.Add(time.Millisecond), ConnID: 1, Dir: zcapture.DirIn, MsgID: 2, Data: []byte("hello")},
+		{Time: start.Add(2 * time.Millisecond), ConnID: 1<<40 + 1, Dir: zcapture.DirOut, MsgID: 200, Data: []byte{}},
+		{Time: start.Add(time.Microsecond), ConnID: 1, Dir: zcapture.DirClose, Data: []byte("closed by client")},
+	}
+
+	var buf bytes.Buffer
+	w, err := zcapture.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if err := w.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := zcapture.ReadAll(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(records) {
+		t.Fatalf("read %d records, want %d", len(got), len(records))
+	}
+	for i := range records {
+		want := records[i]
+		if !got[i].Time.Equal(want.Time) || got[i].ConnID != want.ConnID || got[i].Dir != want.Dir ||
+			got[i].MsgID != want.MsgID || !bytes.Equal(got[i].Data, want.Data) {
+			t.Errorf("record %d = %v, want %v", i, got[i], want)
+		}
+	}
+
+	//截断的文件返回已经读到的记录
+	got, err = zcapture.ReadAll(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	if err == nil || len(got) != len(records)-1 {
+		t.Errorf("truncated file: %d records, err %v", len(got), err)
+	}
+}
+
+func TestCaptureReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zcapture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.zcap")
+
+	//在被抓包的Server上跑一段会话
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9881
+	s := znet.NewServer(znet.WithConfig(conf))
+	s.AddRouter(1, &EchoNameRouter{name: "a"})
+	s.Start()
+	defer s.Stop()
+	capture, err := zcapture.Start(s, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := dialRetry(t, "127.0.0.1:9881")
+	for _, data := range []string{"1", "2", "3"} {
+		sendAndRecv(t, conn, 1, []byte(data))
+	}
+	conn.Close()
+	waitFor(t, "conn stopped", func() bool { return s.GetConnMgr().Len() == 0 })
+	if err := capture.Stop(); err != nil {
+		t.Fatal("stop capture err: ", err)
+	}
+
+	records, err := zcapture.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := make(map[zcapture.Direction]int)
+	for _, record := range records {
+		dirs[record.Dir]++
+	}
+	if dirs[zcapture.DirOpen] != 1 || dirs[zcapture.DirIn] != 3 || dirs[zcapture.DirOut] != 3 || dirs[zcapture.DirClose] != 1 {
+		t.Fatalf("captured records = %v", records)
+	}
+
+	//回放到行为相同的Server，回复一致
+	same := utils.NewGlobalObj()
+	same.Host = "127.0.0.1"
+	same.TcpPort = 9882
+	s2 := znet.NewServer(znet.WithConfig(same))
+	s2.AddRouter(1, &EchoNameRouter{name: "a"})
+	s2.Start()
+	defer s2.Stop()
+	dialRetry(t, "127.0.0.1:9882").Close()
+
+	opts := zcapture.ReplayOptions{Speed: 4, Drain: 200 * time.Millisecond, CompareData: true}
+	report, err := zcapture.Replay(records, "127.0.0.1:9882", opts)
+	if err != nil {
+		t.Fatal("replay err: ", err)
+	}
+	if !report.OK() || report.Conns != 1 || report.Sent != 3 || report.Received != 3 {
+		t.Fatalf("replay report = %+v", report)
+	}
+
+	//回放到回复不同的Server，逐条报告不一致
+	changed := utils.NewGlobalObj()
+	changed.Host = "127.0.0.1"
+	changed.TcpPort = 9883
+	s3 := znet.NewServer(znet.WithConfig(changed))
+	s3.AddRouter(1, &EchoNameRouter{name: "b"})
+	s3.Start()
+	defer s3.Stop()
+	dialRetry(t, "127.0.0.1:9883").Close()
+
+	opts.Speed = 0
+	report, err = zcapture.Replay(records, "127.0.0.1:9883", opts)
+	if err != nil {
+		t.Fatal("replay err: ", err)
+	}
+	if len(report.Mismatches) != 3 || report.Mismatches[0].Got == nil || string(report.Mismatches[0].Got.Data) != "b:1" {
+		t.Fatalf("replay report = %+v", report)
+	}
+	//只比较msgID时一致
+	opts.CompareData = false
+	if report, err = zcapture.Replay(records, "127.0.0.1:9883", opts); err != nil || !report.OK() {
+		t.Fatalf("replay report = %+v, err %v", report, err)
+	}
+}
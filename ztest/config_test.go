@@ -0,0 +1,128 @@
+package ztest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"server/utils"
+	"strings"
+	"testing"
+)
+
+/*
+	配置热更新单元测试
+	go test -v ./ztest -run=TestConfigReload
+*/
+
+func TestConfigReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := utils.NewGlobalObj()
+	conf.ConfFilePath = filepath.Join(dir, "config.json")
+	writeConf := func(content string) {
+		if err := ioutil.WriteFile(conf.ConfFilePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//首次加载，全部配置生效
+	writeConf(`{"TcpPort": 8999, "MaxConn": 100}`)
+	if err := conf.Reload(); err != nil {
+		t.Fatal("first load err: ", err)
+	}
+	if conf.TcpPort != 8999 || conf.MaxConn != 100 {
+		t.Fatalf("first load: TcpPort = %d, MaxConn = %d", conf.TcpPort, conf.MaxConn)
+	}
+
+	var notified []string
+	conf.Subscribe(func(c *utils.GlobalObj, changed []string) {
+		notified = changed
+	}, "MaxConn")
+
+	//运行期只有可热更新的配置生效
+	writeConf(`{"TcpPort": 7999, "MaxConn": 200}`)
+	if err := conf.Reload(); err != nil {
+		t.Fatal("reload err: ", err)
+	}
+	if conf.MaxConn != 200 {
+		t.Errorf("MaxConn = %d, want 200", conf.MaxConn)
+	}
+	if conf.TcpPort != 8999 {
+		t.Errorf("TcpPort = %d, restart-only key should not change at runtime", conf.TcpPort)
+	}
+	if len(notified) != 1 || notified[0] != "MaxConn" {
+		t.Errorf("notified = %v, want [MaxConn]", notified)
+	}
+
+	//不合法的配置被拒绝，当前配置保持不变
+	writeConf(`{"MaxConn": -1}`)
+	if err := conf.Reload(); err == nil {
+		t.Error("invalid config should be rejected")
+	}
+	if conf.MaxConn != 200 {
+		t.Errorf("MaxConn = %d after rejected reload, want 200", conf.MaxConn)
+	}
+
+	writeConf(`{"MaxConn": `)
+	if err := conf.Reload(); err == nil {
+		t.Error("broken json should be rejected")
+	}
+}
+
+/*
+	分层配置单元测试
+	go test -v ./ztest -run=TestConfigLayers
+*/
+
+func TestConfigLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	confPath := filepath.Join(dir, "server.yaml")
+	content := "# server config\nName: \"yaml server\"\nTcpPort: 8001\nMaxConn: 50\n"
+	if err := ioutil.WriteFile(confPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	//环境变量覆盖配置文件，命令行参数覆盖环境变量
+	os.Setenv("SERVER_TCPPORT", "8002")
+	os.Setenv("SERVER_MAXCONN", "60")
+	defer os.Unsetenv("SERVER_TCPPORT")
+	defer os.Unsetenv("SERVER_MAXCONN")
+
+	conf := utils.NewGlobalObj()
+	if err := conf.Load([]string{"-conf", confPath, "-MaxConn=70", "-LogDebugClose"}); err != nil {
+		t.Fatal("load err: ", err)
+	}
+	if conf.Name != "yaml server" {
+		t.Errorf("Name = %q, want value from file", conf.Name)
+	}
+	if conf.TcpPort != 8002 {
+		t.Errorf("TcpPort = %d, want value from env", conf.TcpPort)
+	}
+	if conf.MaxConn != 70 || conf.LogDebugClose != true {
+		t.Errorf("MaxConn = %d, LogDebugClose = %v, want values from flags", conf.MaxConn, conf.LogDebugClose)
+	}
+	t.Log(conf.Dump())
+
+	//未知配置项给出提示
+	if err := ioutil.WriteFile(confPath, []byte("MaxConns: 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = utils.NewGlobalObj().Load([]string{"-conf", confPath})
+	if err == nil || !strings.Contains(err.Error(), "did you mean MaxConn") {
+		t.Errorf("unknown key err = %v", err)
+	}
+
+	//显式指定的配置文件不存在时报错
+	if err := utils.NewGlobalObj().Load([]string{"-conf", filepath.Join(dir, "missing.json")}); err == nil {
+		t.Error("missing explicit config file should be an error")
+	}
+}
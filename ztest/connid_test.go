@@ -0,0 +1,68 @@
+package ztest
+
+import (
+	"server/znet"
+	"testing"
+	"time"
+)
+
+/*
+	连接ID生成器
+	go test -v ./ztest -run=TestConnID
+*/
+
+func TestSeqConnID(t *testing.T) {
+	s := znet.NewServer()
+	mgr := s.GetConnMgr()
+	gen := znet.NewSeqConnIDGenerator(3)
+
+	//0、2仍在使用中，回绕之后跳过
+	znet.NewConn(s, nil, 0, s.GetMsgHandler())
+	znet.NewConn(s, nil, 2, s.GetMsgHandler())
+	want := []uint64{1, 3, 1, 3}
+	for i, w := range want {
+		id, err := gen.NextConnID(mgr)
+		if err != nil || id != w {
+			t.Fatalf("id[%d] = %d %v, want %d", i, id, err, w)
+		}
+	}
+
+	//全部ID都在使用中
+	znet.NewConn(s, nil, 1, s.GetMsgHandler())
+	znet.NewConn(s, nil, 3, s.GetMsgHandler())
+	if _, err := gen.NextConnID(mgr); err == nil {
+		t.Error("expect error when all conn ids are in use")
+	}
+}
+
+func TestSnowflakeConnID(t *testing.T) {
+	if _, err := znet.NewSnowflakeConnIDGenerator(znet.SnowflakeMaxNodeID + 1); err == nil {
+		t.Error("expect error for node id out of range")
+	}
+
+	gen1, _ := znet.NewSnowflakeConnIDGenerator(1)
+	gen2, _ := znet.NewSnowflakeConnIDGenerator(2)
+	mgr := znet.NewConnMgr()
+	seen := make(map[uint64]bool)
+	var last uint64
+	start := time.Now().Add(-time.Millisecond)
+	for i := 0; i < 10000; i++ {
+		for _, gen := range []*znet.SnowflakeConnIDGenerator{gen1, gen2} {
+			id, _ := gen.NextConnID(mgr)
+			if seen[id] {
+				t.Fatalf("duplicate id %d", id)
+			}
+			seen[id] = true
+		}
+		id, _ := gen1.NextConnID(mgr)
+		if id <= last {
+			t.Fatalf("id %d not increasing after %d", id, last)
+		}
+		last = id
+	}
+
+	nodeID, created := znet.ParseSnowflakeConnID(last)
+	if nodeID != 1 || created.Before(start) {
+		t.Errorf("parse = node %d created %v, want node 1 after %v", nodeID, created, start)
+	}
+}
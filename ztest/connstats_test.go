@@ -0,0 +1,82 @@
+package ztest
+
+import (
+	"encoding/binary"
+	"server/utils"
+	"server/ziface"
+	"server/znet"
+	"testing"
+	"time"
+)
+
+/*
+	连接统计和RTT测量
+	go test -v ./ztest -run=TestConnStats
+*/
+
+func TestConnStats(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9871
+	conf.PingInterval = 50
+
+	s := znet.NewServer(znet.WithConfig(conf))
+	s.AddRouter(1, &EchoNameRouter{name: "s"})
+	s.Start()
+	defer s.Stop()
+
+	conn := dialRetry(t, "127.0.0.1:9871")
+	defer conn.Close()
+	sendMsg(t, conn, 1, []byte("hello"))
+	//服务端没有发送过的ping对应的pong不计入RTT
+	forged := make([]byte, 8)
+	binary.LittleEndian.PutUint64(forged, 1)
+	sendMsg(t, conn, znet.MsgIDPong, forged)
+
+	//客户端的ping原样回复pong，服务端的ping延迟30ms回复pong
+	sendMsg(t, conn, znet.MsgIDPing, []byte("12345678"))
+	gotEcho, gotPong, pings := false, false, 0
+	for !gotEcho || !gotPong || pings < 3 {
+		msg := recvMsg(t, conn)
+		switch msg.GetMsgID() {
+		case 1:
+			gotEcho = string(msg.GetData()) == "s:hello"
+		case znet.MsgIDPong:
+			gotPong = string(msg.GetData()) == "12345678"
+		case znet.MsgIDPing:
+			pings++
+			time.Sleep(30 * time.Millisecond)
+			sendMsg(t, conn, znet.MsgIDPong, msg.GetData())
+		default:
+			t.Fatalf("unexpected msgId = %d", msg.GetMsgID())
+		}
+	}
+
+	var stats ziface.ConnStats
+	waitFor(t, "rtt measured", func() bool {
+		conns := s.GetConnMgr().GetAllConns()
+		if len(conns) != 1 {
+			return false
+		}
+		stats = conns[0].Stats()
+		return stats.RTT > 0
+	})
+	if stats.RTT < 30*time.Millisecond || stats.RTT > time.Second {
+		t.Errorf("rtt = %v, want about 30ms", stats.RTT)
+	}
+	//系统消息只计入字节数，不计入消息数
+	if stats.MsgsIn != 1 || stats.MsgsOut != 1 {
+		t.Errorf("msgs in/out = %d/%d, want 1/1", stats.MsgsIn, stats.MsgsOut)
+	}
+	if stats.BytesIn < 8+5+8+8 || stats.BytesOut < 8+7+8+8 {
+		t.Errorf("bytes in/out = %d/%d too small", stats.BytesIn, stats.BytesOut)
+	}
+	if stats.LastReadTime.IsZero() || stats.LastWriteTime.IsZero() || stats.Age <= 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	total := s.GetConnMgr().Stats()
+	if total.Conns != 1 || total.MsgsIn != 1 || total.MaxRTT < 30*time.Millisecond || total.AvgRTT != total.MaxRTT {
+		t.Errorf("conn mgr stats = %+v", total)
+	}
+}
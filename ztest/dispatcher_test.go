@@ -0,0 +1,92 @@
+package ztest
+
+import (
+	"server/utils"
+	"server/ziface"
+	"server/znet"
+	"testing"
+	"time"
+)
+
+/*
+	消息分配策略单元测试
+	go test -v ./ztest -run=TestDispatcher
+*/
+
+type fakeRequest struct {
+	conn  ziface.IConn
+	msgID uint32
+	data  []byte
+}
+
+func (r *fakeRequest) GetConn() ziface.IConn { return r.conn }
+func (r *fakeRequest) GetData() []byte       { return r.data }
+func (r *fakeRequest) GetMsgID() uint32      { return r.msgID }
+
+type fakePool []int
+
+func (p fakePool) Size() int                 { return len(p) }
+func (p fakePool) QueueLen(workerID int) int { return p[workerID] }
+
+func TestDispatcher(t *testing.T) {
+	s := znet.NewServer()
+	conn1 := znet.NewConn(s, nil, 1, s.GetMsgHandler())
+	conn2 := znet.NewConn(s, nil, 6, s.GetMsgHandler())
+	pool := fakePool{3, 0, 2, 5}
+
+	if id := (&znet.ConnIDDispatcher{}).Dispatch(&fakeRequest{conn: conn2}, pool); id != 2 {
+		t.Errorf("ConnIDDispatcher = %d, want 2", id)
+	}
+
+	//相同pid的两个连接分配到同一个worker
+	conn1.SetProperty("pid", int32(100))
+	conn2.SetProperty("pid", int32(100))
+	d := znet.NewPropertyDispatcher("pid")
+	if d.Dispatch(&fakeRequest{conn: conn1}, pool) != d.Dispatch(&fakeRequest{conn: conn2}, pool) {
+		t.Error("PropertyDispatcher should dispatch same pid to the same worker")
+	}
+
+	if id := (&znet.LeastLoadedDispatcher{}).Dispatch(&fakeRequest{conn: conn1}, pool); id != 1 {
+		t.Errorf("LeastLoadedDispatcher = %d, want 1", id)
+	}
+
+	if id := (&znet.LogicLoopDispatcher{}).Dispatch(&fakeRequest{conn: conn1}, pool); id != znet.LogicLoopWorkerID {
+		t.Errorf("LogicLoopDispatcher = %d, want %d", id, znet.LogicLoopWorkerID)
+	}
+}
+
+//返回固定workerID的分配策略
+type fixedDispatcher int
+
+func (d fixedDispatcher) Dispatch(request ziface.IRequest, pool ziface.IWorkerPool) int {
+	return int(d)
+}
+
+type doneRouter struct {
+	znet.BaseRouter
+	done chan uint32
+}
+
+func (r *doneRouter) Handle(request ziface.IRequest) {
+	r.done <- request.GetMsgID()
+}
+
+//自定义策略返回LogicLoopWorkerID以外的负数时归一化，不能panic
+func TestDispatcherNegativeID(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.WorkerPoolSize = 3
+	s := znet.NewServer(znet.WithConfig(conf))
+	done := make(chan uint32, 1)
+	s.AddRouter(1, &doneRouter{done: done})
+	handler := s.GetMsgHandler()
+	handler.SetDispatcher(fixedDispatcher(-7))
+	handler.StartWorkerPool()
+	defer handler.StopWorkerPool()
+
+	handler.SendMsgToTaskQueue(&fakeRequest{conn: znet.NewConn(s, nil, 1, handler), msgID: 1})
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("request with negative workerID not handled")
+	}
+}
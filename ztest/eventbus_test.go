@@ -0,0 +1,137 @@
+package ztest
+
+import (
+	"encoding/binary"
+	"server/utils"
+	"server/ziface"
+	"server/zlog"
+	"server/znet"
+	"sync"
+	"testing"
+)
+
+/*
+	事件总线：连接生命周期和消息收发事件
+	go test -v ./ztest -run=TestEventBus
+*/
+
+//data为panic时panic，否则回显
+type PanicRouter struct {
+	znet.BaseRouter
+}
+
+func (r *PanicRouter) Handle(request ziface.IRequest) {
+	if string(request.GetData()) == "panic" {
+		panic("router panic")
+	}
+	_ = request.GetConn().SendMsg(request.GetMsgID(), request.GetData())
+}
+
+//记录收到的事件
+type eventRecorder struct {
+	lock   sync.Mutex
+	events []ziface.Event
+}
+
+func (r *eventRecorder) record(event *ziface.Event) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, *event)
+}
+
+func (r *eventRecorder) find(t ziface.EventType) []ziface.Event {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var found []ziface.Event
+	for _, event := range r.events {
+		if event.Type == t {
+			found = append(found, event)
+		}
+	}
+	return found
+}
+
+func TestEventBus(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9861
+	conf.WorkerPoolSize = 2
+
+	s := znet.NewServer(znet.WithConfig(conf))
+	s.AddRouter(1, &PanicRouter{})
+	all, panics := &eventRecorder{}, &eventRecorder{}
+	s.GetEventBus().Subscribe(all.record)
+	s.GetEventBus().Subscribe(panics.record, ziface.EventHandlerPanic)
+	s.Start()
+	defer s.Stop()
+
+	conn := dialRetry(t, "127.0.0.1:9861")
+	defer conn.Close()
+	if reply := sendAndRecv(t, conn, 1, []byte("hello")); string(reply.GetData()) != "hello" {
+		t.Fatalf("reply = %s, want hello", reply.GetData())
+	}
+	//业务panic之后连接和worker仍然可用
+	sendMsg(t, conn, 1, []byte("panic"))
+	if reply := sendAndRecv(t, conn, 1, []byte("again")); string(reply.GetData()) != "again" {
+		t.Fatalf("reply = %s, want again", reply.GetData())
+	}
+	waitFor(t, "handler panic event", func() bool { return len(panics.find(ziface.EventHandlerPanic)) == 1 })
+	if event := panics.find(ziface.EventHandlerPanic)[0]; event.MsgID != 1 || event.Panic != "router panic" || event.Stack == "" {
+		t.Errorf("panic event = %+v", event)
+	}
+	if len(panics.find(ziface.EventMsgReceived)) != 0 {
+		t.Error("subscriber got events of types it did not subscribe")
+	}
+
+	//超过MaxPacketSize的数据包解包失败，连接被关闭
+	head := make([]byte, 8)
+	binary.LittleEndian.PutUint32(head, conf.MaxPacketSize+1)
+	binary.LittleEndian.PutUint32(head[4:], 1)
+	if _, err := conn.Write(head); err != nil {
+		t.Fatal("write err: ", err)
+	}
+	waitFor(t, "conn stopped event", func() bool { return len(all.find(ziface.EventConnStopped)) == 1 })
+
+	expects := map[ziface.EventType]int{
+		ziface.EventConnAccepted: 1,
+		ziface.EventConnStarted:  1,
+		ziface.EventMsgReceived:  3,
+		ziface.EventMsgSent:      2,
+		ziface.EventDecodeError:  1,
+		ziface.EventHandlerPanic: 1,
+		ziface.EventConnStopped:  1,
+	}
+	for eventType, n := range expects {
+		if got := len(all.find(eventType)); got != n {
+			t.Errorf("%s events = %d, want %d", eventType, got, n)
+		}
+	}
+	for _, event := range all.find(ziface.EventConnStopped) {
+		if event.Conn == nil || event.Reason == "" || event.Time.IsZero() {
+			t.Errorf("stopped event = %+v", event)
+		}
+	}
+}
+
+func TestEventBusUnsubscribe(t *testing.T) {
+	bus := znet.NewEventBus(zlog.StdLog)
+	if bus.HasSubscribers(ziface.EventMsgSent) {
+		t.Fatal("empty bus has subscribers")
+	}
+
+	called := 0
+	id := bus.Subscribe(func(event *ziface.Event) { called++ }, ziface.EventMsgSent)
+	//订阅者panic不影响其他订阅者
+	bus.Subscribe(func(event *ziface.Event) { panic("subscriber panic") })
+	bus.Publish(&ziface.Event{Type: ziface.EventMsgSent})
+	bus.Publish(&ziface.Event{Type: ziface.EventMsgReceived})
+	if called != 1 {
+		t.Fatalf("called = %d, want 1", called)
+	}
+
+	bus.Unsubscribe(id)
+	bus.Publish(&ziface.Event{Type: ziface.EventMsgSent})
+	if called != 1 {
+		t.Fatalf("called = %d after unsubscribe, want 1", called)
+	}
+}
@@ -0,0 +1,166 @@
+package ztest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"server/utils"
+	"server/ziface"
+	"server/znet"
+	"testing"
+	"time"
+)
+
+/*
+	大消息分片和重组
+	go test -v ./ztest -run=TestFragment
+*/
+
+//从帧中取出数据部分
+func frameData(frame []byte) []byte {
+	return frame[8:]
+}
+
+func TestFragmentReassembler(t *testing.T) {
+	dp := znet.NewDataPack()
+	big1 := bytes.Repeat([]byte("a"), 100)
+	big2 := bytes.Repeat([]byte("b"), 50)
+	frames1, err := znet.PackFragments(dp, 1, big1, 32, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames2, _ := znet.PackFragments(dp, 2, big2, 32, 2)
+	if len(frames1) != 5 || len(frames2) != 3 {
+		t.Fatalf("fragments = %d, %d, want 5, 3", len(frames1), len(frames2))
+	}
+	//不超过MaxPacketSize的消息不分片
+	if frames, _ := znet.PackFragments(dp, 3, []byte("small"), 32, 3); len(frames) != 1 {
+		t.Fatalf("small msg fragments = %d, want 1", len(frames))
+	}
+
+	//两条消息的分片交错到达
+	r := znet.NewReassembler(1024, 1024, time.Second)
+	var got [][]byte
+	for _, frame := range [][]byte{frames1[0], frames2[0], frames1[1], frames2[1], frames1[2], frames2[2], frames1[3], frames1[4]} {
+		msg, err := r.Add(frameData(frame))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg != nil {
+			got = append(got, msg.GetData())
+		}
+	}
+	if len(got) != 2 || !bytes.Equal(got[0], big2) || !bytes.Equal(got[1], big1) || r.Pending() != 0 {
+		t.Fatalf("reassembled %d msgs, pending %d", len(got), r.Pending())
+	}
+
+	//超过单条消息上限和内存上限
+	if _, err := znet.NewReassembler(64, 1024, time.Second).Add(frameData(frames1[0])); err == nil {
+		t.Error("msg larger than max msg size accepted")
+	}
+	//内存上限按实际收到的数据计算，第一个分片声明的总长度不占用内存上限
+	r = znet.NewReassembler(1024, 70, time.Second)
+	for _, frame := range [][]byte{frames1[0], frames2[0], frames1[1]} {
+		if _, err := r.Add(frameData(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.Add(frameData(frames2[1])); err == nil {
+		t.Error("fragment buffer over limit accepted")
+	}
+
+	//超时未收齐的消息被丢弃
+	if expired := r.Expire(time.Now().Add(2 * time.Second)); len(expired) != 2 || r.Pending() != 0 {
+		t.Errorf("expired = %v, pending %d", expired, r.Pending())
+	}
+}
+
+func TestFragmentServer(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9891
+	conf.MaxPacketSize = 64
+	conf.MaxMsgSize = 1024
+	conf.MaxFragmentBuffer = 2048
+
+	s := znet.NewServer(znet.WithConfig(conf))
+	s.AddRouter(1, &EchoNameRouter{name: "s"})
+	s.Start()
+	defer s.Stop()
+
+	conn := dialRetry(t, "127.0.0.1:9891")
+	defer conn.Close()
+	dp := znet.NewDataPackWithConfig(conf)
+	big := bytes.Repeat([]byte("0123456789"), 30)
+	frames, _ := znet.PackFragments(dp, 1, big, conf.MaxPacketSize, 7)
+	for _, frame := range frames {
+		if _, err := conn.Write(frame); err != nil {
+			t.Fatal("write err: ", err)
+		}
+	}
+
+	//回复同样超过MaxPacketSize，分片下发
+	r := znet.NewReassembler(conf.MaxMsgSize, conf.MaxFragmentBuffer, time.Second)
+	for {
+		msg := recvMsg(t, conn)
+		if msg.GetMsgID() != znet.MsgIDFragment {
+			t.Fatalf("got msgId = %d, want fragment", msg.GetMsgID())
+		}
+		if int(msg.GetDataLen()) > int(conf.MaxPacketSize) {
+			t.Fatalf("fragment len %d exceeds MaxPacketSize", msg.GetDataLen())
+		}
+		full, err := r.Add(msg.GetData())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if full != nil {
+			if full.GetMsgID() != 1 || string(full.GetData()) != "s:"+string(big) {
+				t.Fatalf("reply msgId = %d len %d", full.GetMsgID(), full.GetDataLen())
+			}
+			break
+		}
+	}
+
+	//超过MaxMsgSize的分片消息断开连接
+	fragment := make([]byte, 16)
+	binary.LittleEndian.PutUint32(fragment, 8)
+	binary.LittleEndian.PutUint32(fragment[4:], 1)
+	binary.LittleEndian.PutUint32(fragment[8:], conf.MaxMsgSize+1)
+	sendMsg(t, conn, znet.MsgIDFragment, fragment)
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read after oversized msg err = %v, want EOF", err)
+	}
+}
+
+func TestFragmentExpire(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9892
+	conf.MaxPacketSize = 64
+	conf.MaxMsgSize = 1024
+	conf.FragmentTimeout = 100
+
+	s := znet.NewServer(znet.WithConfig(conf))
+	expired := make(chan uint32, 1)
+	s.GetEventBus().Subscribe(func(e *ziface.Event) { expired <- e.MsgID }, ziface.EventDecodeError)
+	s.Start()
+	defer s.Stop()
+
+	conn := dialRetry(t, "127.0.0.1:9892")
+	defer conn.Close()
+	frames, _ := znet.PackFragments(znet.NewDataPackWithConfig(conf), 3, make([]byte, 200), conf.MaxPacketSize, 1)
+
+	//只发送第一个分片后不再发送任何数据，超时后仍然被丢弃
+	if _, err := conn.Write(frames[0]); err != nil {
+		t.Fatal("write err: ", err)
+	}
+	select {
+	case msgID := <-expired:
+		if msgID != 3 {
+			t.Errorf("expired msgId = %d, want 3", msgID)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("stalled fragment not expired")
+	}
+}
@@ -0,0 +1,207 @@
+package ztest
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"server/utils"
+	"server/ziface"
+	"server/znet"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+	网关模式：网关进程持有客户端连接，按msgID区间转发给独立进程中的后端节点
+	go test -v ./ztest -run=TestGateway
+*/
+
+//后端节点子进程的监听地址和名称，由TestGateway通过环境变量传入
+const (
+	backendAddrEnv = "ZTEST_BACKEND_ADDR"
+	backendNameEnv = "ZTEST_BACKEND_NAME"
+)
+
+//后端节点子进程踢掉客户端连接
+type KickRouter struct {
+	znet.BaseRouter
+}
+
+func (r *KickRouter) Handle(request ziface.IRequest) {
+	request.GetConn().Stop()
+}
+
+//作为后端节点子进程运行，单独执行时直接跳过
+func TestGatewayBackendProcess(t *testing.T) {
+	addr := os.Getenv(backendAddrEnv)
+	if addr == "" {
+		t.Skip("only run as backend process of TestGateway")
+	}
+
+	b := znet.NewBackend(addr, utils.NewGlobalObj())
+	b.AddRouter(1001, &EchoNameRouter{name: os.Getenv(backendNameEnv)})
+	b.AddRouter(1002, &LoginRouter{})
+	b.AddRouter(1003, &KickRouter{})
+	if err := b.Start(); err != nil {
+		t.Fatal("backend start err ", err)
+	}
+	defer b.Stop()
+
+	//父进程结束测试时杀掉子进程，这里只是防止子进程残留
+	time.Sleep(30 * time.Second)
+}
+
+//启动后端节点子进程
+func startBackendProcess(t *testing.T, addr, name string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestGatewayBackendProcess$")
+	cmd.Env = append(os.Environ(), backendAddrEnv+"="+addr, backendNameEnv+"="+name)
+	if err := cmd.Start(); err != nil {
+		t.Fatal("start backend process err ", err)
+	}
+	return cmd
+}
+
+func TestGateway(t *testing.T) {
+	for _, backend := range []struct{ addr, name string }{
+		{"127.0.0.1:9912", "A"},
+		{"127.0.0.1:9913", "B"},
+	} {
+		cmd := startBackendProcess(t, backend.addr, backend.name)
+		defer func() {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		}()
+	}
+
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9911
+	conf.RequireAuth = true
+	conf.UnauthenticatedMsgID = 99
+
+	s := znet.NewServer(znet.WithConfig(conf))
+	s.AddRouter(1, &EchoNameRouter{name: "gw"})
+	s.GetMsgHandler().SetLoginMsgIDs(1002)
+	gw := znet.NewGateway(s)
+	gw.AddRoute(1000, 1999, "127.0.0.1:9912", "127.0.0.1:9913")
+	gw.Start()
+	defer gw.Stop()
+	s.Start()
+	defer s.Stop()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !gw.Ready() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for backends")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	//两个客户端按ConnID分到不同的后端节点
+	names := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		conn := dialRetry(t, "127.0.0.1:9911")
+		defer conn.Close()
+
+		//认证在网关上检查，登录之前转发的消息被拒绝
+		reply := sendAndRecv(t, conn, 1001, []byte("x"))
+		if reply.GetMsgID() != 99 || binary.LittleEndian.Uint32(reply.GetData()) != 1001 {
+			t.Fatalf("reply msgId = %d data %v, want rejected msgId 1001", reply.GetMsgID(), reply.GetData())
+		}
+		//后端节点处理登录，通过网关标记连接已认证
+		if reply := sendAndRecv(t, conn, 1002, []byte("secret")); string(reply.GetData()) != "ok" {
+			t.Fatalf("login reply = %s, want ok", reply.GetData())
+		}
+		//区间之外的msgID由网关本地处理
+		if reply := sendAndRecv(t, conn, 1, []byte("x")); string(reply.GetData()) != "gw:x" {
+			t.Fatalf("local reply = %s, want gw:x", reply.GetData())
+		}
+		reply = sendAndRecv(t, conn, 1001, []byte("hello"))
+		parts := strings.SplitN(string(reply.GetData()), ":", 2)
+		if reply.GetMsgID() != 1001 || len(parts) != 2 || parts[1] != "hello" {
+			t.Fatalf("backend reply msgId = %d data %s", reply.GetMsgID(), reply.GetData())
+		}
+		names[parts[0]] = true
+
+		//同一个连接总是转发到同一个后端节点
+		if reply := sendAndRecv(t, conn, 1001, []byte("again")); string(reply.GetData()) != parts[0]+":again" {
+			t.Fatalf("backend reply = %s, want %s:again", reply.GetData(), parts[0])
+		}
+	}
+	if !names["A"] || !names["B"] {
+		t.Fatalf("backends = %v, want both A and B", names)
+	}
+
+	//后端节点通过网关断开客户端连接
+	conn := dialRetry(t, "127.0.0.1:9911")
+	defer conn.Close()
+	if reply := sendAndRecv(t, conn, 1002, []byte("secret")); string(reply.GetData()) != "ok" {
+		t.Fatalf("login reply = %s, want ok", reply.GetData())
+	}
+	sendMsg(t, conn, 1003, nil)
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("conn not kicked by backend, err ", err)
+	}
+}
+
+//后端节点不再读取时，转发在写超时后断开内部连接并重连，不会一直阻塞
+func TestGatewayStalledBackend(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:9915")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var accepted int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			//只接受连接，从不读取
+			defer conn.Close()
+			atomic.AddInt32(&accepted, 1)
+		}
+	}()
+
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9914
+	conf.MaxPacketSize = 1 << 20
+
+	s := znet.NewServer(znet.WithConfig(conf))
+	gw := znet.NewGateway(s)
+	gw.AddRoute(1000, 1999, "127.0.0.1:9915")
+	gw.Start()
+	defer gw.Stop()
+	s.Start()
+	defer s.Stop()
+	waitFor(t, "backend connected", gw.Ready)
+
+	conn := dialRetry(t, "127.0.0.1:9914")
+	defer conn.Close()
+	data := make([]byte, 1<<20)
+	for i := 0; i < 64; i++ {
+		sendMsg(t, conn, 1000, data)
+	}
+
+	//转发阻塞期间查询连接状态不会被阻塞
+	start := time.Now()
+	gw.Ready()
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("Ready blocked %v by stalled write", d)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for atomic.LoadInt32(&accepted) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("stalled backend link not reconnected")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
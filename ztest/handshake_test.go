@@ -0,0 +1,112 @@
+package ztest
+
+import (
+	"io"
+	"net"
+	"server/utils"
+	"server/ziface"
+	"server/znet"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/*
+	连接握手：协议版本和能力位协商
+	go test -v ./ztest -run=TestHandshake
+*/
+
+//读取握手回复，被拒绝时确认服务端随后断开连接
+func recvHandshakeReply(t *testing.T, conn net.Conn) znet.HandshakeReply {
+	msg := recvMsg(t, conn)
+	if msg.GetMsgID() != znet.MsgIDHandshake {
+		t.Fatalf("got msgId = %d, want handshake reply", msg.GetMsgID())
+	}
+	reply, err := znet.DecodeHandshakeReply(msg.GetData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reply.Accepted {
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("read after rejected err = %v, want EOF", err)
+		}
+	}
+	return reply
+}
+
+func TestHandshake(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9895
+	conf.RequireHandshake = true
+	conf.HandshakeTimeout = 200
+	conf.ProtocolVersion = 3
+	conf.MinProtocolVersion = 2
+	conf.Capabilities = znet.CapCompression
+	conf.MaxMsgSize = 8192
+
+	var lock sync.Mutex
+	var started []ziface.Handshake
+	stopped := 0
+	s := znet.NewServer(znet.WithConfig(conf))
+	s.AddRouter(1, &EchoNameRouter{name: "s"})
+	s.SetOnConnStart(func(conn ziface.IConn) {
+		lock.Lock()
+		defer lock.Unlock()
+		started = append(started, conn.Handshake())
+	})
+	s.SetOnConnStop(func(conn ziface.IConn) {
+		lock.Lock()
+		defer lock.Unlock()
+		stopped++
+	})
+	s.Start()
+	defer s.Stop()
+
+	rejects := []struct {
+		name   string
+		send   func(conn net.Conn)
+		reason string
+	}{
+		{"timeout", func(conn net.Conn) {}, "timeout"},
+		{"not handshake", func(conn net.Conn) { sendMsg(t, conn, 1, []byte("hi")) }, "first msg must be handshake"},
+		{"bad magic", func(conn net.Conn) { sendMsg(t, conn, znet.MsgIDHandshake, make([]byte, 12)) }, "magic"},
+		{"old version", func(conn net.Conn) {
+			sendMsg(t, conn, znet.MsgIDHandshake, znet.EncodeHandshake(1, znet.CapFragment, "0.9"))
+		}, "protocol version 1 not supported"},
+		{"missing capability", func(conn net.Conn) {
+			sendMsg(t, conn, znet.MsgIDHandshake, znet.EncodeHandshake(2, znet.CapCompression, "1.0"))
+		}, "required capabilities"},
+	}
+	for _, c := range rejects {
+		conn := dialRetry(t, "127.0.0.1:9895")
+		c.send(conn)
+		reply := recvHandshakeReply(t, conn)
+		if reply.Accepted || reply.ProtocolVersion != 3 || !strings.Contains(reply.Reason, c.reason) {
+			t.Errorf("%s: reply = %+v, want rejected with %q", c.name, reply, c.reason)
+		}
+		conn.Close()
+	}
+
+	conn := dialRetry(t, "127.0.0.1:9895")
+	defer conn.Close()
+	sendMsg(t, conn, znet.MsgIDHandshake, znet.EncodeHandshake(2, znet.CapFragment|znet.CapCompression|znet.CapSequence, "1.2.3"))
+	reply := recvHandshakeReply(t, conn)
+	if !reply.Accepted || reply.Capabilities != znet.CapFragment|znet.CapCompression {
+		t.Fatalf("reply = %+v, want accepted with fragment and compression", reply)
+	}
+	if echo := sendAndRecv(t, conn, 1, []byte("hello")); string(echo.GetData()) != "s:hello" {
+		t.Fatalf("echo = %s", echo.GetData())
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	//被拒绝的连接不调用OnConnStart和OnConnStop
+	if len(started) != 1 || stopped != 0 {
+		t.Fatalf("started = %d, stopped = %d, want 1, 0", len(started), stopped)
+	}
+	h := started[0]
+	if h.ProtocolVersion != 2 || h.ClientBuild != "1.2.3" || !h.Has(znet.CapCompression) || h.Has(znet.CapSequence) {
+		t.Errorf("handshake = %+v", h)
+	}
+}
@@ -0,0 +1,84 @@
+package ztest
+
+import (
+	"io"
+	"server/utils"
+	"server/ziface"
+	"server/znet"
+	"strconv"
+	"testing"
+	"time"
+)
+
+/*
+	没有启动工作池时，同一个连接的消息按顺序处理，不同连接之间并行处理
+	go test -v ./ztest -run=TestMailbox
+*/
+
+//处理耗时递减的回显路由，并发处理时回复顺序会被打乱
+type SlowEchoRouter struct {
+	znet.BaseRouter
+	release chan struct{}
+}
+
+func (r *SlowEchoRouter) Handle(request ziface.IRequest) {
+	if string(request.GetData()) == "block" {
+		<-r.release
+	} else if n, err := strconv.Atoi(string(request.GetData())); err == nil {
+		time.Sleep(time.Duration(10-n) * time.Millisecond)
+	}
+	_ = request.GetConn().SendMsg(request.GetMsgID(), request.GetData())
+}
+
+func TestMailbox(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9831
+	conf.WorkerPoolSize = 0
+	conf.MaxMailboxLen = 4
+
+	router := &SlowEchoRouter{release: make(chan struct{})}
+	s := znet.NewServer(znet.WithConfig(conf))
+	s.AddRouter(1, router)
+	s.Start()
+	defer s.Stop()
+
+	//第一个连接阻塞在处理中
+	blocked := dialRetry(t, "127.0.0.1:9831")
+	defer blocked.Close()
+	dp := znet.NewDataPack()
+	msg, _ := dp.Pack(znet.NewMsgPackage(1, []byte("block")))
+	if _, err := blocked.Write(msg); err != nil {
+		t.Fatal("write err: ", err)
+	}
+
+	//第二个连接不受影响，并且按发送顺序收到回复
+	conn := dialRetry(t, "127.0.0.1:9831")
+	defer conn.Close()
+	for i := 0; i < 10; i++ {
+		msg, _ := dp.Pack(znet.NewMsgPackage(1, []byte(strconv.Itoa(i))))
+		if _, err := conn.Write(msg); err != nil {
+			t.Fatal("write err: ", err)
+		}
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for i := 0; i < 10; i++ {
+		headData := make([]byte, dp.GetHeadLen())
+		if _, err := io.ReadFull(conn, headData); err != nil {
+			t.Fatal("read head err: ", err)
+		}
+		reply, _ := dp.UnPack(headData)
+		body := make([]byte, reply.GetDataLen())
+		if _, err := io.ReadFull(conn, body); err != nil {
+			t.Fatal("read body err: ", err)
+		}
+		if string(body) != strconv.Itoa(i) {
+			t.Fatalf("reply %d = %s, out of order", i, body)
+		}
+	}
+
+	close(router.release)
+	if reply := sendAndRecv(t, blocked, 1, []byte("0")); string(reply.GetData()) != "block" {
+		t.Errorf("blocked conn reply = %s, want block", reply.GetData())
+	}
+}
@@ -0,0 +1,98 @@
+package ztest
+
+import (
+	"io"
+	"net"
+	"server/utils"
+	"server/ziface"
+	"server/znet"
+	"testing"
+	"time"
+)
+
+/*
+	同一进程中运行多个互相独立的Server
+	go test -v ./ztest -run=TestMultiServer
+*/
+
+//回显路由，回复时带上Server的名称
+type EchoNameRouter struct {
+	znet.BaseRouter
+	name string
+}
+
+func (r *EchoNameRouter) Handle(request ziface.IRequest) {
+	_ = request.GetConn().SendMsg(request.GetMsgID(), []byte(r.name+":"+string(request.GetData())))
+}
+
+//连接服务器，服务器异步启动，所以需要重试
+func dialRetry(t *testing.T, addr string) net.Conn {
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			return conn
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("dial ", addr, " failed")
+	return nil
+}
+
+//发送一条消息并读取一条回复
+func sendAndRecv(t *testing.T, conn net.Conn, msgID uint32, data []byte) ziface.IMsg {
+	dp := znet.NewDataPack()
+	msg, _ := dp.Pack(znet.NewMsgPackage(msgID, data))
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal("write err: ", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	headData := make([]byte, dp.GetHeadLen())
+	if _, err := io.ReadFull(conn, headData); err != nil {
+		t.Fatal("read head err: ", err)
+	}
+	reply, err := dp.UnPack(headData)
+	if err != nil {
+		t.Fatal("unpack err: ", err)
+	}
+	body := make([]byte, reply.GetDataLen())
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Fatal("read body err: ", err)
+	}
+	reply.SetData(body)
+	return reply
+}
+
+func TestMultiServer(t *testing.T) {
+	gatewayConf := utils.NewGlobalObj()
+	gatewayConf.Name = "gateway"
+	gatewayConf.Host = "127.0.0.1"
+	gatewayConf.TcpPort = 9811
+
+	gameConf := utils.NewGlobalObj()
+	gameConf.Name = "game"
+	gameConf.Host = "127.0.0.1"
+	gameConf.TcpPort = 9812
+	gameConf.WorkerPoolSize = 0
+
+	gateway := znet.NewServer(znet.WithConfig(gatewayConf))
+	gateway.AddRouter(1, &EchoNameRouter{name: "gateway"})
+	game := znet.NewServer(znet.WithConfig(gameConf))
+	game.AddRouter(1, &EchoNameRouter{name: "game"})
+
+	gateway.Start()
+	defer gateway.Stop()
+	game.Start()
+	defer game.Stop()
+
+	gatewayConn := dialRetry(t, "127.0.0.1:9811")
+	defer gatewayConn.Close()
+	gameConn := dialRetry(t, "127.0.0.1:9812")
+	defer gameConn.Close()
+
+	if reply := sendAndRecv(t, gatewayConn, 1, []byte("hi")); string(reply.GetData()) != "gateway:hi" {
+		t.Errorf("gateway reply = %q", reply.GetData())
+	}
+	if reply := sendAndRecv(t, gameConn, 1, []byte("hi")); string(reply.GetData()) != "game:hi" {
+		t.Errorf("game reply = %q", reply.GetData())
+	}
+}
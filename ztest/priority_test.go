@@ -0,0 +1,97 @@
+package ztest
+
+import (
+	"server/utils"
+	"server/ziface"
+	"server/znet"
+	"sync"
+	"testing"
+)
+
+/*
+	消息优先级：高优先级先处理，低优先级不会被饿死，同一优先级内保持顺序
+	go test -v ./ztest -run=TestPriority
+*/
+
+//记录处理顺序的路由，第一条消息阻塞直到release被关闭
+type RecordRouter struct {
+	znet.BaseRouter
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+	lock    sync.Mutex
+	order   []string
+	done    chan struct{}
+	total   int
+}
+
+func (r *RecordRouter) Handle(request ziface.IRequest) {
+	r.once.Do(func() {
+		close(r.started)
+		<-r.release
+	})
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.order = append(r.order, string(request.GetData()))
+	if len(r.order) == r.total {
+		close(r.done)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.WorkerPoolSize = 1
+	conf.MaxWorkerTaskLen = 64
+
+	s := znet.NewServer(znet.WithConfig(conf))
+	router := &RecordRouter{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+		done:    make(chan struct{}),
+		total:   31,
+	}
+	s.AddRouter(2, router)
+	s.AddRouter(3, router)
+	handler := s.GetMsgHandler()
+	handler.SetMsgPriority(3, znet.PriorityHigh)
+	handler.SetMsgPriority(2, znet.PriorityLow)
+	handler.StartWorkerPool()
+	defer handler.StopWorkerPool()
+
+	conn := znet.NewConn(s, nil, 1, handler)
+	send := func(msgID uint32, data string) {
+		handler.SendMsgToTaskQueue(&fakeRequest{conn: conn, msgID: msgID, data: []byte(data)})
+	}
+	//第一条消息阻塞worker，其余消息在队列中排队
+	send(2, "block")
+	<-router.started
+	for i := 0; i < 10; i++ {
+		send(2, "chat"+string(rune('0'+i)))
+	}
+	for i := 0; i < 20; i++ {
+		send(3, "move")
+	}
+	close(router.release)
+	<-router.done
+
+	order := router.order[1:]
+	//高优先级先处理，低优先级最多连续跳过8次
+	for i := 0; i < 8; i++ {
+		if order[i] != "move" {
+			t.Fatalf("order[%d] = %s, want move, order = %v", i, order[i], order)
+		}
+	}
+	if order[8] != "chat0" {
+		t.Errorf("chat starved, order = %v", order)
+	}
+	//同一优先级内保持顺序
+	next := 0
+	for _, data := range order {
+		if data != "move" {
+			if data != "chat"+string(rune('0'+next)) {
+				t.Fatalf("chat out of order, order = %v", order)
+			}
+			next++
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package ztest
+
+import (
+	"net"
+	"server/ziface"
+	"server/znet"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+	连接属性：带类型读取、有效期、变更Hook，以及连接的Context
+	go test -v ./ztest -run=TestConnProperty
+*/
+
+func TestConnProperty(t *testing.T) {
+	type change struct {
+		key              string
+		oldValue, newVal interface{}
+	}
+	var lock sync.Mutex
+	var changes []change
+	s := znet.NewServer()
+	s.SetOnPropertyChange(func(conn ziface.IConn, key string, oldValue, newValue interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		changes = append(changes, change{key, oldValue, newValue})
+	})
+	//Stop需要关闭真实的socket
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	tcpConn, err := listener.AcceptTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := znet.NewConn(s, tcpConn, 1, s.GetMsgHandler())
+
+	conn.SetProperty("pid", int32(100))
+	if pid, err := conn.GetPropertyInt32("pid"); err != nil || pid != 100 {
+		t.Errorf("GetPropertyInt32 = %d %v, want 100", pid, err)
+	}
+	if _, err := conn.GetPropertyString("pid"); err == nil {
+		t.Error("GetPropertyString on int32 should return error")
+	}
+	if _, err := conn.GetPropertyInt32("missing"); err == nil {
+		t.Error("GetPropertyInt32 on missing property should return error")
+	}
+
+	//过期之后自动删除，并触发变更Hook
+	conn.SetPropertyWithTTL("token", "abc", 30*time.Millisecond)
+	if token, _ := conn.GetPropertyString("token"); token != "abc" {
+		t.Errorf("token = %q, want abc", token)
+	}
+	waitFor(t, "property expired", func() bool {
+		_, err := conn.GetProperty("token")
+		return err != nil
+	})
+
+	//重新设置之后之前的有效期不再生效
+	conn.SetPropertyWithTTL("name", "a", 30*time.Millisecond)
+	conn.SetProperty("name", "b")
+	time.Sleep(60 * time.Millisecond)
+	if name, err := conn.GetPropertyString("name"); err != nil || name != "b" {
+		t.Errorf("name = %q %v, want b", name, err)
+	}
+	conn.DelProperty("name")
+
+	lock.Lock()
+	want := []change{
+		{"pid", nil, int32(100)},
+		{"token", nil, "abc"},
+		{"token", "abc", nil},
+		{"name", nil, "a"},
+		{"name", "a", "b"},
+		{"name", "b", nil},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("changes[%d] = %v, want %v", i, changes[i], want[i])
+		}
+	}
+	lock.Unlock()
+
+	//连接停止时Context被取消
+	select {
+	case <-conn.Context().Done():
+		t.Fatal("context done before Stop")
+	default:
+	}
+	conn.Stop()
+	select {
+	case <-conn.Context().Done():
+	case <-time.After(time.Second):
+		t.Error("context not done after Stop")
+	}
+}
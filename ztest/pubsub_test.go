@@ -0,0 +1,197 @@
+package ztest
+
+import (
+	"net"
+	"server/utils"
+	"server/znet"
+	"server/zpubsub"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+	发布订阅总线：进程内的LocalBus，以及经Broker在多个节点之间分发的ClusterBus
+	go test -v ./ztest -run=TestPubSub
+*/
+
+//记录收到的消息
+type pubsubRecorder struct {
+	lock sync.Mutex
+	msgs []string
+}
+
+func (r *pubsubRecorder) handle(topic string, data []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.msgs = append(r.msgs, topic+"="+string(data))
+}
+
+func (r *pubsubRecorder) has(msg string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, m := range r.msgs {
+		if m == msg {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *pubsubRecorder) count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.msgs)
+}
+
+func TestPubSubMatch(t *testing.T) {
+	cases := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"world.chat", "world.chat", true},
+		{"world.chat", "world.chats", false},
+		{"world.*", "world.chat", true},
+		{"world.*", "world", false},
+		{"world.*", "worlds.chat", false},
+		{"*", "system.announce", true},
+	}
+	for _, c := range cases {
+		if got := zpubsub.Match(c.pattern, c.topic); got != c.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", c.pattern, c.topic, got, c.want)
+		}
+	}
+}
+
+func TestPubSubLocal(t *testing.T) {
+	bus := zpubsub.NewLocalBus()
+	exact := &pubsubRecorder{}
+	wildcard := &pubsubRecorder{}
+	exactID := bus.Subscribe("world.chat", exact.handle)
+	bus.Subscribe("world.*", wildcard.handle)
+
+	bus.Publish("world.chat", []byte("hi"))
+	bus.Publish("world.trade", []byte("sell"))
+	if !exact.has("world.chat=hi") || exact.count() != 1 {
+		t.Fatal("exact subscriber got ", exact.msgs)
+	}
+	if !wildcard.has("world.chat=hi") || !wildcard.has("world.trade=sell") {
+		t.Fatal("wildcard subscriber got ", wildcard.msgs)
+	}
+
+	bus.Unsubscribe(exactID)
+	bus.Publish("world.chat", []byte("again"))
+	if exact.count() != 1 {
+		t.Fatal("unsubscribed handler still called ", exact.msgs)
+	}
+}
+
+func TestPubSubCluster(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9925
+
+	broker := zpubsub.NewBroker(znet.WithConfig(conf))
+	broker.Start()
+
+	nodeA := zpubsub.Connect("127.0.0.1:9925", conf)
+	defer nodeA.Close()
+	nodeB := zpubsub.Connect("127.0.0.1:9925", conf)
+	defer nodeB.Close()
+	waitFor(t, "nodes connected", func() bool { return nodeA.Ready() && nodeB.Ready() })
+
+	chatA := &pubsubRecorder{}
+	chatB := &pubsubRecorder{}
+	allB := &pubsubRecorder{}
+	nodeA.Subscribe("world.chat", chatA.handle)
+	nodeB.Subscribe("world.chat", chatB.handle)
+	nodeB.Subscribe("*", allB.handle)
+
+	//不同节点的订阅和发布之间没有先后顺序，重复发布直到对方收到
+	waitFor(t, "chat delivered to both nodes", func() bool {
+		if err := nodeA.Publish("world.chat", []byte("hello")); err != nil {
+			t.Fatal("publish err ", err)
+		}
+		return chatA.has("world.chat=hello") && chatB.has("world.chat=hello") && allB.has("world.chat=hello")
+	})
+
+	//一个节点的多个订阅都匹配时，每个订阅都收到一次
+	if err := nodeB.Publish("system.announce", []byte("maintenance")); err != nil {
+		t.Fatal("publish err ", err)
+	}
+	waitFor(t, "announce delivered", func() bool { return allB.has("system.announce=maintenance") })
+	if chatA.has("system.announce=maintenance") {
+		t.Fatal("unmatched topic delivered")
+	}
+
+	//Broker重启后节点自动重连并恢复订阅
+	broker.Stop()
+	waitFor(t, "nodes disconnected", func() bool { return !nodeA.Ready() && !nodeB.Ready() })
+	if err := nodeA.Publish("world.chat", []byte("lost")); err == nil {
+		t.Fatal("publish without broker succeeded")
+	}
+
+	broker = zpubsub.NewBroker(znet.WithConfig(conf))
+	broker.Start()
+	defer broker.Stop()
+	waitFor(t, "chat delivered after broker restart", func() bool {
+		if !nodeB.Ready() {
+			return false
+		}
+		nodeB.Publish("world.chat", []byte("back"))
+		return chatA.has("world.chat=back") && chatB.has("world.chat=back")
+	})
+}
+
+//Broker不读取时发布在写超时后返回error并重连，期间查询连接状态不被阻塞
+func TestPubSubStalledBroker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:9926")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var accepted int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			//只接受连接，从不读取
+			defer conn.Close()
+			atomic.AddInt32(&accepted, 1)
+		}
+	}()
+
+	conf := utils.NewGlobalObj()
+	conf.MaxPacketSize = 1 << 20
+	bus := zpubsub.Connect("127.0.0.1:9926", conf)
+	defer bus.Close()
+	waitFor(t, "broker connected", bus.Ready)
+
+	published := make(chan error, 1)
+	go func() {
+		data := make([]byte, 1<<19)
+		for {
+			if err := bus.Publish("world.chat", data); err != nil {
+				published <- err
+				return
+			}
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	bus.Ready()
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("Ready blocked %v by stalled publish", d)
+	}
+
+	select {
+	case <-published:
+	case <-time.After(10 * time.Second):
+		t.Fatal("publish to stalled broker never timed out")
+	}
+	waitFor(t, "broker reconnected", func() bool { return atomic.LoadInt32(&accepted) >= 2 })
+}
@@ -0,0 +1,190 @@
+package ztest
+
+import (
+	"net"
+	"server/utils"
+	"server/ziface"
+	"server/znet"
+	"server/zregistry"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+/*
+	注册中心：节点注册、心跳续约、租约过期和节点发现
+	go test -v ./ztest -run=TestRegistry
+*/
+
+//记录收到的节点变更
+type nodeEventRecorder struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (r *nodeEventRecorder) watch(event ziface.NodeEvent) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, event.Type.String()+":"+event.Node.ID)
+}
+
+func (r *nodeEventRecorder) has(event string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, e := range r.events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRegistry(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9931
+	conf.RegistryLease = 300
+
+	registry := zregistry.NewRegistry(znet.WithConfig(conf))
+	registry.Start()
+	defer registry.Stop()
+
+	var load int32 = 5
+	game := znet.NewRegistryClient("127.0.0.1:9931", conf)
+	defer game.Close()
+	if err := game.Register(ziface.NodeInfo{
+		ID:           "game-1",
+		Kind:         "game",
+		Addr:         "127.0.0.1:8999",
+		Capabilities: []string{"chat"},
+	}, func() int { return int(atomic.LoadInt32(&load)) }); err != nil {
+		t.Fatal("register err ", err)
+	}
+
+	gateway := znet.NewRegistryClient("127.0.0.1:9931", conf)
+	defer gateway.Close()
+	events := &nodeEventRecorder{}
+	gateway.Watch(events.watch)
+
+	//网关发现game节点，租约期间心跳保持节点存在
+	waitFor(t, "game node discovered", func() bool { return events.has("added:game-1") })
+	node, ok := gateway.Pick("game", "chat")
+	if !ok || node.Addr != "127.0.0.1:8999" || node.Load != 5 {
+		t.Fatal("picked ", node, ok)
+	}
+	if _, ok := gateway.Pick("game", "rpc"); ok {
+		t.Fatal("picked node without capability")
+	}
+
+	//负载变化随心跳推送给订阅者
+	atomic.StoreInt32(&load, 1)
+	waitFor(t, "load updated", func() bool {
+		node, ok := gateway.Pick("game", "")
+		return ok && node.Load == 1
+	})
+
+	//第二个game节点负载更低时被选中
+	other := znet.NewRegistryClient("127.0.0.1:9931", conf)
+	defer other.Close()
+	if err := other.Register(ziface.NodeInfo{ID: "game-2", Kind: "game", Addr: "127.0.0.1:9000"}, nil); err != nil {
+		t.Fatal("register err ", err)
+	}
+	waitFor(t, "second game node picked", func() bool {
+		node, ok := gateway.Pick("game", "")
+		return ok && node.ID == "game-2"
+	})
+	if len(registry.Nodes("game")) != 2 || len(gateway.Nodes("gateway")) != 0 {
+		t.Fatal("nodes ", registry.Nodes(""))
+	}
+
+	//主动注销的节点立即删除
+	if err := other.Deregister(); err != nil {
+		t.Fatal("deregister err ", err)
+	}
+	waitFor(t, "deregistered node removed", func() bool { return events.has("removed:game-2") })
+
+	//进程退出没有注销时，租约过期后删除
+	game.Close()
+	waitFor(t, "closed node removed", func() bool { return events.has("removed:game-1") })
+	if len(gateway.Nodes("")) != 0 || len(registry.Nodes("")) != 0 {
+		t.Fatal("nodes left ", registry.Nodes(""))
+	}
+}
+
+//注册中心重启后，节点重新连接时自动重新注册
+func TestRegistryRestart(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9932
+	conf.RegistryLease = 300
+
+	registry := zregistry.NewRegistry(znet.WithConfig(conf))
+	registry.Start()
+
+	client := znet.NewRegistryClient("127.0.0.1:9932", conf)
+	defer client.Close()
+	if err := client.Register(ziface.NodeInfo{ID: "gateway-1", Kind: "gateway", Addr: "127.0.0.1:7000"}, nil); err != nil {
+		t.Fatal("register err ", err)
+	}
+	waitFor(t, "node registered", func() bool { return len(registry.Nodes("gateway")) == 1 })
+
+	registry.Stop()
+	waitFor(t, "client disconnected", func() bool { return !client.Ready() })
+
+	registry = zregistry.NewRegistry(znet.WithConfig(conf))
+	registry.Start()
+	defer registry.Stop()
+	waitFor(t, "node registered again", func() bool { return len(registry.Nodes("gateway")) == 1 })
+	waitFor(t, "own node discovered", func() bool { return len(client.Nodes("gateway")) == 1 })
+}
+
+//不读取推送的订阅者不阻塞注册和其他订阅者
+func TestRegistryStalledWatcher(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9933
+	conf.RegistryLease = 3000
+	conf.MaxPacketSize = 128 * 1024
+	conf.MaxMsgChanLen = 1
+
+	registry := zregistry.NewRegistry(znet.WithConfig(conf))
+	registry.Start()
+	defer registry.Stop()
+
+	gateway := znet.NewRegistryClient("127.0.0.1:9933", conf)
+	defer gateway.Close()
+	events := &nodeEventRecorder{}
+	gateway.Watch(events.watch)
+	waitFor(t, "gateway connected", gateway.Ready)
+
+	//订阅之后不再读取
+	stalled, err := net.Dial("tcp", "127.0.0.1:9933")
+	if err != nil {
+		t.Fatal("dial err ", err)
+	}
+	defer stalled.Close()
+	frame, _ := znet.NewDataPackWithConfig(conf).Pack(znet.NewMsgPackage(znet.RegistryMsgWatch, nil))
+	if _, err := stalled.Write(frame); err != nil {
+		t.Fatal("write err ", err)
+	}
+
+	//推送的数据远超过socket缓冲区，订阅者的发送队列写满
+	game := znet.NewRegistryClient("127.0.0.1:9933", conf)
+	defer game.Close()
+	waitFor(t, "game connected", game.Ready)
+	big := strings.Repeat("x", 64*1024)
+	for i := 0; i < 400; i++ {
+		node := ziface.NodeInfo{ID: "game-1", Kind: "game", Capabilities: []string{big, strconv.Itoa(i)}}
+		if err := game.Register(node, nil); err != nil {
+			t.Fatal("register err ", err)
+		}
+	}
+
+	if err := game.Register(ziface.NodeInfo{ID: "game-2", Kind: "game"}, nil); err != nil {
+		t.Fatal("register err ", err)
+	}
+	waitFor(t, "node registered", func() bool { return len(registry.Nodes("game")) == 2 })
+	waitFor(t, "node discovered", func() bool { return events.has("added:game-2") })
+}
@@ -0,0 +1,148 @@
+package ztest
+
+import (
+	"encoding/binary"
+	"server/utils"
+	"server/ziface"
+	"server/znet"
+	"sync/atomic"
+	"testing"
+)
+
+/*
+	可靠传输：消息编号、重复消息丢弃、恢复会话后重传
+	go test -v ./ztest -run=TestReliable
+*/
+
+//回显路由，记录处理次数
+type CountEchoRouter struct {
+	znet.BaseRouter
+	count int32
+}
+
+func (r *CountEchoRouter) Handle(request ziface.IRequest) {
+	atomic.AddInt32(&r.count, 1)
+	_ = request.GetConn().SendBuffMsg(request.GetMsgID(), request.GetData())
+}
+
+func seqData(seq uint64) []byte {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, seq)
+	return data
+}
+
+func TestReliable(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9851
+	conf.SessionGracePeriod = 2
+	conf.ReliableDelivery = true
+
+	router := &CountEchoRouter{}
+	connCh := make(chan ziface.IConn, 1)
+	s := znet.NewServer(znet.WithConfig(conf))
+	s.AddRouter(1, router)
+	s.SetOnConnStart(func(conn ziface.IConn) { connCh <- conn })
+	s.Start()
+	defer s.Stop()
+
+	client := dialRetry(t, "127.0.0.1:9851")
+	sendMsg(t, client, znet.MsgIDResume, nil)
+	token := recvMsg(t, client).GetData()
+	conn := <-connCh
+
+	//收到带编号的回复
+	expect := func(seq uint64, data string) {
+		t.Helper()
+		if msg := recvMsg(t, client); msg.GetMsgID() != znet.MsgIDSeq || binary.LittleEndian.Uint64(msg.GetData()) != seq {
+			t.Fatalf("got msgId %d %v, want seq %d", msg.GetMsgID(), msg.GetData(), seq)
+		}
+		if msg := recvMsg(t, client); string(msg.GetData()) != data {
+			t.Fatalf("got %s, want %s", msg.GetData(), data)
+		}
+	}
+
+	sendMsg(t, client, znet.MsgIDSeq, seqData(1))
+	sendMsg(t, client, 1, []byte("a"))
+	expect(1, "a")
+
+	//重传的重复消息被丢弃
+	sendMsg(t, client, znet.MsgIDSeq, seqData(1))
+	sendMsg(t, client, 1, []byte("a"))
+	sendMsg(t, client, znet.MsgIDSeq, seqData(2))
+	sendMsg(t, client, 1, []byte("b"))
+	expect(2, "b")
+	if n := atomic.LoadInt32(&router.count); n != 2 {
+		t.Errorf("handled %d msgs, want 2", n)
+	}
+
+	//断线，断线期间发给客户端的消息被缓存
+	client.Close()
+	waitFor(t, "conn closed", func() bool { return s.GetConnMgr().Len() == 0 })
+	if err := conn.SendBuffMsg(1, []byte("c")); err != nil {
+		t.Fatal("send to parked session err: ", err)
+	}
+
+	//恢复会话时告知只收到了序号1，服务端重传序号2，再发送缓存的消息
+	client = dialRetry(t, "127.0.0.1:9851")
+	defer client.Close()
+	sendMsg(t, client, znet.MsgIDResume, append(token, seqData(1)...))
+	if msg := recvMsg(t, client); msg.GetMsgID() != znet.MsgIDResume {
+		t.Fatalf("got msgId %d, want new token", msg.GetMsgID())
+	}
+	if msg := recvMsg(t, client); msg.GetMsgID() != znet.MsgIDAck || binary.LittleEndian.Uint64(msg.GetData()) != 2 {
+		t.Fatalf("got msgId %d %v, want ack 2", msg.GetMsgID(), msg.GetData())
+	}
+	expect(2, "b")
+	expect(3, "c")
+}
+
+func TestReliableResync(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9852
+	conf.SessionGracePeriod = 2
+	conf.ReliableDelivery = true
+	conf.ReliableBufferLen = 2
+
+	var stopped int32
+	connCh := make(chan ziface.IConn, 1)
+	s := znet.NewServer(znet.WithConfig(conf))
+	s.AddRouter(1, &CountEchoRouter{})
+	s.SetOnConnStart(func(conn ziface.IConn) { connCh <- conn })
+	s.SetOnConnStop(func(conn ziface.IConn) { atomic.AddInt32(&stopped, 1) })
+	s.Start()
+	defer s.Stop()
+
+	client := dialRetry(t, "127.0.0.1:9852")
+	sendMsg(t, client, znet.MsgIDResume, nil)
+	token := recvMsg(t, client).GetData()
+	conn := <-connCh
+
+	//客户端不确认，重传缓存只保留序号2和3
+	for _, data := range []string{"a", "b", "c"} {
+		_ = conn.SendBuffMsg(1, []byte(data))
+		recvMsg(t, client)
+		recvMsg(t, client)
+	}
+	client.Close()
+	waitFor(t, "conn closed", func() bool { return s.GetConnMgr().Len() == 0 })
+
+	//客户端只收到了序号0，需要的序号1已经被丢弃，恢复失败并要求重新同步
+	client = dialRetry(t, "127.0.0.1:9852")
+	defer client.Close()
+	sendMsg(t, client, znet.MsgIDResume, append(token, seqData(0)...))
+	if msg := recvMsg(t, client); msg.GetMsgID() != znet.MsgIDResync || binary.LittleEndian.Uint64(msg.GetData()) != 2 {
+		t.Fatalf("got msgId %d %v, want resync from 2", msg.GetMsgID(), msg.GetData())
+	}
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Error("conn not closed after resync")
+	}
+	//会话马上销毁，不再等待保留期
+	if n := atomic.LoadInt32(&stopped); n != 1 {
+		t.Errorf("OnConnStop called %d times, want 1", n)
+	}
+	if s.GetConnMgr().Len() != 0 {
+		t.Error("resync conn should not be added")
+	}
+}
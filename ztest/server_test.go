@@ -2,75 +2,20 @@ package ztest
 
 import (
 	"fmt"
-	"io"
-	"net"
+	"server/main/mmo_game/pb"
+	"server/utils"
 	"server/ziface"
 	"server/znet"
+	"server/znettest"
 	"testing"
 	"time"
 )
 
 /*
-	服务器单元测试
+	服务器单元测试，使用znettest在内存中运行路由和Hook，不监听端口
 	go test -v ./ztest -run=TestServer
 */
 
-//模拟客户端
-func ClientTest(i uint32) {
-
-	fmt.Println("Client Test ... start")
-	//3秒之后发起测试请求，给服务端开启服务的机会
-	time.Sleep(3 * time.Second)
-
-	conn, err := net.Dial("tcp", "127.0.0.1:9999")
-	if err != nil {
-		fmt.Println("client start err, exit!")
-		return
-	}
-
-	for {
-		dp := znet.NewDataPack()
-		msg, _ := dp.Pack(znet.NewMsgPackage(i, []byte("client test message")))
-		_, err := conn.Write(msg)
-		if err != nil {
-			fmt.Println("client write err: ", err)
-			return
-		}
-
-		//先读出流中的head部分
-		headData := make([]byte, dp.GetHeadLen())
-		_, err = io.ReadFull(conn, headData)
-		if err != nil {
-			fmt.Println("client read head err: ", err)
-			return
-		}
-
-		// 将headData字节流 拆包到msg中
-		msgHead, err := dp.UnPack(headData)
-		if err != nil {
-			fmt.Println("client unpack head err: ", err)
-			return
-		}
-
-		if msgHead.GetDataLen() > 0 {
-			//msg 是有data数据的，需要再次读取data数据
-			msg := msgHead.(*znet.Msg)
-			msg.Data = make([]byte, msg.GetDataLen())
-
-			//根据dataLen从io中读取字节流
-			_, err := io.ReadFull(conn, msg.Data)
-			if err != nil {
-				fmt.Println("client unpack data err")
-				return
-			}
-
-			fmt.Printf("==> Client receive Msg: Id = %d, len = %d , data = %s\n", msg.ID, msg.Len, msg.Data)
-		}
-
-		time.Sleep(time.Second)
-	}
-}
-
 //模拟服务端
 //ping test 自定义路由
 type PingRouter struct {
@@ -135,8 +80,8 @@ func DoConnectionLost(conn ziface.IConn) {
 }
 
 func TestServer(t *testing.T) {
-	//创建一个server句柄
-	s := znet.NewServer()
+	//创建一个测试server句柄
+	s := znettest.NewServer()
 
 	//注册链接hook回调函数
 	s.SetOnConnStart(DoConnectionBegin)
@@ -146,15 +91,158 @@ func TestServer(t *testing.T) {
 	s.AddRouter(1, &PingRouter{})
 	s.AddRouter(2, &HelloRouter{})
 
-	//	客户端测试
-	go ClientTest(1)
-	go ClientTest(2)
+	//OnConnStart中发送的消息
+	conn := s.Connect()
+	if data := conn.Expect(t, 2).GetData(); string(data) != "DoConnection BEGIN..." {
+		t.Fatal("begin msg = ", string(data))
+	}
+
+	//PreHandle、Handle、PostHandle依次回复
+	if err := conn.Send(1, []byte("client test message")); err != nil {
+		t.Fatal("send err ", err)
+	}
+	for _, want := range []string{"before ping ....\n", "ping...ping...ping\n", "After ping .....\n"} {
+		if data := conn.Expect(t, 1).GetData(); string(data) != want {
+			t.Fatalf("ping reply = %q, want %q", data, want)
+		}
+	}
+	if err := conn.Send(2, []byte("client test message")); err != nil {
+		t.Fatal("send err ", err)
+	}
+	conn.Expect(t, 2)
+	conn.ExpectNone(t)
+
+	//一次写入两个帧和半个帧，再写入剩下的半个帧，三个帧都被处理
+	dp := znet.NewDataPack()
+	frame, _ := dp.Pack(znet.NewMsgPackage(2, []byte("hello")))
+	stream := append(append(append([]byte{}, frame...), frame...), frame[:5]...)
+	if _, err := conn.Write(stream); err != nil {
+		t.Fatal("write err ", err)
+	}
+	if _, err := conn.Write(frame[5:]); err != nil {
+		t.Fatal("write err ", err)
+	}
+	if err := conn.Sync(); err != nil {
+		t.Fatal("sync err ", err)
+	}
+	if msgs := conn.Drain(); len(msgs) != 3 {
+		t.Fatal("replies = ", len(msgs))
+	}
+
+	stopped := false
+	s.SetOnConnStop(func(ziface.IConn) { stopped = true })
+	conn.Close()
+	if !stopped || s.GetConnMgr().Len() != 0 {
+		t.Fatal("conn not stopped")
+	}
+	if err := conn.Send(1, nil); err == nil {
+		t.Fatal("send to closed conn succeeded")
+	}
+}
+
+//未认证的连接在登录超时之后被断开，属性在有效期之后删除
+func TestServerClock(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.RequireAuth = true
+	conf.LoginTimeout = 1000
+	s := znettest.NewServer(znet.WithConfig(conf))
+	s.GetMsgHandler().SetLoginMsgIDs(1)
+	s.AddRouter(1, &loginRouter{})
+	s.AddRouter(2, &HelloRouter{})
+
+	var reasons []string
+	s.GetEventBus().Subscribe(func(event *ziface.Event) {
+		reasons = append(reasons, event.Reason)
+	}, ziface.EventConnStopped)
+
+	//未登录时拒绝其他消息
+	idle, conn := s.Connect(), s.Connect()
+	if err := conn.Send(2, nil); err != nil {
+		t.Fatal("send err ", err)
+	}
+	conn.ExpectNone(t)
+	if err := conn.Send(1, nil); err != nil {
+		t.Fatal("send err ", err)
+	}
+	conn.SetPropertyWithTTL("token", "abc", 2*time.Second)
+
+	s.Clock().Advance(999 * time.Millisecond)
+	if idle.IsClosed() {
+		t.Fatal("closed before login timeout")
+	}
+	s.Clock().Advance(time.Millisecond)
+	if !idle.IsClosed() || conn.IsClosed() || len(reasons) != 1 || reasons[0] != "login timeout" {
+		t.Fatal("after login timeout reasons = ", reasons)
+	}
+	if token, err := conn.GetPropertyString("token"); err != nil || token != "abc" {
+		t.Fatal("token = ", token, err)
+	}
+	if _, err := conn.GetPropertyInt("token"); err == nil {
+		t.Fatal("want type error")
+	}
+	s.Clock().Advance(time.Second)
+	if _, err := conn.GetProperty("token"); err == nil {
+		t.Fatal("property not expired")
+	}
+	if err := conn.Send(2, nil); err != nil {
+		t.Fatal("send err ", err)
+	}
+	conn.Expect(t, 2)
+
+	s.Stop()
+	if !conn.IsClosed() || s.GetConnMgr().Len() != 0 {
+		t.Fatal("conn not stopped with server")
+	}
+}
+
+//断开的会话在保留期之后销毁，保留期使用测试服务器的时钟
+func TestServerSessionClock(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.SessionGracePeriod = 10
+	s := znettest.NewServer(znet.WithConfig(conf))
+	stopped := 0
+	s.SetOnConnStop(func(ziface.IConn) { stopped++ })
+
+	//第一帧不是恢复请求，新建会话并下发令牌
+	conn := s.Connect()
+	if err := conn.Sync(); err != nil {
+		t.Fatal("sync err ", err)
+	}
+	conn.Expect(t, znet.MsgIDResume)
+	conn.Close()
 
-	//2 开启服务
-	go s.Serve()
+	s.Clock().Advance(10*time.Second - time.Millisecond)
+	if stopped != 0 {
+		t.Fatal("session expired before grace period")
+	}
+	s.Clock().Advance(time.Millisecond)
+	if stopped != 1 {
+		t.Fatal("session not expired, OnConnStop called ", stopped)
+	}
+}
 
-	select {
-	case <-time.After(time.Second * 10):
-		return
+//生成的处理接口，按protobuf消息断言回复
+func TestServerProto(t *testing.T) {
+	s := znettest.NewServer()
+	pb.RegisterTalkHandler(s, &talkHandler{})
+	conn := s.Connect()
+	if err := conn.SendProto(pb.MsgIDTalk, &pb.Talk{Content: "hi"}); err != nil {
+		t.Fatal("send err ", err)
 	}
+	reply := &pb.BroadCast{}
+	conn.ExpectProto(t, pb.MsgIDBroadCast, reply)
+	if reply.GetContent() != "hi" {
+		t.Fatal("reply = ", reply)
+	}
+	if stats := conn.Stats(); stats.MsgsIn != 1 || stats.MsgsOut != 1 {
+		t.Fatal("stats = ", stats)
+	}
+}
+
+type loginRouter struct {
+	znet.BaseRouter
+}
+
+func (r *loginRouter) Handle(request ziface.IRequest) {
+	request.GetConn().SetAuthenticated()
 }
@@ -0,0 +1,163 @@
+package ztest
+
+import (
+	"io"
+	"net"
+	"server/utils"
+	"server/ziface"
+	"server/znet"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+	断线重连恢复会话
+	go test -v ./ztest -run=TestSession
+*/
+
+//读取一条消息
+func recvMsg(t *testing.T, conn net.Conn) ziface.IMsg {
+	dp := znet.NewDataPack()
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	headData := make([]byte, dp.GetHeadLen())
+	if _, err := io.ReadFull(conn, headData); err != nil {
+		t.Fatal("read head err: ", err)
+	}
+	msg, err := dp.UnPack(headData)
+	if err != nil {
+		t.Fatal("unpack err: ", err)
+	}
+	data := make([]byte, msg.GetDataLen())
+	if _, err := io.ReadFull(conn, data); err != nil {
+		t.Fatal("read body err: ", err)
+	}
+	msg.SetData(data)
+	return msg
+}
+
+//发送一条消息
+func sendMsg(t *testing.T, conn net.Conn, msgID uint32, data []byte) {
+	msg, _ := znet.NewDataPack().Pack(znet.NewMsgPackage(msgID, data))
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal("write err: ", err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9841
+	conf.SessionGracePeriod = 1
+	conf.SessionResumeWait = 100
+
+	var lock sync.Mutex
+	var started, stopped, resumed []ziface.IConn
+	//有效期属性的变更
+	type change struct {
+		conn  ziface.IConn
+		value interface{}
+	}
+	var buffChanges []change
+	s := znet.NewServer(znet.WithConfig(conf))
+	s.SetOnConnStart(func(conn ziface.IConn) {
+		conn.SetProperty("pid", int32(len(started)+1))
+		conn.SetPropertyWithTTL("buff", "haste", 800*time.Millisecond)
+		lock.Lock()
+		started = append(started, conn)
+		lock.Unlock()
+	})
+	s.SetOnConnStop(func(conn ziface.IConn) {
+		lock.Lock()
+		stopped = append(stopped, conn)
+		lock.Unlock()
+	})
+	s.SetOnSessionResume(func(conn ziface.IConn) {
+		lock.Lock()
+		resumed = append(resumed, conn)
+		lock.Unlock()
+	})
+	s.SetOnPropertyChange(func(conn ziface.IConn, key string, oldValue, newValue interface{}) {
+		if key == "buff" {
+			lock.Lock()
+			buffChanges = append(buffChanges, change{conn, newValue})
+			lock.Unlock()
+		}
+	})
+	s.Start()
+	defer s.Stop()
+	count := func(conns *[]ziface.IConn) int {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(*conns)
+	}
+
+	//空的恢复请求直接新建会话，收到恢复令牌
+	client := dialRetry(t, "127.0.0.1:9841")
+	sendMsg(t, client, znet.MsgIDResume, nil)
+	token := recvMsg(t, client)
+	if token.GetMsgID() != znet.MsgIDResume || token.GetDataLen() == 0 {
+		t.Fatalf("first msg = %d, want resume token", token.GetMsgID())
+	}
+
+	//断线后会话保留，发给旧连接的消息被缓存
+	client.Close()
+	waitFor(t, "conn closed", func() bool { return s.GetConnMgr().Len() == 0 })
+	if err := started[0].SendBuffMsg(5, []byte("missed")); err != nil {
+		t.Fatal("send to parked session err: ", err)
+	}
+	if count(&stopped) != 0 {
+		t.Fatal("OnConnStop called during grace period")
+	}
+
+	//凭令牌恢复会话，收到新令牌和断线期间的消息
+	client = dialRetry(t, "127.0.0.1:9841")
+	defer client.Close()
+	sendMsg(t, client, znet.MsgIDResume, token.GetData())
+	newToken := recvMsg(t, client)
+	if newToken.GetMsgID() != znet.MsgIDResume || string(newToken.GetData()) == string(token.GetData()) {
+		t.Errorf("resume reply = %d %s, want a new token", newToken.GetMsgID(), newToken.GetData())
+	}
+	if missed := recvMsg(t, client); missed.GetMsgID() != 5 || string(missed.GetData()) != "missed" {
+		t.Errorf("pending msg = %d %s, want 5 missed", missed.GetMsgID(), missed.GetData())
+	}
+	waitFor(t, "resume hook", func() bool { return count(&resumed) == 1 })
+	if pid, _ := resumed[0].GetProperty("pid"); pid != int32(1) || count(&started) != 1 {
+		t.Errorf("resumed pid = %v, started = %d, want pid 1 and no new OnConnStart", pid, count(&started))
+	}
+
+	//有效期属性带着剩余的有效期转移到新连接，到期后在新连接上删除
+	if buff, err := resumed[0].GetProperty("buff"); err != nil || buff != "haste" {
+		t.Errorf("resumed buff = %v %v, want haste", buff, err)
+	}
+	waitFor(t, "buff expired", func() bool {
+		_, err := resumed[0].GetProperty("buff")
+		return err != nil
+	})
+	lock.Lock()
+	var values []interface{}
+	for _, c := range buffChanges {
+		if c.conn == resumed[0] {
+			values = append(values, c.value)
+		}
+	}
+	lock.Unlock()
+	if len(values) != 2 || values[0] != "haste" || values[1] != nil {
+		t.Errorf("buff changes on resumed conn = %v, want [haste <nil>]", values)
+	}
+
+	//旧令牌已经失效，不发送第一帧的连接等待超时后新建会话
+	other := dialRetry(t, "127.0.0.1:9841")
+	defer other.Close()
+	if msg := recvMsg(t, other); msg.GetMsgID() != znet.MsgIDResume {
+		t.Errorf("first msg = %d, want resume token", msg.GetMsgID())
+	}
+	waitFor(t, "new session", func() bool { return count(&started) == 2 })
+
+	//保留期结束仍未恢复，调用OnConnStop
+	client.Close()
+	waitFor(t, "session expired", func() bool { return count(&stopped) == 1 })
+	if stopped[0] != resumed[0] {
+		t.Error("OnConnStop should be called with the resumed conn")
+	}
+}
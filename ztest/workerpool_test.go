@@ -0,0 +1,189 @@
+package ztest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"server/utils"
+	"server/ziface"
+	"server/znet"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+	弹性Worker池扩缩容与过载拒绝
+	go test -v ./ztest -run=TestWorkerPool
+*/
+
+//阻塞直到release被关闭
+type BlockRouter struct {
+	znet.BaseRouter
+	release chan struct{}
+}
+
+func (r *BlockRouter) Handle(request ziface.IRequest) {
+	<-r.release
+}
+
+//等待条件成立
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for ", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9821
+	conf.WorkerPoolSize = 1
+	conf.MaxWorkerPoolSize = 3
+	conf.MaxWorkerTaskLen = 4
+	conf.WorkerScaleInterval = 20
+	conf.WorkerScaleUpQueuePercent = 50
+	conf.ShedQueuePercent = 50
+	conf.OverloadMsgID = 99
+
+	release := make(chan struct{})
+	s := znet.NewServer(znet.WithConfig(conf))
+	s.AddRouter(1, &BlockRouter{release: release})
+	s.AddRouter(2, &EchoNameRouter{name: "chat"})
+	s.GetMsgHandler().SetSheddable(2)
+	s.Start()
+	defer s.Stop()
+
+	conn := dialRetry(t, "127.0.0.1:9821")
+	defer conn.Close()
+
+	//worker被第一条消息阻塞，之后的两条消息在队列中排队
+	dp := znet.NewDataPack()
+	for i := 0; i < 3; i++ {
+		msg, _ := dp.Pack(znet.NewMsgPackage(1, nil))
+		if _, err := conn.Write(msg); err != nil {
+			t.Fatal("write err: ", err)
+		}
+	}
+	handler := s.GetMsgHandler()
+	waitFor(t, "queued tasks", func() bool { return sum(handler.GetTaskQueueLens()) >= 2 })
+
+	//队列占用达到50%，可丢弃的消息直接被拒绝
+	reply := sendAndRecv(t, conn, 2, []byte("hi"))
+	if reply.GetMsgID() != 99 || binary.LittleEndian.Uint32(reply.GetData()) != 2 {
+		t.Errorf("overloaded reply = %d %v, want 99 [2 0 0 0]", reply.GetMsgID(), reply.GetData())
+	}
+
+	//队列持续拥堵，扩容到上限，槽位数量不变
+	pool := handler.(*znet.MsgHandle)
+	waitFor(t, "scale up", func() bool { return pool.Workers() == 3 })
+	if n := len(handler.GetTaskQueueLens()); n != 3 {
+		t.Errorf("slots = %d, want 3", n)
+	}
+
+	//消息处理完之后缩容回WorkerPoolSize
+	close(release)
+	waitFor(t, "scale down", func() bool { return pool.Workers() == 1 })
+}
+
+func sum(lens []int) int {
+	n := 0
+	for _, l := range lens {
+		n += l
+	}
+	return n
+}
+
+//记录每个连接处理消息的顺序，检查同一个连接的消息没有并发执行
+type orderRouter struct {
+	znet.BaseRouter
+	pool *znet.MsgHandle
+	lock sync.Mutex
+	//每个连接正在处理的消息数量和处理过的序号
+	inflight map[uint64]int
+	seqs     map[uint64][]uint32
+	errs     []string
+	//处理期间出现过的最大goroutine数量
+	maxWorkers int
+}
+
+func (r *orderRouter) Handle(request ziface.IRequest) {
+	connID := request.GetConn().GetConnID()
+	r.lock.Lock()
+	r.inflight[connID]++
+	if r.inflight[connID] > 1 {
+		r.errs = append(r.errs, fmt.Sprint("ConnID = ", connID, " handled concurrently"))
+	}
+	if w := r.pool.Workers(); w > r.maxWorkers {
+		r.maxWorkers = w
+	}
+	r.lock.Unlock()
+
+	time.Sleep(200 * time.Microsecond)
+
+	r.lock.Lock()
+	r.inflight[connID]--
+	r.seqs[connID] = append(r.seqs[connID], binary.LittleEndian.Uint32(request.GetData()))
+	r.lock.Unlock()
+}
+
+//扩缩容期间同一个连接的消息仍然按顺序依次处理
+func TestWorkerPoolOrder(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.WorkerPoolSize = 1
+	conf.MaxWorkerPoolSize = 4
+	conf.MaxWorkerTaskLen = 8
+	conf.WorkerScaleInterval = 2
+	conf.WorkerScaleUpQueuePercent = 50
+	s := znet.NewServer(znet.WithConfig(conf))
+	handler := s.GetMsgHandler()
+	pool := handler.(*znet.MsgHandle)
+	router := &orderRouter{pool: pool, inflight: make(map[uint64]int), seqs: make(map[uint64][]uint32)}
+	s.AddRouter(1, router)
+	handler.StartWorkerPool()
+	defer handler.StopWorkerPool()
+
+	const conns, msgs = 6, 300
+	var wg sync.WaitGroup
+	for i := 1; i <= conns; i++ {
+		conn := znet.NewConn(s, nil, uint64(i), handler)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := uint32(0); seq < msgs; seq++ {
+				data := make([]byte, 4)
+				binary.LittleEndian.PutUint32(data, seq)
+				handler.SendMsgToTaskQueue(&fakeRequest{conn: conn, msgID: 1, data: data})
+			}
+		}()
+	}
+	wg.Wait()
+	waitFor(t, "all handled", func() bool {
+		router.lock.Lock()
+		defer router.lock.Unlock()
+		n := 0
+		for _, seqs := range router.seqs {
+			n += len(seqs)
+		}
+		return n == conns*msgs
+	})
+
+	router.lock.Lock()
+	defer router.lock.Unlock()
+	if router.maxWorkers < 2 {
+		t.Errorf("pool never scaled up, max workers = %d", router.maxWorkers)
+	}
+	for _, err := range router.errs {
+		t.Error(err)
+	}
+	for connID, seqs := range router.seqs {
+		for i, seq := range seqs {
+			if seq != uint32(i) {
+				t.Fatalf("ConnID = %d msg %d handled at position %d", connID, seq, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package ztest
+
+import (
+	"fmt"
+	"server/main/mmo_game/pb"
+	"server/utils"
+	"server/zadmin"
+	"server/ziface"
+	"server/znet"
+	"server/zplugin"
+	"server/zproto"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	msgID常量生成、消息类型注册表和消息的可读文本
+	go test -v ./ztest -run="TestMsgID|TestMsgFormat"
+*/
+
+func TestMsgIDGen(t *testing.T) {
+	req := &zplugin.CodeGeneratorRequest{
+		FileToGenerate: []string{"msg.proto"},
+		Parameter:      proto.String("csharp"),
+		ProtoFile: []*zplugin.FileDescriptorProto{{
+			Name:    proto.String("msg.proto"),
+			Package: proto.String("pb"),
+			Options: &zplugin.FileOptions{CsharpNamespace: proto.String("Pb")},
+			MessageType: []*zplugin.DescriptorProto{
+				{Name: proto.String("SyncPid")},
+				{Name: proto.String("Talk")},
+				{Name: proto.String("Player")},
+			},
+			SourceCodeInfo: &zplugin.SourceCodeInfo{Location: []*zplugin.SourceCodeInfo_Location{
+				{Path: []int32{4, 0}, LeadingComments: proto.String("同步客户端玩家ID\n@msgid 201 s2c PlayerOffline 玩家下线\n@msgid 1 s2c\n")},
+				{Path: []int32{4, 1}, LeadingComments: proto.String("玩家聊天数据\n@msgid 2 c2s\n")},
+			}},
+		}},
+	}
+	files, err := zproto.GenerateMsgIDs(req)
+	if err != nil {
+		t.Fatal("generate err ", err)
+	}
+	if len(files) != 2 || *files[0].Name != "msg.zmsg.go" || *files[1].Name != "msg.msgid.cs" {
+		t.Fatalf("files = %v", files)
+	}
+	goCode, csCode := *files[0].Content, *files[1].Content
+	for _, want := range []string{
+		"package pb",
+		"// 同步客户端玩家ID\n\tMsgIDSyncPid uint32 = 1",
+		"// 玩家下线\n\tMsgIDPlayerOffline uint32 = 201",
+		`zproto.Register(MsgIDPlayerOffline, zproto.ServerToClient, "PlayerOffline", func() proto.Message { return new(SyncPid) })`,
+		"func SendPlayerOffline(conn ziface.IConn, msg *SyncPid) error",
+		"HandleTalk(request ziface.IRequest, msg *Talk)",
+		"func RegisterTalkHandler(s ziface.IServer, h TalkHandler)",
+	} {
+		if !strings.Contains(goCode, want) {
+			t.Errorf("generated go code missing %q:\n%s", want, goCode)
+		}
+	}
+	//常量按msgID排序
+	if strings.Index(goCode, "MsgIDSyncPid ") > strings.Index(goCode, "MsgIDPlayerOffline ") {
+		t.Errorf("constants not sorted by msgID:\n%s", goCode)
+	}
+	for _, want := range []string{
+		"namespace Pb {",
+		"public const uint PlayerOffline = 201;",
+		"{ PlayerOffline, global::Pb.SyncPid.Parser },",
+	} {
+		if !strings.Contains(csCode, want) {
+			t.Errorf("generated c# code missing %q:\n%s", want, csCode)
+		}
+	}
+	if strings.Contains(csCode, "{ Talk,") {
+		t.Errorf("c2s message in server parsers:\n%s", csCode)
+	}
+
+	//同一个方向上重复的msgID、系统保留的msgID和错误的方向报错
+	for _, bad := range []string{"@msgid 1 s2c Again", "@msgid 4294967041 s2c", "@msgid 5 up"} {
+		req.ProtoFile[0].SourceCodeInfo.Location[1].LeadingComments = proto.String(bad)
+		if _, err := zproto.GenerateMsgIDs(req); err == nil {
+			t.Errorf("want error for %q", bad)
+		}
+	}
+}
+
+//使用生成的处理接口和发送方法
+type talkHandler struct{}
+
+func (h *talkHandler) HandleTalk(request ziface.IRequest, msg *pb.Talk) {
+	_ = pb.SendBroadCast(request.GetConn(), &pb.BroadCast{Tp: 1, Data: &pb.BroadCast_Content{Content: msg.Content}})
+}
+
+func TestMsgIDRegistry(t *testing.T) {
+	bc, ok := zproto.DefaultRegistry.Lookup(201, zproto.ServerToClient)
+	if !ok || bc.Name != "PlayerOffline" || bc.TypeName != "pb.SyncPid" {
+		t.Fatal("lookup 201 = ", bc, ok)
+	}
+	if _, ok := zproto.DefaultRegistry.Lookup(201, zproto.ClientToServer); ok {
+		t.Fatal("201 registered as c2s")
+	}
+	if ids := zproto.DefaultRegistry.MsgIDs(&pb.SyncPid{}, zproto.ServerToClient); len(ids) != 2 || ids[0] != 1 || ids[1] != 201 {
+		t.Fatal("msgIDs of SyncPid = ", ids)
+	}
+
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9941
+	s := znet.NewServer(znet.WithConfig(conf))
+	pb.RegisterTalkHandler(s, &talkHandler{})
+	s.Start()
+	defer s.Stop()
+
+	conn := dialRetry(t, "127.0.0.1:9941")
+	defer conn.Close()
+	data, _ := proto.Marshal(&pb.Talk{Content: "hi"})
+	reply := sendAndRecv(t, conn, pb.MsgIDTalk, data)
+	if reply.GetMsgID() != pb.MsgIDBroadCast {
+		t.Fatal("reply msgID = ", reply.GetMsgID())
+	}
+	msg, err := zproto.DefaultRegistry.Decode(reply.GetMsgID(), zproto.ServerToClient, reply.GetData())
+	if err != nil {
+		t.Fatal("decode err ", err)
+	}
+	if bc := msg.(*pb.BroadCast); bc.GetContent() != "hi" {
+		t.Fatal("reply = ", bc)
+	}
+}
+
+func TestMsgFormat(t *testing.T) {
+	data, _ := proto.Marshal(&pb.BroadCast{Pid: 1, Tp: 1, Data: &pb.BroadCast_Content{Content: "hi"}})
+	reg := zproto.DefaultRegistry
+	if text := reg.Format(pb.MsgIDBroadCast, zproto.ServerToClient, data); text != `s2c msgId=200 BroadCast {Pid:1 Tp:1 Content:"hi"}` {
+		t.Fatal("format = ", text)
+	}
+	if text := reg.FormatIndent(pb.MsgIDBroadCast, zproto.ServerToClient, data); !strings.Contains(text, "{\n  Pid: 1\n") {
+		t.Fatal("format indent = ", text)
+	}
+	//没有注册的方向和系统消息只打印十六进制
+	if text := reg.Format(pb.MsgIDBroadCast, zproto.ClientToServer, []byte{0xab}); text != "c2s msgId=200 unknown len=1 hex=ab" {
+		t.Fatal("format unknown = ", text)
+	}
+	if text := reg.Format(znet.SysMsgIDBase, zproto.ServerToClient, nil); !strings.Contains(text, " sys len=0") {
+		t.Fatal("format sys = ", text)
+	}
+	if text := reg.Format(pb.MsgIDTalk, zproto.ClientToServer, []byte{0xff}); !strings.Contains(text, "Talk decode err") {
+		t.Fatal("format bad data = ", text)
+	}
+
+	//两个连续的帧，第二个帧被截断
+	dp := znet.NewDataPack()
+	frame1, _ := dp.Pack(znet.NewMsgPackage(pb.MsgIDBroadCast, data))
+	frame2, _ := dp.Pack(znet.NewMsgPackage(pb.MsgIDSyncPid, []byte{0x08, 0x02}))
+	hexText := "0x" + strings.ToUpper(hexSpaced(append(frame1, frame2...)))
+	buf, err := zproto.ParseHex(hexText)
+	if err != nil {
+		t.Fatal("parse hex err ", err)
+	}
+	msgs, err := zproto.SplitFrames(dp, buf)
+	if err != nil || len(msgs) != 2 || msgs[0].GetMsgID() != pb.MsgIDBroadCast || msgs[1].GetMsgID() != pb.MsgIDSyncPid {
+		t.Fatal("split = ", msgs, err)
+	}
+	if msgs, err = zproto.SplitFrames(dp, buf[:len(buf)-1]); err == nil || len(msgs) != 1 {
+		t.Fatal("split truncated = ", msgs, err)
+	}
+
+	//管理后台
+	admin := zadmin.NewAdmin(znet.NewServer(znet.WithConfig(utils.NewGlobalObj())))
+	text, err := admin.Decode("s2c", 1, "08 02")
+	if err != nil || text != "s2c msgId=1 SyncPid {Pid:2}" {
+		t.Fatal("admin decode = ", text, err)
+	}
+	if _, err := admin.Decode("up", 1, "08 02"); err == nil {
+		t.Fatal("want error for bad direction")
+	}
+	found := false
+	for _, info := range admin.MsgTypes() {
+		if info.MsgID == pb.MsgIDPlayerOffline && info.Dir == "s2c" && info.Name == "PlayerOffline" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("msg types = ", admin.MsgTypes())
+	}
+}
+
+//以空格分隔的十六进制字节
+func hexSpaced(data []byte) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(parts, " ")
+}
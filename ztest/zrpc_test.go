@@ -0,0 +1,289 @@
+package ztest
+
+import (
+	"context"
+	"net"
+	"server/main/mmo_game/pb"
+	"server/utils"
+	"server/znet"
+	"server/zplugin"
+	"server/zrpc"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+	服务间RPC：调用、截止时间和取消的传递、连接池以及根据service生成代码
+	go test -v ./ztest -run=TestRPC
+*/
+
+//测试用的服务，Slow一直等待到Context结束，并把Context结束的原因发给done
+func echoService(done chan error) *zrpc.ServiceDesc {
+	newTalk := func() proto.Message { return &pb.Talk{} }
+	return &zrpc.ServiceDesc{
+		Name: "test.Echo",
+		Methods: []zrpc.MethodDesc{
+			{
+				Name:       "Say",
+				NewRequest: newTalk,
+				Handler: func(ctx context.Context, req proto.Message) (proto.Message, error) {
+					return &pb.Talk{Content: "echo:" + req.(*pb.Talk).Content}, nil
+				},
+			},
+			{
+				Name:       "Slow",
+				NewRequest: newTalk,
+				Handler: func(ctx context.Context, req proto.Message) (proto.Message, error) {
+					if _, ok := ctx.Deadline(); !ok && req.(*pb.Talk).Content == "deadline" {
+						done <- nil
+						return nil, nil
+					}
+					<-ctx.Done()
+					done <- ctx.Err()
+					return nil, ctx.Err()
+				},
+			},
+			{
+				Name:       "Fail",
+				NewRequest: newTalk,
+				Handler: func(ctx context.Context, req proto.Message) (proto.Message, error) {
+					if req.(*pb.Talk).Content == "panic" {
+						panic("boom")
+					}
+					return nil, zrpc.Errorf(zrpc.CodeBadRequest, "bad %s", req.(*pb.Talk).Content)
+				},
+			},
+		},
+	}
+}
+
+func TestRPC(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9921
+
+	done := make(chan error, 1)
+	s := zrpc.NewServer(znet.WithConfig(conf))
+	s.RegisterService(echoService(done))
+	s.Start()
+	defer s.Stop()
+
+	waitFor(t, "rpc server listening", func() bool {
+		c, err := zrpc.Dial("127.0.0.1:9921", conf)
+		if err != nil {
+			return false
+		}
+		c.Close()
+		return true
+	})
+	pool := zrpc.NewPool("127.0.0.1:9921", 2, conf)
+	defer pool.Close()
+
+	//连接池按调用的ctx连接，ctx已经取消时不发起连接
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := pool.Call(cancelled, "test.Echo/Say", &pb.Talk{}, &pb.Talk{}); err != context.Canceled {
+		t.Fatal("call with cancelled ctx err ", err)
+	}
+
+	//连接池上的并发调用
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			content := strings.Repeat("x", i)
+			resp := &pb.Talk{}
+			if err := pool.Call(context.Background(), "test.Echo/Say", &pb.Talk{Content: content}, resp); err != nil {
+				t.Error("call err ", err)
+				return
+			}
+			if resp.Content != "echo:"+content {
+				t.Errorf("resp = %q, want echo:%s", resp.Content, content)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	//错误码
+	err := pool.Call(context.Background(), "test.Echo/Missing", &pb.Talk{}, &pb.Talk{})
+	if zrpc.CodeOf(err) != zrpc.CodeNotFound {
+		t.Fatal("missing method err ", err)
+	}
+	err = pool.Call(context.Background(), "test.Echo/Fail", &pb.Talk{Content: "req"}, &pb.Talk{})
+	if zrpc.CodeOf(err) != zrpc.CodeBadRequest || !strings.Contains(err.Error(), "bad req") {
+		t.Fatal("handler err ", err)
+	}
+	err = pool.Call(context.Background(), "test.Echo/Fail", &pb.Talk{Content: "panic"}, &pb.Talk{})
+	if zrpc.CodeOf(err) != zrpc.CodeInternal {
+		t.Fatal("panic err ", err)
+	}
+
+	//没有截止时间的调用，服务端的Context也没有截止时间
+	if err := pool.Call(context.Background(), "test.Echo/Slow", &pb.Talk{Content: "deadline"}, &pb.Talk{}); err != nil {
+		t.Fatal("call without deadline err ", err)
+	}
+	<-done
+
+	//截止时间传递给服务端
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	start := time.Now()
+	err = pool.Call(ctx, "test.Echo/Slow", &pb.Talk{}, &pb.Talk{})
+	cancel()
+	if err != context.DeadlineExceeded || time.Since(start) > time.Second {
+		t.Fatal("deadline call err ", err, " after ", time.Since(start))
+	}
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Fatal("server ctx err ", err, ", want deadline exceeded")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server handler not canceled by deadline")
+	}
+
+	//调用方取消时服务端的处理也被取消
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if err := pool.Call(ctx, "test.Echo/Slow", &pb.Talk{}, &pb.Talk{}); err != context.Canceled {
+		t.Fatal("canceled call err ", err)
+	}
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatal("server ctx err ", err, ", want canceled")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server handler not canceled")
+	}
+}
+
+func TestRPCStubGen(t *testing.T) {
+	req := &zplugin.CodeGeneratorRequest{
+		FileToGenerate: []string{"chat.proto"},
+		ProtoFile: []*zplugin.FileDescriptorProto{{
+			Name:    proto.String("chat.proto"),
+			Package: proto.String("pb"),
+			Options: &zplugin.FileOptions{GoPackage: proto.String("server/main/mmo_game/pb")},
+			MessageType: []*zplugin.DescriptorProto{
+				{Name: proto.String("Talk")},
+			},
+			Service: []*zplugin.ServiceDescriptorProto{{
+				Name: proto.String("Chat"),
+				Method: []*zplugin.MethodDescriptorProto{
+					{Name: proto.String("send_talk"), InputType: proto.String(".pb.Talk"), OutputType: proto.String(".pb.Talk")},
+				},
+			}},
+			SourceCodeInfo: &zplugin.SourceCodeInfo{Location: []*zplugin.SourceCodeInfo_Location{
+				{Path: []int32{6, 0, 2, 0}, LeadingComments: proto.String(" 发送聊天\n")},
+			}},
+		}},
+	}
+	//请求经过一次编解码，与protoc传入的数据一致
+	data, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &zplugin.CodeGeneratorRequest{}
+	if err := proto.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := zrpc.GenerateStubs(decoded)
+	if err != nil {
+		t.Fatal("generate err ", err)
+	}
+	if len(files) != 1 || *files[0].Name != "chat.zrpc.go" {
+		t.Fatalf("files = %v", files)
+	}
+	content := *files[0].Content
+	for _, want := range []string{
+		"package pb",
+		"type ChatServer interface",
+		"// 发送聊天",
+		"SendTalk(ctx context.Context, req *Talk) (*Talk, error)",
+		"func RegisterChatServer(s *zrpc.Server, impl ChatServer)",
+		`c.cc.Call(ctx, "pb.Chat/send_talk", req, resp)`,
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("generated code missing %q:\n%s", want, content)
+		}
+	}
+
+	//请求和回复不在同一个包中时报错
+	decoded.ProtoFile[0].Service[0].Method[0].InputType = proto.String(".other.Talk")
+	if _, err := zrpc.GenerateStubs(decoded); err == nil {
+		t.Fatal("want error for message of other package")
+	}
+}
+
+func TestRPCLimits(t *testing.T) {
+	conf := utils.NewGlobalObj()
+	conf.Host = "127.0.0.1"
+	conf.TcpPort = 9922
+	conf.MaxPacketSize = 64 << 20
+
+	done := make(chan error, 4)
+	s := zrpc.NewServer(znet.WithConfig(conf))
+	s.RegisterService(echoService(done))
+	s.SetMaxInflight(2)
+	s.Start()
+	defer s.Stop()
+
+	var client *zrpc.Client
+	waitFor(t, "rpc server listening", func() bool {
+		var err error
+		client, err = zrpc.Dial("127.0.0.1:9922", conf)
+		return err == nil
+	})
+	defer client.Close()
+
+	//同一个连接上进行中的调用超过上限时被拒绝
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < 2; i++ {
+		go func() {
+			//与下面的Say竞争时可能被拒绝，重试直到进入处理方法
+			for zrpc.CodeOf(client.Call(ctx, "test.Echo/Slow", &pb.Talk{}, &pb.Talk{})) == zrpc.CodeResourceExhausted {
+			}
+		}()
+	}
+	waitFor(t, "inflight limit", func() bool {
+		err := client.Call(context.Background(), "test.Echo/Say", &pb.Talk{}, &pb.Talk{})
+		return zrpc.CodeOf(err) == zrpc.CodeResourceExhausted
+	})
+	cancel()
+	<-done
+	<-done
+	waitFor(t, "inflight calls released", func() bool {
+		return client.Call(context.Background(), "test.Echo/Say", &pb.Talk{}, &pb.Talk{}) == nil
+	})
+
+	//服务端不读取时，写请求在截止时间返回
+	listener, err := net.Listen("tcp", "127.0.0.1:9923")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			defer conn.Close()
+			time.Sleep(3 * time.Second)
+		}
+	}()
+	stalled, err := zrpc.Dial("127.0.0.1:9923", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = stalled.Call(ctx, "test.Echo/Say", &pb.Talk{Content: strings.Repeat("x", 32<<20)}, &pb.Talk{})
+	if err != context.DeadlineExceeded || time.Since(start) > time.Second {
+		t.Fatal("stalled write err ", err, " after ", time.Since(start))
+	}
+}
@@ -0,0 +1,33 @@
+package ztimer
+
+import "time"
+
+/*
+	时钟抽象：需要定时的业务通过Clock获取当前时间和注册延迟回调，
+	线上使用SystemClock，测试中替换为znettest.FakeClock，手动推进时间而不用真实等待
+*/
+type Clock interface {
+	//当前时间
+	Now() time.Time
+	//d之后在另外的goroutine中调用f，返回的定时器可以取消
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+//Clock创建的定时器，*time.Timer满足该接口
+type ClockTimer interface {
+	//取消定时器，定时器已经触发或者已经取消时返回false
+	Stop() bool
+}
+
+//使用系统时间的时钟
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}